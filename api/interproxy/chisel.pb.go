@@ -161,6 +161,7 @@ func (m *PbChannelDescriptor) GetSkeletonDescriptor() *PbEndpointDescriptor {
 type PbSessionConfigRequest struct {
 	ClientVersion        string                 `protobuf:"bytes,1,opt,name=ClientVersion,json=clientVersion,proto3" json:"ClientVersion,omitempty"`
 	ChannelDescriptors   []*PbChannelDescriptor `protobuf:"bytes,2,rep,name=ChannelDescriptors,json=channelDescriptors,proto3" json:"ChannelDescriptors,omitempty"`
+	AllowPartial         bool                   `protobuf:"varint,3,opt,name=AllowPartial,json=allowPartial,proto3" json:"AllowPartial,omitempty"`
 	XXX_NoUnkeyedLiteral struct{}               `json:"-"`
 	XXX_unrecognized     []byte                 `json:"-"`
 	XXX_sizecache        int32                  `json:"-"`
@@ -205,6 +206,13 @@ func (m *PbSessionConfigRequest) GetChannelDescriptors() []*PbChannelDescriptor
 	return nil
 }
 
+func (m *PbSessionConfigRequest) GetAllowPartial() bool {
+	if m != nil {
+		return m.AllowPartial
+	}
+	return false
+}
+
 type PbDialRequest struct {
 	UseDescriptor          bool                  `protobuf:"varint,1,opt,name=UseDescriptor,json=useDescriptor,proto3" json:"UseDescriptor,omitempty"`
 	ChannelDescriptorIndex int32                 `protobuf:"varint,2,opt,name=ChannelDescriptorIndex,json=channelDescriptorIndex,proto3" json:"ChannelDescriptorIndex,omitempty"`
@@ -279,31 +287,31 @@ func init() {
 func init() { proto.RegisterFile("wstunnel.proto", fileDescriptor_166ce0f0cfe77f00) }
 
 var fileDescriptor_166ce0f0cfe77f00 = []byte{
-	// 402 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xac, 0x52, 0xc1, 0x6e, 0xd3, 0x40,
-	0x10, 0xc5, 0x89, 0x21, 0xee, 0xa4, 0x09, 0xd1, 0x50, 0x22, 0x8b, 0x53, 0x14, 0x2a, 0x14, 0x71,
-	0x70, 0xa4, 0x20, 0xb8, 0x71, 0x69, 0x92, 0x43, 0x55, 0xe4, 0x5a, 0xeb, 0x04, 0x10, 0x37, 0xef,
-	0x76, 0x5a, 0xaf, 0x70, 0x77, 0x8d, 0x77, 0x5d, 0xd1, 0x3b, 0xbf, 0xc4, 0x7f, 0xf0, 0x49, 0x28,
-	0x36, 0x52, 0x1d, 0x39, 0xe2, 0xd4, 0xdb, 0xce, 0x9b, 0xb7, 0xf3, 0x76, 0xde, 0x3e, 0x38, 0x16,
-	0xa9, 0x34, 0x94, 0x05, 0x79, 0xa1, 0xad, 0x9e, 0x0a, 0x38, 0x89, 0xf8, 0x5a, 0x5d, 0xe5, 0x5a,
-	0x2a, 0xbb, 0x22, 0x23, 0x0a, 0x99, 0x5b, 0x5d, 0xe0, 0x6b, 0x70, 0x99, 0xce, 0xc8, 0x77, 0x26,
-	0xce, 0x6c, 0xb8, 0x78, 0x1e, 0x3c, 0x90, 0x76, 0x30, 0x73, 0x0b, 0x9d, 0x11, 0x22, 0xb8, 0x9b,
-	0xfb, 0x9c, 0xfc, 0xce, 0xc4, 0x99, 0x1d, 0x31, 0xd7, 0xde, 0xe7, 0x15, 0x16, 0x25, 0x36, 0xf5,
-	0xbb, 0x35, 0x96, 0x27, 0x36, 0x9d, 0xfe, 0x76, 0xe0, 0x45, 0xc4, 0x97, 0x69, 0xa2, 0x14, 0x65,
-	0x0d, 0x11, 0x1f, 0x7a, 0x8c, 0xee, 0xa8, 0x30, 0xb5, 0x8e, 0xc7, 0x7a, 0x45, 0x5d, 0xe2, 0x47,
-	0x18, 0xc6, 0xb6, 0xe4, 0x0f, 0xdc, 0x4a, 0xa3, 0xbf, 0x78, 0x19, 0x1c, 0x7a, 0x2d, 0x1b, 0x9a,
-	0x3d, 0x32, 0xae, 0x01, 0xe3, 0xef, 0x94, 0x91, 0xd5, 0xaa, 0x31, 0xa2, 0xfb, 0xbf, 0x11, 0x68,
-	0x5a, 0x17, 0xa6, 0xbf, 0x1c, 0x18, 0x47, 0x3c, 0x26, 0x63, 0xa4, 0x56, 0x4b, 0xad, 0xae, 0xe5,
-	0x0d, 0xa3, 0x1f, 0x25, 0x19, 0x8b, 0xa7, 0x30, 0x58, 0x66, 0x92, 0x94, 0xfd, 0x4c, 0xc5, 0xae,
-	0x5b, 0x2d, 0x70, 0xc4, 0x06, 0xa2, 0x09, 0xe2, 0x0a, 0xb0, 0xb5, 0xb5, 0xf1, 0x3b, 0x93, 0xee,
-	0xac, 0xbf, 0x38, 0x09, 0x0e, 0x58, 0xc2, 0x50, 0xb4, 0xf8, 0xd3, 0x3f, 0x0e, 0x0c, 0x22, 0xbe,
-	0x92, 0x49, 0xd6, 0x50, 0xdf, 0x1a, 0x6a, 0xac, 0x56, 0xdb, 0x37, 0x28, 0x9b, 0x20, 0x7e, 0x80,
-	0x71, 0x4b, 0xe0, 0x5c, 0x5d, 0xd1, 0xcf, 0xca, 0xcc, 0xa7, 0x6c, 0x2c, 0x0e, 0x76, 0x1f, 0xc9,
-	0x3d, 0x7c, 0x05, 0xde, 0xee, 0x0f, 0xc3, 0xe4, 0x96, 0x7c, 0xb7, 0x72, 0xc7, 0x33, 0xff, 0xea,
-	0xb7, 0xef, 0x61, 0xb8, 0x9f, 0x28, 0xec, 0x43, 0x6f, 0x1b, 0x5e, 0x84, 0x97, 0x5f, 0xc2, 0xd1,
-	0x13, 0xf4, 0xc0, 0x8d, 0x37, 0xdb, 0xb3, 0x91, 0x83, 0xc7, 0xe0, 0xc5, 0x17, 0xeb, 0x4f, 0xeb,
-	0xcd, 0x65, 0x38, 0xea, 0x9c, 0xbd, 0xf9, 0x76, 0x7a, 0x23, 0x6d, 0x5a, 0xf2, 0x40, 0xe8, 0xdb,
-	0xf9, 0x57, 0xba, 0xd3, 0xe7, 0x4a, 0xcc, 0xeb, 0x40, 0xcf, 0x45, 0x5a, 0x45, 0x9a, 0x97, 0xd7,
-	0xfc, 0x59, 0x75, 0x7a, 0xf7, 0x37, 0x00, 0x00, 0xff, 0xff, 0x00, 0x75, 0xb2, 0xd0, 0xec, 0x02,
-	0x00, 0x00,
+	// 415 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xad, 0x52, 0x4d, 0x4f, 0xdb, 0x40,
+	0x10, 0xc5, 0xc4, 0x10, 0x33, 0x24, 0x21, 0x9a, 0xd2, 0xc8, 0xe2, 0x84, 0x0c, 0xaa, 0x2a, 0x0e,
+	0x46, 0x02, 0xc1, 0x8d, 0x43, 0xf3, 0x71, 0x88, 0x52, 0x19, 0x6b, 0x93, 0xb4, 0x55, 0x6f, 0xb6,
+	0x19, 0x88, 0x85, 0xd9, 0x35, 0xde, 0x75, 0x68, 0xff, 0x11, 0x7f, 0x80, 0xff, 0xd1, 0x9f, 0xd4,
+	0x8d, 0x5d, 0x29, 0x8e, 0x12, 0x71, 0xea, 0xc5, 0x9e, 0x7d, 0xfb, 0x3c, 0xf3, 0xde, 0xf3, 0x40,
+	0x23, 0x9a, 0xc5, 0x92, 0x12, 0x37, 0xcd, 0x84, 0x12, 0x4e, 0x04, 0x87, 0x7e, 0x38, 0xe0, 0x77,
+	0xa9, 0x88, 0xb9, 0xea, 0x93, 0x8c, 0xb2, 0x38, 0x55, 0x22, 0xc3, 0x13, 0x30, 0x99, 0x48, 0xc8,
+	0x36, 0x8e, 0x8d, 0xcf, 0xad, 0x8b, 0x03, 0x77, 0x49, 0x5a, 0xc0, 0xcc, 0xcc, 0xf4, 0x13, 0x11,
+	0xcc, 0xc9, 0xef, 0x94, 0xec, 0x6d, 0x4d, 0xda, 0x63, 0xa6, 0xd2, 0xf5, 0x02, 0xf3, 0x03, 0x35,
+	0xb3, 0x6b, 0x25, 0x96, 0xea, 0xda, 0x79, 0x33, 0xe0, 0x83, 0x1f, 0xf6, 0x66, 0x01, 0xe7, 0x94,
+	0x54, 0x86, 0xd8, 0x50, 0x67, 0x34, 0xa7, 0x4c, 0x96, 0x73, 0x2c, 0x56, 0xcf, 0xca, 0x23, 0xde,
+	0x40, 0x6b, 0xac, 0xf2, 0x70, 0xc9, 0x2d, 0x66, 0xec, 0x5f, 0x7c, 0x74, 0x37, 0xa9, 0x65, 0x2d,
+	0xb9, 0x42, 0xc6, 0x01, 0xe0, 0xf8, 0x91, 0x12, 0x52, 0x82, 0x57, 0x5a, 0xd4, 0xde, 0x6b, 0x81,
+	0x72, 0xed, 0x03, 0xe7, 0xd5, 0x80, 0x8e, 0x1f, 0x8e, 0x49, 0xca, 0x58, 0xf0, 0x9e, 0xe0, 0xf7,
+	0xf1, 0x03, 0xa3, 0xe7, 0x9c, 0xa4, 0xc2, 0x53, 0x68, 0xf6, 0x92, 0x98, 0xb8, 0xfa, 0xa6, 0xf5,
+	0xea, 0xdb, 0xc2, 0xc0, 0x1e, 0x6b, 0x46, 0x55, 0x10, 0xfb, 0x80, 0x6b, 0xae, 0xa5, 0xb6, 0x52,
+	0xd3, 0x3a, 0x0e, 0xdd, 0x0d, 0x91, 0x30, 0x8c, 0xd6, 0xf8, 0xe8, 0x40, 0xe3, 0x4b, 0x92, 0x88,
+	0x17, 0x3f, 0xc8, 0x54, 0x1c, 0x24, 0x85, 0x0f, 0x8b, 0x35, 0x82, 0x0a, 0xe6, 0xfc, 0x31, 0xa0,
+	0xe9, 0x87, 0x7d, 0x5d, 0x56, 0x14, 0x4e, 0x25, 0x55, 0xec, 0x97, 0x11, 0x37, 0xf3, 0x2a, 0x88,
+	0xd7, 0xd0, 0x59, 0x13, 0x31, 0xe4, 0x77, 0xf4, 0xab, 0x08, 0x7c, 0x87, 0x75, 0xa2, 0x8d, 0xb7,
+	0xff, 0x29, 0x61, 0x3c, 0x02, 0x6b, 0xf1, 0x9f, 0xbd, 0xe0, 0x89, 0x6c, 0xb3, 0x48, 0xd0, 0x92,
+	0xff, 0xce, 0x67, 0x57, 0xd0, 0x5a, 0xdd, 0x3a, 0xdc, 0x87, 0xfa, 0xd4, 0x1b, 0x79, 0xb7, 0xdf,
+	0xbd, 0xf6, 0x16, 0x5a, 0x60, 0x8e, 0x27, 0xd3, 0x6e, 0xdb, 0xc0, 0x86, 0x6e, 0x32, 0x1a, 0x7c,
+	0x1d, 0x4c, 0x6e, 0xbd, 0xf6, 0x76, 0xf7, 0xd3, 0xcf, 0xd3, 0x87, 0x58, 0xcd, 0xf2, 0xd0, 0x8d,
+	0xc4, 0xd3, 0xf9, 0x0f, 0x9a, 0x8b, 0x21, 0x8f, 0xce, 0xcb, 0xa5, 0xd7, 0xaf, 0x62, 0xed, 0xc3,
+	0xfc, 0x3e, 0xdc, 0x2d, 0xaa, 0xcb, 0xbf, 0x04, 0x54, 0x62, 0xb6, 0x10, 0x03, 0x00, 0x00,
 }
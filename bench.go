@@ -0,0 +1,357 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/jpillora/sizestr"
+	chshare "github.com/sammck-go/wstunnel/share"
+)
+
+var benchHelp = `
+  Usage: wstunnel bench [options]
+
+  Measures the throughput and latency of a tunnel, for capacity
+  planning and buffer size tuning. A client is started in-process, with
+  a remote to a benchmark service that is also run in-process, and data
+  is pushed through it in each direction. Results are printed to stdout.
+
+  By default, the server is also run in-process, on the loopback
+  interface, and the remote is a forward remote to the benchmark
+  service, so that each byte crosses the tunnel once.
+
+  With --server, an external server is measured instead. The server
+  must allow reverse remotes (--reverse) and loop endpoints. The remote
+  then goes through a loop endpoint on the server and back to the
+  benchmark service on this host, so that each byte crosses the
+  connection to the server once in each direction.
+
+  Options:
+
+    --server, The URL of an external server to measure, as given to
+    "wstunnel client". Defaults to a server run in-process.
+
+    --auth, --fingerprint, As for "wstunnel client", for an external
+    server.
+
+    --size, The amount of data to send in each direction, in MiB
+    (defaults to 64).
+
+    --samples, The number of round trips of a small message used to
+    measure latency (defaults to 100).
+
+    -v, Enable verbose logging
+
+    --help, This help text
+
+  Version:
+    ` + chshare.BuildVersion + `
+
+  Read more:
+    https://github.com/sammck-go/wstunnel
+
+`
+
+// benchEchoSize is the size of the messages echoed to measure latency
+const benchEchoSize = 64
+
+// Commands sent as the first byte of each connection to the benchmark service
+const (
+	// benchUpload: the service reads until end-of-stream, then replies with the 8-byte count
+	benchUpload = 'u'
+	// benchDownload: the service reads an 8-byte count, then sends that many bytes and closes
+	benchDownload = 'd'
+	// benchEcho: the service echoes everything until end-of-stream
+	benchEcho = 'e'
+)
+
+func bench(ctx context.Context, args []string) {
+
+	flags := flag.NewFlagSet("bench", flag.ContinueOnError)
+
+	serverURL := flags.String("server", "", "")
+	auth := flags.String("auth", "", "")
+	fingerprint := flags.String("fingerprint", "", "")
+	sizeMiB := flags.Int("size", 64, "")
+	samples := flags.Int("samples", 100, "")
+	verbose := flags.Bool("v", false, "")
+	flags.Usage = func() {
+		fmt.Print(benchHelp)
+		os.Exit(1)
+	}
+	flags.Parse(args)
+	if len(flags.Args()) > 0 {
+		log.Fatalf("Unexpected arguments: %q", flags.Args())
+	}
+	if *sizeMiB <= 0 || *samples <= 0 {
+		log.Fatalf("--size and --samples must be positive")
+	}
+	size := int64(*sizeMiB) << 20
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	target, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer target.Close()
+	go serveBenchTarget(target)
+	targetPort := target.Addr().(*net.TCPAddr).Port
+
+	stubPort, err := freeLocalPort()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var remotes []string
+	if *serverURL == "" {
+		s, url, err := startBenchServer(ctx, *verbose)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer s.Close()
+		*serverURL = url
+		*fingerprint = s.GetFingerprint()
+		remotes = []string{fmt.Sprintf("127.0.0.1:%d:127.0.0.1:%d", stubPort, targetPort)}
+	} else {
+		loopName := fmt.Sprintf("wstunnel-bench-%d", os.Getpid())
+		remotes = []string{
+			fmt.Sprintf("tcp://127.0.0.1:%d,loop://%s", stubPort, loopName),
+			fmt.Sprintf("R:loop://%s,tcp://127.0.0.1:%d", loopName, targetPort),
+		}
+	}
+
+	c, err := chshare.NewClient(&chshare.Config{
+		Debug:         *verbose,
+		Server:        *serverURL,
+		Auth:          *auth,
+		Fingerprint:   *fingerprint,
+		ChdStrings:    remotes,
+		MaxRetryCount: 0,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer c.Close()
+	if err := c.Start(ctx); err != nil {
+		log.Fatal(err)
+	}
+	connectCtx, connectCancel := context.WithTimeout(ctx, 30*time.Second)
+	_, err = c.GetSSHConnContext(connectCtx)
+	connectCancel()
+	if err != nil {
+		log.Fatalf("Unable to connect to %s: %s", *serverURL, err)
+	}
+
+	stubAddr := "127.0.0.1:" + strconv.Itoa(stubPort)
+	fmt.Printf("Tunnel to %s, %s in each direction\n", *serverURL, sizestr.ToString(size))
+
+	d, err := benchUploadTo(stubAddr, size)
+	if err != nil {
+		log.Fatalf("Upload failed: %s", err)
+	}
+	fmt.Printf("upload:   %s in %s (%s/s)\n", sizestr.ToString(size), d.Round(time.Millisecond), benchRate(size, d))
+
+	d, err = benchDownloadFrom(stubAddr, size)
+	if err != nil {
+		log.Fatalf("Download failed: %s", err)
+	}
+	fmt.Printf("download: %s in %s (%s/s)\n", sizestr.ToString(size), d.Round(time.Millisecond), benchRate(size, d))
+
+	rtts, err := benchLatency(stubAddr, *samples)
+	if err != nil {
+		log.Fatalf("Latency measurement failed: %s", err)
+	}
+	sort.Slice(rtts, func(i, j int) bool { return rtts[i] < rtts[j] })
+	var total time.Duration
+	for _, rtt := range rtts {
+		total += rtt
+	}
+	fmt.Printf("latency:  %d round trips of %d bytes: min %s, avg %s, median %s, max %s\n",
+		len(rtts), benchEchoSize, rtts[0], total/time.Duration(len(rtts)), rtts[len(rtts)/2], rtts[len(rtts)-1])
+}
+
+// startBenchServer runs a server on the loopback interface, returning it and its URL once it is
+// accepting connections
+func startBenchServer(ctx context.Context, verbose bool) (*chshare.Server, string, error) {
+	port, err := freeLocalPort()
+	if err != nil {
+		return nil, "", err
+	}
+	s, err := chshare.NewServer(&chshare.ProxyServerConfig{
+		KeySeed: "wstunnel-bench",
+		Debug:   verbose,
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	go s.Run(ctx, "127.0.0.1", strconv.Itoa(port))
+	addr := "127.0.0.1:" + strconv.Itoa(port)
+	for deadline := time.Now().Add(10 * time.Second); ; {
+		conn, err := net.Dial("tcp4", addr)
+		if err == nil {
+			conn.Close()
+			break
+		}
+		if time.Now().After(deadline) {
+			s.Close()
+			return nil, "", fmt.Errorf("In-process server did not start: %s", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return s, "http://" + addr, nil
+}
+
+// freeLocalPort returns a TCP port on the loopback interface that was free when checked
+func freeLocalPort() (int, error) {
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
+
+// serveBenchTarget runs the benchmark service on l until it is closed
+func serveBenchTarget(l net.Listener) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			cmd := make([]byte, 1)
+			if _, err := io.ReadFull(conn, cmd); err != nil {
+				return
+			}
+			switch cmd[0] {
+			case benchUpload:
+				n, _ := io.Copy(ioutil.Discard, conn)
+				binary.Write(conn, binary.BigEndian, n)
+			case benchDownload:
+				var n int64
+				if binary.Read(conn, binary.BigEndian, &n) == nil {
+					io.CopyN(conn, zeroReader{}, n)
+				}
+			case benchEcho:
+				io.Copy(conn, conn)
+			}
+		}()
+	}
+}
+
+// zeroReader is an endless source of zero bytes
+type zeroReader struct{}
+
+func (zeroReader) Read(b []byte) (int, error) {
+	for i := range b {
+		b[i] = 0
+	}
+	return len(b), nil
+}
+
+// benchUploadTo sends size bytes through the tunnel at addr, and returns the time until the
+// service has confirmed receiving them all
+func benchUploadTo(addr string, size int64) (time.Duration, error) {
+	conn, err := net.Dial("tcp4", addr)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	t0 := time.Now()
+	if _, err := conn.Write([]byte{benchUpload}); err != nil {
+		return 0, err
+	}
+	if _, err := io.CopyN(conn, zeroReader{}, size); err != nil {
+		return 0, err
+	}
+	if err := conn.(*net.TCPConn).CloseWrite(); err != nil {
+		return 0, err
+	}
+	var n int64
+	if err := binary.Read(conn, binary.BigEndian, &n); err != nil {
+		return 0, fmt.Errorf("No confirmation from the benchmark service: %s", err)
+	}
+	d := time.Since(t0)
+	if n != size {
+		return 0, fmt.Errorf("The benchmark service received %d bytes; expected %d", n, size)
+	}
+	return d, nil
+}
+
+// benchDownloadFrom has the service send size bytes through the tunnel at addr, and returns the
+// time until they have all been received
+func benchDownloadFrom(addr string, size int64) (time.Duration, error) {
+	conn, err := net.Dial("tcp4", addr)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	t0 := time.Now()
+	if _, err := conn.Write([]byte{benchDownload}); err != nil {
+		return 0, err
+	}
+	if err := binary.Write(conn, binary.BigEndian, size); err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(ioutil.Discard, conn)
+	if err != nil {
+		return 0, err
+	}
+	d := time.Since(t0)
+	if n != size {
+		return 0, fmt.Errorf("Received %d bytes; expected %d", n, size)
+	}
+	return d, nil
+}
+
+// benchLatency returns the round trip times of samples small messages echoed by the service
+// through the tunnel at addr
+func benchLatency(addr string, samples int) ([]time.Duration, error) {
+	conn, err := net.Dial("tcp4", addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte{benchEcho}); err != nil {
+		return nil, err
+	}
+	msg := make([]byte, benchEchoSize)
+	reply := make([]byte, benchEchoSize)
+	rtts := make([]time.Duration, 0, samples)
+	for i := 0; i < samples; i++ {
+		t0 := time.Now()
+		if _, err := conn.Write(msg); err != nil {
+			return nil, err
+		}
+		if _, err := io.ReadFull(conn, reply); err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				err = fmt.Errorf("Tunnel closed after %d round trips", i)
+			}
+			return nil, err
+		}
+		rtts = append(rtts, time.Since(t0))
+	}
+	return rtts, nil
+}
+
+// benchRate formats the rate at which size bytes were transferred in d
+func benchRate(size int64, d time.Duration) string {
+	if d <= 0 {
+		return "-"
+	}
+	return sizestr.ToString(int64(float64(size) / d.Seconds()))
+}
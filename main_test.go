@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/sammck-go/wstunnel/pkg/wstchannel"
+)
+
+func TestReadRemotes(t *testing.T) {
+	defaults := wstchannel.NewChannelDescriptorDefaults()
+	input := "# forward\n3000:localhost:80\n\n   \n  R:2222:localhost:22  \n# done\n"
+	remotes, err := readRemotes("remotes.txt", strings.NewReader(input), defaults)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"3000:localhost:80", "R:2222:localhost:22"}
+	if !reflect.DeepEqual(remotes, want) {
+		t.Errorf("readRemotes() = %q, want %q", remotes, want)
+	}
+
+	_, err = readRemotes("remotes.txt", strings.NewReader("3000:localhost:80\n\nR:stdio:localhost:22\n"), defaults)
+	if err == nil {
+		t.Fatalf("readRemotes() succeeded with an invalid remote")
+	}
+	if !strings.HasPrefix(err.Error(), "remotes.txt:3: ") {
+		t.Errorf("readRemotes() error %q does not name the file and line", err)
+	}
+}
+
+func TestExpandRemoteArgs(t *testing.T) {
+	defaults := wstchannel.NewChannelDescriptorDefaults()
+	dir, err := ioutil.TempDir("", "wstunnel-remotes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "remotes.txt")
+	if err := ioutil.WriteFile(file, []byte("3001:localhost:81\n# comment\n3002:localhost:82\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	args := []string{"3000:localhost:80", "@" + file, "-", "stdio:localhost:22"}
+	remotes, err := expandRemoteArgs(args[:3], strings.NewReader("R:2222:localhost:22\n"), defaults)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"3000:localhost:80", "3001:localhost:81", "3002:localhost:82", "R:2222:localhost:22"}
+	if !reflect.DeepEqual(remotes, want) {
+		t.Errorf("expandRemoteArgs() = %q, want %q", remotes, want)
+	}
+
+	if _, err := expandRemoteArgs([]string{"@" + filepath.Join(dir, "missing.txt")}, nil, defaults); err == nil {
+		t.Errorf("expandRemoteArgs() succeeded with a missing remotes file")
+	}
+	if _, err := expandRemoteArgs([]string{"-", "-"}, strings.NewReader(""), defaults); err == nil {
+		t.Errorf("expandRemoteArgs() read remotes from stdin twice")
+	}
+
+	// A stdio remote tunnels stdin, so it cannot be combined with remotes read from stdin
+	if _, err := expandRemoteArgs(args, strings.NewReader("R:2222:localhost:22\n"), defaults); err == nil {
+		t.Errorf("expandRemoteArgs() read remotes from stdin with a stdio stub remote")
+	}
+	if _, err := expandRemoteArgs([]string{"-"}, strings.NewReader("R:2222:stdio\n"), defaults); err == nil {
+		t.Errorf("expandRemoteArgs() read remotes from stdin with a stdio skeleton remote")
+	}
+	remotes, err = expandRemoteArgs([]string{"stdio:localhost:22"}, nil, defaults)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(remotes, []string{"stdio:localhost:22"}) {
+		t.Errorf("expandRemoteArgs() = %q, want a single stdio remote", remotes)
+	}
+}
+
+func TestValidateDescriptors(t *testing.T) {
+	valid := "3000:localhost:80"
+	chd, _, err := wstchannel.ParseChannelDescriptorPath(valid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	invalid := "R:stdio:localhost:22"
+
+	var stdout, stderr bytes.Buffer
+	if failed := validateDescriptors([]string{valid}, &stdout, &stderr); failed != 0 {
+		t.Fatalf("validateDescriptors(%q) = %d failed, want 0; stderr: %s", valid, failed, stderr.String())
+	}
+	if want := "OK \"" + valid + "\" => " + chd.String() + "\n"; stdout.String() != want {
+		t.Errorf("validateDescriptors(%q) printed %q, want %q", valid, stdout.String(), want)
+	}
+	if stderr.Len() != 0 {
+		t.Errorf("validateDescriptors(%q) printed errors: %s", valid, stderr.String())
+	}
+
+	stdout.Reset()
+	stderr.Reset()
+	if failed := validateDescriptors([]string{valid, invalid}, &stdout, &stderr); failed != 1 {
+		t.Fatalf("validateDescriptors(%q, %q) = %d failed, want 1", valid, invalid, failed)
+	}
+	if !strings.Contains(stdout.String(), "OK \""+valid+"\"") {
+		t.Errorf("validateDescriptors() did not report %q as valid: %s", valid, stdout.String())
+	}
+	if !strings.HasPrefix(stderr.String(), "INVALID \""+invalid+"\"") {
+		t.Errorf("validateDescriptors() did not report %q as invalid: %s", invalid, stderr.String())
+	}
+	if !strings.HasSuffix(stderr.String(), "1 of 2 descriptors failed validation\n") {
+		t.Errorf("validateDescriptors() did not report the failure count: %s", stderr.String())
+	}
+}
@@ -190,3 +190,41 @@ func TestBipipeBridge(t *testing.T) {
 		}
 	}
 }
+
+// shortWriteBipipe is a testBipipe that violates the io.Writer contract by writing only part of each
+// buffer without returning an error
+type shortWriteBipipe struct {
+	*testBipipe
+}
+
+func (bp *shortWriteBipipe) Write(p []byte) (n int, err error) {
+	if len(p) < 2 {
+		return bp.testBipipe.Write(p)
+	}
+	return bp.testBipipe.Write(p[:len(p)/2])
+}
+
+func TestBipipeBridgeShortWrite(t *testing.T) {
+	lg, err := logger.New(
+		logger.WithWriter(os.Stderr),
+		logger.WithLogLevel(logger.LogLevelDebug),
+		logger.WithPrefix("TestBipipeBridgeShortWrite"),
+	)
+	if err != nil {
+		t.Fatalf("logger.New() returned error: %s", err)
+	}
+
+	bp0 := NewTestBipipe(t, lg, 0)
+	bp1 := &shortWriteBipipe{NewTestBipipe(t, lg, 1)}
+
+	// A misbehaving Bipipe must fail the bridge rather than panic
+	bb := NewBipipeBridger(lg, bp0, bp1, 32*1024, true)
+
+	err = bb.WaitShutdown()
+	if err == nil {
+		t.Errorf("Bipipe bridge with short-writing Bipipe completed without error")
+	}
+	if !bp0.IsDoneShutdown() || !bp1.IsDoneShutdown() {
+		t.Errorf("Bridged Bipipes were not shut down by failed bridge")
+	}
+}
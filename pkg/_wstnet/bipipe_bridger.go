@@ -143,6 +143,14 @@ func (bb *BipipeBridge) HandleOnceShutdown(completionErr error) error {
 	return finalErr
 }
 
+// contractViolationf logs and returns an error describing a Bipipe that has violated the io.Reader or
+// io.Writer contract. Such an anomaly fails only the bridge, rather than panicking and taking down the process.
+func (bb *BipipeBridge) contractViolationf(format string, args ...interface{}) error {
+	err := fmt.Errorf(format, args...)
+	bb.DLogf("Bipipe contract violation; bridge will be shut down: %s", err)
+	return err
+}
+
 // forwardOneBridgeEdgeDirection is called in its own goroutine; it forwards bytes in one direction from
 // one edge to another, keeping track of byte counts.  If the publishProgress is false, and
 // either srcEdge implements io.WriterTo or dstEdge implements io.ReaderFrom, then io.Copy is
@@ -150,7 +158,8 @@ func (bb *BipipeBridge) HandleOnceShutdown(completionErr error) error {
 // appear to be 0 until the stream is complete or an error occurs. bufferSize specifies the maximum
 // number of bytes to transfer at once; if 0, the default is used (cu 32KB from source code).
 // On successful completion without error, the Write half of dstEdge will have been shut down.
-// On any error, the entire bridge is scheduled for shutdown.
+// On any error, including a Bipipe that misbehaves (e.g., a short write with no error), the entire
+// bridge is scheduled for shutdown.
 // The asyncobj.Helper wait group is signalled when the goroutine completes.
 func (bb *BipipeBridge) forwardOneBridgeEdgeDirection(
 	srcEdge *bipipeBridgeEdge,
@@ -185,33 +194,30 @@ func (bb *BipipeBridge) forwardOneBridgeEdgeDirection(
 		buffer := make([]byte, bufferSize)
 		for {
 			nbr, rerr := src.Read(buffer)
-			bb.TLogf("Bipipe src %v read %v bytes, err=%v", src, nbr, err)
+			bb.TLogf("Bipipe src %v read %v bytes, err=%v", src, nbr, rerr)
 			if nbr > len(buffer) {
-				bb.Panicf("Bipipe src %v read more (%d) bytes than requested (%d)", src, nbr, len(buffer))
-				nbr = len(buffer)
+				rerr = bb.contractViolationf("Bipipe src %v read more (%d) bytes than requested (%d)", src, nbr, len(buffer))
+				nbr = 0
 			} else if nbr < 0 {
-				bb.Panicf("Bipipe src %v read less (%d) than zero bytes", src, nbr)
+				rerr = bb.contractViolationf("Bipipe src %v read less (%d) than zero bytes", src, nbr)
 				nbr = 0
-			}
-			if nbr == 0 && rerr == nil {
-				bb.Panicf("Bipipe src %v read 0 bytes but returned no error", src)
-				rerr = io.EOF
+			} else if nbr == 0 && rerr == nil {
+				rerr = bb.contractViolationf("Bipipe src %v read 0 bytes but returned no error", src)
 			}
 			var werr error = nil
 			var nbw int = 0
 			if nbr > 0 {
 				nbw, werr = dst.Write(buffer[:nbr])
-				bb.TLogf("Bipipe dst %v wrote %v bytes, err=%v", dst, nbw, err)
+				bb.TLogf("Bipipe dst %v wrote %v bytes, err=%v", dst, nbw, werr)
 				if nbw > nbr {
-					bb.Panicf("Bipipe dst %v wrote more (%d) bytes than requested (%d)", dst, nbw, nbr)
+					werr = bb.contractViolationf("Bipipe dst %v wrote more (%d) bytes than requested (%d)", dst, nbw, nbr)
 					nbw = nbr
 				} else if nbw < 0 {
-					bb.Panicf("Bipipe dst %v wrote less (%d) than zero bytes", dst, nbw)
+					werr = bb.contractViolationf("Bipipe dst %v wrote less (%d) than zero bytes", dst, nbw)
 					nbw = 0
-				}
-				if werr == nil && nbw < nbr {
-					bb.Panicf("Bipipe dst %v wrote fewer (%d) bytes than requested (%d) but returned no error", dst, nbw, nbr)
-					werr = io.ErrShortWrite
+				} else if werr == nil && nbw < nbr {
+					werr = bb.contractViolationf("Bipipe dst %v wrote fewer (%d) bytes than requested (%d) but returned no error: %s",
+						dst, nbw, nbr, io.ErrShortWrite)
 				}
 				if nbw > 0 {
 					bb.Lock.Lock()
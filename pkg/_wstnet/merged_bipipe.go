@@ -77,7 +77,7 @@ func NewMergedBipipe(
 		writerIsClosed: false,
 		writerIsReallyClosed: false,
 		writerCloseErr: nil,
-		writerCloseChan: make(chan struct{})
+		writerCloseChan: make(chan struct{}),
 	}
 	bp.Helper = asyncobj.NewHelper(logger.ForkLogStr(bp.name), bp)
 
@@ -4,6 +4,8 @@ import (
 	"context"
 	"fmt"
 	"net"
+	"sync"
+	"time"
 
 	"github.com/sammck-go/asyncobj"
 	"github.com/sammck-go/logger"
@@ -26,11 +28,15 @@ type netBipipeListener struct {
 	slcb startNetBipipeListenerCallback
 
 	// newConns is a chan through which new connections created by the low-level acceptor
-	// goroutine are delivered to Accept() calls. It is unbuffered, so at most one connection
-	// is created ahead of Accept().  In the future, a buffer size could be configured
-	// to allow a backlog of connections to be accepted.
+	// goroutine are delivered to Accept() calls. It is buffered with the configured backlog size,
+	// so at most backlog+1 connections are created ahead of Accept(). With a backlog of 0 it is
+	// unbuffered, and at most one connection is created ahead of Accept().
 	newConns chan net.Conn
 
+	// stopAcceptor is closed when shutdown begins, to unblock the low-level acceptor goroutine
+	// if it is waiting to deliver a connection that no one will accept.
+	stopAcceptor chan struct{}
+
 	// acceptorDone is signalled when the lowlevel acceptor goroutine exits. It is nil until
 	// the goroutine is started
 	acceptorDone chan struct{}
@@ -38,18 +44,32 @@ type netBipipeListener struct {
 	// cleanClose is set to true when shutdown is started if shutdown was not caused by an error. This
 	// is used in error handling for Accept()
 	cleanClose bool
+
+	// drainLinger, if nonzero, is the time allowed for a graceful close of pre-accepted connections
+	// that are drained at shutdown. See SetDrainLinger.
+	drainLinger time.Duration
 }
 
+// NewNetBipipeListenerWithStartCallback creates a BipipeListener that will accept incomming net.Conn
+// connections from a net.Listener created by startCallback when listening starts. backlog is the
+// number of connections that may be accepted ahead of Accept() callers, in addition to the one
+// connection held by the low-level acceptor goroutine. A backlog of 0 allows one connection to be
+// accepted ahead of Accept() callers.
 func NewNetBipipeListenerWithStartCallback(
 	logger logger.Logger,
 	name string,
 	startCallback startNetBipipeListenerCallback,
+	backlog int,
 ) *netBipipeListener {
+	if backlog < 0 {
+		backlog = 0
+	}
 	l := &netBipipeListener{
 		nl:           nil,
 		name:         name,
 		slcb:         startCallback,
-		newConns:     make(chan net.Conn),
+		newConns:     make(chan net.Conn, backlog),
+		stopAcceptor: make(chan struct{}),
 		acceptorDone: nil,
 		cleanClose:   false,
 	}
@@ -69,37 +89,93 @@ func NewNetBipipeListenerWithStartCallback(
 //
 // For Unix domain sockets (network "unix"), the address parameter is the local filesystem pathname
 // of the unix domain socket. if network "unix" is specified and lockUnixSocket is true, then an additional
-// file with a ".lock" extension will be created and locked with flock
+// file with a ".lock" extension will be created and locked with flock (see NewLockedUnixSocketListener).
+//
+// backlog is the number of connections that may be accepted ahead of Accept() callers; see
+// NewNetBipipeListenerWithStartCallback.
+//
+// An error is returned if network is not supported.
 func NewNetBipipeListener(
 	logger logger.Logger,
 	network string,
 	address string,
 	lockUnixSocket bool,
-) *netBipipeListener {
-	name := fmt.Sprintf("%s:%s", network, address)
-	return NewNetBipipeListenerWithStartCallback(
-		logger,
-		name,
-		func() (net.Listener, error) {
+	backlog int,
+) (*netBipipeListener, error) {
+	var startCallback startNetBipipeListenerCallback
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+		startCallback = func() (net.Listener, error) {
 			return net.Listen(network, address)
-		},
-	)
-	l := &netBipipeListener{
-		nl:           nil,
-		name:         name,
-		slcb:         startCallback,
-		newConns:     make(chan net.Conn),
-		acceptorDone: nil,
-		cleanClose:   false,
+		}
+	case "unix":
+		if lockUnixSocket {
+			startCallback = func() (net.Listener, error) {
+				return NewLockedUnixSocketListener(logger, address)
+			}
+		} else {
+			startCallback = func() (net.Listener, error) {
+				return net.Listen(network, address)
+			}
+		}
+	default:
+		return nil, fmt.Errorf("Unsupported BipipeListener network \"%s\"; must be \"tcp\", \"tcp4\", \"tcp6\", or \"unix\"", network)
 	}
-	l.Helper = asyncobj.NewHelper(logger, l)
-	return l
+	name := fmt.Sprintf("%s:%s", network, address)
+	return NewNetBipipeListenerWithStartCallback(logger, name, startCallback, backlog), nil
 }
 
 func (l *netBipipeListener) String() string {
 	return l.name
 }
 
+// SetDrainLinger enables a graceful close of connections that were accepted from dialing clients but
+// never delivered to an Accept() caller, when the listener is shut down. Rather than closing them
+// outright (which may reset the connection), the write side is shut down first (e.g., sending a TCP FIN),
+// and any data from the client is discarded for up to linger, or until the client closes its side,
+// before the connection is closed. Shutdown is delayed by at most linger. A linger of 0 (the default)
+// closes drained connections immediately. Must be called before listening starts.
+func (l *netBipipeListener) SetDrainLinger(linger time.Duration) {
+	l.Lock.Lock()
+	l.drainLinger = linger
+	l.Lock.Unlock()
+}
+
+// closeDrained closes connections that will never be delivered to an Accept() caller, gracefully if
+// a drain linger is configured. It returns within the drain linger.
+func (l *netBipipeListener) closeDrained(ncs []net.Conn) {
+	if l.drainLinger <= 0 {
+		for _, nc := range ncs {
+			nc.Close()
+		}
+		return
+	}
+	deadline := time.Now().Add(l.drainLinger)
+	var wg sync.WaitGroup
+	for _, nc := range ncs {
+		wg.Add(1)
+		go func(nc net.Conn) {
+			defer wg.Done()
+			defer nc.Close()
+			cw, ok := nc.(interface{ CloseWrite() error })
+			if !ok || cw.CloseWrite() != nil {
+				return
+			}
+			// discarding the client's data until it closes avoids a reset due to unread data
+			if nc.SetReadDeadline(deadline) != nil {
+				return
+			}
+			buf := make([]byte, 1024)
+			for {
+				if _, err := nc.Read(buf); err != nil {
+					return
+				}
+			}
+		}(nc)
+	}
+	wg.Wait()
+}
+
 // HandleOnceShutdown will be called exactly once, in its own goroutine. It should take completionError
 // as an advisory completion value, actually shut down, then return the real completion value.
 func (l *netBipipeListener) HandleOnceShutdown(completionErr error) error {
@@ -107,6 +183,8 @@ func (l *netBipipeListener) HandleOnceShutdown(completionErr error) error {
 	l.cleanClose = (completionErr == nil)
 	l.Lock.Unlock()
 
+	close(l.stopAcceptor)
+
 	if l.nl != nil {
 		err := l.nl.Close()
 		if completionErr == nil {
@@ -119,16 +197,19 @@ func (l *netBipipeListener) HandleOnceShutdown(completionErr error) error {
 			// After this, no new pre-accepted connections will be added.
 			<-l.acceptorDone
 
-			// drain and abandon any pre-accepted connections
+			// drain and abandon any pre-accepted connections, including
+			// any queued in the backlog
+			var drained []net.Conn
 		DRAIN:
 			for {
 				select {
 				case nc := <-l.newConns:
-					nc.Close()
+					drained = append(drained, nc)
 				default:
 					break DRAIN
 				}
 			}
+			l.closeDrained(drained)
 		}
 	}
 
@@ -153,8 +234,12 @@ func (l *netBipipeListener) HandleOnceActivate() error {
 					l.StartShutdown(err)
 					break
 				} else {
-					// This will block until someone calls Accept or the chan is drained by HandleOnceShutdown
-					l.newConns <- nc
+					// This will block until someone calls Accept, there is room in the backlog, or shutdown starts
+					select {
+					case l.newConns <- nc:
+					case <-l.stopAcceptor:
+						l.closeDrained([]net.Conn{nc})
+					}
 				}
 
 			}
@@ -171,7 +256,7 @@ func (l *netBipipeListener) HandleOnceActivate() error {
 // made, there is no way to stop listening or prevent this BipipeListener from acknowledging connections
 // from dialing clients, other than shutting down the listener.--if noone calls Accept, then clients
 // will successfully connect but find the connection unresponsive. When the listener is shut down, any clients that
-// connected but have not yet been accepted will be rudely disconnected.
+// connected but have not yet been accepted will be rudely disconnected, unless SetDrainLinger was used.
 func (l *netBipipeListener) StartListening() error {
 	return l.DoOnceActivate(nil, false)
 }
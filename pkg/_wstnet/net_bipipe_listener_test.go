@@ -0,0 +1,173 @@
+package wstnet
+
+import (
+	"context"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sammck-go/logger"
+)
+
+// The go tool skips directories whose names begin with "_" when matching "./...", so these tests
+// only run when the package is named explicitly, e.g. "go test ./pkg/_wstnet".
+
+func newTestListenerLogger(t *testing.T, prefix string) logger.Logger {
+	lg, err := logger.New(
+		logger.WithWriter(os.Stderr),
+		logger.WithLogLevel(logger.LogLevelDebug),
+		logger.WithPrefix(prefix),
+	)
+	if err != nil {
+		t.Fatalf("logger.New() returned error: %s", err)
+	}
+	return lg
+}
+
+func testNetBipipeListenerAccept(t *testing.T, network string, address string, lockUnixSocket bool) {
+	lg := newTestListenerLogger(t, "TestNetBipipeListener")
+
+	l, err := NewNetBipipeListener(lg, network, address, lockUnixSocket, 0)
+	if err != nil {
+		t.Fatalf("NewNetBipipeListener(\"%s\", \"%s\") returned error: %s", network, address, err)
+	}
+	defer l.Close()
+
+	err = l.StartListening()
+	if err != nil {
+		if network == "tcp6" {
+			t.Skipf("IPv6 not available: %s", err)
+		}
+		t.Fatalf("%v StartListening() returned error: %s", l, err)
+	}
+
+	nc, err := net.Dial(network, l.nl.Addr().String())
+	if err != nil {
+		t.Fatalf("net.Dial(\"%s\", \"%s\") returned error: %s", network, l.nl.Addr(), err)
+	}
+	defer nc.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	bp, _, err, _ := l.AcceptWithContext(ctx)
+	if err != nil {
+		t.Fatalf("%v AcceptWithContext() returned error: %s", l, err)
+	}
+
+	msg := []byte("hello")
+	_, err = nc.Write(msg)
+	if err != nil {
+		t.Fatalf("Write to dialed connection failed: %s", err)
+	}
+	buf := make([]byte, len(msg))
+	_, err = bp.Read(buf)
+	if err != nil {
+		t.Fatalf("Read from accepted Bipipe failed: %s", err)
+	}
+	if string(buf) != string(msg) {
+		t.Errorf("Accepted Bipipe read \"%s\"; expected \"%s\"", buf, msg)
+	}
+	bp.Close()
+}
+
+func TestNetBipipeListenerTCP(t *testing.T) {
+	testNetBipipeListenerAccept(t, "tcp", "127.0.0.1:0", false)
+}
+
+func TestNetBipipeListenerTCP4(t *testing.T) {
+	testNetBipipeListenerAccept(t, "tcp4", "127.0.0.1:0", false)
+}
+
+func TestNetBipipeListenerTCP6(t *testing.T) {
+	testNetBipipeListenerAccept(t, "tcp6", "[::1]:0", false)
+}
+
+func TestNetBipipeListenerUnix(t *testing.T) {
+	testNetBipipeListenerAccept(t, "unix", filepath.Join(t.TempDir(), "test.sock"), false)
+}
+
+func TestNetBipipeListenerLockedUnix(t *testing.T) {
+	testNetBipipeListenerAccept(t, "unix", filepath.Join(t.TempDir(), "accept.sock"), true)
+
+	lg := newTestListenerLogger(t, "TestNetBipipeListener")
+	path := filepath.Join(t.TempDir(), "test.sock")
+	l, err := NewNetBipipeListener(lg, "unix", path, true, 0)
+	if err != nil {
+		t.Fatalf("NewNetBipipeListener(\"unix\", \"%s\") returned error: %s", path, err)
+	}
+	defer l.Close()
+	err = l.StartListening()
+	if err != nil {
+		t.Fatalf("%v StartListening() returned error: %s", l, err)
+	}
+
+	// the lock file is held while the listener is open, and keeps a second listener off the path
+	if _, err := os.Stat(path + ".lock"); err != nil {
+		t.Errorf("Lock file for locked unix socket listener was not created: %s", err)
+	}
+	l2, err := NewNetBipipeListener(lg, "unix", path, true, 0)
+	if err == nil {
+		err = l2.StartListening()
+		l2.Close()
+	}
+	if err == nil {
+		t.Errorf("A second locked unix socket listener on %s did not fail", path)
+	}
+
+	// and is removed when it is closed
+	l.Close()
+	if _, err := os.Stat(path + ".lock"); !os.IsNotExist(err) {
+		t.Errorf("Lock file for locked unix socket listener was not removed on close: %v", err)
+	}
+}
+
+func TestNetBipipeListenerUnsupportedNetwork(t *testing.T) {
+	lg := newTestListenerLogger(t, "TestNetBipipeListener")
+
+	_, err := NewNetBipipeListener(lg, "udp", "127.0.0.1:0", false, 0)
+	if err == nil {
+		t.Errorf("NewNetBipipeListener(\"udp\") did not return an error")
+	}
+}
+
+func TestNetBipipeListenerDrainLinger(t *testing.T) {
+	lg := newTestListenerLogger(t, "TestNetBipipeListener")
+
+	l, err := NewNetBipipeListener(lg, "tcp", "127.0.0.1:0", false, 0)
+	if err != nil {
+		t.Fatalf("NewNetBipipeListener() returned error: %s", err)
+	}
+	linger := 200 * time.Millisecond
+	l.SetDrainLinger(linger)
+	err = l.StartListening()
+	if err != nil {
+		t.Fatalf("%v StartListening() returned error: %s", l, err)
+	}
+
+	nc, err := net.Dial("tcp", l.nl.Addr().String())
+	if err != nil {
+		t.Fatalf("net.Dial() returned error: %s", err)
+	}
+	defer nc.Close()
+	// unread data would cause an abrupt close to reset the connection
+	if _, err = nc.Write([]byte("hello")); err != nil {
+		t.Fatalf("Write to dialed connection failed: %s", err)
+	}
+	// give the acceptor goroutine time to pre-accept the connection
+	time.Sleep(50 * time.Millisecond)
+
+	start := time.Now()
+	l.Close()
+	if elapsed := time.Since(start); elapsed > linger+time.Second {
+		t.Errorf("Shutdown with drain linger %s took %s", linger, elapsed)
+	}
+
+	nc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 16)
+	if _, err = nc.Read(buf); err != io.EOF {
+		t.Errorf("Read from drained connection returned %v; expected a clean EOF", err)
+	}
+}
@@ -0,0 +1,172 @@
+package wstchannel
+
+import (
+	"context"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultProgressBufferSize is the buffer size used in each direction of a bridge that reports
+// progress, if no channel buffer size is set (see WithChannelBufferSize)
+const DefaultProgressBufferSize = 32 * 1024
+
+// BridgeProgress is a snapshot of the cumulative number of bytes transferred by a bridge
+type BridgeProgress struct {
+	// CallerToService is the number of bytes transferred from the caller to the called service
+	CallerToService int64
+
+	// ServiceToCaller is the number of bytes transferred from the called service to the caller
+	ServiceToCaller int64
+
+	// Done is true for the final report, made when the bridge has ended; its counts are the
+	// exact totals returned by the bridge
+	Done bool
+}
+
+// BridgeProgressFunc is called with progress reports for a bridge. Reports for a single bridge
+// are never made concurrently. It is called on the copying goroutines, so it should return promptly.
+type BridgeProgressFunc func(progress BridgeProgress)
+
+// bridgeProgressConfig is the progress reporting requested with WithBridgeProgress
+type bridgeProgressConfig struct {
+	f          BridgeProgressFunc
+	everyBytes int64
+	interval   time.Duration
+}
+
+type bridgeProgressKey struct{}
+
+// WithBridgeProgress returns a context that causes BasicBridgeChannels, and so the DialAndServe and
+// AcceptAndServe methods of endpoints that use it, to call f with cumulative byte counts whenever
+// a further everyBytes bytes have been transferred (in either direction), and/or every interval,
+// and once more with the final totals when the bridge ends. A zero everyBytes or interval disables
+// that trigger. Progress reporting is opt-in because it forces buffered copying, which bypasses the
+// io.WriterTo/io.ReaderFrom fast path. Loop endpoints coupled directly by DialAndServe report
+// progress through the bridge run by the accepting side.
+func WithBridgeProgress(ctx context.Context, everyBytes int64, interval time.Duration, f BridgeProgressFunc) context.Context {
+	return context.WithValue(ctx, bridgeProgressKey{}, &bridgeProgressConfig{f: f, everyBytes: everyBytes, interval: interval})
+}
+
+func getBridgeProgressConfig(ctx context.Context) *bridgeProgressConfig {
+	if ctx == nil {
+		return nil
+	}
+	cfg, _ := ctx.Value(bridgeProgressKey{}).(*bridgeProgressConfig)
+	if cfg == nil || cfg.f == nil {
+		return nil
+	}
+	return cfg
+}
+
+// bridgeProgress tracks the byte counts of a bridge that reports progress
+type bridgeProgress struct {
+	cfg             *bridgeProgressConfig
+	callerToService int64
+	serviceToCaller int64
+	lastReported    int64
+	reportLock      sync.Mutex
+	stop            chan struct{}
+	stopped         chan struct{}
+}
+
+func newBridgeProgress(cfg *bridgeProgressConfig) *bridgeProgress {
+	p := &bridgeProgress{cfg: cfg, stop: make(chan struct{}), stopped: make(chan struct{})}
+	if cfg.interval > 0 {
+		go p.tick()
+	} else {
+		close(p.stopped)
+	}
+	return p
+}
+
+func (p *bridgeProgress) tick() {
+	defer close(p.stopped)
+	ticker := time.NewTicker(p.cfg.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			p.report(false)
+		case <-p.stop:
+			return
+		}
+	}
+}
+
+// report calls the progress function with the current counts
+func (p *bridgeProgress) report(done bool) {
+	p.reportLock.Lock()
+	defer p.reportLock.Unlock()
+	progress := BridgeProgress{
+		CallerToService: atomic.LoadInt64(&p.callerToService),
+		ServiceToCaller: atomic.LoadInt64(&p.serviceToCaller),
+		Done:            done,
+	}
+	p.lastReported = progress.CallerToService + progress.ServiceToCaller
+	p.cfg.f(progress)
+}
+
+// add records n more bytes copied into *counter, reporting if everyBytes has been reached
+func (p *bridgeProgress) add(counter *int64, n int64) {
+	atomic.AddInt64(counter, n)
+	if p.cfg.everyBytes <= 0 {
+		return
+	}
+	total := atomic.LoadInt64(&p.callerToService) + atomic.LoadInt64(&p.serviceToCaller)
+	p.reportLock.Lock()
+	due := total-p.lastReported >= p.cfg.everyBytes
+	p.reportLock.Unlock()
+	if due {
+		p.report(false)
+	}
+}
+
+// finish stops periodic reports and makes the final report with the bridge's exact totals
+func (p *bridgeProgress) finish(callerToService int64, serviceToCaller int64) {
+	close(p.stop)
+	<-p.stopped
+	atomic.StoreInt64(&p.callerToService, callerToService)
+	atomic.StoreInt64(&p.serviceToCaller, serviceToCaller)
+	p.report(true)
+}
+
+// copy copies from src to dst like io.CopyBuffer, always through a buffer of bufferSize bytes (or
+// DefaultProgressBufferSize if 0), recording progress in *counter as each write completes
+func (p *bridgeProgress) copy(dst io.Writer, src io.Reader, counter *int64, bufferSize int) (int64, error) {
+	size := bufferSize
+	if size == 0 {
+		size = DefaultProgressBufferSize
+	}
+	buf := make([]byte, size)
+	var written int64
+	for {
+		nr, rerr := src.Read(buf)
+		if nr > 0 {
+			nw, werr := dst.Write(buf[:nr])
+			if nw < 0 || nw > nr {
+				nw = 0
+				if werr == nil {
+					werr = io.ErrShortWrite
+				}
+			}
+			if nw > 0 {
+				written += int64(nw)
+				p.add(counter, int64(nw))
+			}
+			if werr != nil {
+				return written, werr
+			}
+			if nw != nr {
+				return written, io.ErrShortWrite
+			}
+		}
+		if rerr != nil {
+			if rerr == io.EOF {
+				return written, nil
+			}
+			return written, rerr
+		}
+	}
+}
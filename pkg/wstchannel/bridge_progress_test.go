@@ -0,0 +1,43 @@
+package wstchannel
+
+import (
+	"bytes"
+	"context"
+	"testing"
+)
+
+func TestBridgeProgress(t *testing.T) {
+	var reports []BridgeProgress
+	ctx := WithBridgeProgress(context.Background(), 10000, 0, func(p BridgeProgress) {
+		reports = append(reports, p)
+	})
+	cfg := getBridgeProgressConfig(ctx)
+	if cfg == nil {
+		t.Fatalf("WithBridgeProgress() config not found in context")
+	}
+	if getBridgeProgressConfig(context.Background()) != nil {
+		t.Errorf("getBridgeProgressConfig() found config in a plain context")
+	}
+
+	data := bytes.Repeat([]byte("0123456789abcdef"), 4096)
+	p := newBridgeProgress(cfg)
+	var dst bytes.Buffer
+	n, err := p.copy(&dst, bytes.NewReader(data), &p.callerToService, 0)
+	if err != nil || n != int64(len(data)) || !bytes.Equal(dst.Bytes(), data) {
+		t.Fatalf("copy() = (%d, %v), want (%d, nil)", n, err, len(data))
+	}
+	p.finish(n, 0)
+
+	if len(reports) < 2 {
+		t.Fatalf("Expected periodic reports before the final one, got %v", reports)
+	}
+	for _, r := range reports[:len(reports)-1] {
+		if r.Done || r.CallerToService > n {
+			t.Errorf("Unexpected intermediate report %+v", r)
+		}
+	}
+	final := reports[len(reports)-1]
+	if !final.Done || final.CallerToService != n || final.ServiceToCaller != 0 {
+		t.Errorf("Final report %+v, want exact totals %d/0", final, n)
+	}
+}
@@ -0,0 +1,128 @@
+package wstchannel
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Bridge tracing records the bytes copied by BasicBridgeChannels in both directions, for debugging
+// protocol problems through a tunnel. It is off unless enabled with WithBridgeTrace, since the
+// traced traffic may be private and tracing forces buffered copying. The bytes are recorded as
+// they are copied, and are not altered.
+//
+// The capture file is text: one header line per chunk, giving the time, the direction and the
+// length, followed by a hex dump of the chunk:
+//
+//	2024-01-02T15:04:05.000000001Z caller->service 5 bytes
+//	00000000  68 65 6c 6c 6f                                    |hello|
+
+type bridgeTraceKey struct{}
+
+type bridgeTraceConfig struct {
+	path    string
+	hexDump bool
+}
+
+// WithBridgeTrace returns a context that causes BasicBridgeChannels to record the traffic it
+// bridges. If path is not empty, every chunk is appended to the capture file at path, which is
+// flushed and closed when the bridge ends. If hexDump is true, every chunk is also logged as a hex
+// dump at trace log level. If path is empty and hexDump is false, ctx is returned unchanged.
+func WithBridgeTrace(ctx context.Context, path string, hexDump bool) context.Context {
+	if path == "" && !hexDump {
+		return ctx
+	}
+	return context.WithValue(ctx, bridgeTraceKey{}, &bridgeTraceConfig{path: path, hexDump: hexDump})
+}
+
+func getBridgeTraceConfig(ctx context.Context) *bridgeTraceConfig {
+	if ctx == nil {
+		return nil
+	}
+	cfg, _ := ctx.Value(bridgeTraceKey{}).(*bridgeTraceConfig)
+	return cfg
+}
+
+// bridgeTracer records the traffic of a single bridge
+type bridgeTracer struct {
+	logger  Logger
+	hexDump bool
+	lock    sync.Mutex
+	file    *os.File
+	w       *bufio.Writer
+}
+
+// newBridgeTracer creates the tracer for a bridge. A capture file that cannot be opened is logged
+// and skipped; it does not prevent the bridge from running.
+func newBridgeTracer(logger Logger, cfg *bridgeTraceConfig) *bridgeTracer {
+	t := &bridgeTracer{logger: logger, hexDump: cfg.hexDump}
+	if cfg.path != "" {
+		f, err := os.OpenFile(cfg.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+		if err != nil {
+			logger.ILogf("Unable to open trace capture file, not capturing: %s", err)
+		} else {
+			logger.ILogf("Capturing bridged traffic to %s", cfg.path)
+			t.file = f
+			t.w = bufio.NewWriter(f)
+		}
+	}
+	return t
+}
+
+// record records a chunk copied in one direction
+func (t *bridgeTracer) record(direction string, b []byte) {
+	if t.hexDump {
+		t.logger.TLogf("%s %d bytes:\n%s", direction, len(b), hex.Dump(b))
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.w == nil {
+		return
+	}
+	fmt.Fprintf(t.w, "%s %s %d bytes\n", time.Now().UTC().Format(time.RFC3339Nano), direction, len(b))
+	d := hex.Dumper(t.w)
+	d.Write(b)
+	d.Close()
+}
+
+// close flushes and closes the capture file, if any
+func (t *bridgeTracer) close() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.w == nil {
+		return
+	}
+	err := t.w.Flush()
+	if cerr := t.file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		t.logger.ILogf("Error writing trace capture file: %s", err)
+	}
+	t.w = nil
+	t.file = nil
+}
+
+// traceChannelConn wraps the source ChannelConn of one direction of a bridge, recording every
+// chunk read from it. Reads are passed through unchanged.
+type traceChannelConn struct {
+	ChannelConn
+	tracer    *bridgeTracer
+	direction string
+}
+
+func (c *traceChannelConn) Read(b []byte) (int, error) {
+	n, err := c.ChannelConn.Read(b)
+	if n > 0 {
+		c.tracer.record(c.direction, b[:n])
+	}
+	return n, err
+}
+
+func (c *traceChannelConn) String() string {
+	return fmt.Sprintf("%s", c.ChannelConn)
+}
@@ -0,0 +1,52 @@
+package wstchannel
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBridgeTrace(t *testing.T) {
+	ctx := context.Background()
+	if WithBridgeTrace(ctx, "", false) != ctx || getBridgeTraceConfig(ctx) != nil {
+		t.Errorf("Bridge tracing is not off by default")
+	}
+	cfg := getBridgeTraceConfig(WithBridgeTrace(ctx, "/tmp/chan.dump", true))
+	if cfg == nil || cfg.path != "/tmp/chan.dump" || !cfg.hexDump {
+		t.Errorf("getBridgeTraceConfig() = %+v", cfg)
+	}
+
+	dir, err := ioutil.TempDir("", "bridge-trace")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "chan.dump")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracer := &bridgeTracer{file: f, w: bufio.NewWriter(f)}
+	b := []byte("hello\x00\xff")
+	tracer.record("caller->service", b)
+	tracer.record("service->caller", []byte("world"))
+	tracer.close()
+	tracer.close()
+	if string(b) != "hello\x00\xff" {
+		t.Errorf("record() altered the traced bytes")
+	}
+	capture, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"caller->service 7 bytes\n" + hex.Dump(b), "service->caller 5 bytes\n"} {
+		if !strings.Contains(string(capture), want) {
+			t.Errorf("Capture file %q does not contain %q", capture, want)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package wstchannel
 
 import (
 	"context"
+	"fmt"
 	"io"
 	"sync"
 	"sync/atomic"
@@ -12,6 +13,17 @@ import (
 
 var lastBasicBridgeNum int64 = 0
 
+// copyChannel copies from src to dst until end-of-stream, through a buffer of bufferSize bytes,
+// or with io.Copy's own buffering if bufferSize is 0 (see WithChannelBufferSize)
+func copyChannel(dst io.Writer, src io.Reader, bufferSize int) (int64, error) {
+	if bufferSize == 0 {
+		return io.Copy(dst, src)
+	}
+	// hide io.WriterTo/io.ReaderFrom, so the copy goes through our bounded buffer rather than
+	// whatever buffering src or dst would otherwise choose
+	return io.CopyBuffer(struct{ io.Writer }{dst}, struct{ io.Reader }{src}, make([]byte, bufferSize))
+}
+
 // BasicBridgeChannels connects two ChannelConn's together, copying betweeen them bi-directionally
 // until end-of-stream is reached in both directions. Both channels are closed before this function
 // returns. Three values are returned:
@@ -19,10 +31,15 @@ var lastBasicBridgeNum int64 = 0
 //    Number of bytes transferred from calledService to caller
 //    If io.Copy() returned an error in either direction, the error value.
 //
-// CloseWrite() is called on each channel after transfer to that channel is complete.
+// CloseWrite() is called on each channel after transfer to that channel is complete. If a channel
+// also implements ReadHalfCloser, CloseRead() is called on it after end-of-stream is cleanly read from it.
 //
-// Currently the context is not used and there is no way to cancel the bridge without closing
-// one of the ChannelConn's.
+// If the context was created with WithBridgeProgress, progress is reported as the bridge runs.
+// If it was created with WithChannelBufferSize, each direction is copied through a buffer of that size.
+// If it was created with WithHalfCloseLinger, both ChannelConn's are force closed when one direction
+// has ended and the other does not complete in time, and an error wrapping ErrHalfCloseLinger is
+// returned. If it was created with WithBridgeTrace, the bridged bytes are recorded. Otherwise the
+// context is not used. There is no way to cancel the bridge without closing one of the ChannelConn's.
 func BasicBridgeChannels(
 	ctx context.Context,
 	logger Logger,
@@ -34,23 +51,71 @@ func BasicBridgeChannels(
 	logger.DLogf("Starting")
 	var callerToServiceBytes, serviceToCallerBytes int64
 	var callerToServiceErr, serviceToCallerErr error
+	bufferSize := getChannelBufferSize(ctx)
+	var progress *bridgeProgress
+	if cfg := getBridgeProgressConfig(ctx); cfg != nil {
+		progress = newBridgeProgress(cfg)
+	}
+	var tracer *bridgeTracer
+	if cfg := getBridgeTraceConfig(ctx); cfg != nil {
+		tracer = newBridgeTracer(logger, cfg)
+	}
+	linger := newHalfCloseLinger(getHalfCloseLinger(ctx), func() {
+		logger.DLogf("Half-close linger timeout expired with one direction still open; force closing")
+		calledService.Close()
+		caller.Close()
+	})
 	var wg sync.WaitGroup
 	wg.Add(2)
-	copyFunc := func(src ChannelConn, dst ChannelConn, bytesCopied *int64, copyErr *error) {
-		// Copy from caller to calledService
-		*bytesCopied, *copyErr = io.Copy(dst, src)
+	copyFunc := func(src ChannelConn, dst ChannelConn, bytesCopied *int64, copyErr *error, callerToService bool) {
+		var reader ChannelConn = src
+		if tracer != nil {
+			direction := "service->caller"
+			if callerToService {
+				direction = "caller->service"
+			}
+			reader = &traceChannelConn{ChannelConn: src, tracer: tracer, direction: direction}
+		}
+		if progress == nil {
+			*bytesCopied, *copyErr = copyChannel(dst, reader, bufferSize)
+		} else {
+			counter := &progress.serviceToCaller
+			if callerToService {
+				counter = &progress.callerToService
+			}
+			*bytesCopied, *copyErr = progress.copy(dst, reader, counter, bufferSize)
+		}
 		if *copyErr != nil {
 			logger.DLogf("io.Copy(%s->%s) returned error: %s", src, dst, *copyErr)
 		}
 		logger.DLogf("Done with io.Copy(%s->%s); shutting down write side", src, dst)
 		dst.CloseWrite()
 		logger.DLogf("Done with write side shutdown of %s->%s", src, dst)
+		if *copyErr == nil {
+			// src has reached end-of-stream, so no further data can arrive; release its read side
+			// early if the transport supports it. Not done on error, so nothing in flight is dropped.
+			if rhc, ok := src.(ReadHalfCloser); ok {
+				logger.DLogf("Shutting down read side of %s", src)
+				err := rhc.CloseRead()
+				if err != nil {
+					logger.DLogf("CloseRead of %s failed, ignoring: %s", src, err)
+				}
+			}
+		}
+		linger.directionDone()
 		wg.Done()
 	}
-	go copyFunc(caller, calledService, &callerToServiceBytes, &callerToServiceErr)
-	go copyFunc(calledService, caller, &serviceToCallerBytes, &serviceToCallerErr)
+	go copyFunc(caller, calledService, &callerToServiceBytes, &callerToServiceErr, true)
+	go copyFunc(calledService, caller, &serviceToCallerBytes, &serviceToCallerErr, false)
 	wg.Wait()
 	logger.DLogf("Wait complete")
+	lingerExpired := linger.stop()
+	if tracer != nil {
+		tracer.close()
+	}
+	if progress != nil {
+		progress.finish(callerToServiceBytes, serviceToCallerBytes)
+	}
 	logger.DLogf("callerToService=%d, err=%s", callerToServiceBytes, callerToServiceErr)
 	logger.DLogf("serviceToCaller=%d, err=%s", serviceToCallerBytes, serviceToCallerErr)
 	logger.DLogf("Closing calledService")
@@ -61,6 +126,9 @@ func BasicBridgeChannels(
 	if err == nil {
 		err = serviceToCallerErr
 	}
+	if lingerExpired {
+		err = fmt.Errorf("%w after %s; force closed", ErrHalfCloseLinger, linger.linger)
+	}
 	logger.DLogf("Exiting, callerToService=%d, serviceToCaller=%d, err=%s", callerToServiceBytes, serviceToCallerBytes, err)
 	return callerToServiceBytes, serviceToCallerBytes, err
 }
@@ -83,4 +151,11 @@ type LocalChannelEnv interface {
 	// a listener on the client accepts a connection before the server has ackknowledged
 	// configuration. An error response indicates that the SSH connection failed to initialize.
 	GetSSHConn() (ssh.Conn, error)
+
+	// GetSSHConnContext is like GetSSHConn, but gives up and returns an error if ctx is
+	// cancelled or reaches its deadline before the ssh.Conn is available.
+	GetSSHConnContext(ctx context.Context) (ssh.Conn, error)
+
+	// GetChannelType returns the SSH channel type used to open tunnelled channels to the remote proxy
+	GetChannelType() string
 }
@@ -0,0 +1,54 @@
+package wstchannel
+
+import (
+	"context"
+	"fmt"
+)
+
+// MinChannelBufferSize is the smallest nonzero channel buffer size accepted by
+// ValidateChannelBufferSize
+const MinChannelBufferSize = 1024
+
+// ChannelBufferEnv may be implemented by a LocalChannelEnv to provide the size of the buffer used
+// in each direction of the bridges it runs (see WithChannelBufferSize)
+type ChannelBufferEnv interface {
+	// GetChannelBufferSize returns the channel buffer size, or 0 for the default
+	GetChannelBufferSize() int
+}
+
+// GetEnvChannelBufferSize returns the channel buffer size provided by env, or 0 if it does not
+// implement ChannelBufferEnv
+func GetEnvChannelBufferSize(env LocalChannelEnv) int {
+	benv, ok := env.(ChannelBufferEnv)
+	if !ok {
+		return 0
+	}
+	return benv.GetChannelBufferSize()
+}
+
+// ValidateChannelBufferSize returns an error if size is neither 0 nor at least
+// MinChannelBufferSize
+func ValidateChannelBufferSize(size int) error {
+	if size != 0 && size < MinChannelBufferSize {
+		return fmt.Errorf("Invalid channel buffer size %d: must be 0 or at least %d", size, MinChannelBufferSize)
+	}
+	return nil
+}
+
+type channelBufferSizeKey struct{}
+
+// WithChannelBufferSize returns a context that causes BasicBridgeChannels to copy each direction
+// through a buffer of exactly size bytes, which bounds the number of bytes the bridge holds in
+// flight for a stalled channel. A size of 0 uses io.Copy's own buffering, which may hand the copy
+// to src or dst if either supports io.WriterTo or io.ReaderFrom.
+func WithChannelBufferSize(ctx context.Context, size int) context.Context {
+	return context.WithValue(ctx, channelBufferSizeKey{}, size)
+}
+
+func getChannelBufferSize(ctx context.Context) int {
+	if ctx == nil {
+		return 0
+	}
+	size, _ := ctx.Value(channelBufferSizeKey{}).(int)
+	return size
+}
@@ -21,3 +21,18 @@ type ChannelConn interface {
 	// GetNumBytesWritten returns the number of bytes written so far on a ChannelConn
 	GetNumBytesWritten() uint64
 }
+
+// OriginalDestinationConn is an optional interface implemented by ChannelConns accepted by a transparent
+// proxy stub. It provides the destination that the caller originally connected to before being redirected
+// to the stub, so that the remote skeleton can connect there instead of to its configured target.
+type OriginalDestinationConn interface {
+	// GetOriginalDestination returns the original "<host>:<port>" destination, or "" if unknown
+	GetOriginalDestination() string
+}
+
+// SourcePortConn is an optional interface implemented by ChannelConns accepted by a TCP stub. It provides
+// the Caller's source port, so that it can be propagated to the skeleton (see ChannelMetadata).
+type SourcePortConn interface {
+	// GetSourcePort returns the Caller's TCP source port, or 0 if unknown
+	GetSourcePort() int
+}
@@ -1,6 +1,7 @@
 package wstchannel
 
 import (
+	"encoding/json"
 	"fmt"
 )
 
@@ -24,6 +25,19 @@ type ChannelDescriptor struct {
 	Skeleton *ChannelEndpointDescriptor
 }
 
+// NewChannelDescriptor creates a ChannelDescriptor from a stub and skeleton endpoint descriptor
+func NewChannelDescriptor(stub ChannelEndpointDescriptor, skeleton ChannelEndpointDescriptor, reverse bool) (ChannelDescriptor, error) {
+	if stub == nil || skeleton == nil {
+		return ChannelDescriptor{}, fmt.Errorf("Channel descriptor requires both a stub and a skeleton endpoint")
+	}
+	d := ChannelDescriptor{
+		Reverse:  reverse,
+		Stub:     &stub,
+		Skeleton: &skeleton,
+	}
+	return d, nil
+}
+
 // Validate a ChannelDescriptor
 func (d ChannelDescriptor) Validate() error {
 	err := d.Stub.Validate()
@@ -41,6 +55,12 @@ func (d ChannelDescriptor) Validate() error {
 		return fmt.Errorf("%s: Role of skeleton must be ChannelEndpointRoleSkeleton", d.String())
 	}
 
+	if d.Skeleton.Type == ChannelEndpointProtocolTCP {
+		if _, err := TCPSkeletonTargets(d.Skeleton.Path, d.Skeleton.GetParamsMap()); err != nil {
+			return fmt.Errorf("%s: %s", d.String(), err)
+		}
+	}
+
 	if (!d.Reverse && d.Skeleton.Type == ChannelEndpointProtocolStdio) ||
 		(d.Reverse && d.Stub.Type == ChannelEndpointProtocolStdio) {
 		return fmt.Errorf("%s: STDIO endpoint must be on client proxy side", d.String())
@@ -49,12 +69,182 @@ func (d ChannelDescriptor) Validate() error {
 	return nil
 }
 
+// String returns the fully resolved form of the descriptor, as given by CanonicalString
 func (d ChannelDescriptor) String() string {
+	return d.CanonicalString()
+}
+
+// CanonicalString returns the descriptor in full form (see ParseFullChannelDescriptorPath), with
+// every default applied in parsing filled in; e.g., "3000" is "tcp://0.0.0.0:3000,tcp://localhost:3000"
+// and "R:2222:socks" is "R:tcp://127.0.0.1:2222,socks://". JSON params are rendered as compact
+// JSON. The form is stable: parsing the canonical string of a descriptor parsed from a string
+// yields an identical descriptor, with the same canonical string.
+func (d ChannelDescriptor) CanonicalString() string {
 	reversePrefix := ""
 	if d.Reverse {
 		reversePrefix = "R:"
 	}
-	return reversePrefix + d.Stub.String() + ":" + d.Skeleton.String()
+	return reversePrefix + canonicalEndpointString(*d.Stub) + "," + canonicalEndpointString(*d.Skeleton)
+}
+
+// AccessStrings returns the strings against which access to the channel is checked by an
+// authfile's regular expressions. These keep the legacy address form, independent of String(), so
+// that existing authfiles go on granting the same access: the address of the endpoint on the
+// server, which is "<remote-host>:<remote-port>" for a forward channel's skeleton and
+// "R:<local-interface>:<local-port>" for a reverse channel's stub. Endpoints other than TCP give
+// "<type>:<path>", or just "<type>" if they have no path (e.g., "socks"). A TCP skeleton with
+// several fallback targets (see TCPSkeletonTargets) gives one string per target, so that each
+// target must be allowed on its own.
+func (d ChannelDescriptor) AccessStrings() []string {
+	if d.Reverse {
+		return []string{"R:" + legacyAccessAddress(*d.Stub)}
+	}
+	if d.Skeleton.GetType() == ChannelEndpointProtocolTCP {
+		targets, err := TCPSkeletonTargets(d.Skeleton.GetParamsPath(), d.Skeleton.GetParamsMap())
+		if err == nil {
+			return targets
+		}
+	}
+	return []string{legacyAccessAddress(*d.Skeleton)}
+}
+
+// legacyAccessAddress returns the address of an endpoint in the form matched by authfiles (see
+// ChannelDescriptor.AccessStrings)
+func legacyAccessAddress(ep ChannelEndpointDescriptor) string {
+	path := ep.GetParamsPath()
+	if ep.GetType() == ChannelEndpointProtocolTCP {
+		if bind, err := ParamString(ep.GetParamsMap(), "bind", ""); err == nil && bind != "" {
+			return bind
+		}
+		return path
+	}
+	if path == "" {
+		return string(ep.GetType())
+	}
+	return string(ep.GetType()) + ":" + path
+}
+
+// canonicalEndpointString returns an endpoint in the full "<protocol>://<params>" form
+func canonicalEndpointString(ep ChannelEndpointDescriptor) string {
+	path := ep.GetParamsPath()
+	if params := ep.GetParamsMap(); params != nil {
+		if b, err := json.Marshal(params); err == nil {
+			path = string(b)
+		}
+	}
+	return string(ep.GetType()) + "://" + path
+}
+
+// TunnelNameParam is the endpoint param that names a tunnel (see ChannelDescriptor.Name). It is
+// distinct from the "name" param of Stdio endpoints, which lives in its own namespace.
+const TunnelNameParam = "tunnel"
+
+// Name returns the optional name of the tunnel, given by a "tunnel" param on the stub or the skeleton
+// endpoint (e.g., tcp://{"bind":"0.0.0.0:5432","tunnel":"db"}), or "" if it is unnamed. It is an
+// error for the stub and skeleton to give different names.
+func (d ChannelDescriptor) Name() (string, error) {
+	stubName, err := ParamString(d.Stub.GetParamsMap(), TunnelNameParam, "")
+	if err != nil {
+		return "", fmt.Errorf("%s: stub %s", d.String(), err)
+	}
+	skeletonName, err := ParamString(d.Skeleton.GetParamsMap(), TunnelNameParam, "")
+	if err != nil {
+		return "", fmt.Errorf("%s: skeleton %s", d.String(), err)
+	}
+	if stubName != "" && skeletonName != "" && stubName != skeletonName {
+		return "", fmt.Errorf("%s: Stub and skeleton have different names \"%s\" and \"%s\"", d.String(), stubName, skeletonName)
+	}
+	if stubName != "" {
+		return stubName, nil
+	}
+	return skeletonName, nil
+}
+
+// GetClientEndpoint returns the endpoint descriptor that lives on the client proxy side
+// (the Stub for a forward channel, or the Skeleton for a reverse channel)
+func (d ChannelDescriptor) GetClientEndpoint() *ChannelEndpointDescriptor {
+	if d.Reverse {
+		return d.Skeleton
+	}
+	return d.Stub
+}
+
+// ValidateChannelDescriptors validates a set of ChannelDescriptors that will be used together
+// on a single proxy client. In addition to validating each descriptor, it ensures that Stdio
+// endpoints do not collide: at most one unnamed Stdio endpoint is allowed, every named Stdio
+// endpoint must have a distinct name, and no two Stdio endpoints may share a file descriptor.
+// It also ensures that every loop skeleton on the client (in a reverse descriptor) has a loop
+// stub with the same name on the client (in a forward descriptor) to connect to, since loops
+// never cross between proxies, and that tunnel names (see ChannelDescriptor.Name) are unique.
+func ValidateChannelDescriptors(chds []*ChannelDescriptor) error {
+	names := map[string]bool{}
+	fds := map[int]string{}
+	tunnelNames := map[string]bool{}
+	for _, d := range chds {
+		err := d.Validate()
+		if err != nil {
+			return err
+		}
+		tunnelName, err := d.Name()
+		if err != nil {
+			return err
+		}
+		if tunnelName != "" {
+			if tunnelNames[tunnelName] {
+				return fmt.Errorf("%s: Duplicate tunnel name \"%s\"", d.String(), tunnelName)
+			}
+			tunnelNames[tunnelName] = true
+		}
+		ced := d.GetClientEndpoint()
+		if ced.Type != ChannelEndpointProtocolStdio {
+			continue
+		}
+		params := ced.GetParamsMap()
+		name, err := ParamString(params, "name", "")
+		if err != nil {
+			return fmt.Errorf("%s: %s", d.String(), err)
+		}
+		if names[name] {
+			if name == "" {
+				return fmt.Errorf("%s: Only one unnamed STDIO endpoint is allowed; use distinct {\"name\": ..., \"fds\": [...]} params for additional STDIO endpoints", d.String())
+			}
+			return fmt.Errorf("%s: Duplicate STDIO endpoint name \"%s\"", d.String(), name)
+		}
+		names[name] = true
+
+		if name != "" && params["fds"] == nil {
+			return fmt.Errorf("%s: Named STDIO endpoint \"%s\" requires an \"fds\" param", d.String(), name)
+		}
+		input, output, err := getStdioFds(params)
+		if err != nil {
+			return fmt.Errorf("%s: %s", d.String(), err)
+		}
+		for _, fd := range []int{input, output} {
+			if other, ok := fds[fd]; ok && other != name {
+				return fmt.Errorf("%s: STDIO endpoint \"%s\" uses fd %d, which is already used by STDIO endpoint \"%s\"", d.String(), name, fd, other)
+			}
+			fds[fd] = name
+		}
+	}
+	return validateClientLoops(chds)
+}
+
+// validateClientLoops ensures that each loop skeleton on the client has a matching loop stub on
+// the client. Loop skeletons on the server may be served by other clients' reverse stubs, so they
+// cannot be checked here.
+func validateClientLoops(chds []*ChannelDescriptor) error {
+	stubs := map[string]bool{}
+	for _, d := range chds {
+		if !d.Reverse && d.Stub.Type == ChannelEndpointProtocolLoop {
+			stubs[d.Stub.Path] = true
+		}
+	}
+	for _, d := range chds {
+		if d.Reverse && d.Skeleton.Type == ChannelEndpointProtocolLoop && !stubs[d.Skeleton.Path] {
+			return fmt.Errorf("%s: Loop skeleton \"%s\" is on the client, but no loop stub \"%s\" is on the client; a loop only connects endpoints on the same proxy", d.String(), d.Skeleton.Path, d.Skeleton.Path)
+		}
+	}
+	return nil
 }
 
 // LongString converts a ChannelDescriptor to a long descriptive string
@@ -64,5 +254,5 @@ func (d ChannelDescriptor) LongString() string {
 		reverseStr = "true"
 	}
 
-	return "ChannelDescriptor(reverse='" + reverseStr + "', stub=" + d.Stub.LongString() + ", skeleton=" + d.Skeleton.LongString() + ")"
+	return "ChannelDescriptor(reverse='" + reverseStr + "', stub=" + (*d.Stub).LongString() + ", skeleton=" + (*d.Skeleton).LongString() + ")"
 }
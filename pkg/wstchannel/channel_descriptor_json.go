@@ -0,0 +1,92 @@
+package wstchannel
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// channelDescriptorJSON is the stable JSON schema of a ChannelDescriptor, for external tools
+type channelDescriptorJSON struct {
+	Reverse  bool                    `json:"reverse"`
+	Stub     *endpointDescriptorJSON `json:"stub"`
+	Skeleton *endpointDescriptorJSON `json:"skeleton"`
+}
+
+// endpointDescriptorJSON is the stable JSON schema of a ChannelEndpointDescriptor. Params holds
+// the endpoint's parameters exactly as provided, either as a JSON string (a params path) or as a
+// JSON object; Path is the equivalent params path, informational when Params is present.
+type endpointDescriptorJSON struct {
+	Role   ChannelEndpointRole     `json:"role"`
+	Type   ChannelEndpointProtocol `json:"type"`
+	Path   string                  `json:"path,omitempty"`
+	Params json.RawMessage         `json:"params,omitempty"`
+}
+
+func newEndpointDescriptorJSON(ced ChannelEndpointDescriptor) *endpointDescriptorJSON {
+	return &endpointDescriptorJSON{
+		Role:   ced.GetRole(),
+		Type:   ced.GetType(),
+		Path:   ced.GetParamsPath(),
+		Params: ced.GetParamsRaw(),
+	}
+}
+
+func (j *endpointDescriptorJSON) toEndpointDescriptor(role ChannelEndpointRole) (ChannelEndpointDescriptor, error) {
+	if j == nil {
+		return nil, fmt.Errorf("Missing %s endpoint", role)
+	}
+	if j.Role != role {
+		return nil, fmt.Errorf("Role of %s endpoint must be \"%s\", got \"%s\"", role, role, j.Role)
+	}
+	if j.Type == "" {
+		return nil, fmt.Errorf("Missing type for %s endpoint", role)
+	}
+	if len(j.Params) > 0 {
+		return NewChannelEndpointDescriptorWithJson(j.Role, j.Type, "", j.Params, "")
+	}
+	ced, _, err := NewChannelEndpointDescriptorWithParamsPath(j.Role, j.Type, "", j.Path, false)
+	return ced, err
+}
+
+// ToJSON converts a ChannelDescriptor to JSON with a stable schema, for external tools:
+//
+//	{"reverse": <bool>, "stub": <endpoint>, "skeleton": <endpoint>}
+//
+// where each <endpoint> is {"role": ..., "type": ..., "path": ..., "params": ...}. "params" is omitted
+// if the endpoint has no parameters, and otherwise is either a JSON string or a JSON object, as the
+// parameters were provided. The result can be parsed with ParseChannelDescriptorJSON.
+func (d *ChannelDescriptor) ToJSON() ([]byte, error) {
+	if d.Stub == nil || d.Skeleton == nil {
+		return nil, fmt.Errorf("Channel descriptor requires both a stub and a skeleton endpoint")
+	}
+	return json.Marshal(&channelDescriptorJSON{
+		Reverse:  d.Reverse,
+		Stub:     newEndpointDescriptorJSON(*d.Stub),
+		Skeleton: newEndpointDescriptorJSON(*d.Skeleton),
+	})
+}
+
+// ParseChannelDescriptorJSON parses and validates a ChannelDescriptor in the form produced by
+// ChannelDescriptor.ToJSON. If an endpoint has "params", they take precedence over its "path".
+func ParseChannelDescriptorJSON(b []byte) (*ChannelDescriptor, error) {
+	var j channelDescriptorJSON
+	if err := json.Unmarshal(b, &j); err != nil {
+		return nil, fmt.Errorf("Invalid channel descriptor JSON: %s", err)
+	}
+	stub, err := j.Stub.toEndpointDescriptor(ChannelEndpointRoleStub)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid channel descriptor JSON: %s", err)
+	}
+	skeleton, err := j.Skeleton.toEndpointDescriptor(ChannelEndpointRoleSkeleton)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid channel descriptor JSON: %s", err)
+	}
+	d, err := NewChannelDescriptor(stub, skeleton, j.Reverse)
+	if err != nil {
+		return nil, err
+	}
+	if err := d.Validate(); err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
@@ -0,0 +1,58 @@
+package wstchannel
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestChannelDescriptorJSONRoundTrip(t *testing.T) {
+	tests := []string{
+		`{"reverse":false,"stub":{"role":"stub","type":"tcp","path":"127.0.0.1:3000","params":"127.0.0.1:3000"},"skeleton":{"role":"skeleton","type":"tcp","path":"localhost:80","params":"localhost:80"}}`,
+		`{"reverse":true,"stub":{"role":"stub","type":"tcp","path":"127.0.0.1:2222","params":"127.0.0.1:2222"},"skeleton":{"role":"skeleton","type":"socks"}}`,
+		`{"reverse":false,"stub":{"role":"stub","type":"stdio","path":"{\"fds\":[3,4],\"name\":\"a\"}","params":{"fds":[3,4],"name":"a"}},"skeleton":{"role":"skeleton","type":"tcp","path":"localhost:22","params":"localhost:22"}}`,
+	}
+	for _, want := range tests {
+		d, err := ParseChannelDescriptorJSON([]byte(want))
+		if err != nil {
+			t.Errorf("ParseChannelDescriptorJSON(%s): unexpected error: %s", want, err)
+			continue
+		}
+		got, err := d.ToJSON()
+		if err != nil {
+			t.Errorf("ToJSON() of %s: unexpected error: %s", want, err)
+			continue
+		}
+		if string(got) != want {
+			t.Errorf("JSON round trip changed descriptor:\n got %s\nwant %s", got, want)
+		}
+	}
+
+	// the string form and the JSON form describe the same descriptor
+	d, _, err := ParseChannelDescriptorPath("R:2222:socks")
+	if err != nil {
+		t.Fatalf("ParseChannelDescriptorPath(): unexpected error: %s", err)
+	}
+	b, err := d.ToJSON()
+	if err != nil {
+		t.Fatalf("ToJSON(): unexpected error: %s", err)
+	}
+	d2, err := ParseChannelDescriptorJSON(b)
+	if err != nil || d2.String() != d.String() {
+		t.Errorf("ParseChannelDescriptorJSON(%s) = %v, %v; want %s", b, d2, err, d.String())
+	}
+
+	invalid := []struct {
+		json string
+		want string
+	}{
+		{`{"stub":{"role":"stub","type":"tcp","path":"3000"}}`, "Missing skeleton endpoint"},
+		{`{"stub":{"role":"skeleton","type":"tcp","path":"3000"},"skeleton":{"role":"skeleton","type":"socks"}}`, "Role of stub endpoint"},
+		{`[]`, "Invalid channel descriptor JSON"},
+	}
+	for _, tt := range invalid {
+		_, err := ParseChannelDescriptorJSON([]byte(tt.json))
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("ParseChannelDescriptorJSON(%s) error = %v, want it to contain %q", tt.json, err, tt.want)
+		}
+	}
+}
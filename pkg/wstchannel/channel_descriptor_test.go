@@ -0,0 +1,127 @@
+package wstchannel
+
+import (
+	"strings"
+	"testing"
+)
+
+func parseDescriptors(t *testing.T, specs []string) []*ChannelDescriptor {
+	var chds []*ChannelDescriptor
+	for _, s := range specs {
+		d, _, err := ParseChannelDescriptorPath(s)
+		if err != nil {
+			t.Fatalf("ParseChannelDescriptorPath(%q): unexpected error: %s", s, err)
+		}
+		chds = append(chds, &d)
+	}
+	return chds
+}
+
+func TestValidateLoopDescriptors(t *testing.T) {
+	valid := [][]string{
+		// forward loop stub on the client, served by a skeleton on the server
+		{"loop:foo:localhost:80"},
+		// reverse loop stub on the server
+		{"R:loop:foo:localhost:22"},
+		// forward loop skeleton on the server, served by another client's reverse loop stub
+		{"3000:loop:foo"},
+		// reverse loop skeleton on the client, served by a loop stub on the same client
+		{"R:2222:loop:bar", "loop:bar:localhost:80"},
+		// differently named loops on both sides
+		{"loop:foo:loop:bar"},
+		// the stub and skeleton loops are on different proxies, so the same name does not collide
+		{"loop:foo:loop:foo"},
+		{"loop:foo:loop:foo", "R:loop:foo:loop:foo"},
+	}
+	for _, specs := range valid {
+		if err := ValidateChannelDescriptors(parseDescriptors(t, specs)); err != nil {
+			t.Errorf("ValidateChannelDescriptors(%q): unexpected error: %s", specs, err)
+		}
+	}
+
+	invalid := []struct {
+		specs   []string
+		errText string
+	}{
+		{[]string{"R:2222:loop:bar"}, "no loop stub \"bar\" is on the client"},
+		{[]string{"R:2222:loop:bar", "R:loop:bar:localhost:80"}, "no loop stub \"bar\" is on the client"},
+	}
+	for _, tt := range invalid {
+		err := ValidateChannelDescriptors(parseDescriptors(t, tt.specs))
+		if err == nil || !strings.Contains(err.Error(), tt.errText) {
+			t.Errorf("ValidateChannelDescriptors(%q) = %v, want error containing %q", tt.specs, err, tt.errText)
+		}
+	}
+}
+
+func TestChannelDescriptorNames(t *testing.T) {
+	chds := parseDescriptors(t, []string{
+		`tcp://{"bind":"0.0.0.0:5432","tunnel":"db"},tcp://db.internal:5432`,
+		`R:tcp://0.0.0.0:2222,tcp://{"host":"localhost","port":22,"tunnel":"ssh"}`,
+		"3000:google.com:80",
+		`stdio://{"name":"a","fds":[3,4]},tcp://localhost:22`,
+		`stdio://{"name":"b","fds":[5,6]},tcp://localhost:23`,
+	})
+	for i, want := range []string{"db", "ssh", "", "", ""} {
+		if name, err := chds[i].Name(); err != nil || name != want {
+			t.Errorf("Name() of %s = %q, %v; want %q", chds[i], name, err, want)
+		}
+	}
+	if err := ValidateChannelDescriptors(chds); err != nil {
+		t.Errorf("ValidateChannelDescriptors(): unexpected error: %s", err)
+	}
+
+	dup := append(chds, parseDescriptors(t, []string{`tcp://{"bind":"0.0.0.0:5433","tunnel":"db"},tcp://db2.internal:5432`})...)
+	if err := ValidateChannelDescriptors(dup); err == nil || !strings.Contains(err.Error(), "Duplicate tunnel name \"db\"") {
+		t.Errorf("ValidateChannelDescriptors() with a duplicate name = %v, want duplicate tunnel name error", err)
+	}
+}
+
+func TestChannelDescriptorCanonicalString(t *testing.T) {
+	tests := []struct {
+		s         string
+		canonical string
+	}{
+		{"3000", "tcp://0.0.0.0:3000,tcp://localhost:3000"},
+		{"3000:google.com:80", "tcp://0.0.0.0:3000,tcp://google.com:80"},
+		{"R:2222:localhost:22", "R:tcp://0.0.0.0:2222,tcp://localhost:22"},
+		{"socks", "tcp://127.0.0.1:1080,socks://"},
+		{"R:2222:socks", "R:tcp://127.0.0.1:2222,socks://"},
+		{"loop:foo:localhost:80", "loop://foo,tcp://localhost:80"},
+		{"R:2222:loop:bar", "R:tcp://0.0.0.0:2222,loop://bar"},
+		{"stdio:localhost:22", "stdio://,tcp://localhost:22"},
+		{"R:2222:unix:/tmp/s.sock", "R:tcp://0.0.0.0:2222,unix:///tmp/s.sock"},
+		// without a configured default host, the dialing side resolves a full-form skeleton's host
+		{"tcp://0.0.0.0:3000,tcp://:80", "tcp://0.0.0.0:3000,tcp://:80"},
+		{`tcp://{ "name": "db", "bind": "0.0.0.0:5432" },tcp://db:5432`, `tcp://{"bind":"0.0.0.0:5432","name":"db"},tcp://db:5432`},
+	}
+
+	for _, tt := range tests {
+		d, _, err := ParseChannelDescriptorPath(tt.s)
+		if err != nil {
+			t.Errorf("ParseChannelDescriptorPath(%q): unexpected error: %s", tt.s, err)
+			continue
+		}
+		canonical := d.CanonicalString()
+		if canonical != tt.canonical {
+			t.Errorf("ParseChannelDescriptorPath(%q).CanonicalString() = %q, expected %q", tt.s, canonical, tt.canonical)
+			continue
+		}
+		if d.String() != canonical {
+			t.Errorf("ParseChannelDescriptorPath(%q).String() = %q, expected %q", tt.s, d.String(), canonical)
+		}
+		d2, _, err := ParseChannelDescriptorPath(canonical)
+		if err != nil {
+			t.Errorf("ParseChannelDescriptorPath(%q): canonical form does not parse: %s", canonical, err)
+			continue
+		}
+		if d2.Reverse != d.Reverse || d2.CanonicalString() != canonical {
+			t.Errorf("ParseChannelDescriptorPath(%q): canonical form re-parses as %q", canonical, d2.CanonicalString())
+		}
+		stub, stub2 := *d.Stub, *d2.Stub
+		skeleton, skeleton2 := *d.Skeleton, *d2.Skeleton
+		if stub.GetType() != stub2.GetType() || skeleton.GetType() != skeleton2.GetType() {
+			t.Errorf("ParseChannelDescriptorPath(%q): canonical form changes endpoint types", canonical)
+		}
+	}
+}
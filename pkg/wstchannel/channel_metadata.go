@@ -0,0 +1,82 @@
+package wstchannel
+
+import (
+	"encoding/json"
+)
+
+// channelMetadataKey is the key under which ChannelMetadata is added to the skeleton endpoint
+// descriptor JSON that is sent as the ExtraData of an SSH OpenChannel request. Peers that do not
+// understand it ignore it.
+const channelMetadataKey = "metadata"
+
+// ChannelMetadata describes the Caller of a tunnelled connection. It is optionally sent by the
+// stub side along with the skeleton endpoint descriptor, and is passed as extraData to the
+// skeleton endpoint's Dial.
+type ChannelMetadata struct {
+	// SourcePort is the Caller's TCP source port, or 0 if it is not propagated. A TCP skeleton
+	// binds its dial to the same port if it can, and falls back to an ephemeral port otherwise.
+	SourcePort int `json:"sourcePort,omitempty"`
+}
+
+// SourcePortEnv may be implemented by a LocalChannelEnv whose stubs should propagate the
+// Caller's source port to the skeleton
+type SourcePortEnv interface {
+	// IsSourcePortPreserved returns true if Callers' source ports are propagated
+	IsSourcePortPreserved() bool
+}
+
+// NewChannelMetadata returns the metadata to send for a Caller connection accepted by a stub
+// in env, or nil if there is none
+func NewChannelMetadata(env LocalChannelEnv, callerConn ChannelConn) *ChannelMetadata {
+	spEnv, ok := env.(SourcePortEnv)
+	if !ok || !spEnv.IsSourcePortPreserved() {
+		return nil
+	}
+	spc, ok := callerConn.(SourcePortConn)
+	if !ok || spc.GetSourcePort() == 0 {
+		return nil
+	}
+	return &ChannelMetadata{SourcePort: spc.GetSourcePort()}
+}
+
+// AddChannelMetadata adds md to the JSON of a skeleton endpoint descriptor, for the ExtraData
+// of an SSH OpenChannel request. If md is nil, epdJSON is returned unchanged.
+func AddChannelMetadata(epdJSON []byte, md *ChannelMetadata) ([]byte, error) {
+	if md == nil {
+		return epdJSON, nil
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(epdJSON, &fields); err != nil {
+		return nil, err
+	}
+	mdJSON, err := json.Marshal(md)
+	if err != nil {
+		return nil, err
+	}
+	fields[channelMetadataKey] = mdJSON
+	return json.Marshal(fields)
+}
+
+// ExtractChannelMetadata returns the raw ChannelMetadata JSON carried in the ExtraData of an SSH
+// OpenChannel request, suitable for passing as extraData to Dial, or nil if there is none
+func ExtractChannelMetadata(openData []byte) []byte {
+	var fields struct {
+		Metadata json.RawMessage `json:"metadata"`
+	}
+	if err := json.Unmarshal(openData, &fields); err != nil {
+		return nil
+	}
+	return fields.Metadata
+}
+
+// ParseChannelMetadata parses the extraData passed to Dial. Missing or malformed metadata
+// results in empty metadata.
+func ParseChannelMetadata(extraData []byte) *ChannelMetadata {
+	md := &ChannelMetadata{}
+	if len(extraData) > 0 {
+		if err := json.Unmarshal(extraData, md); err != nil {
+			return &ChannelMetadata{}
+		}
+	}
+	return md
+}
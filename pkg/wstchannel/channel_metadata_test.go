@@ -0,0 +1,31 @@
+package wstchannel
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestChannelMetadataRoundTrip(t *testing.T) {
+	epdJSON := []byte(`{"role":"skeleton","protocol":"tcp","params":"localhost:80"}`)
+
+	same, err := AddChannelMetadata(epdJSON, nil)
+	if err != nil || !bytes.Equal(same, epdJSON) {
+		t.Errorf("AddChannelMetadata(nil) = %s, %v; want unchanged", same, err)
+	}
+	if md := ExtractChannelMetadata(epdJSON); md != nil {
+		t.Errorf("ExtractChannelMetadata() without metadata = %s, want nil", md)
+	}
+
+	openData, err := AddChannelMetadata(epdJSON, &ChannelMetadata{SourcePort: 5060})
+	if err != nil {
+		t.Fatalf("AddChannelMetadata() failed: %s", err)
+	}
+	md := ParseChannelMetadata(ExtractChannelMetadata(openData))
+	if md.SourcePort != 5060 {
+		t.Errorf("SourcePort after round trip = %d, want 5060", md.SourcePort)
+	}
+
+	if md := ParseChannelMetadata([]byte("not json")); md.SourcePort != 0 {
+		t.Errorf("ParseChannelMetadata() of malformed metadata = %+v, want empty", md)
+	}
+}
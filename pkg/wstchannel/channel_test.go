@@ -0,0 +1,47 @@
+package wstchannel
+
+import (
+	"bytes"
+	"context"
+	"testing"
+)
+
+// maxWriteRecorder records the largest single Write it receives
+type maxWriteRecorder struct {
+	bytes.Buffer
+	maxWrite int
+}
+
+func (w *maxWriteRecorder) Write(p []byte) (int, error) {
+	if len(p) > w.maxWrite {
+		w.maxWrite = len(p)
+	}
+	return w.Buffer.Write(p)
+}
+
+func TestCopyChannelBufferSize(t *testing.T) {
+	if err := ValidateChannelBufferSize(10); err == nil {
+		t.Errorf("ValidateChannelBufferSize(10) did not return an error")
+	}
+	if err := ValidateChannelBufferSize(4096); err != nil {
+		t.Fatalf("ValidateChannelBufferSize(4096) returned error: %s", err)
+	}
+	if size := getChannelBufferSize(WithChannelBufferSize(context.Background(), 4096)); size != 4096 {
+		t.Fatalf("getChannelBufferSize() = %d, want 4096", size)
+	}
+
+	data := bytes.Repeat([]byte("0123456789abcdef"), 4096)
+	// bytes.Reader implements io.WriterTo, which would otherwise write everything at once
+	src := bytes.NewReader(data)
+	dst := &maxWriteRecorder{}
+	n, err := copyChannel(dst, src, 4096)
+	if err != nil || n != int64(len(data)) {
+		t.Fatalf("copyChannel() = (%d, %v), want (%d, nil)", n, err, len(data))
+	}
+	if !bytes.Equal(dst.Bytes(), data) {
+		t.Errorf("copyChannel() corrupted data")
+	}
+	if dst.maxWrite > 4096 {
+		t.Errorf("copyChannel() wrote %d bytes at once with a 4096 byte buffer", dst.maxWrite)
+	}
+}
@@ -0,0 +1,62 @@
+package wstchannel
+
+import (
+	"errors"
+)
+
+// CodedError is an error that carries a machine-readable code in addition to its
+// human-readable message, so that handlers can switch on the code rather than parse
+// the message. Create one with Errorcf or one of its logging variants.
+//
+// errors.Is(err, ErrorCode(code)) is true for any CodedError in err's chain with the
+// given code, regardless of message.
+type CodedError struct {
+	// Code is the machine-readable error code
+	Code string
+
+	// Msg is the human-readable message, including the logger prefix
+	Msg string
+}
+
+func (e *CodedError) Error() string {
+	return e.Msg
+}
+
+// Is reports whether target is the sentinel for e's code (see ErrorCode)
+func (e *CodedError) Is(target error) bool {
+	t, ok := target.(*CodedError)
+	return ok && t.Msg == "" && t.Code == e.Code
+}
+
+// ErrorCode returns a sentinel error for a code, for use with errors.Is
+func ErrorCode(code string) error {
+	return &CodedError{Code: code}
+}
+
+// GetErrorCode returns the code of the first CodedError in err's chain, or "" if there is none
+func GetErrorCode(err error) string {
+	var ce *CodedError
+	if errors.As(err, &ce) {
+		return ce.Code
+	}
+	return ""
+}
+
+func newCodedError(code string, err error) error {
+	return &CodedError{Code: code, Msg: err.Error()}
+}
+
+// Errorcf is like logger.Errorf, but returns a CodedError with the given code
+func Errorcf(logger Logger, code string, f string, args ...interface{}) error {
+	return newCodedError(code, logger.Errorf(f, args...))
+}
+
+// DLogErrorcf is like logger.DLogErrorf, but returns a CodedError with the given code
+func DLogErrorcf(logger Logger, code string, f string, args ...interface{}) error {
+	return newCodedError(code, logger.DLogErrorf(f, args...))
+}
+
+// WLogErrorcf is like logger.WLogErrorf, but returns a CodedError with the given code
+func WLogErrorcf(logger Logger, code string, f string, args ...interface{}) error {
+	return newCodedError(code, logger.WLogErrorf(f, args...))
+}
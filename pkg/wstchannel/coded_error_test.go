@@ -0,0 +1,42 @@
+package wstchannel
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/sammck-go/logger"
+)
+
+func TestCodedError(t *testing.T) {
+	l, err := logger.New(
+		logger.WithLogLevel(logger.LogLevelInfo),
+		logger.WithPrefix("TestCodedError"),
+	)
+	if err != nil {
+		t.Fatalf("logger.New() returned error: %s", err)
+	}
+	err = Errorcf(l, "limit", "Too many %s", "widgets")
+	if !strings.HasPrefix(err.Error(), l.Prefix()) || !strings.HasSuffix(err.Error(), "Too many widgets") {
+		t.Errorf("Errorcf() message %q does not have prefix %q and formatted message", err, l.Prefix())
+	}
+
+	wrapped := fmt.Errorf("session failed: %w", err)
+	if !errors.Is(wrapped, ErrorCode("limit")) {
+		t.Errorf("errors.Is(%v, ErrorCode(limit)) = false", wrapped)
+	}
+	if errors.Is(wrapped, ErrorCode("draining")) {
+		t.Errorf("errors.Is(%v, ErrorCode(draining)) = true", wrapped)
+	}
+	var ce *CodedError
+	if !errors.As(wrapped, &ce) || ce.Code != "limit" {
+		t.Errorf("errors.As(%v) did not find CodedError with code limit", wrapped)
+	}
+	if code := GetErrorCode(wrapped); code != "limit" {
+		t.Errorf("GetErrorCode(%v) = %q, want limit", wrapped, code)
+	}
+	if code := GetErrorCode(errors.New("plain")); code != "" {
+		t.Errorf("GetErrorCode(plain) = %q, want empty", code)
+	}
+}
@@ -48,6 +48,14 @@ type AcceptorChannelEndpoint interface {
 	AcceptAndServe(ctx context.Context, calledServiceConn ChannelConn) (int64, int64, error)
 }
 
+// ListenStopper may be implemented by an AcceptorChannelEndpoint that can stop accepting new Callers
+// without closing the connections it has already accepted (closing the endpoint closes them too)
+type ListenStopper interface {
+	// StopListening closes the endpoint's listener. Connections already accepted are unaffected;
+	// subsequent Accept calls fail. The endpoint must still be closed when it is no longer needed.
+	StopListening() error
+}
+
 // DialerChannelEndpoint is a ChannelEndpoint that can be asked to create a new connection to a network service
 // as expected in the endpoint configuration.
 type DialerChannelEndpoint interface {
@@ -93,6 +101,30 @@ type BasicEndpoint struct {
 	ShutdownHelper
 	Strname string
 	ced     *ChannelEndpointDescriptor
+
+	// OnAccept, if not nil, is called with each connection returned by Accept (including the
+	// Accept performed by AcceptAndServe), before it is returned and before any bridging starts,
+	// so that library consumers can attach per-connection logging or metrics and inspect
+	// metadata such as the remote address. It is called synchronously on the accepting
+	// goroutine, so it should return promptly; it must not read from or write to the connection.
+	// It must be set before the endpoint begins accepting connections.
+	OnAccept func(ChannelConn)
+
+	// OnDial, if not nil, is called with each connection returned by Dial (including the
+	// Dial performed by DialAndServe), under the same rules as OnAccept. Loop skeleton endpoints
+	// couple DialAndServe directly to the accepting loop stub, without a dialed connection of
+	// their own, so OnDial is not called in that case; the stub's OnAccept sees the connection.
+	OnDial func(ChannelConn)
+
+	// BeforeBridge, if not nil, is called by the DialAndServe of TCP and unix skeleton endpoints
+	// with each dialed connection, after any prelude has been sent and before bridging starts, so
+	// that it can prepare the Called Service; e.g., by writing a greeting or a PROXY protocol
+	// header. Unlike OnDial, it may write to and read from the connection. extraData is the channel
+	// metadata passed to Dial (see ParseChannelMetadata). Returning an error fails the channel;
+	// both connections are closed. Bytes it writes are not counted as bridged (see skeletonPrelude).
+	// It must be set before the endpoint begins dialing connections; the skeletons created by
+	// NewLocalSkeletonChannelEndpoint take it from their LocalChannelEnv (see BeforeBridgeEnv).
+	BeforeBridge func(ctx context.Context, calledServiceConn ChannelConn, extraData []byte) error
 }
 
 // InitBasicEndpoint initializes a BasicEndpoint
@@ -107,6 +139,20 @@ func (ep *BasicEndpoint) InitBasicEndpoint(
 	ep.PanicOnError(ep.Activate())
 }
 
+// notifyAccept invokes the OnAccept callback, if any, for a newly accepted connection
+func (ep *BasicEndpoint) notifyAccept(conn ChannelConn) {
+	if ep.OnAccept != nil {
+		ep.OnAccept(conn)
+	}
+}
+
+// notifyDial invokes the OnDial callback, if any, for a newly dialed connection
+func (ep *BasicEndpoint) notifyDial(conn ChannelConn) {
+	if ep.OnDial != nil {
+		ep.OnDial(conn)
+	}
+}
+
 func (ep *BasicEndpoint) String() string {
 	return ep.Strname
 }
@@ -136,7 +182,7 @@ func NewLocalStubChannelEndpoint(
 			ep, err = NewLoopStubEndpoint(logger, ced, loopServer)
 		}
 	} else if ced.Type == ChannelEndpointProtocolTCP {
-		ep, err = NewTCPStubEndpoint(logger, ced)
+		ep, err = NewTCPStubEndpoint(logger, env, ced)
 	} else if ced.Type == ChannelEndpointProtocolUnix {
 		ep, err = NewUnixStubEndpoint(logger, ced)
 	} else if ced.Type == ChannelEndpointProtocolSocks {
@@ -173,15 +219,15 @@ func NewLocalSkeletonChannelEndpoint(
 			ep, err = NewLoopSkeletonEndpoint(logger, ced, loopServer)
 		}
 	} else if ced.Type == ChannelEndpointProtocolTCP {
-		ep, err = NewTCPSkeletonEndpoint(logger, ced)
+		ep, err = NewTCPSkeletonEndpoint(logger, env, ced)
 	} else if ced.Type == ChannelEndpointProtocolUnix {
-		ep, err = NewUnixSkeletonEndpoint(logger, ced)
+		ep, err = NewUnixSkeletonEndpoint(logger, env, ced)
 	} else if ced.Type == ChannelEndpointProtocolSocks {
 		socksServer := env.GetSocksServer()
 		if socksServer == nil {
 			err = fmt.Errorf("%s: socks endpoints are disabled: %s", logger.Prefix(), ced.LongString())
 		} else {
-			ep, err = NewSocksSkeletonEndpoint(logger, ced, socksServer)
+			ep, err = NewSocksSkeletonEndpoint(logger, ced, socksServer, isSocks4Enabled(env))
 		}
 	} else {
 		err = fmt.Errorf("%s: Unsupported endpoint type '%s': %s", logger.Prefix(), ced.Type, ced.LongString())
@@ -3,6 +3,7 @@ package wstchannel
 import (
 	"encoding/json"
 	"fmt"
+	"strings"
 	"unicode/utf8"
 )
 
@@ -60,8 +61,9 @@ const (
 	// considered active as soon as a connect request is received from the remote proxy service. This type of
 	// endpoint can only be associated with the proxy client's end, can only be connected once,
 	// and once that connection is closed, it can no longer be used or reconnected for the duration
-	// of the session with the remote proxy. There can only be one Stdio endpoint defined on a given
-	// proxy client.
+	// of the session with the remote proxy. There can only be one unnamed Stdio endpoint defined on a given
+	// proxy client. Additional Stdio endpoints may be defined if each is given a distinct name and its
+	// own pair of file descriptors with params {"name": <name>, "fds": [<input-fd>, <output-fd>]}.
 	ChannelEndpointProtocolStdio ChannelEndpointProtocol = "stdio"
 
 	// ChannelEndpointProtocolLoop ChannelEndpointProtocol is a virtual loopack socket, identified by an opaque
@@ -247,25 +249,27 @@ func NewChannelEndpointDescriptorWithParamsPath(
 	decodeJsonPath bool, // If true and paramsPath starts with '{', it will be decoded as json and used as a params object
 ) (d ChannelEndpointDescriptor, nb int, err error) {
 	var rawParams json.RawMessage
-	var err error
 
 	if decodeJsonPath && len(paramsPath) > 0 && paramsPath[0] == '{' {
-		rawParams, nb, err := UnmarshParseJsonValueInString(paramsPath)
+		rawParams, nb, err = ParseJsonValueInString(paramsPath)
 		if err != nil {
 			return nil, nb, fmt.Errorf("Bad JSON at char offset %d in channel params <%s>: %v", utf8.RuneCountInString(paramsPath[:nb]), paramsPath, err)
 		}
-		d, err := NewChannelEndpointDescriptorWithJson(registry, epRole, epType, version, rawParams, paramsPath)
-		return d, err
+		d, err = NewChannelEndpointDescriptorWithJson(epRole, epType, version, rawParams, paramsPath)
+		if err != nil {
+			return nil, 0, err
+		}
+		return d, len(paramsPath), nil
 	}
 
 	if paramsPath != "" {
 		rawParams, err = json.Marshal(paramsPath)
 		if err != nil {
-			return nil, fmt.Errorf("Unable to marshal channel param path to JSON string: %v", err)
+			return nil, 0, fmt.Errorf("Unable to marshal channel param path to JSON string: %v", err)
 		}
 	}
 
-	d := &RawChannelEndpointDescriptor{
+	rd := &RawChannelEndpointDescriptor{
 		EpRole:           epRole,
 		EpType:           epType,
 		Version:          version,
@@ -276,16 +280,16 @@ func NewChannelEndpointDescriptorWithParamsPath(
 		shortDescription: "",
 	}
 
-	d.initShortDescription()
+	rd.initShortDescription()
 
-	return d, nil
+	return rd, len(paramsPath), nil
 }
 
 func (d *RawChannelEndpointDescriptor) initShortDescription() {
 	if d.paramsPath == "" {
-		d.shortDescription = fmt.Sprintf("<Endpoint %s %s>", d.epRole, d.epType)
+		d.shortDescription = fmt.Sprintf("<Endpoint %s %s>", d.EpRole, d.EpType)
 	} else {
-		d.shortDescription = fmt.Sprintf("<Endpoint %s <%s:%s>>", d.epRole, d.epType, d.paramsPath)
+		d.shortDescription = fmt.Sprintf("<Endpoint %s <%s:%s>>", d.EpRole, d.EpType, d.paramsPath)
 	}
 }
 
@@ -294,13 +298,27 @@ func (d *RawChannelEndpointDescriptor) String() string {
 	return d.shortDescription
 }
 
-// LongString gets a complete descriptive string, which typically is the json of the descriptor
+// LongString gets a complete descriptive string, including the role, type, and pretty JSON params
 func (d *RawChannelEndpointDescriptor) LongString() string {
-	js, err := ToPrettyJsonString(d)
-	if err != nil {
-		return d.String()
+	return EndpointDescriptorLongString(d)
+}
+
+// EndpointDescriptorLongString formats a complete descriptive string for any ChannelEndpointDescriptor, in the form:
+//
+//     Endpoint(role='<role>', type='<type>', params=<pretty-json-params>)
+//
+// Implementations of ChannelEndpointDescriptor.LongString should use this so that all descriptors log consistently.
+func EndpointDescriptorLongString(d ChannelEndpointDescriptor) string {
+	params := "null"
+	if raw := d.GetParamsRaw(); len(raw) > 0 {
+		js, err := ToPrettyJsonString(raw)
+		if err != nil {
+			params = string(raw)
+		} else {
+			params = strings.TrimSpace(js)
+		}
 	}
-	return fmt.Sprint("Endpoint %s", js)
+	return fmt.Sprintf("Endpoint(role='%s', type='%s', params=%s)", d.GetRole(), d.GetType(), params)
 }
 
 // ValidateLocal ensures that the descriptor is valid for local instantiation.  For generic remote descriptors,
@@ -334,8 +352,7 @@ func (d *RawChannelEndpointDescriptor) GetParamsPath() string {
 // return nil if there are no parameters, or will unmarshall to one of:
 //       1. a single JSON string (unmarshalls to string) which is the params path.
 //       2. A JSON object (unmarshalls to map[string]interface{} that has provider-specific endpoint configuration data
-func (d *RawChannelEndpointDescriptor) GetParamsRaw() json.RawMessage
-{
+func (d *RawChannelEndpointDescriptor) GetParamsRaw() json.RawMessage {
 	return d.RawParams
 }
 
@@ -0,0 +1,50 @@
+package wstchannel
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestEndpointDescriptorLongString(t *testing.T) {
+	tests := []struct {
+		role   ChannelEndpointRole
+		epType ChannelEndpointProtocol
+		params string
+	}{
+		{ChannelEndpointRoleStub, ChannelEndpointProtocolTCP, `"0.0.0.0:3000"`},
+		{ChannelEndpointRoleSkeleton, ChannelEndpointProtocolTCP, `{"host":"localhost","port":22}`},
+		{ChannelEndpointRoleSkeleton, ChannelEndpointProtocolSocks, ``},
+	}
+
+	for _, tt := range tests {
+		var jsonParams json.RawMessage
+		if tt.params != "" {
+			jsonParams = json.RawMessage(tt.params)
+		}
+		d, err := NewChannelEndpointDescriptorWithJson(tt.role, tt.epType, "", jsonParams, "")
+		if err != nil {
+			t.Errorf("NewChannelEndpointDescriptorWithJson(%s, %s, %s) returned error: %s", tt.role, tt.epType, tt.params, err)
+			continue
+		}
+		ls := d.LongString()
+		if strings.Contains(ls, "%!") || strings.Contains(ls, "%s") {
+			t.Errorf("LongString() has formatting artifacts: %q", ls)
+		}
+		if !strings.Contains(ls, "role='"+string(tt.role)+"'") {
+			t.Errorf("LongString() %q does not contain role %s", ls, tt.role)
+		}
+		if !strings.Contains(ls, "type='"+string(tt.epType)+"'") {
+			t.Errorf("LongString() %q does not contain type %s", ls, tt.epType)
+		}
+		i := strings.Index(ls, "params=")
+		if i < 0 || !strings.HasSuffix(ls, ")") {
+			t.Errorf("LongString() %q does not contain params", ls)
+			continue
+		}
+		js := ls[i+len("params=") : len(ls)-1]
+		if !json.Valid([]byte(js)) {
+			t.Errorf("LongString() %q params are not valid JSON: %q", ls, js)
+		}
+	}
+}
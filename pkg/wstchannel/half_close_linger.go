@@ -0,0 +1,94 @@
+package wstchannel
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrHalfCloseLinger is returned (wrapped) by BasicBridgeChannels when one direction reached
+// end-of-stream and the other did not complete within the half-close linger timeout, so both
+// sides were force closed
+var ErrHalfCloseLinger = errors.New("Half-close linger timeout expired")
+
+// HalfCloseLingerEnv may be implemented by a LocalChannelEnv to provide a default half-close
+// linger timeout for the bridges it runs (see WithHalfCloseLinger)
+type HalfCloseLingerEnv interface {
+	// GetHalfCloseLinger returns the half-close linger timeout, or 0 for none
+	GetHalfCloseLinger() time.Duration
+}
+
+// GetEnvHalfCloseLinger returns the half-close linger timeout provided by env, or 0 if it does
+// not implement HalfCloseLingerEnv
+func GetEnvHalfCloseLinger(env LocalChannelEnv) time.Duration {
+	lenv, ok := env.(HalfCloseLingerEnv)
+	if !ok {
+		return 0
+	}
+	return lenv.GetHalfCloseLinger()
+}
+
+type halfCloseLingerKey struct{}
+
+// WithHalfCloseLinger returns a context that causes BasicBridgeChannels, once one direction has
+// reached end-of-stream and its write side has been shut down, to wait at most linger for the
+// other direction to complete before force closing both sides. A linger of 0 waits indefinitely.
+func WithHalfCloseLinger(ctx context.Context, linger time.Duration) context.Context {
+	return context.WithValue(ctx, halfCloseLingerKey{}, linger)
+}
+
+func getHalfCloseLinger(ctx context.Context) time.Duration {
+	if ctx == nil {
+		return 0
+	}
+	linger, _ := ctx.Value(halfCloseLingerKey{}).(time.Duration)
+	return linger
+}
+
+// halfCloseLinger tracks the two directions of a bridge, and calls forceClose if the second
+// direction does not complete within linger of the first
+type halfCloseLinger struct {
+	lock       sync.Mutex
+	linger     time.Duration
+	forceClose func()
+	remaining  int
+	timer      *time.Timer
+	expired    bool
+}
+
+func newHalfCloseLinger(linger time.Duration, forceClose func()) *halfCloseLinger {
+	return &halfCloseLinger{linger: linger, forceClose: forceClose, remaining: 2}
+}
+
+// directionDone is called when a direction of the bridge has completed
+func (l *halfCloseLinger) directionDone() {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.remaining--
+	if l.remaining == 1 && l.linger > 0 {
+		l.timer = time.AfterFunc(l.linger, l.expire)
+	}
+}
+
+func (l *halfCloseLinger) expire() {
+	l.lock.Lock()
+	if l.remaining == 0 {
+		l.lock.Unlock()
+		return
+	}
+	l.expired = true
+	l.lock.Unlock()
+	l.forceClose()
+}
+
+// stop cancels the timer once both directions have completed, and returns true if it had
+// already expired
+func (l *halfCloseLinger) stop() bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.timer != nil {
+		l.timer.Stop()
+	}
+	return l.expired
+}
@@ -0,0 +1,117 @@
+package wstchannel
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/sammck-go/logger"
+)
+
+func TestHalfCloseLinger(t *testing.T) {
+	if getHalfCloseLinger(context.Background()) != 0 {
+		t.Errorf("getHalfCloseLinger() of a plain context is nonzero")
+	}
+	if got := getHalfCloseLinger(WithHalfCloseLinger(context.Background(), time.Second)); got != time.Second {
+		t.Errorf("getHalfCloseLinger() = %s, want 1s", got)
+	}
+
+	// the second direction completes in time
+	closed := make(chan struct{})
+	l := newHalfCloseLinger(time.Hour, func() { close(closed) })
+	l.directionDone()
+	l.directionDone()
+	if l.stop() {
+		t.Errorf("Linger expired although both directions completed")
+	}
+
+	// the second direction hangs until force closed
+	l = newHalfCloseLinger(10*time.Millisecond, func() { close(closed) })
+	l.directionDone()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Linger did not force close a hung direction")
+	}
+	l.directionDone()
+	if !l.stop() {
+		t.Errorf("stop() did not report the expired linger")
+	}
+
+	// a linger of 0 never expires
+	l = newHalfCloseLinger(0, func() { t.Errorf("Linger of 0 force closed") })
+	l.directionDone()
+	time.Sleep(10 * time.Millisecond)
+	l.directionDone()
+	if l.stop() {
+		t.Errorf("Linger of 0 reported expiry")
+	}
+}
+
+// newTestSocketConnPair returns the two ends of a loopback TCP connection: a plain net.Conn for
+// the test, and a SocketConn for the bridge
+func newTestSocketConnPair(t *testing.T, l Logger) (net.Conn, *SocketConn) {
+	listener, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	testEnd, err := net.Dial("tcp4", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	bridgeEnd, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := NewSocketConn(l, bridgeEnd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testEnd, conn
+}
+
+func TestBridgeHalfCloseLinger(t *testing.T) {
+	l, err := logger.New(logger.WithPrefix("TestBridgeHalfCloseLinger"))
+	if err != nil {
+		t.Fatalf("logger.New() returned error: %s", err)
+	}
+	for _, serviceCloses := range []bool{true, false} {
+		caller, callerConn := newTestSocketConnPair(t, l)
+		service, serviceConn := newTestSocketConnPair(t, l)
+
+		// the Caller sends a request and half-closes; the Called Service reads it, and then either
+		// closes too or hangs with its write side open
+		caller.Write([]byte("request"))
+		caller.(*net.TCPConn).CloseWrite()
+		go func(service net.Conn, serviceCloses bool) {
+			ioutil.ReadAll(service)
+			if serviceCloses {
+				service.Close()
+			}
+		}(service, serviceCloses)
+
+		ctx := WithHalfCloseLinger(context.Background(), 50*time.Millisecond)
+		result := make(chan error, 1)
+		go func() {
+			_, _, err := BasicBridgeChannels(ctx, l, callerConn, serviceConn)
+			result <- err
+		}()
+		select {
+		case err := <-result:
+			if serviceCloses && err != nil {
+				t.Errorf("BasicBridgeChannels() with both directions completed = %v, want nil", err)
+			}
+			if !serviceCloses && !errors.Is(err, ErrHalfCloseLinger) {
+				t.Errorf("BasicBridgeChannels() with a hung direction = %v, want %v", err, ErrHalfCloseLinger)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("BasicBridgeChannels() did not force close a hung direction after the linger")
+		}
+		caller.Close()
+		service.Close()
+	}
+}
@@ -0,0 +1,48 @@
+package wstchannel
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// ParseIPNets parses a list of CIDR blocks ("10.0.0.0/8", "2001:db8::/32") or bare IP addresses,
+// which are treated as single-address blocks. Empty entries and surrounding whitespace are ignored.
+func ParseIPNets(entries []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, e := range entries {
+		e = strings.TrimSpace(e)
+		if e == "" {
+			continue
+		}
+		if !strings.Contains(e, "/") {
+			ip := net.ParseIP(e)
+			if ip == nil {
+				return nil, fmt.Errorf("'%s' is not an IP address or CIDR block", e)
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip = ip4
+				bits = 8 * net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(e)
+		if err != nil {
+			return nil, fmt.Errorf("'%s': %s", e, err)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// IPNetsContain returns true if ip is contained in any of nets
+func IPNetsContain(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
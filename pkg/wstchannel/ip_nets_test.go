@@ -0,0 +1,29 @@
+package wstchannel
+
+import (
+	"net"
+	"testing"
+)
+
+func TestParseIPNets(t *testing.T) {
+	nets, err := ParseIPNets([]string{"10.0.0.0/8", " 192.168.1.5 ", "", "2001:db8::1"})
+	if err != nil {
+		t.Fatalf("ParseIPNets(): unexpected error: %s", err)
+	}
+	if len(nets) != 3 {
+		t.Fatalf("ParseIPNets() returned %d networks, want 3", len(nets))
+	}
+	if !IPNetsContain(nets, net.ParseIP("192.168.1.5")) || IPNetsContain(nets, net.ParseIP("192.168.1.6")) {
+		t.Errorf("ParseIPNets() did not parse a bare IPv4 address as a single host")
+	}
+	if !IPNetsContain(nets, net.ParseIP("2001:db8::1")) || IPNetsContain(nets, net.ParseIP("2001:db8::2")) {
+		t.Errorf("ParseIPNets() did not parse a bare IPv6 address as a single host")
+	}
+
+	if _, err := ParseIPNets([]string{"10.0.0.0/33"}); err == nil {
+		t.Errorf("ParseIPNets() accepted an invalid CIDR")
+	}
+	if _, err := ParseIPNets([]string{"not-an-ip"}); err == nil {
+		t.Errorf("ParseIPNets() accepted an invalid address")
+	}
+}
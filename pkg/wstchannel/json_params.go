@@ -0,0 +1,126 @@
+package wstchannel
+
+// Helper functions for extracting typed values from a decoded JSON parameter object, as returned
+// by ChannelEndpointDescriptor.GetParamsMap(). A missing key (or a nil map) yields the provided
+// default without error; a key that is present with a value of the wrong type yields an error
+// that names the key.
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// ParamString extracts a string-valued parameter from a decoded JSON parameter object
+func ParamString(m map[string]interface{}, key string, defaultValue string) (string, error) {
+	v, ok := m[key]
+	if !ok || v == nil {
+		return defaultValue, nil
+	}
+	s, ok := v.(string)
+	if !ok {
+		return defaultValue, fmt.Errorf("Parameter \"%s\" must be a string; got %s", key, jsonTypeName(v))
+	}
+	return s, nil
+}
+
+// ParamInt extracts an integer-valued parameter from a decoded JSON parameter object. JSON numbers
+// are decoded as float64; a number with a fractional part or outside the range of int is an error.
+func ParamInt(m map[string]interface{}, key string, defaultValue int) (int, error) {
+	v, ok := m[key]
+	if !ok || v == nil {
+		return defaultValue, nil
+	}
+	var f float64
+	switch x := v.(type) {
+	case float64:
+		f = x
+	case int:
+		return x, nil
+	case int64:
+		f = float64(x)
+	default:
+		return defaultValue, fmt.Errorf("Parameter \"%s\" must be an integer; got %s", key, jsonTypeName(v))
+	}
+	if f != math.Trunc(f) {
+		return defaultValue, fmt.Errorf("Parameter \"%s\" must be an integer; got %v", key, f)
+	}
+	i := int(f)
+	if f > math.MaxInt64 || f < math.MinInt64 || float64(i) != f {
+		return defaultValue, fmt.Errorf("Parameter \"%s\" is out of range: %v", key, f)
+	}
+	return i, nil
+}
+
+// ParamBool extracts a boolean-valued parameter from a decoded JSON parameter object
+func ParamBool(m map[string]interface{}, key string, defaultValue bool) (bool, error) {
+	v, ok := m[key]
+	if !ok || v == nil {
+		return defaultValue, nil
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return defaultValue, fmt.Errorf("Parameter \"%s\" must be a boolean; got %s", key, jsonTypeName(v))
+	}
+	return b, nil
+}
+
+// ParamStringList extracts an array-of-strings parameter from a decoded JSON parameter object
+func ParamStringList(m map[string]interface{}, key string, defaultValue []string) ([]string, error) {
+	v, ok := m[key]
+	if !ok || v == nil {
+		return defaultValue, nil
+	}
+	a, ok := v.([]interface{})
+	if !ok {
+		return defaultValue, fmt.Errorf("Parameter \"%s\" must be an array of strings; got %s", key, jsonTypeName(v))
+	}
+	result := make([]string, len(a))
+	for i, e := range a {
+		s, ok := e.(string)
+		if !ok {
+			return defaultValue, fmt.Errorf("Parameter \"%s\" must be an array of strings; element %d is %s", key, i, jsonTypeName(e))
+		}
+		result[i] = s
+	}
+	return result, nil
+}
+
+// ParamDuration extracts a duration-valued parameter from a decoded JSON parameter object. The value
+// may be a string in time.ParseDuration format (e.g., "30s", "1m30s"), or a number of seconds.
+func ParamDuration(m map[string]interface{}, key string, defaultValue time.Duration) (time.Duration, error) {
+	v, ok := m[key]
+	if !ok || v == nil {
+		return defaultValue, nil
+	}
+	switch x := v.(type) {
+	case string:
+		d, err := time.ParseDuration(x)
+		if err != nil {
+			return defaultValue, fmt.Errorf("Parameter \"%s\" is not a valid duration: %s", key, err)
+		}
+		return d, nil
+	case float64:
+		return time.Duration(x * float64(time.Second)), nil
+	}
+	return defaultValue, fmt.Errorf("Parameter \"%s\" must be a duration string or a number of seconds; got %s", key, jsonTypeName(v))
+}
+
+// jsonTypeName returns the JSON type name of a decoded generic JSON value, for error messages
+func jsonTypeName(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "string"
+	case float64, int, int64:
+		return "number"
+	case bool:
+		return "boolean"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return fmt.Sprintf("%T", v)
+}
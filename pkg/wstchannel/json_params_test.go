@@ -0,0 +1,59 @@
+package wstchannel
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJSONParams(t *testing.T) {
+	var m map[string]interface{}
+	err := UnmarshalJsonString(`{"mode":"fast","backlog":16,"frac":1.5,"keepalive":true,"idleTimeout":"90s","linger":2}`, &m)
+	if err != nil {
+		t.Fatalf("UnmarshalJsonString failed: %s", err)
+	}
+
+	if s, err := ParamString(m, "mode", "slow"); err != nil || s != "fast" {
+		t.Errorf("ParamString(mode) = %q, %v", s, err)
+	}
+	if i, err := ParamInt(m, "backlog", 0); err != nil || i != 16 {
+		t.Errorf("ParamInt(backlog) = %d, %v", i, err)
+	}
+	if b, err := ParamBool(m, "keepalive", false); err != nil || !b {
+		t.Errorf("ParamBool(keepalive) = %v, %v", b, err)
+	}
+	if d, err := ParamDuration(m, "idleTimeout", 0); err != nil || d != 90*time.Second {
+		t.Errorf("ParamDuration(idleTimeout) = %v, %v", d, err)
+	}
+	if d, err := ParamDuration(m, "linger", 0); err != nil || d != 2*time.Second {
+		t.Errorf("ParamDuration(linger) = %v, %v", d, err)
+	}
+
+	// missing keys, and a nil map, yield the default without error
+	if s, err := ParamString(m, "missing", "dflt"); err != nil || s != "dflt" {
+		t.Errorf("ParamString(missing) = %q, %v", s, err)
+	}
+	if i, err := ParamInt(nil, "backlog", 7); err != nil || i != 7 {
+		t.Errorf("ParamInt(nil map) = %d, %v", i, err)
+	}
+
+	// present but wrong type is an error naming the key
+	wrongType := []struct {
+		key string
+		f   func() error
+	}{
+		{"mode", func() error { _, err := ParamInt(m, "mode", 0); return err }},
+		{"frac", func() error { _, err := ParamInt(m, "frac", 0); return err }},
+		{"backlog", func() error { _, err := ParamBool(m, "backlog", false); return err }},
+		{"keepalive", func() error { _, err := ParamString(m, "keepalive", ""); return err }},
+		{"mode", func() error { _, err := ParamDuration(m, "mode", 0); return err }},
+	}
+	for _, tt := range wrongType {
+		err := tt.f()
+		if err == nil {
+			t.Errorf("Expected type mismatch error for key %q", tt.key)
+		} else if !strings.Contains(err.Error(), "\""+tt.key+"\"") {
+			t.Errorf("Error %q does not name key %q", err, tt.key)
+		}
+	}
+}
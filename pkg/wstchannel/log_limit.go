@@ -0,0 +1,74 @@
+package wstchannel
+
+import (
+	"fmt"
+	"unicode/utf8"
+)
+
+// TruncateLogRecord truncates a formatted log message to max bytes (without splitting a UTF-8
+// sequence), appending an ellipsis and the number of bytes dropped. If max is 0 or the message
+// is short enough, it is returned unchanged.
+func TruncateLogRecord(msg string, max int) string {
+	if max <= 0 || len(msg) <= max {
+		return msg
+	}
+	n := max
+	for n > 0 && !utf8.RuneStart(msg[n]) {
+		n--
+	}
+	return fmt.Sprintf("%s...[%d bytes truncated]", msg[:n], len(msg)-n)
+}
+
+// limitedLogger wraps a Logger, truncating formatted messages, and the messages of the errors it
+// creates, to max bytes before they reach the underlying logger. Panic and Fatal messages are
+// passed through untouched, so the cause of a crash is never lost.
+type limitedLogger struct {
+	Logger
+	max int
+}
+
+// LimitLogger returns a Logger that truncates l's messages longer than max bytes, including those
+// of loggers forked from it and of the errors they create, with an ellipsis and the number of bytes
+// dropped, so that e.g. a descriptor with a huge parameter object cannot flood the log. If max is
+// 0, l is returned unchanged.
+func LimitLogger(l Logger, max int) Logger {
+	if max <= 0 {
+		return l
+	}
+	if ll, ok := l.(*limitedLogger); ok && ll.max == max {
+		return l
+	}
+	return &limitedLogger{Logger: l, max: max}
+}
+
+func (l *limitedLogger) limitf(f string, args ...interface{}) string {
+	return TruncateLogRecord(fmt.Sprintf(f, args...), l.max)
+}
+
+func (l *limitedLogger) Fork(prefix string, args ...interface{}) Logger {
+	return LimitLogger(l.Logger.Fork(prefix, args...), l.max)
+}
+
+func (l *limitedLogger) TLogf(f string, args ...interface{}) {
+	l.Logger.TLogf("%s", l.limitf(f, args...))
+}
+
+func (l *limitedLogger) DLogf(f string, args ...interface{}) {
+	l.Logger.DLogf("%s", l.limitf(f, args...))
+}
+
+func (l *limitedLogger) ILogf(f string, args ...interface{}) {
+	l.Logger.ILogf("%s", l.limitf(f, args...))
+}
+
+func (l *limitedLogger) Errorf(f string, args ...interface{}) error {
+	return l.Logger.Errorf("%s", l.limitf(f, args...))
+}
+
+func (l *limitedLogger) DLogErrorf(f string, args ...interface{}) error {
+	return l.Logger.DLogErrorf("%s", l.limitf(f, args...))
+}
+
+func (l *limitedLogger) WLogErrorf(f string, args ...interface{}) error {
+	return l.Logger.WLogErrorf("%s", l.limitf(f, args...))
+}
@@ -0,0 +1,51 @@
+package wstchannel
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/sammck-go/logger"
+)
+
+func TestTruncateLogRecord(t *testing.T) {
+	tests := []struct {
+		msg  string
+		max  int
+		want string
+	}{
+		{"short", 0, "short"},
+		{"short", 5, "short"},
+		{"0123456789", 4, "0123...[6 bytes truncated]"},
+		// never split a multi-byte rune
+		{"abécd", 3, "ab...[4 bytes truncated]"},
+	}
+	for _, tt := range tests {
+		if got := TruncateLogRecord(tt.msg, tt.max); got != tt.want {
+			t.Errorf("TruncateLogRecord(%q, %d) = %q, want %q", tt.msg, tt.max, got, tt.want)
+		}
+	}
+
+	huge := strings.Repeat("x", 1<<20)
+	if got := TruncateLogRecord(huge, 100); len(got) > 200 {
+		t.Errorf("TruncateLogRecord() of a 1MB message returned %d bytes", len(got))
+	}
+}
+
+func TestLimitLoggerErrorf(t *testing.T) {
+	l, err := logger.New(
+		logger.WithLogLevel(logger.LogLevelInfo),
+		logger.WithPrefix("TestLimitLoggerErrorf"),
+	)
+	if err != nil {
+		t.Fatalf("logger.New() returned error: %s", err)
+	}
+	if LimitLogger(l, 0) != l {
+		t.Errorf("LimitLogger() with no limit did not return the logger unchanged")
+	}
+
+	limited := LimitLogger(l, 10).Fork("child")
+	err = limited.Errorf("%s", strings.Repeat("x", 1000))
+	if !strings.HasSuffix(err.Error(), "xxxxxxxxxx...[990 bytes truncated]") {
+		t.Errorf("Errorf() of a forked limited logger returned %q", err)
+	}
+}
@@ -0,0 +1,138 @@
+package wstchannel
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/sammck-go/logger"
+)
+
+// LogRecord is a log message retained by a LogRing
+type LogRecord struct {
+	Time    time.Time `json:"time"`
+	Level   string    `json:"level"`
+	Prefix  string    `json:"prefix"`
+	Message string    `json:"message"`
+}
+
+func (r LogRecord) String() string {
+	return fmt.Sprintf("%s [%s] %s %s", r.Time.Format("2006/01/02 15:04:05.000000"), r.Level, r.Prefix, r.Message)
+}
+
+// LogRing retains the most recent log records written through loggers created with RingLogger,
+// for diagnosis without shipping logs. It is safe for concurrent use.
+type LogRing struct {
+	lock    sync.Mutex
+	records []LogRecord
+	// next is the index at which the next record is stored
+	next int
+	// full is true once the ring has wrapped around
+	full bool
+}
+
+// NewLogRing creates a LogRing that retains the most recent size records. Returns nil if size <= 0;
+// RingLogger does not record to a nil LogRing.
+func NewLogRing(size int) *LogRing {
+	if size <= 0 {
+		return nil
+	}
+	return &LogRing{records: make([]LogRecord, size)}
+}
+
+func (r *LogRing) add(level string, prefix string, msg string) {
+	rec := LogRecord{Time: time.Now(), Level: level, Prefix: prefix, Message: msg}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.records[r.next] = rec
+	r.next++
+	if r.next == len(r.records) {
+		r.next = 0
+		r.full = true
+	}
+}
+
+// Records returns a copy of the retained records, oldest first
+func (r *LogRing) Records() []LogRecord {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if !r.full {
+		return append([]LogRecord(nil), r.records[:r.next]...)
+	}
+	result := make([]LogRecord, 0, len(r.records))
+	result = append(result, r.records[r.next:]...)
+	return append(result, r.records[:r.next]...)
+}
+
+// Dump writes the retained records to w, one per line, oldest first
+func (r *LogRing) Dump(w io.Writer) error {
+	for _, rec := range r.Records() {
+		if _, err := fmt.Fprintln(w, rec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ringLogger wraps a Logger, recording each message written through it in a LogRing before
+// passing it on. Trace and debug messages are only recorded if l's log level enables them.
+// Panic and Fatal messages are recorded before the underlying logger ends the process.
+type ringLogger struct {
+	Logger
+	ring *LogRing
+}
+
+// RingLogger returns a Logger that records l's messages, including those of loggers forked from
+// it, in ring. If ring is nil, l is returned unchanged.
+func RingLogger(l Logger, ring *LogRing) Logger {
+	if ring == nil {
+		return l
+	}
+	return &ringLogger{Logger: l, ring: ring}
+}
+
+func (l *ringLogger) record(level logger.LogLevel, levelName string, f string, args ...interface{}) string {
+	msg := fmt.Sprintf(f, args...)
+	if l.GetLogLevel() >= level {
+		l.ring.add(levelName, l.Prefix(), msg)
+	}
+	return msg
+}
+
+func (l *ringLogger) Fork(prefix string, args ...interface{}) Logger {
+	return RingLogger(l.Logger.Fork(prefix, args...), l.ring)
+}
+
+func (l *ringLogger) TLogf(f string, args ...interface{}) {
+	l.Logger.TLogf("%s", l.record(logger.LogLevelTrace, "TRACE", f, args...))
+}
+
+func (l *ringLogger) DLogf(f string, args ...interface{}) {
+	l.Logger.DLogf("%s", l.record(logger.LogLevelDebug, "DEBUG", f, args...))
+}
+
+func (l *ringLogger) ILogf(f string, args ...interface{}) {
+	l.Logger.ILogf("%s", l.record(logger.LogLevelInfo, "INFO", f, args...))
+}
+
+func (l *ringLogger) WLog(args ...interface{}) {
+	l.record(logger.LogLevelWarning, "WARN", "%s", fmt.Sprint(args...))
+	l.Logger.WLog(args...)
+}
+
+func (l *ringLogger) DLogErrorf(f string, args ...interface{}) error {
+	return l.Logger.DLogErrorf("%s", l.record(logger.LogLevelDebug, "DEBUG", f, args...))
+}
+
+func (l *ringLogger) WLogErrorf(f string, args ...interface{}) error {
+	return l.Logger.WLogErrorf("%s", l.record(logger.LogLevelWarning, "WARN", f, args...))
+}
+
+func (l *ringLogger) Panicf(f string, args ...interface{}) {
+	l.Logger.Panicf("%s", l.record(logger.LogLevelPanic, "PANIC", f, args...))
+}
+
+func (l *ringLogger) Fatalf(f string, args ...interface{}) {
+	l.Logger.Fatalf("%s", l.record(logger.LogLevelFatal, "FATAL", f, args...))
+}
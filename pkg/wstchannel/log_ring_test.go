@@ -0,0 +1,71 @@
+package wstchannel
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/sammck-go/logger"
+)
+
+func TestLogRing(t *testing.T) {
+	if NewLogRing(0) != nil {
+		t.Errorf("NewLogRing(0) is not nil")
+	}
+	r := NewLogRing(3)
+	if len(r.Records()) != 0 {
+		t.Errorf("Records() of an empty ring = %v", r.Records())
+	}
+	for i := 1; i <= 5; i++ {
+		r.add("INFO", "test", fmt.Sprintf("msg%d", i))
+	}
+	records := r.Records()
+	if len(records) != 3 || records[0].Message != "msg3" || records[2].Message != "msg5" {
+		t.Errorf("Records() = %v, want msg3..msg5", records)
+	}
+	var b bytes.Buffer
+	if err := r.Dump(&b); err != nil || strings.Count(b.String(), "\n") != 3 || !strings.Contains(b.String(), "[INFO] test msg5") {
+		t.Errorf("Dump() = %q, %v", b.String(), err)
+	}
+
+	// concurrent logging
+	r = NewLogRing(100)
+	var wg sync.WaitGroup
+	for g := 0; g < 10; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				r.add("INFO", "test", "msg")
+			}
+		}()
+	}
+	wg.Wait()
+	if len(r.Records()) != 100 {
+		t.Errorf("Records() after concurrent logging has %d records, want 100", len(r.Records()))
+	}
+}
+
+func TestRingLogger(t *testing.T) {
+	l, err := logger.New(
+		logger.WithLogLevel(logger.LogLevelInfo),
+		logger.WithPrefix("TestRingLogger"),
+	)
+	if err != nil {
+		t.Fatalf("logger.New() returned error: %s", err)
+	}
+	if RingLogger(l, nil) != l {
+		t.Errorf("RingLogger() with a nil ring did not return the logger unchanged")
+	}
+	r := NewLogRing(10)
+	rl := RingLogger(l, r)
+	rl.DLogf("debug %d", 1)
+	rl.ILogf("info %d", 2)
+	rl.Fork("child").ILogf("info %d", 3)
+	records := r.Records()
+	if len(records) != 2 || records[0].Message != "info 2" || records[1].Message != "info 3" {
+		t.Errorf("Records() = %v, want the two info messages", records)
+	}
+}
@@ -1,4 +1,4 @@
-package chshare
+package wstchannel
 
 import (
 	"context"
@@ -15,22 +15,66 @@ type loopEntry struct {
 	acceptor *LoopStubEndpoint
 }
 
+// DefaultLoopMaxPendingCallerConns is the default limit on the number of Caller connections that
+// may be waiting to be accepted, across all the loop pathnames of a LoopServer. Each
+// LoopStubEndpoint also limits its own backlog, so this only matters when there are many of them.
+const DefaultLoopMaxPendingCallerConns = 1024
+
 // LoopServer maintains a namespace of loop pathnames with waiting LoopStubEndpoint's.
 type LoopServer struct {
 	Logger
 	lock    sync.Mutex
 	entries map[string]*loopEntry
+	// pending is the number of Caller connections waiting to be accepted, across all names
+	pending int
+	// maxPending limits pending; 0 means no limit
+	maxPending int
 }
 
 // NewLoopServer creates a new LoopServer
 func NewLoopServer(logger Logger) (*LoopServer, error) {
 	s := &LoopServer{
-		Logger:  logger.Fork("LoopServer"),
-		entries: make(map[string]*loopEntry),
+		Logger:     logger.Fork("LoopServer"),
+		entries:    make(map[string]*loopEntry),
+		maxPending: DefaultLoopMaxPendingCallerConns,
 	}
 	return s, nil
 }
 
+// SetMaxPendingCallerConns sets the limit on the number of Caller connections that may be waiting
+// to be accepted, across all loop pathnames (see DefaultLoopMaxPendingCallerConns). Connections in
+// excess of either this limit or the backlog of their LoopStubEndpoint are refused. 0 means no limit.
+func (s *LoopServer) SetMaxPendingCallerConns(max int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.maxPending = max
+}
+
+// reservePending counts a Caller connection that is about to be queued for Accept. Returns false,
+// without counting it, if the limit has been reached.
+func (s *LoopServer) reservePending() bool {
+	if s == nil {
+		return true
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.maxPending > 0 && s.pending >= s.maxPending {
+		return false
+	}
+	s.pending++
+	return true
+}
+
+// releasePending uncounts a Caller connection that was accepted, refused or discarded
+func (s *LoopServer) releasePending() {
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.pending--
+}
+
 func (s *LoopServer) String() string {
 	return s.Logger.Prefix()
 }
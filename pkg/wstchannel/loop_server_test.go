@@ -0,0 +1,31 @@
+package wstchannel
+
+import "testing"
+
+func TestLoopServerPendingLimit(t *testing.T) {
+	s := &LoopServer{maxPending: 2}
+	if !s.reservePending() || !s.reservePending() {
+		t.Fatalf("reservePending() failed below the limit")
+	}
+	if s.reservePending() {
+		t.Fatalf("reservePending() succeeded at the limit")
+	}
+	s.releasePending()
+	if !s.reservePending() {
+		t.Fatalf("reservePending() failed after a release")
+	}
+
+	s.SetMaxPendingCallerConns(0)
+	for i := 0; i < 10; i++ {
+		if !s.reservePending() {
+			t.Fatalf("reservePending() failed with no limit")
+		}
+	}
+
+	// endpoints without a LoopServer are not limited
+	var none *LoopServer
+	if !none.reservePending() {
+		t.Fatalf("reservePending() failed on a nil LoopServer")
+	}
+	none.releasePending()
+}
@@ -50,6 +50,7 @@ func (ep *LoopSkeletonEndpoint) Dial(ctx context.Context, extraData []byte) (Cha
 	}
 
 	ep.AddShutdownChild(conn)
+	ep.notifyDial(conn)
 
 	return conn, nil
 }
@@ -51,6 +51,7 @@ func (ep *LoopStubEndpoint) HandleOnceShutdown(completionErr error) error {
 	ep.Lock.Unlock()
 
 	for dc := range ep.callerConns {
+		ep.loopServer.releasePending()
 		if dc != nil {
 			dc.Close()
 		}
@@ -82,24 +83,39 @@ func (ep *LoopStubEndpoint) StartListening() error {
 }
 
 // Accept listens for and accepts a single connection from a Caller network client as specified in the
-// endpoint configuration. This call does not return until a new connection is available or a
-// error occurs. There is no way to cancel an Accept() request other than closing the endpoint. Part of
+// endpoint configuration. This call does not return until a new connection is available, ctx
+// is cancelled, or an error occurs. If ctx is cancelled, ctx.Err() is returned; a connection that
+// was dequeued concurrently with cancellation is closed rather than leaked. Part of
 // the AcceptorChannelEndpoint interface.
 func (ep *LoopStubEndpoint) Accept(ctx context.Context) (ChannelConn, error) {
-	dialConn, ok := <-ep.callerConns
+	var dialConn ChannelConn
+	var ok bool
+	select {
+	case dialConn, ok = <-ep.callerConns:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
 	if !ok {
 		return nil, fmt.Errorf("%s: endpoint is closed", ep.Logger.Prefix())
 	}
+	ep.loopServer.releasePending()
+	if err := ctx.Err(); err != nil {
+		// select picks randomly when both are ready; nobody will receive this conn now
+		if dialConn != nil {
+			dialConn.Close()
+		}
+		return nil, err
+	}
 	ep.AddShutdownChild(dialConn)
+	ep.notifyAccept(dialConn)
 	return dialConn, nil
 }
 
 // AcceptAndServe listens for and accepts a single connection from a Caller network client as specified in the
 // endpoint configuration, then services the connection using an already established
 // calledServiceConn as the proxied Called Service's end of the session. This call does not return until
-// the bridged session completes or an error occurs. There is no way to cancel the Accept() portion
-// of the request other than closing the endpoint through other means. After the connection has been
-// accepted, the context may be used to cancel servicing of the active session.
+// the bridged session completes or an error occurs. The context may be used to cancel the Accept()
+// portion of the request, or, after the connection has been accepted, servicing of the active session.
 // Ownership of calledServiceConn is transferred to this function, and it will be closed before this function returns.
 // This API may be more efficient than separately using Accept() and then bridging between the two
 // ChannelConns with BasicBridgeChannels. In particular, "loop" endpoints can avoid creation
@@ -118,17 +134,23 @@ func (ep *LoopStubEndpoint) AcceptAndServe(ctx context.Context, calledServiceCon
 	return BasicBridgeChannels(ctx, ep.Logger, callerConn, calledServiceConn)
 }
 
-// EnqueueCallerConn provides a ChannelConn to be returned by a future or pending Accept call
+// EnqueueCallerConn provides a ChannelConn to be returned by a future or pending Accept call. It
+// fails if the endpoint's own backlog is full, or if the LoopServer's limit on pending Caller
+// connections across all loop pathnames has been reached.
 func (ep *LoopStubEndpoint) EnqueueCallerConn(dialConn ChannelConn) error {
 	ep.Lock.Lock()
 	defer ep.Lock.Unlock()
 	if !ep.listening {
 		return fmt.Errorf("%s: No listener on loop path", ep.Logger.Prefix())
 	}
+	if !ep.loopServer.reservePending() {
+		return fmt.Errorf("%s: Too many connections waiting to be accepted across all loop paths", ep.Logger.Prefix())
+	}
 	select {
 	case ep.callerConns <- dialConn:
 		return nil
 	default:
+		ep.loopServer.releasePending()
 		return fmt.Errorf("%s: Listener accept backlog full", ep.Logger.Prefix())
 	}
 }
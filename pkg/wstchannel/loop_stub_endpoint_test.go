@@ -0,0 +1,48 @@
+package wstchannel
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// closeRecorderConn is a ChannelConn that only records whether it was closed
+type closeRecorderConn struct {
+	ChannelConn
+	closed bool
+}
+
+func (c *closeRecorderConn) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestLoopStubAcceptCancel(t *testing.T) {
+	ep := &LoopStubEndpoint{callerConns: make(chan ChannelConn, 5)}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+	if _, err := ep.Accept(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Accept() of a cancelled pending accept returned %v, want context.Canceled", err)
+	}
+
+	// a conn that is ready at the same time as cancellation is either left queued or closed
+	for i := 0; i < 100; i++ {
+		conn := &closeRecorderConn{}
+		ep.callerConns <- conn
+		if _, err := ep.Accept(ctx); !errors.Is(err, context.Canceled) {
+			t.Fatalf("Accept() with a cancelled context returned %v, want context.Canceled", err)
+		}
+		select {
+		case queued := <-ep.callerConns:
+			if queued != conn || conn.closed {
+				t.Fatalf("Accept() left an unexpected conn in the queue")
+			}
+		default:
+			if !conn.closed {
+				t.Fatalf("Accept() dequeued a conn after cancellation without closing it")
+			}
+		}
+	}
+}
@@ -0,0 +1,44 @@
+//+build linux
+
+package wstchannel
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+)
+
+// soOriginalDst is the netfilter getsockopt option that returns the pre-NAT destination of a
+// redirected connection (SO_ORIGINAL_DST in <linux/netfilter_ipv4.h>)
+const soOriginalDst = 80
+
+// getOriginalDst returns the original "<ip>:<port>" destination of a TCP connection that was
+// redirected to a local listener by an iptables REDIRECT rule in the nat table. Only IPv4 is
+// supported.
+func getOriginalDst(netConn net.Conn) (string, error) {
+	tcpConn, ok := netConn.(*net.TCPConn)
+	if !ok {
+		return "", fmt.Errorf("Original destination requires a TCP connection, got %T", netConn)
+	}
+	rawConn, err := tcpConn.SyscallConn()
+	if err != nil {
+		return "", err
+	}
+	var mreq *syscall.IPv6Mreq
+	var sockErr error
+	err = rawConn.Control(func(fd uintptr) {
+		// The kernel returns a struct sockaddr_in, which fits in the IPv6Mreq buffer
+		mreq, sockErr = syscall.GetsockoptIPv6Mreq(int(fd), syscall.IPPROTO_IP, soOriginalDst)
+	})
+	if err != nil {
+		return "", err
+	}
+	if sockErr != nil {
+		return "", fmt.Errorf("getsockopt(SO_ORIGINAL_DST) failed: %s", sockErr)
+	}
+	// struct sockaddr_in: family (2 bytes), port (2 bytes, network order), addr (4 bytes)
+	raw := mreq.Multiaddr
+	port := int(raw[2])<<8 | int(raw[3])
+	ip := net.IPv4(raw[4], raw[5], raw[6], raw[7])
+	return net.JoinHostPort(ip.String(), fmt.Sprintf("%d", port)), nil
+}
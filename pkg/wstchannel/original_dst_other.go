@@ -0,0 +1,14 @@
+//+build !linux
+
+package wstchannel
+
+import (
+	"fmt"
+	"net"
+	"runtime"
+)
+
+// getOriginalDst is only supported on Linux, where netfilter provides SO_ORIGINAL_DST
+func getOriginalDst(netConn net.Conn) (string, error) {
+	return "", fmt.Errorf("Transparent proxy stubs are not supported on %s", runtime.GOOS)
+}
@@ -205,9 +205,10 @@ func parseNextBracketedBlock(s string) (bs string, nb int, err error) {
 	}
 
 	result := make([]byte, 0, 16)
+	result = append(result, s[:orsize]...)
 
 	for i := orsize; i < len(s); {
-		c, csize := utf8.DecodeRuneInString(s)
+		c, csize := utf8.DecodeRuneInString(s[i:])
 		if c == utf8.RuneError {
 			return "", i, fmt.Errorf("Incomplete UTF-8 rune")
 		}
@@ -242,7 +243,7 @@ func parseNextSingleQuotedString(s string) (qs string, nb int, err error) {
 	}
 
 	for i := 1; i < len(s); {
-		c, csize := utf8.DecodeRuneInString(s)
+		c, csize := utf8.DecodeRuneInString(s[i:])
 		if c == utf8.RuneError {
 			return "", i, fmt.Errorf("Incomplete UTF-8 rune")
 		}
@@ -268,7 +269,7 @@ func parseNextDoubleQuotedString(s string) (qs string, nb int, err error) {
 	}
 
 	for i := 1; i < len(s); {
-		c, csize := utf8.DecodeRuneInString(s)
+		c, csize := utf8.DecodeRuneInString(s[i:])
 		if c == utf8.RuneError {
 			return "", i, fmt.Errorf("Incomplete UTF-8 rune")
 		}
@@ -331,6 +332,8 @@ func ParseNextElement(s string) (bs string, nb int, err error) {
 		bs, nb, err = parseNextDoubleQuotedString(s)
 	} else if c == '\'' {
 		bs, nb, err = parseNextSingleQuotedString(s)
+	} else {
+		bs, nb = s[:csize], csize
 	}
 	return bs, nb, err
 }
@@ -369,7 +372,7 @@ func ParseElementsToDelim(s string, delims []rune) (bs string, nb int, delim run
 
 	var i int
 	for i = 0; i < len(s); {
-		c, _ := utf8.DecodeRuneInString(s[i:])
+		c, csize := utf8.DecodeRuneInString(s[i:])
 		if c == utf8.RuneError {
 			return "", i, 0, fmt.Errorf("Incomplete UTF-8 rune")
 		}
@@ -377,6 +380,7 @@ func ParseElementsToDelim(s string, delims []rune) (bs string, nb int, delim run
 			// special case == "://" is not a delimeter even if ":" is in delimeter list
 			if c != ':' || i+3 > len(s) || s[i+1:i+3] != "//" {
 				delim = c
+				i += csize
 				break
 			}
 		}
@@ -573,6 +577,106 @@ func parseProtocolPrefix(s string) (protocol ChannelEndpointProtocol, nb int) {
 	return "", 0
 }
 
+// DefaultSocksStubBindAddress is the stub bind address used for a legacy "socks" channel descriptor
+// that does not specify one, unless ChannelDescriptorDefaults give another
+const DefaultSocksStubBindAddress = "127.0.0.1"
+
+// DefaultSocksStubPort is the stub port used for a legacy "socks" channel descriptor that does not
+// specify one, unless ChannelDescriptorDefaults give another
+const DefaultSocksStubPort = PortNumber(1080)
+
+// ChannelDescriptorDefaults holds the defaults that parsing applies to what a channel descriptor
+// string omits (see ParseChannelDescriptorPathWithDefaults). They belong to whoever parses, e.g., a
+// client's Config, so that different parsers in one process do not affect each other.
+type ChannelDescriptorDefaults struct {
+	// SocksStubBindAddress is the stub bind address of a legacy "socks" descriptor that gives none
+	SocksStubBindAddress string
+
+	// SocksStubPort is the stub port of a legacy "socks" descriptor that gives none
+	SocksStubPort PortNumber
+
+	// SkeletonHost, if not empty, is the target host of a TCP skeleton that gives a port but no
+	// host, in both the legacy form (e.g., "3000" or "3000:80") and the full form (e.g.,
+	// "tcp://:80"). If empty, a legacy skeleton gets DefaultSkeletonHost, and a full-form skeleton
+	// is left without a host, for the side that dials it to resolve (see TCPSkeletonHostEnv).
+	SkeletonHost string
+}
+
+// NewChannelDescriptorDefaults returns the built-in defaults, which are also used when parsing
+// with nil ChannelDescriptorDefaults
+func NewChannelDescriptorDefaults() *ChannelDescriptorDefaults {
+	return &ChannelDescriptorDefaults{
+		SocksStubBindAddress: DefaultSocksStubBindAddress,
+		SocksStubPort:        DefaultSocksStubPort,
+	}
+}
+
+// SetSocksStubAddress sets the stub bind address and/or port used for legacy "socks" channel
+// descriptors that do not specify them explicitly. hostPort may be <host>:<port>, <host>, or <port>;
+// a part that is omitted retains its current default. An address or port given explicitly in a
+// descriptor always takes precedence.
+func (defaults *ChannelDescriptorDefaults) SetSocksStubAddress(hostPort string) error {
+	host, port, err := ParseHostPort(hostPort, defaults.SocksStubBindAddress, defaults.SocksStubPort)
+	if err != nil {
+		return fmt.Errorf("Invalid default SOCKS stub address \"%s\": %s", hostPort, err)
+	}
+	if host == "" {
+		return fmt.Errorf("Invalid default SOCKS stub address \"%s\": empty bind address", hostPort)
+	}
+	defaults.SocksStubBindAddress = host
+	defaults.SocksStubPort = port
+	return nil
+}
+
+// DefaultSkeletonHost is the target host of a legacy TCP skeleton that gives a port but no host,
+// unless ChannelDescriptorDefaults give another
+const DefaultSkeletonHost = "localhost"
+
+// SetSkeletonHost sets the target host used for TCP skeletons that do not specify one. A host given
+// explicitly in a descriptor always takes precedence.
+func (defaults *ChannelDescriptorDefaults) SetSkeletonHost(host string) error {
+	host, err := NormalizeSkeletonHost(host)
+	if err != nil {
+		return err
+	}
+	defaults.SkeletonHost = host
+	return nil
+}
+
+// NormalizeSkeletonHost checks a default skeleton host (see ChannelDescriptorDefaults.SkeletonHost
+// and TCPSkeletonHostEnv), bracketing an IPv6 address so that it can be joined with a port
+func NormalizeSkeletonHost(host string) (string, error) {
+	if host == "" || strings.ContainsAny(host, "/ ") {
+		return "", fmt.Errorf("Invalid default skeleton host \"%s\"", host)
+	}
+	if strings.Contains(host, ":") && !strings.HasPrefix(host, "[") {
+		host = "[" + host + "]"
+	}
+	return host, nil
+}
+
+// defaultTCPSkeletonPath applies defaultHost to a TCP skeleton params path that has a port but no
+// host, or to each such target of a list of fallback targets (see TCPSkeletonTargets). Any other
+// path, or any path if defaultHost is empty, is returned unchanged.
+func defaultTCPSkeletonPath(path string, defaultHost string) string {
+	if defaultHost == "" || path == "" || path[0] == '{' {
+		return path
+	}
+	if strings.Contains(path, TCPSkeletonTargetSeparator) {
+		// each fallback target gets the default host
+		targets := strings.Split(path, TCPSkeletonTargetSeparator)
+		for i, target := range targets {
+			targets[i] = defaultTCPSkeletonPath(strings.TrimSpace(target), defaultHost)
+		}
+		return strings.Join(targets, TCPSkeletonTargetSeparator)
+	}
+	host, port, err := ParseHostPort(path, "", UnknownPortNumber)
+	if err != nil || host != "" || port == UnknownPortNumber {
+		return path
+	}
+	return fmt.Sprintf("%s:%d", defaultHost, port)
+}
+
 // ParseNextLegacyChannelEndpointItem parses the next endpoint or endpoint:port out of a presplit ":"-delimited string,
 // returning the remainder of unparsed parts
 func ParseNextLegacyChannelEndpointDescriptor(parts []string) (epProtocol ChannelEndpointProtocol, epParams string, port PortNumber, remParts []string, nb int, err error) {
@@ -584,16 +688,37 @@ func ParseNextLegacyChannelEndpointDescriptor(parts []string) (epProtocol Channe
 		port, _ = ParsePortNumber(parts[0])
 		return ChannelEndpointProtocolTCP, "", port, parts[1:], len(parts[0]), nil
 	}
+	if protocol, pnb := parseProtocolPrefix(s); protocol != "" {
+		// A full-form "<protocol>://<params>" endpoint consumes the remainder of the descriptor
+		if protocol == ChannelEndpointProtocolTCP {
+			host, port, err := ParseHostPort(s[pnb:], "", UnknownPortNumber)
+			if err != nil {
+				return ChannelEndpointProtocolUnknown, "", UnknownPortNumber, nil, pnb, err
+			}
+			return ChannelEndpointProtocolTCP, host, port, nil, len(s), nil
+		}
+		return protocol, s[pnb:], UnknownPortNumber, nil, len(s), nil
+	}
 	sp := strings.ToLower(parts[0])
-	if sp == "stdio" {
+	if sp == "unix" {
+		if len(parts) < 2 || parts[1] == "" {
+			return ChannelEndpointProtocolUnknown, "", UnknownPortNumber, nil, len(parts[0]), fmt.Errorf("Missing unix domain socket path: '%s'", s)
+		}
+		return ChannelEndpointProtocolUnix, parts[1], UnknownPortNumber, parts[2:], len(parts[0]) + 1 + len(parts[1]), nil
+	} else if sp == "loop" {
+		if len(parts) < 2 || parts[1] == "" {
+			return ChannelEndpointProtocolUnknown, "", UnknownPortNumber, nil, len(parts[0]), fmt.Errorf("Missing loop name: '%s'", s)
+		}
+		return ChannelEndpointProtocolLoop, parts[1], UnknownPortNumber, parts[2:], len(parts[0]) + 1 + len(parts[1]), nil
+	} else if sp == "stdio" {
 		return ChannelEndpointProtocolStdio, "", UnknownPortNumber, parts[1:], len(parts[0]), nil
 	} else if sp == "socks" {
-		return ChannelEndpointProtocolStdio, "", UnknownPortNumber, parts[1:], len(parts[0]), nil
+		return ChannelEndpointProtocolSocks, "", UnknownPortNumber, parts[1:], len(parts[0]), nil
 	} else {
 		port = UnknownPortNumber
 		np := 1
 		nb = len(parts[0])
-		if len(parts) > 1 && IsPortNumberString(parts[0]) {
+		if len(parts) > 1 && IsPortNumberString(parts[1]) {
 			port, _ = ParsePortNumber(parts[1])
 			np = 2
 			nb += len(parts[1]) + 1
@@ -621,6 +746,8 @@ func ParseNextLegacyChannelEndpointDescriptor(parts []string) (epProtocol Channe
 //         <TCP-bind-port-number>
 //         <IPV4-bind address> ':' <TCP bind port number>
 //         '[' <IPV6 bind address> ']' ':' <TCP bind port number>
+//         "unix:" <unix-domain-socket-path>
+//         "loop:" <loop-name>
 //         "stdio"
 //		   "socks"
 //
@@ -629,9 +756,29 @@ func ParseNextLegacyChannelEndpointDescriptor(parts []string) (epProtocol Channe
 //         <IPV4 target address> ':' <TCP bind port number>
 //         '[' <IPV6 target address> ']' ':' <TCP bind port number>
 //         <target hostname> ':' <TCP bind port number>
+//         "unix:" <unix-domain-socket-path>
+//         "loop:" <loop-name>
+//         "stdio"
+//         <protocol> "://" [ <protocol-params> ]
 //         "socks"
+//
+// The skeleton tokens apply equally to reverse descriptors, e.g., "R:2222:unix:/tmp/s.sock" listens
+// on TCP port 2222 on the server and forwards to a unix domain socket on the client. A skeleton
+// without a protocol token is TCP. The resulting descriptor is validated, so combinations that
+// cannot work (e.g., a STDIO endpoint on the server side) are rejected.
+//
 // If an error occurs, nb indicates a best guess at the byte offset of the error.
-func ParseLegacyChannelDescriptorPath(s string) (d ChannelEndpointDescriptor, nb int, err error) {
+func ParseLegacyChannelDescriptorPath(s string) (d ChannelDescriptor, nb int, err error) {
+	return parseLegacyChannelDescriptorPath(s, false, nil)
+}
+
+// parseLegacyChannelDescriptorPath implements ParseLegacyChannelDescriptorPath. If strict is true,
+// omitted endpoints, addresses and ports are errors rather than defaulted. Otherwise they are
+// given defaults, or the built-in defaults if defaults is nil.
+func parseLegacyChannelDescriptorPath(s string, strict bool, defaults *ChannelDescriptorDefaults) (d ChannelDescriptor, nb int, err error) {
+	if defaults == nil {
+		defaults = NewChannelDescriptorDefaults()
+	}
 	reverse := false
 	nbr := 0
 	if strings.HasPrefix(s, "R:") {
@@ -641,31 +788,64 @@ func ParseLegacyChannelDescriptorPath(s string) (d ChannelEndpointDescriptor, nb
 
 	parts, nb, err := SplitBalanced(s[nbr:], []rune{':'})
 	if err != nil {
-		return nil, nb, fmt.Errorf("Invalid channel descriptor at offset %d of \"%s\": %v", utf8.RuneCountInString(s[:nb]), err)
+		return ChannelDescriptor{}, nbr + nb, fmt.Errorf("Invalid channel descriptor at char offset %d of \"%s\": %v", utf8.RuneCountInString(s[:nbr+nb]), s, err)
 	}
 	if len(parts) == 0 {
-		return nil, len(s), fmt.Errorf("Empty channel descriptor \"%s\"", s)
+		return ChannelDescriptor{}, len(s), fmt.Errorf("Empty channel descriptor \"%s\"", s)
 	}
 	stubProtocol, stubParams, stubPort, remParts1, nb1, err := ParseNextLegacyChannelEndpointDescriptor(parts)
 	if err != nil {
-		return nil, nbr + nb1, fmt.Errorf("Invalid stub channel descriptor \"%s\": %v", s, err)
+		return ChannelDescriptor{}, nbr + nb1, fmt.Errorf("Invalid stub channel descriptor \"%s\": %v", s, err)
 	}
 	var skeletonProtocol ChannelEndpointProtocol
 	var skeletonPort PortNumber = UnknownPortNumber
 	var skeletonParams string
 	if len(remParts1) == 0 {
-		skeletonProtocol = ChannelEndpointProtocolUnknown
-		skeletonPort = UnknownPortNumber
-		skeletonParams = ""
+		if stubProtocol == ChannelEndpointProtocolTCP && stubParams != "" {
+			// A lone <host>:<port> names the skeleton (e.g., "example.com:3000"); the stub is defaulted
+			skeletonProtocol = ChannelEndpointProtocolTCP
+			skeletonParams = stubParams
+			skeletonPort = stubPort
+			stubParams = ""
+			stubPort = UnknownPortNumber
+		} else {
+			skeletonProtocol = ChannelEndpointProtocolUnknown
+			skeletonPort = UnknownPortNumber
+			skeletonParams = ""
+		}
 	} else {
 		var remParts2 []string
 		var nb2 int
 		skeletonProtocol, skeletonParams, skeletonPort, remParts2, nb2, err = ParseNextLegacyChannelEndpointDescriptor(remParts1)
 		if err != nil {
-			return nil, nbr + nb1 + 1 + nb2, fmt.Errorf("Invalid skeleton channel descriptor \"%s\": %v", s, err)
+			return ChannelDescriptor{}, nbr + nb1 + 1 + nb2, fmt.Errorf("Invalid skeleton channel descriptor \"%s\": %v", s, err)
 		}
 		if len(remParts2) > 0 {
-			return nil, nbr + nb1 + 1 + nb2, fmt.Errorf("Extraneous ':' delimeter in channel descriptor \"%s\"", s)
+			return ChannelDescriptor{}, nbr + nb1 + 1 + nb2, fmt.Errorf("Extraneous ':' delimeter in channel descriptor \"%s\"", s)
+		}
+	}
+
+	if stubProtocol == ChannelEndpointProtocolSocks && skeletonProtocol == ChannelEndpointProtocolUnknown {
+		// A lone "socks" is a socks skeleton with a default TCP stub
+		stubProtocol = ChannelEndpointProtocolTCP
+		skeletonProtocol = ChannelEndpointProtocolSocks
+	}
+
+	if strict {
+		field := ""
+		if skeletonProtocol == ChannelEndpointProtocolUnknown {
+			field = "skeleton endpoint"
+		} else if stubProtocol == ChannelEndpointProtocolTCP && stubParams == "" {
+			field = "stub bind address"
+		} else if stubProtocol == ChannelEndpointProtocolTCP && stubPort == UnknownPortNumber {
+			field = "stub port"
+		} else if skeletonProtocol == ChannelEndpointProtocolTCP && skeletonParams == "" {
+			field = "skeleton host"
+		} else if skeletonProtocol == ChannelEndpointProtocolTCP && skeletonPort == UnknownPortNumber {
+			field = "skeleton port"
+		}
+		if field != "" {
+			return ChannelDescriptor{}, len(s), strictOmittedError(s, field)
 		}
 	}
 
@@ -675,7 +855,7 @@ func ParseLegacyChannelDescriptorPath(s string) (d ChannelEndpointDescriptor, nb
 
 	if stubProtocol == ChannelEndpointProtocolTCP && stubParams == "" {
 		if skeletonProtocol == ChannelEndpointProtocolSocks {
-			stubParams = "127.0.0.1"
+			stubParams = defaults.SocksStubBindAddress
 		} else {
 			stubParams = "0.0.0.0"
 		}
@@ -683,7 +863,7 @@ func ParseLegacyChannelDescriptorPath(s string) (d ChannelEndpointDescriptor, nb
 
 	if stubProtocol == ChannelEndpointProtocolTCP && stubPort == UnknownPortNumber {
 		if skeletonProtocol == ChannelEndpointProtocolSocks {
-			stubPort = PortNumber(1080)
+			stubPort = defaults.SocksStubPort
 		} else if skeletonPort != UnknownPortNumber {
 			stubPort = skeletonPort
 		}
@@ -697,39 +877,45 @@ func ParseLegacyChannelDescriptorPath(s string) (d ChannelEndpointDescriptor, nb
 
 	if stubProtocol == ChannelEndpointProtocolTCP {
 		if stubParams == "" {
-			return nil, len(s), fmt.Errorf("Unable to determine stub bind address in channel descriptor string: '%s'", s)
+			return ChannelDescriptor{}, len(s), fmt.Errorf("Unable to determine stub bind address in channel descriptor string: '%s'", s)
 		}
 		if stubPort == UnknownPortNumber {
-			return nil, len(s), fmt.Errorf("Unable to determine stub port number in channel descriptor string: '%s'", s)
+			return ChannelDescriptor{}, len(s), fmt.Errorf("Unable to determine stub port number in channel descriptor string: '%s'", s)
 		}
 		stubParams = fmt.Sprintf("%s:%d", stubParams, stubPort)
 	}
 
 	if skeletonProtocol == ChannelEndpointProtocolTCP {
 		if skeletonParams == "" {
-			skeletonParams = "localhost"
+			skeletonParams = defaults.SkeletonHost
+			if skeletonParams == "" {
+				skeletonParams = DefaultSkeletonHost
+			}
 		}
 		if skeletonPort == UnknownPortNumber {
-			return nil, len(s), fmt.Errorf("Unable to determine skeleton port number in channel descriptor string: '%s'", s)
+			return ChannelDescriptor{}, len(s), fmt.Errorf("Unable to determine skeleton port number in channel descriptor string: '%s'", s)
 		}
 		skeletonParams = fmt.Sprintf("%s:%d", skeletonParams, skeletonPort)
 	}
 
 	if skeletonProtocol == ChannelEndpointProtocolUnknown {
-		return nil, len(s), fmt.Errorf("Unable to determine skeleton endpoint type: '%s'", s)
+		return ChannelDescriptor{}, len(s), fmt.Errorf("Unable to determine skeleton endpoint type: '%s'", s)
 	}
 
 	stub, _, err := NewChannelEndpointDescriptorWithParamsPath(ChannelEndpointRoleStub, stubProtocol, "", stubParams, false)
 	if err != nil {
-		return nil, len(s), fmt.Errorf("Invalid stub descriptor \"%s\": %v", s, err)
+		return ChannelDescriptor{}, len(s), fmt.Errorf("Invalid stub descriptor \"%s\": %v", s, err)
 	}
 
 	skeleton, _, err := NewChannelEndpointDescriptorWithParamsPath(ChannelEndpointRoleSkeleton, skeletonProtocol, "", skeletonParams, false)
 	if err != nil {
-		return nil, len(s), fmt.Errorf("Invalid skeleton descriptor \"%s\": %v", s, err)
+		return ChannelDescriptor{}, len(s), fmt.Errorf("Invalid skeleton descriptor \"%s\": %v", s, err)
 	}
 
 	d, err = NewChannelDescriptor(stub, skeleton, reverse)
+	if err == nil {
+		err = d.Validate()
+	}
 
 	return d, len(s), err
 }
@@ -744,6 +930,12 @@ func ParseLegacyChannelDescriptorPath(s string) (d ChannelEndpointDescriptor, nb
 // If the first character in <protocol-params> is '{', then it is parsed as JSON and provided to the descriptor in object form.
 // If an error occurs, nb indicates a best guess at the byte offset of the error.
 func ParseFullEndpointDescriptorPath(s string, role ChannelEndpointRole) (d ChannelEndpointDescriptor, nb int, err error) {
+	return parseFullEndpointDescriptorPath(s, role, false, nil)
+}
+
+// parseFullEndpointDescriptorPath implements ParseFullEndpointDescriptorPath. A TCP skeleton
+// without a host is given the SkeletonHost of defaults, if any, unless strict is true.
+func parseFullEndpointDescriptorPath(s string, role ChannelEndpointRole, strict bool, defaults *ChannelDescriptorDefaults) (d ChannelEndpointDescriptor, nb int, err error) {
 	rnb := 0
 
 	parsedRole := ChannelEndpointRoleUnknown
@@ -771,6 +963,9 @@ func ParseFullEndpointDescriptorPath(s string, role ChannelEndpointRole) (d Chan
 	}
 	nbp := rnb + nbProtocol
 	paramsPath := s[nbp:]
+	if role == ChannelEndpointRoleSkeleton && protocol == ChannelEndpointProtocolTCP && !strict && defaults != nil {
+		paramsPath = defaultTCPSkeletonPath(paramsPath, defaults.SkeletonHost)
+	}
 
 	d, nb, err = NewChannelEndpointDescriptorWithParamsPath(role, protocol, "", paramsPath, true)
 	if err != nil {
@@ -799,6 +994,13 @@ func ParseFullEndpointDescriptorPath(s string, role ChannelEndpointRole) (d Chan
 //  to the descriptor in object form.
 //  If an error occurs, nb indicates a best guess at the byte offset of the error.
 func ParseFullChannelDescriptorPath(s string) (d ChannelDescriptor, nb int, err error) {
+	return parseFullChannelDescriptorPath(s, false, nil)
+}
+
+// parseFullChannelDescriptorPath implements ParseFullChannelDescriptorPath. If strict is true, TCP
+// endpoints must give both a host and a port. Otherwise, a TCP skeleton without a host is given
+// the SkeletonHost of defaults, if any.
+func parseFullChannelDescriptorPath(s string, strict bool, defaults *ChannelDescriptorDefaults) (d ChannelDescriptor, nb int, err error) {
 	reverse := false
 	rnb := 0
 	if strings.HasPrefix(s, "R:") {
@@ -807,26 +1009,42 @@ func ParseFullChannelDescriptorPath(s string) (d ChannelDescriptor, nb int, err
 	}
 	parts, nb, err := SplitBalanced(s[rnb:], []rune{','})
 	if err != nil {
-		return nil, rnb + nb, fmt.Errorf("Invalid channel descriptor at offset %d of \"%s\": %v", utf8.RuneCountInString(s[:rnb+nb]), err)
+		return ChannelDescriptor{}, rnb + nb, fmt.Errorf("Invalid channel descriptor at char offset %d of \"%s\": %v", utf8.RuneCountInString(s[:rnb+nb]), s, err)
 	}
 	if len(parts) < 2 {
-		return nil, len(s), fmt.Errorf("Missing comma in channel descriptor \"%s\"", s)
+		return ChannelDescriptor{}, len(s), fmt.Errorf("Missing comma in channel descriptor \"%s\"", s)
 	}
-	boffs = []int{rnb, rnb + len(parts[0]) + 1, rnb + len(parts[0]) + 1 + len(parts[1])}
+	// boffs holds the byte offsets within s of the start of the stub part, the start
+	// of the skeleton part, and the first extraneous comma (if any)
+	boffs := []int{rnb, rnb + len(parts[0]) + 1, rnb + len(parts[0]) + 1 + len(parts[1])}
 	if len(parts) > 2 {
-		return nil, boffs[2], fmt.Errorf("Extraneous comma at char offset %d of channel descriptor \"%s\"",
+		return ChannelDescriptor{}, boffs[2], fmt.Errorf("Extraneous comma at char offset %d of channel descriptor \"%s\"",
 			utf8.RuneCountInString(s[:boffs[2]]), s)
 	}
-	stub, nb0, err := ParseFullEndpointDescriptorPath(parts[0], ChannelEndpointRoleStub)
+	if strings.TrimSpace(parts[0]) == "" {
+		return ChannelDescriptor{}, boffs[0], fmt.Errorf("Missing stub endpoint descriptor before comma in channel descriptor \"%s\"", s)
+	}
+	if strings.TrimSpace(parts[1]) == "" {
+		return ChannelDescriptor{}, boffs[1], fmt.Errorf("Missing skeleton endpoint descriptor after comma in channel descriptor \"%s\"", s)
+	}
+	stub, nb0, err := parseFullEndpointDescriptorPath(parts[0], ChannelEndpointRoleStub, strict, defaults)
 	if err != nil {
-		return nil, boffs[0] + nb0, fmt.Errorf("Bad stub descriptor at char offset %d of \"%s\": %v",
+		return ChannelDescriptor{}, boffs[0] + nb0, fmt.Errorf("Bad stub descriptor at char offset %d of \"%s\": %v",
 			utf8.RuneCountInString(s[:boffs[0]+nb0]), s, err)
 	}
-	skeleton, nb1, err := ParseFullEndpointDescriptorPath(parts[1], ChannelEndpointRoleSkeleton)
+	skeleton, nb1, err := parseFullEndpointDescriptorPath(parts[1], ChannelEndpointRoleSkeleton, strict, defaults)
 	if err != nil {
-		return nil, boffs[1] + nb1, fmt.Errorf("Bad skeleton descriptor at char offset %d of \"%s\": %v",
+		return ChannelDescriptor{}, boffs[1] + nb1, fmt.Errorf("Bad skeleton descriptor at char offset %d of \"%s\": %v",
 			utf8.RuneCountInString(s[:boffs[1]+nb1]), s, err)
 	}
+	if strict {
+		if err := checkStrictTCPEndpoint(s, stub); err != nil {
+			return ChannelDescriptor{}, boffs[0], err
+		}
+		if err := checkStrictTCPEndpoint(s, skeleton); err != nil {
+			return ChannelDescriptor{}, boffs[1], err
+		}
+	}
 	d, err = NewChannelDescriptor(stub, skeleton, reverse)
 	return d, len(s), err
 }
@@ -839,8 +1057,8 @@ func ParseFullChannelDescriptorPath(s string) (d ChannelDescriptor, nb int, err
 //
 //     The optional "R:" prefix indicates a reverse-proxy.
 //
-// <forward-channel-spec> may be presented on one of two forms (the presence of a ","" or "://" anywhere
-//          in the path indicates the full form):
+// <forward-channel-spec> may be presented on one of two forms (the presence of a "," anywhere in the path,
+//          or a path that begins with "<protocol>://", indicates the full form):
 //
 //   Legacy/abbreviated form (suitable only for TCP and unparameterized socks/stdio endpoints) is one of:
 //     socks
@@ -852,6 +1070,7 @@ func ParseFullChannelDescriptorPath(s string) (d ChannelDescriptor, nb int, err
 //         <TCP-bind-port-number>
 //         <IPV4-bind address> ':' <TCP bind port number>
 //         '[' <IPV6 bind address> ']' ':' <TCP bind port number>
+//         "unix:" <unix-domain-socket-path>
 //         "stdio"
 //		   "socks"
 //
@@ -860,6 +1079,8 @@ func ParseFullChannelDescriptorPath(s string) (d ChannelDescriptor, nb int, err
 //         <IPV4 target address> ':' <TCP bind port number>
 //         '[' <IPV6 target address> ']' ':' <TCP bind port number>
 //         <target hostname> ':' <TCP bind port number>
+//         "unix:" <unix-domain-socket-path>
+//         <protocol> "://" [ <protocol-params> ]
 //         "socks"
 //
 //
@@ -879,11 +1100,96 @@ func ParseFullChannelDescriptorPath(s string) (d ChannelDescriptor, nb int, err
 //    to the descriptor in object form.
 //
 //  If an error occurs, nb indicates a best guess at the byte offset of the error.
+//
+// This is the single entry point for parsing descriptor strings; the client, the remotes file
+// and the validate command all go through it, and the server validates what it receives with
+// the same ChannelDescriptor.Validate.
 func ParseChannelDescriptorPath(s string) (d ChannelDescriptor, nb int, err error) {
-	if strings.Contains(s, ",") || strings.Contains(s, "://") {
-		d, nb, err = ParseFullChannelDescriptorPath(s)
+	return ParseChannelDescriptorPathWithDefaults(s, nil)
+}
+
+// ParseChannelDescriptorPathWithDefaults is like ParseChannelDescriptorPath, but applies the given
+// defaults to what the descriptor omits. If defaults is nil, the built-in defaults are used.
+func ParseChannelDescriptorPathWithDefaults(s string, defaults *ChannelDescriptorDefaults) (d ChannelDescriptor, nb int, err error) {
+	if strings.Contains(s, ",") || isFullFormChannelDescriptorPath(s) {
+		d, nb, err = parseFullChannelDescriptorPath(s, false, defaults)
 	} else {
-		d, nb, err = ParseLegacyChannelDescriptorPath(s)
+		d, nb, err = parseLegacyChannelDescriptorPath(s, false, defaults)
 	}
 	return d, nb, err
 }
+
+// ParseChannelDescriptorStrict parses a channel descriptor string in either form, like
+// ParseChannelDescriptorPath, but without defaulting anything that is omitted: there is no default
+// stub bind address, skeleton host, socks stub, or port copied from the other endpoint. Every TCP
+// endpoint must give both a host and a port (e.g., "0.0.0.0:3000:localhost:80" or
+// "tcp://0.0.0.0:3000,tcp://localhost:80"), and a legacy descriptor must give both endpoints. It is
+// meant for scripted or validated configurations, where a guessed default would hide a mistake;
+// the error names the field that was omitted.
+//
+//  If an error occurs, nb indicates a best guess at the byte offset of the error.
+func ParseChannelDescriptorStrict(s string) (d ChannelDescriptor, nb int, err error) {
+	if strings.Contains(s, ",") || isFullFormChannelDescriptorPath(s) {
+		d, nb, err = parseFullChannelDescriptorPath(s, true, nil)
+	} else {
+		d, nb, err = parseLegacyChannelDescriptorPath(s, true, nil)
+	}
+	return d, nb, err
+}
+
+// strictOmittedError returns the error for a field omitted from channel descriptor s, which
+// ParseChannelDescriptorStrict does not default
+func strictOmittedError(s string, field string) error {
+	return fmt.Errorf("Channel descriptor \"%s\" omits the %s, which is required in strict mode", s, field)
+}
+
+// checkStrictTCPEndpoint returns an error if ep, an endpoint of full-form channel descriptor s, is
+// a TCP endpoint that omits a host or port. Each fallback target of a skeleton is checked.
+func checkStrictTCPEndpoint(s string, ep ChannelEndpointDescriptor) error {
+	if ep.GetType() != ChannelEndpointProtocolTCP {
+		return nil
+	}
+	role := ep.GetRole()
+	hostField := "stub bind address"
+	if role == ChannelEndpointRoleSkeleton {
+		hostField = "skeleton host"
+	}
+	var addrs []string
+	if role == ChannelEndpointRoleSkeleton {
+		targets, err := TCPSkeletonTargets(ep.GetParamsPath(), ep.GetParamsMap())
+		if err != nil {
+			return fmt.Errorf("Invalid skeleton in channel descriptor \"%s\": %s", s, err)
+		}
+		addrs = targets
+	} else if params := ep.GetParamsMap(); params != nil {
+		bind, err := ParamString(params, "bind", "")
+		if err != nil {
+			return fmt.Errorf("Invalid stub in channel descriptor \"%s\": %s", s, err)
+		}
+		addrs = []string{bind}
+	} else {
+		addrs = []string{ep.GetParamsPath()}
+	}
+	for _, addr := range addrs {
+		host, port, err := ParseHostPort(addr, "", UnknownPortNumber)
+		if err != nil {
+			return fmt.Errorf("Invalid %s endpoint in channel descriptor \"%s\": %s", role, s, err)
+		}
+		if host == "" {
+			return strictOmittedError(s, hostField)
+		}
+		if port == UnknownPortNumber {
+			return strictOmittedError(s, fmt.Sprintf("%s port", role))
+		}
+	}
+	return nil
+}
+
+// isFullFormChannelDescriptorPath returns true if s (after an optional "R:" prefix) begins
+// with a full-form "<protocol>://" or role-qualified endpoint descriptor
+func isFullFormChannelDescriptorPath(s string) bool {
+	s = strings.TrimPrefix(s, "R:")
+	s = strings.TrimPrefix(s, string(ChannelEndpointRoleStub)+":")
+	protocol, _ := parseProtocolPrefix(s)
+	return protocol != ""
+}
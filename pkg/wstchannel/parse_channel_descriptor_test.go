@@ -0,0 +1,472 @@
+package wstchannel
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSplitBalancedParts(t *testing.T) {
+	tests := []struct {
+		s     string
+		parts []string
+	}{
+		{"", []string{}},
+		{"a", []string{"a"}},
+		{"a:b", []string{"a", "b"}},
+		{"a:", []string{"a", ""}},
+		{"[::1]:80", []string{"[::1]", "80"}},
+		{"tcp://x:1", []string{"tcp://x", "1"}},
+		{"'a:b':\"c\\\":d\"", []string{"'a:b'", "\"c\\\":d\""}},
+		{"(a:[b:c]):d", []string{"(a:[b:c])", "d"}},
+		{"a\\:b:c", []string{"a\\:b", "c"}},
+	}
+
+	for _, tt := range tests {
+		parts, nb, err := SplitBalanced(tt.s, nil)
+		if err != nil {
+			t.Errorf("SplitBalanced(%q): unexpected error: %s", tt.s, err)
+			continue
+		}
+		if nb != len(tt.s) {
+			t.Errorf("SplitBalanced(%q): nb=%d, expected %d", tt.s, nb, len(tt.s))
+		}
+		if strings.Join(parts, "|") != strings.Join(tt.parts, "|") || len(parts) != len(tt.parts) {
+			t.Errorf("SplitBalanced(%q): got %q, expected %q", tt.s, parts, tt.parts)
+		}
+	}
+}
+
+func TestSplitBalancedErrorOffsets(t *testing.T) {
+	tests := []struct {
+		s  string
+		nb int
+	}{
+		{"ab:(cd]ef)", 6},
+		{"ab:(cd", 6},
+		{"ab:'cd", 6},
+		{"ab:\"cd", 6},
+		{"ab\\", 2},
+		{"ab:x\xffy", 4},
+		{"ab:(é", 6},
+		{"ab:'é", 6},
+		{"x:é\xff", 4},
+		{"{\"unterminated\": ", 0},
+	}
+
+	for _, tt := range tests {
+		_, nb, err := SplitBalanced(tt.s, nil)
+		if err == nil {
+			t.Errorf("SplitBalanced(%q): expected error", tt.s)
+			continue
+		}
+		if nb != tt.nb {
+			t.Errorf("SplitBalanced(%q): error offset %d, expected %d (%s)", tt.s, nb, tt.nb, err)
+		}
+	}
+}
+
+func TestParseFullChannelDescriptorPathErrorOffsets(t *testing.T) {
+	s := "tcp://localhost:80,tcp://remote:80,tcp://extra:80"
+	_, nb, err := ParseFullChannelDescriptorPath(s)
+	if err == nil {
+		t.Fatalf("ParseFullChannelDescriptorPath(%q): expected error", s)
+	}
+	expected := strings.LastIndex(s, ",")
+	if nb != expected {
+		t.Errorf("ParseFullChannelDescriptorPath(%q): error offset %d, expected %d (%s)", s, nb, expected, err)
+	}
+}
+
+func TestSplitBalancedMultipleDelims(t *testing.T) {
+	// multibyte runes inside brackets and quotes once decoded the first rune of the whole
+	// string, and a delimiter ended an element without being consumed
+	tests := []struct {
+		s     string
+		parts []string
+	}{
+		{"a:b,c", []string{"a", "b", "c"}},
+		{"é:ü", []string{"é", "ü"}},
+		{"(é:x):y", []string{"(é:x)", "y"}},
+		{"'é:x':y", []string{"'é:x'", "y"}},
+		{"[é]:\"ü\",'ö'", []string{"[é]", "\"ü\"", "'ö'"}},
+		{"R:tcp://0.0.0.0:80,tcp://{\"host\": \"x\"}", []string{"R", "tcp://0.0.0.0", "80", "tcp://{\"host\": \"x\"}"}},
+	}
+
+	for _, tt := range tests {
+		parts, nb, err := SplitBalanced(tt.s, []rune{':', ','})
+		if err != nil {
+			t.Errorf("SplitBalanced(%q): unexpected error: %s", tt.s, err)
+			continue
+		}
+		if nb != len(tt.s) {
+			t.Errorf("SplitBalanced(%q): nb=%d, expected %d", tt.s, nb, len(tt.s))
+		}
+		if strings.Join(parts, "|") != strings.Join(tt.parts, "|") || len(parts) != len(tt.parts) {
+			t.Errorf("SplitBalanced(%q): got %q, expected %q", tt.s, parts, tt.parts)
+		}
+	}
+}
+
+func TestSplitBalancedProtectedCommas(t *testing.T) {
+	tests := []struct {
+		s     string
+		parts []string
+	}{
+		{`stub:tcp://{"host":"a,b"},skeleton:tcp://host:80`, []string{`stub:tcp://{"host":"a,b"}`, `skeleton:tcp://host:80`}},
+		{`tcp://'a,b',tcp://"c,d"`, []string{`tcp://'a,b'`, `tcp://"c,d"`}},
+		{`tcp://[a,b],tcp://(c,<d,e>)`, []string{`tcp://[a,b]`, `tcp://(c,<d,e>)`}},
+		{`tcp://a\,b,tcp://c`, []string{`tcp://a\,b`, `tcp://c`}},
+		{`tcp://{"x":{"y":[1,2]}},tcp://c`, []string{`tcp://{"x":{"y":[1,2]}}`, `tcp://c`}},
+	}
+
+	for _, tt := range tests {
+		parts, _, err := SplitBalanced(tt.s, []rune{','})
+		if err != nil {
+			t.Errorf("SplitBalanced(%q): unexpected error: %s", tt.s, err)
+			continue
+		}
+		if strings.Join(parts, "|") != strings.Join(tt.parts, "|") || len(parts) != len(tt.parts) {
+			t.Errorf("SplitBalanced(%q): got %q, expected %q", tt.s, parts, tt.parts)
+		}
+	}
+}
+
+func TestParseFullChannelDescriptorPathMissingEndpoint(t *testing.T) {
+	tests := []struct {
+		s       string
+		nb      int
+		errText string
+	}{
+		{"tcp://localhost:80,", 19, "Missing skeleton"},
+		{"R:tcp://localhost:80, ", 21, "Missing skeleton"},
+		{",tcp://localhost:80", 0, "Missing stub"},
+	}
+
+	for _, tt := range tests {
+		_, nb, err := ParseFullChannelDescriptorPath(tt.s)
+		if err == nil {
+			t.Errorf("ParseFullChannelDescriptorPath(%q): expected error", tt.s)
+			continue
+		}
+		if !strings.Contains(err.Error(), tt.errText) {
+			t.Errorf("ParseFullChannelDescriptorPath(%q): error %q does not contain %q", tt.s, err, tt.errText)
+		}
+		if nb != tt.nb {
+			t.Errorf("ParseFullChannelDescriptorPath(%q): error offset %d, expected %d", tt.s, nb, tt.nb)
+		}
+	}
+}
+
+func TestSetSocksStubAddress(t *testing.T) {
+	defaults := NewChannelDescriptorDefaults()
+	if defaults.SocksStubBindAddress != "127.0.0.1" || defaults.SocksStubPort != PortNumber(1080) {
+		t.Errorf("Unconfigured default SOCKS stub is %s:%d; expected 127.0.0.1:1080", defaults.SocksStubBindAddress, defaults.SocksStubPort)
+	}
+
+	tests := []struct {
+		s    string
+		addr string
+		port PortNumber
+	}{
+		{"0.0.0.0:1081", "0.0.0.0", PortNumber(1081)},
+		{"1082", "0.0.0.0", PortNumber(1082)},
+		{"192.168.0.5", "192.168.0.5", PortNumber(1082)},
+		{"[::1]:1083", "[::1]", PortNumber(1083)},
+	}
+	for _, tt := range tests {
+		err := defaults.SetSocksStubAddress(tt.s)
+		if err != nil {
+			t.Errorf("SetSocksStubAddress(\"%s\") returned error: %s", tt.s, err)
+			continue
+		}
+		if defaults.SocksStubBindAddress != tt.addr || defaults.SocksStubPort != tt.port {
+			t.Errorf("SetSocksStubAddress(\"%s\") set %s:%d; expected %s:%d", tt.s,
+				defaults.SocksStubBindAddress, defaults.SocksStubPort, tt.addr, tt.port)
+		}
+	}
+
+	if err := defaults.SetSocksStubAddress("localhost:notaport"); err == nil {
+		t.Errorf("SetSocksStubAddress(\"localhost:notaport\") did not return an error")
+	}
+
+	d, _, err := ParseChannelDescriptorPathWithDefaults("socks", defaults)
+	if err != nil || d.Stub.GetParamsPath() != "[::1]:1083" {
+		t.Errorf("ParseChannelDescriptorPathWithDefaults(\"socks\") stub = %s, %v; expected [::1]:1083", d.Stub, err)
+	}
+	// other parsers are not affected
+	d, _, err = ParseChannelDescriptorPath("socks")
+	if err != nil || d.Stub.GetParamsPath() != "127.0.0.1:1080" {
+		t.Errorf("ParseChannelDescriptorPath(\"socks\") stub = %s, %v; expected 127.0.0.1:1080", d.Stub, err)
+	}
+}
+
+func TestSetSkeletonHost(t *testing.T) {
+	unconfigured := []struct {
+		s            string
+		skeletonPath string
+	}{
+		{"3000", "localhost:3000"},
+		{"3000:80", "localhost:80"},
+		{"tcp://0.0.0.0:3000,tcp://:80", ":80"},
+		{"tcp://0.0.0.0:3000,tcp://80", "80"},
+	}
+	for _, tt := range unconfigured {
+		d, _, err := ParseChannelDescriptorPathWithDefaults(tt.s, NewChannelDescriptorDefaults())
+		if err != nil {
+			t.Errorf("ParseChannelDescriptorPathWithDefaults(%q): unexpected error: %s", tt.s, err)
+			continue
+		}
+		if skeleton := *d.Skeleton; skeleton.GetParamsPath() != tt.skeletonPath {
+			t.Errorf("ParseChannelDescriptorPathWithDefaults(%q) without a default host: skeleton \"%s\", expected \"%s\"", tt.s, skeleton.GetParamsPath(), tt.skeletonPath)
+		}
+	}
+
+	defaults := NewChannelDescriptorDefaults()
+	if err := defaults.SetSkeletonHost("upstream.internal"); err != nil {
+		t.Fatalf("SetSkeletonHost(): unexpected error: %s", err)
+	}
+
+	tests := []struct {
+		s            string
+		skeletonPath string
+	}{
+		{"3000", "upstream.internal:3000"},
+		{"3000:80", "upstream.internal:80"},
+		{"3000:google.com:80", "google.com:80"},
+		{"tcp://0.0.0.0:3000,tcp://:80", "upstream.internal:80"},
+		{"tcp://0.0.0.0:3000,tcp://80", "upstream.internal:80"},
+		{"tcp://0.0.0.0:3000,tcp://google.com:80", "google.com:80"},
+		{"tcp://0.0.0.0:3000,tcp://[::1]:80", "[::1]:80"},
+	}
+	for _, tt := range tests {
+		d, _, err := ParseChannelDescriptorPathWithDefaults(tt.s, defaults)
+		if err != nil {
+			t.Errorf("ParseChannelDescriptorPathWithDefaults(%q): unexpected error: %s", tt.s, err)
+			continue
+		}
+		if skeleton := *d.Skeleton; skeleton.GetParamsPath() != tt.skeletonPath {
+			t.Errorf("ParseChannelDescriptorPathWithDefaults(%q): skeleton \"%s\", expected \"%s\"", tt.s, skeleton.GetParamsPath(), tt.skeletonPath)
+		}
+	}
+
+	if err := defaults.SetSkeletonHost("::1"); err != nil || defaults.SkeletonHost != "[::1]" {
+		t.Errorf("SetSkeletonHost(\"::1\") set %s, %v; expected [::1]", defaults.SkeletonHost, err)
+	}
+	if err := defaults.SetSkeletonHost(""); err == nil {
+		t.Errorf("SetSkeletonHost(\"\") did not return an error")
+	}
+}
+
+func TestParseLegacyChannelDescriptorPath(t *testing.T) {
+	tests := []struct {
+		s            string
+		reverse      bool
+		stubType     ChannelEndpointProtocol
+		stubPath     string
+		skeletonType ChannelEndpointProtocol
+		skeletonPath string
+	}{
+		{"3000", false, ChannelEndpointProtocolTCP, "0.0.0.0:3000", ChannelEndpointProtocolTCP, "localhost:3000"},
+		{"example.com:3000", false, ChannelEndpointProtocolTCP, "0.0.0.0:3000", ChannelEndpointProtocolTCP, "example.com:3000"},
+		{"3000:google.com:80", false, ChannelEndpointProtocolTCP, "0.0.0.0:3000", ChannelEndpointProtocolTCP, "google.com:80"},
+		{"192.168.0.5:3000:google.com:80", false, ChannelEndpointProtocolTCP, "192.168.0.5:3000", ChannelEndpointProtocolTCP, "google.com:80"},
+		{"socks", false, ChannelEndpointProtocolTCP, "127.0.0.1:1080", ChannelEndpointProtocolSocks, ""},
+		{"5000:socks", false, ChannelEndpointProtocolTCP, "127.0.0.1:5000", ChannelEndpointProtocolSocks, ""},
+		{"R:2222:localhost:22", true, ChannelEndpointProtocolTCP, "0.0.0.0:2222", ChannelEndpointProtocolTCP, "localhost:22"},
+		{"stdio:localhost:22", false, ChannelEndpointProtocolStdio, "", ChannelEndpointProtocolTCP, "localhost:22"},
+		{"R:2222:unix:/tmp/s.sock", true, ChannelEndpointProtocolTCP, "0.0.0.0:2222", ChannelEndpointProtocolUnix, "/tmp/s.sock"},
+		{"R:2222:loop:backend", true, ChannelEndpointProtocolTCP, "0.0.0.0:2222", ChannelEndpointProtocolLoop, "backend"},
+		{"R:2222:stdio", true, ChannelEndpointProtocolTCP, "0.0.0.0:2222", ChannelEndpointProtocolStdio, ""},
+		{"R:2222:socks", true, ChannelEndpointProtocolTCP, "127.0.0.1:2222", ChannelEndpointProtocolSocks, ""},
+	}
+
+	for _, tt := range tests {
+		d, _, err := ParseLegacyChannelDescriptorPath(tt.s)
+		if err != nil {
+			t.Errorf("ParseLegacyChannelDescriptorPath(%q): unexpected error: %s", tt.s, err)
+			continue
+		}
+		if d.Reverse != tt.reverse {
+			t.Errorf("ParseLegacyChannelDescriptorPath(%q): reverse=%v, expected %v", tt.s, d.Reverse, tt.reverse)
+		}
+		stub := *d.Stub
+		if stub.GetType() != tt.stubType || stub.GetParamsPath() != tt.stubPath {
+			t.Errorf("ParseLegacyChannelDescriptorPath(%q): stub %s \"%s\", expected %s \"%s\"",
+				tt.s, stub.GetType(), stub.GetParamsPath(), tt.stubType, tt.stubPath)
+		}
+		skeleton := *d.Skeleton
+		if skeleton.GetType() != tt.skeletonType || skeleton.GetParamsPath() != tt.skeletonPath {
+			t.Errorf("ParseLegacyChannelDescriptorPath(%q): skeleton %s \"%s\", expected %s \"%s\"",
+				tt.s, skeleton.GetType(), skeleton.GetParamsPath(), tt.skeletonType, tt.skeletonPath)
+		}
+	}
+}
+
+func TestParseLegacyChannelDescriptorPathInvalid(t *testing.T) {
+	tests := []struct {
+		s       string
+		errText string
+	}{
+		{"R:stdio:localhost:22", "STDIO endpoint must be on client proxy side"},
+		{"2222:stdio", "STDIO endpoint must be on client proxy side"},
+		{"R:2222:loop:", "Missing loop name"},
+		{"R:2222:unix:", "Missing unix domain socket path"},
+	}
+
+	for _, tt := range tests {
+		_, _, err := ParseLegacyChannelDescriptorPath(tt.s)
+		if err == nil {
+			t.Errorf("ParseLegacyChannelDescriptorPath(%q): expected error", tt.s)
+			continue
+		}
+		if !strings.Contains(err.Error(), tt.errText) {
+			t.Errorf("ParseLegacyChannelDescriptorPath(%q): error %q does not contain %q", tt.s, err, tt.errText)
+		}
+	}
+}
+
+func TestParseChannelDescriptorPathJSONParams(t *testing.T) {
+	d, _, err := ParseChannelDescriptorPath(`tcp://localhost:80,skeleton:unix://{"path":"/tmp/s.sock","mode":"0600"}`)
+	if err != nil {
+		t.Fatalf("ParseChannelDescriptorPath(): unexpected error: %s", err)
+	}
+	skeleton := *d.Skeleton
+	if skeleton.GetType() != ChannelEndpointProtocolUnix {
+		t.Errorf("ParseChannelDescriptorPath(): skeleton type %s, expected %s", skeleton.GetType(), ChannelEndpointProtocolUnix)
+	}
+	if m := skeleton.GetParamsMap(); m == nil || m["mode"] != "0600" {
+		t.Errorf("ParseChannelDescriptorPath(): skeleton params %v, expected mode 0600", m)
+	}
+
+	// the legacy shorthand is still routed to the legacy parser
+	d, _, err = ParseChannelDescriptorPath("3000:google.com:80")
+	if err != nil {
+		t.Fatalf("ParseChannelDescriptorPath(): unexpected error: %s", err)
+	}
+	if skeleton := *d.Skeleton; skeleton.GetParamsPath() != "google.com:80" {
+		t.Errorf("ParseChannelDescriptorPath(): skeleton path \"%s\", expected \"google.com:80\"", skeleton.GetParamsPath())
+	}
+
+	s := `tcp://localhost:80,unix://{"path": "/tmp/s.sock",}`
+	_, nb, err := ParseChannelDescriptorPath(s)
+	if err == nil {
+		t.Fatalf("ParseChannelDescriptorPath(%q): expected error", s)
+	}
+	if start := strings.Index(s, "{"); nb <= start || nb > len(s) {
+		t.Errorf("ParseChannelDescriptorPath(%q): error offset %d, expected within the JSON params at %d (%s)", s, nb, start, err)
+	}
+	if !strings.Contains(err.Error(), "char offset") {
+		t.Errorf("ParseChannelDescriptorPath(%q): error %q does not report the char offset", s, err)
+	}
+}
+
+// TestParseChannelDescriptorPathCompatibility verifies that every legacy descriptor string parses
+// identically through the combined parser used by the client, main and validate, and that each has
+// an equivalent full form.
+func TestParseChannelDescriptorPathCompatibility(t *testing.T) {
+	tests := []struct {
+		legacy string
+		full   string
+	}{
+		{"3000", "tcp://0.0.0.0:3000,tcp://localhost:3000"},
+		{"example.com:3000", "tcp://0.0.0.0:3000,tcp://example.com:3000"},
+		{"3000:google.com:80", "tcp://0.0.0.0:3000,tcp://google.com:80"},
+		{"192.168.0.5:3000:google.com:80", "tcp://192.168.0.5:3000,tcp://google.com:80"},
+		{"5000:socks", "tcp://127.0.0.1:5000,socks://"},
+		{"R:2222:localhost:22", "R:tcp://0.0.0.0:2222,tcp://localhost:22"},
+		{"stdio:localhost:22", "stdio://,tcp://localhost:22"},
+		{"R:2222:unix:/tmp/s.sock", "R:tcp://0.0.0.0:2222,unix:///tmp/s.sock"},
+		{"R:2222:loop:backend", "R:tcp://0.0.0.0:2222,loop://backend"},
+	}
+
+	for _, tt := range tests {
+		ld, _, err := ParseLegacyChannelDescriptorPath(tt.legacy)
+		if err != nil {
+			t.Errorf("ParseLegacyChannelDescriptorPath(%q): unexpected error: %s", tt.legacy, err)
+			continue
+		}
+		for _, s := range []string{tt.legacy, tt.full} {
+			d, _, err := ParseChannelDescriptorPath(s)
+			if err != nil {
+				t.Errorf("ParseChannelDescriptorPath(%q): unexpected error: %s", s, err)
+				continue
+			}
+			if d.Reverse != ld.Reverse {
+				t.Errorf("ParseChannelDescriptorPath(%q): reverse=%v, legacy parser gave %v", s, d.Reverse, ld.Reverse)
+			}
+			for _, ep := range []struct{ got, want ChannelEndpointDescriptor }{{*d.Stub, *ld.Stub}, {*d.Skeleton, *ld.Skeleton}} {
+				if ep.got.GetRole() != ep.want.GetRole() || ep.got.GetType() != ep.want.GetType() || ep.got.GetParamsPath() != ep.want.GetParamsPath() {
+					t.Errorf("ParseChannelDescriptorPath(%q): %s %s \"%s\", legacy parser gave %s %s \"%s\"", s,
+						ep.got.GetRole(), ep.got.GetType(), ep.got.GetParamsPath(), ep.want.GetRole(), ep.want.GetType(), ep.want.GetParamsPath())
+				}
+			}
+		}
+	}
+}
+
+func TestParseChannelDescriptorStrict(t *testing.T) {
+	tests := []struct {
+		s            string
+		stubPath     string
+		skeletonPath string
+	}{
+		{"0.0.0.0:3000:localhost:80", "0.0.0.0:3000", "localhost:80"},
+		{"R:127.0.0.1:2222:[::1]:22", "127.0.0.1:2222", "[::1]:22"},
+		{"127.0.0.1:1080:socks", "127.0.0.1:1080", ""},
+		{"stdio:example.com:22", "", "example.com:22"},
+		{"tcp://0.0.0.0:3000,tcp://localhost:80", "0.0.0.0:3000", "localhost:80"},
+		{"tcp://0.0.0.0:3000,tcp://db1:5432|db2:5432", "0.0.0.0:3000", "db1:5432|db2:5432"},
+		{"unix:/tmp/s.sock:loop:backend", "/tmp/s.sock", "backend"},
+	}
+
+	for _, tt := range tests {
+		d, _, err := ParseChannelDescriptorStrict(tt.s)
+		if err != nil {
+			t.Errorf("ParseChannelDescriptorStrict(%q): unexpected error: %s", tt.s, err)
+			continue
+		}
+		stub := *d.Stub
+		skeleton := *d.Skeleton
+		if stub.GetParamsPath() != tt.stubPath || skeleton.GetParamsPath() != tt.skeletonPath {
+			t.Errorf("ParseChannelDescriptorStrict(%q): got \"%s\", \"%s\"; expected \"%s\", \"%s\"",
+				tt.s, stub.GetParamsPath(), skeleton.GetParamsPath(), tt.stubPath, tt.skeletonPath)
+		}
+	}
+}
+
+func TestParseChannelDescriptorStrictOmitted(t *testing.T) {
+	tests := []struct {
+		s     string
+		field string
+	}{
+		{"3000", "skeleton endpoint"},
+		{"unix:/tmp/s.sock", "skeleton endpoint"},
+		{"example.com:3000", "stub bind address"},
+		{"3000:google.com:80", "stub bind address"},
+		{"socks", "stub bind address"},
+		{"5000:socks", "stub bind address"},
+		{"R:2222:stdio", "stub bind address"},
+		{"0.0.0.0:3000:80", "skeleton host"},
+		{"0.0.0.0:3000:google.com", "skeleton port"},
+		{"tcp://:3000,tcp://localhost:80", "stub bind address"},
+		{"tcp://0.0.0.0,tcp://localhost:80", "stub port"},
+		{"tcp://{\"bind\":\":3000\"},tcp://localhost:80", "stub bind address"},
+		{"tcp://0.0.0.0:3000,tcp://:80", "skeleton host"},
+		{"tcp://0.0.0.0:3000,tcp://localhost", "skeleton port"},
+		{"tcp://0.0.0.0:3000,tcp://db1:5432|:5432", "skeleton host"},
+	}
+
+	for _, tt := range tests {
+		_, _, err := ParseChannelDescriptorStrict(tt.s)
+		if err == nil {
+			t.Errorf("ParseChannelDescriptorStrict(%q): expected error", tt.s)
+			continue
+		}
+		if !strings.Contains(err.Error(), "omits the "+tt.field+",") {
+			t.Errorf("ParseChannelDescriptorStrict(%q): error %q does not name the %s", tt.s, err, tt.field)
+		}
+		if _, _, err := ParseChannelDescriptorPath(tt.s); err != nil && tt.field != "skeleton endpoint" {
+			t.Errorf("ParseChannelDescriptorPath(%q): lenient parse failed: %s", tt.s, err)
+		}
+	}
+}
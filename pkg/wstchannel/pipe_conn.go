@@ -1,11 +1,18 @@
 package wstchannel
 
 import (
+	"errors"
 	"io"
 	"sync"
 	"sync/atomic"
+	"syscall"
 )
 
+// ErrOutputClosed is returned by PipeConn.Write when the reader of the output stream has gone
+// away (EPIPE, e.g. the next command in a shell pipeline exited). It indicates a clean end of
+// stream rather than a failure.
+var ErrOutputClosed = errors.New("Output stream closed by its reader")
+
 // PipeConn implements a local ChannelConn from a read stream and a write stream (e.g., stdin and stdout)
 type PipeConn struct {
 	BasicConn
@@ -13,6 +20,8 @@ type PipeConn struct {
 	output         io.WriteCloser
 	closeWriteOnce sync.Once
 	closeWriteErr  error
+	closeReadOnce  sync.Once
+	closeReadErr   error
 }
 
 // NewPipeConn creates a new PipeConn
@@ -37,11 +46,20 @@ func (c *PipeConn) CloseWrite() error {
 	return c.closeWriteErr
 }
 
+// CloseRead shuts down the reading side of the "Pipe" by closing the input stream. Implements
+// ReadHalfCloser; it is not part of the ChannelConn interface.
+func (c *PipeConn) CloseRead() error {
+	c.closeReadOnce.Do(func() {
+		c.closeReadErr = c.input.Close()
+	})
+	return c.closeReadErr
+}
+
 // HandleOnceShutdown will be called exactly once, in its own goroutine. It should take completionError
 // as an advisory completion value, actually shut down, then return the real completion value.
 func (c *PipeConn) HandleOnceShutdown(completionErr error) error {
 	errW := c.CloseWrite()
-	err := c.input.Close()
+	err := c.CloseRead()
 	if err == nil {
 		err = errW
 	}
@@ -66,9 +84,12 @@ func (c *PipeConn) Read(p []byte) (n int, err error) {
 	return n, err
 }
 
-// Write implements the Writer interface
+// Write implements the Writer interface. A broken pipe is reported as ErrOutputClosed.
 func (c *PipeConn) Write(p []byte) (n int, err error) {
 	n, err = c.output.Write(p)
 	atomic.AddInt64(&c.NumBytesWritten, int64(n))
+	if err != nil && errors.Is(err, syscall.EPIPE) {
+		err = ErrOutputClosed
+	}
 	return n, err
 }
@@ -0,0 +1,28 @@
+package wstchannel
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestPipeConnOutputClosed(t *testing.T) {
+	inR, inW, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer inW.Close()
+	outR, outW, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &PipeConn{input: inR, output: outW}
+	defer c.input.Close()
+	defer c.output.Close()
+
+	// the reader of the output goes away, as when the next command in a pipeline exits
+	outR.Close()
+	if _, err := c.Write([]byte("hello")); !errors.Is(err, ErrOutputClosed) {
+		t.Errorf("Write() to a pipe with no reader returned %v, want ErrOutputClosed", err)
+	}
+}
@@ -0,0 +1,128 @@
+package wstchannel
+
+import (
+	"sync"
+	"time"
+)
+
+// ShutdownPhase orders the shutdown of the children registered with a PhasedShutdown. Lower
+// phases are shut down, and complete, before higher phases are started.
+type ShutdownPhase int
+
+const (
+	// ShutdownPhaseListeners is for children that accept new work (e.g., stub listeners); they
+	// are stopped first, so nothing new starts while the rest drains
+	ShutdownPhaseListeners ShutdownPhase = iota
+
+	// ShutdownPhaseChannels is for active channels, which may be given time to drain
+	ShutdownPhaseChannels
+
+	// ShutdownPhaseTransport is for children that carry the channels (e.g., multiplexed connections)
+	ShutdownPhaseTransport
+
+	numShutdownPhases
+)
+
+// PhasedShutdownChild is a child of a PhasedShutdown. Any object that embeds ShutdownHelper
+// satisfies it.
+type PhasedShutdownChild interface {
+	StartShutdown(completionErr error)
+	WaitShutdown() error
+}
+
+// PhasedShutdown shuts down a set of children in phases, rather than all at once as ShutdownHelper
+// does with children added with AddShutdownChild. An owner calls Shutdown from its
+// HandleOnceShutdown, so the phased children have completed before ShutdownHelper shuts down its
+// own children; a child may be registered in both places. An owner that does not use a
+// PhasedShutdown keeps the default, concurrent behavior.
+type PhasedShutdown struct {
+	lock     sync.Mutex
+	started  bool
+	children [numShutdownPhases]map[PhasedShutdownChild]struct{}
+	drain    [numShutdownPhases]time.Duration
+}
+
+// NewPhasedShutdown creates an empty PhasedShutdown
+func NewPhasedShutdown() *PhasedShutdown {
+	p := &PhasedShutdown{}
+	for i := range p.children {
+		p.children[i] = make(map[PhasedShutdownChild]struct{})
+	}
+	return p
+}
+
+// SetDrainTimeout sets the time that children in a phase are given to complete on their own, once
+// the previous phases have completed, before their shutdown is started. The default is 0, which
+// starts shutdown of the phase immediately.
+func (p *PhasedShutdown) SetDrainTimeout(phase ShutdownPhase, timeout time.Duration) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.drain[phase] = timeout
+}
+
+// AddChild registers a child to be shut down in the given phase. If Shutdown has already been
+// called, the child is not registered, its shutdown is started immediately, and false is returned.
+func (p *PhasedShutdown) AddChild(phase ShutdownPhase, child PhasedShutdownChild) bool {
+	p.lock.Lock()
+	started := p.started
+	if !started {
+		p.children[phase][child] = struct{}{}
+	}
+	p.lock.Unlock()
+	if started {
+		child.StartShutdown(nil)
+		return false
+	}
+	return true
+}
+
+// RemoveChild unregisters a child that has completed on its own
+func (p *PhasedShutdown) RemoveChild(phase ShutdownPhase, child PhasedShutdownChild) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	delete(p.children[phase], child)
+}
+
+// Shutdown shuts down the registered children one phase at a time, in order. Within a phase,
+// children are given the phase's drain timeout to complete on their own, then shut down
+// concurrently with completionErr as the advisory completion error; the next phase does not
+// begin until all of them have completed. Children added after Shutdown has been called are shut
+// down immediately.
+func (p *PhasedShutdown) Shutdown(completionErr error) {
+	p.lock.Lock()
+	p.started = true
+	p.lock.Unlock()
+
+	for phase := ShutdownPhase(0); phase < numShutdownPhases; phase++ {
+		p.lock.Lock()
+		children := make([]PhasedShutdownChild, 0, len(p.children[phase]))
+		for child := range p.children[phase] {
+			children = append(children, child)
+		}
+		drain := p.drain[phase]
+		p.lock.Unlock()
+		if len(children) == 0 {
+			continue
+		}
+
+		done := make(chan struct{})
+		go func() {
+			for _, child := range children {
+				child.WaitShutdown()
+			}
+			close(done)
+		}()
+		if drain > 0 {
+			timer := time.NewTimer(drain)
+			select {
+			case <-done:
+			case <-timer.C:
+			}
+			timer.Stop()
+		}
+		for _, child := range children {
+			child.StartShutdown(completionErr)
+		}
+		<-done
+	}
+}
@@ -0,0 +1,92 @@
+package wstchannel
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// phaseChild records the order in which children are shut down
+type phaseChild struct {
+	name  string
+	order *[]string
+	lock  *sync.Mutex
+	once  sync.Once
+	done  chan struct{}
+}
+
+func newPhaseChild(name string, order *[]string, lock *sync.Mutex) *phaseChild {
+	return &phaseChild{name: name, order: order, lock: lock, done: make(chan struct{})}
+}
+
+func (c *phaseChild) finish() {
+	c.once.Do(func() {
+		c.lock.Lock()
+		*c.order = append(*c.order, c.name)
+		c.lock.Unlock()
+		close(c.done)
+	})
+}
+
+func (c *phaseChild) StartShutdown(completionErr error) {
+	c.finish()
+}
+
+func (c *phaseChild) WaitShutdown() error {
+	<-c.done
+	return nil
+}
+
+func TestPhasedShutdownOrder(t *testing.T) {
+	var order []string
+	var lock sync.Mutex
+	p := NewPhasedShutdown()
+	p.AddChild(ShutdownPhaseTransport, newPhaseChild("transport", &order, &lock))
+	p.AddChild(ShutdownPhaseChannels, newPhaseChild("channel", &order, &lock))
+	p.AddChild(ShutdownPhaseListeners, newPhaseChild("listener", &order, &lock))
+	removed := newPhaseChild("removed", &order, &lock)
+	p.AddChild(ShutdownPhaseChannels, removed)
+	p.RemoveChild(ShutdownPhaseChannels, removed)
+
+	p.Shutdown(nil)
+	want := []string{"listener", "channel", "transport"}
+	if len(order) != len(want) {
+		t.Fatalf("Shutdown() order = %v, want %v", order, want)
+	}
+	for i := range want {
+		if order[i] != want[i] {
+			t.Fatalf("Shutdown() order = %v, want %v", order, want)
+		}
+	}
+
+	late := newPhaseChild("late", &order, &lock)
+	if p.AddChild(ShutdownPhaseListeners, late) {
+		t.Errorf("AddChild() after Shutdown() returned true")
+	}
+	if len(order) != 4 || order[3] != "late" {
+		t.Errorf("AddChild() after Shutdown() did not shut down the child")
+	}
+}
+
+func TestPhasedShutdownDrain(t *testing.T) {
+	var order []string
+	var lock sync.Mutex
+	p := NewPhasedShutdown()
+	p.SetDrainTimeout(ShutdownPhaseChannels, time.Second)
+	draining := newPhaseChild("drained", &order, &lock)
+	p.AddChild(ShutdownPhaseChannels, draining)
+
+	// the channel completes on its own, well within the drain timeout
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		draining.finish()
+	}()
+	start := time.Now()
+	p.Shutdown(nil)
+	if elapsed := time.Since(start); elapsed >= time.Second {
+		t.Errorf("Shutdown() waited %s for a drained channel", elapsed)
+	}
+	if len(order) != 1 || order[0] != "drained" {
+		t.Errorf("Shutdown() order = %v, want [drained]", order)
+	}
+}
@@ -0,0 +1,93 @@
+package wstchannel
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// DefaultShutdownTimeout is a reasonable time to allow for a clean shutdown before
+// resources are forcibly released
+const DefaultShutdownTimeout = 10 * time.Second
+
+// TimedShutdowner is an object that can be shut down with ShutdownWithTimeout. Any
+// object that embeds ShutdownHelper satisfies it.
+type TimedShutdowner interface {
+	StartShutdown(completionErr error)
+	WaitShutdown() error
+	ILogf(format string, args ...interface{})
+}
+
+// ForceCloser may be implemented by a TimedShutdowner that can immediately release its
+// underlying resources (e.g., by closing network connections), to unblock a shutdown that is
+// stuck waiting on a child (e.g., a hung io.Copy). ForceClose must be safe to call concurrently
+// with an in-progress shutdown.
+type ForceCloser interface {
+	ForceClose() error
+}
+
+// PendingShutdownReporter may be implemented by a TimedShutdowner to describe the children
+// that have not yet finished shutting down, for diagnostics when a shutdown times out.
+type PendingShutdownReporter interface {
+	PendingShutdownChildren() []string
+}
+
+// ShutdownTimeoutError is returned by ShutdownWithTimeout if shutdown did not complete in time
+type ShutdownTimeoutError struct {
+	// Timeout is the time that was allowed for shutdown
+	Timeout time.Duration
+
+	// Pending describes the children that had not finished shutting down, if known
+	Pending []string
+
+	// Err is the advisory completion error that shutdown was started with
+	Err error
+}
+
+func (e *ShutdownTimeoutError) Error() string {
+	msg := fmt.Sprintf("Shutdown did not complete within %s", e.Timeout)
+	if len(e.Pending) > 0 {
+		msg += fmt.Sprintf(" (pending: %s)", strings.Join(e.Pending, ", "))
+	}
+	if e.Err != nil {
+		msg += fmt.Sprintf(": %s", e.Err)
+	}
+	return msg
+}
+
+func (e *ShutdownTimeoutError) Unwrap() error {
+	return e.Err
+}
+
+// ShutdownWithTimeout starts shutdown of obj with an advisory completion error, then waits up
+// to timeout for it to complete, returning the final completion status. If shutdown completes
+// in time, this is equivalent to obj.Shutdown(completionErr). Otherwise, the still-pending
+// children are logged, obj.ForceClose() is called if obj implements ForceCloser, and a
+// ShutdownTimeoutError is returned without waiting further.
+func ShutdownWithTimeout(obj TimedShutdowner, completionErr error, timeout time.Duration) error {
+	obj.StartShutdown(completionErr)
+	done := make(chan error, 1)
+	go func() {
+		done <- obj.WaitShutdown()
+	}()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case err := <-done:
+		return err
+	case <-timer.C:
+	}
+
+	timeoutErr := &ShutdownTimeoutError{Timeout: timeout, Err: completionErr}
+	if r, ok := obj.(PendingShutdownReporter); ok {
+		timeoutErr.Pending = r.PendingShutdownChildren()
+	}
+	obj.ILogf("%s; forcing close", timeoutErr)
+	if fc, ok := obj.(ForceCloser); ok {
+		err := fc.ForceClose()
+		if err != nil {
+			obj.ILogf("Force close failed, ignoring: %s", err)
+		}
+	}
+	return timeoutErr
+}
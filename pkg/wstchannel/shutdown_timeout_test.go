@@ -0,0 +1,58 @@
+package wstchannel
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// stuckShutdowner completes shutdown only when release is closed, or when force closed
+type stuckShutdowner struct {
+	release     chan struct{}
+	forceClosed bool
+}
+
+func (s *stuckShutdowner) StartShutdown(completionErr error) {}
+
+func (s *stuckShutdowner) WaitShutdown() error {
+	<-s.release
+	return nil
+}
+
+func (s *stuckShutdowner) ILogf(format string, args ...interface{}) {}
+
+func (s *stuckShutdowner) ForceClose() error {
+	s.forceClosed = true
+	close(s.release)
+	return nil
+}
+
+func (s *stuckShutdowner) PendingShutdownChildren() []string {
+	return []string{"child#1"}
+}
+
+func TestShutdownWithTimeout(t *testing.T) {
+	fast := &stuckShutdowner{release: make(chan struct{})}
+	close(fast.release)
+	if err := ShutdownWithTimeout(fast, nil, time.Second); err != nil {
+		t.Errorf("ShutdownWithTimeout() of a fast shutdown returned %v", err)
+	}
+	if fast.forceClosed {
+		t.Errorf("ShutdownWithTimeout() force closed a fast shutdown")
+	}
+
+	stuck := &stuckShutdowner{release: make(chan struct{})}
+	cause := errors.New("cause")
+	err := ShutdownWithTimeout(stuck, cause, 10*time.Millisecond)
+	var timeoutErr *ShutdownTimeoutError
+	if !errors.As(err, &timeoutErr) {
+		t.Fatalf("ShutdownWithTimeout() of a stuck shutdown returned %v, want ShutdownTimeoutError", err)
+	}
+	if !errors.Is(err, cause) || !strings.Contains(err.Error(), "child#1") {
+		t.Errorf("ShutdownWithTimeout() error %q does not include cause and pending children", err)
+	}
+	if !stuck.forceClosed {
+		t.Errorf("ShutdownWithTimeout() did not force close a stuck shutdown")
+	}
+}
@@ -0,0 +1,65 @@
+package wstchannel
+
+import (
+	"context"
+	"fmt"
+)
+
+// Skeletons that dial a Called Service (TCP and unix skeletons) may prepare each dialed
+// connection in DialAndServe, after the dial succeeds and before bridging begins, for backends
+// that need a greeting or other warm-up. First the "prelude" param of the descriptor, if any, is
+// written to the Called Service, e.g., tcp://{"targets":["localhost:6379"],"prelude":"PING\r\n"};
+// then the BeforeBridge callback of the endpoint, if any, is called. The callback is taken from the
+// LocalChannelEnv when the skeleton is created, if it implements BeforeBridgeEnv. If either fails,
+// both connections are closed and DialAndServe returns the error without bridging.
+//
+// The bytes of the prelude, and any written by BeforeBridge, are not counted in the byte counts
+// returned by DialAndServe, which count only the traffic bridged between the Caller and the
+// Called Service. They are not recorded by bridge tracing either.
+
+// BeforeBridgeEnv may be implemented by a LocalChannelEnv to set the BeforeBridge callback of the
+// TCP and unix skeletons it creates (see BasicEndpoint.BeforeBridge)
+type BeforeBridgeEnv interface {
+	// GetBeforeBridge returns the callback that prepares each Called Service connection before
+	// bridging, or nil for none
+	GetBeforeBridge() func(ctx context.Context, calledServiceConn ChannelConn, extraData []byte) error
+}
+
+// getEnvBeforeBridge returns the callback provided by env, or nil if it does not implement
+// BeforeBridgeEnv
+func getEnvBeforeBridge(env LocalChannelEnv) func(ctx context.Context, calledServiceConn ChannelConn, extraData []byte) error {
+	bbEnv, ok := env.(BeforeBridgeEnv)
+	if !ok {
+		return nil
+	}
+	return bbEnv.GetBeforeBridge()
+}
+
+// skeletonPrelude returns the "prelude" param of a skeleton's params, or nil if there is none
+func skeletonPrelude(params map[string]interface{}) ([]byte, error) {
+	prelude, err := ParamString(params, "prelude", "")
+	if err != nil {
+		return nil, err
+	}
+	if prelude == "" {
+		return nil, nil
+	}
+	return []byte(prelude), nil
+}
+
+// beforeBridge prepares a connection dialed by DialAndServe, by writing prelude to it and then
+// calling the BeforeBridge callback, if any. The connections are not closed on error; that is up to
+// the caller.
+func (ep *BasicEndpoint) beforeBridge(ctx context.Context, calledServiceConn ChannelConn, prelude []byte, extraData []byte) error {
+	if len(prelude) > 0 {
+		if _, err := calledServiceConn.Write(prelude); err != nil {
+			return fmt.Errorf("Unable to send prelude to the Called Service: %s", err)
+		}
+	}
+	if ep.BeforeBridge != nil {
+		if err := ep.BeforeBridge(ctx, calledServiceConn, extraData); err != nil {
+			return fmt.Errorf("Called Service connection setup failed: %s", err)
+		}
+	}
+	return nil
+}
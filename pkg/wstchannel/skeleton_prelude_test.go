@@ -0,0 +1,153 @@
+package wstchannel
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/sammck-go/logger"
+)
+
+// preludeTestConn records what is written to it
+type preludeTestConn struct {
+	ChannelConn
+	written bytes.Buffer
+}
+
+func (c *preludeTestConn) Write(b []byte) (int, error) {
+	return c.written.Write(b)
+}
+
+func TestSkeletonPrelude(t *testing.T) {
+	prelude, err := skeletonPrelude(map[string]interface{}{"prelude": "HELLO\r\n"})
+	if err != nil || string(prelude) != "HELLO\r\n" {
+		t.Errorf("skeletonPrelude() = %q, %v; want \"HELLO\\r\\n\"", prelude, err)
+	}
+	for _, params := range []map[string]interface{}{nil, {}, {"prelude": ""}} {
+		if prelude, err := skeletonPrelude(params); err != nil || prelude != nil {
+			t.Errorf("skeletonPrelude(%v) = %q, %v; want none", params, prelude, err)
+		}
+	}
+	if _, err := skeletonPrelude(map[string]interface{}{"prelude": 42}); err == nil {
+		t.Errorf("skeletonPrelude() accepted a non-string prelude")
+	}
+}
+
+func TestBeforeBridge(t *testing.T) {
+	var ep BasicEndpoint
+	var order []string
+	ep.BeforeBridge = func(ctx context.Context, conn ChannelConn, extraData []byte) error {
+		c := conn.(*preludeTestConn)
+		order = append(order, "callback after "+c.written.String())
+		_, err := conn.Write([]byte(string(extraData)))
+		return err
+	}
+	conn := &preludeTestConn{}
+	if err := ep.beforeBridge(context.Background(), conn, []byte("prelude;"), []byte("meta")); err != nil {
+		t.Fatalf("beforeBridge() failed: %s", err)
+	}
+	if got := conn.written.String(); got != "prelude;meta" {
+		t.Errorf("beforeBridge() wrote %q, want \"prelude;meta\"", got)
+	}
+	if len(order) != 1 || order[0] != "callback after prelude;" {
+		t.Errorf("BeforeBridge was not called once after the prelude: %q", order)
+	}
+
+	ep.BeforeBridge = func(ctx context.Context, conn ChannelConn, extraData []byte) error {
+		return errors.New("backend not ready")
+	}
+	err := ep.beforeBridge(context.Background(), &preludeTestConn{}, nil, nil)
+	if err == nil || !strings.Contains(err.Error(), "backend not ready") {
+		t.Errorf("beforeBridge() = %v, want the callback's error", err)
+	}
+}
+
+// beforeBridgeTestEnv is a LocalChannelEnv that gives a BeforeBridge callback
+type beforeBridgeTestEnv struct {
+	LocalChannelEnv
+	beforeBridge func(ctx context.Context, calledServiceConn ChannelConn, extraData []byte) error
+}
+
+func (env *beforeBridgeTestEnv) GetBeforeBridge() func(ctx context.Context, calledServiceConn ChannelConn, extraData []byte) error {
+	return env.beforeBridge
+}
+
+func TestTCPSkeletonDialAndServePrelude(t *testing.T) {
+	l, err := logger.New(logger.WithPrefix("TestTCPSkeletonDialAndServePrelude"))
+	if err != nil {
+		t.Fatalf("logger.New() returned error: %s", err)
+	}
+
+	// the Called Service records everything it receives, until the Caller's half-close
+	backend, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close()
+	received := make(chan string, 1)
+	go func() {
+		conn, err := backend.Accept()
+		if err != nil {
+			received <- err.Error()
+			return
+		}
+		defer conn.Close()
+		b, _ := ioutil.ReadAll(conn)
+		received <- string(b)
+	}()
+
+	chd, _, err := ParseChannelDescriptorPath(fmt.Sprintf(
+		"tcp://127.0.0.1:3000,tcp://{\"targets\":[\"%s\"],\"prelude\":\"prelude;\"}", backend.Addr()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := &beforeBridgeTestEnv{beforeBridge: func(ctx context.Context, conn ChannelConn, extraData []byte) error {
+		_, err := conn.Write([]byte("setup;"))
+		return err
+	}}
+	ep, err := NewTCPSkeletonEndpoint(l, env, chd.Skeleton)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ep.Close()
+
+	// the Caller is one end of a loopback TCP connection
+	callerListener, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer callerListener.Close()
+	caller, err := net.Dial("tcp4", callerListener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer caller.Close()
+	callerNetConn, err := callerListener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	callerConn, err := NewSocketConn(l, callerNetConn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := caller.Write([]byte("bridged")); err != nil {
+		t.Fatal(err)
+	}
+	caller.(*net.TCPConn).CloseWrite()
+
+	sent, _, err := ep.DialAndServe(context.Background(), callerConn, nil)
+	if err != nil {
+		t.Fatalf("DialAndServe() failed: %s", err)
+	}
+	if got := <-received; got != "prelude;setup;bridged" {
+		t.Errorf("Called Service received %q, want \"prelude;setup;bridged\"", got)
+	}
+	if sent != int64(len("bridged")) {
+		t.Errorf("DialAndServe() counted %d bytes sent, want %d", sent, len("bridged"))
+	}
+}
@@ -9,7 +9,8 @@ import (
 // SocketConn implements a local TCP or Unix Domain ChannelConn
 type SocketConn struct {
 	BasicConn
-	netConn net.Conn
+	netConn     net.Conn
+	originalDst string
 }
 
 // NewSocketConn creates a new SocketConn
@@ -21,6 +22,21 @@ func NewSocketConn(logger Logger, netConn net.Conn) (*SocketConn, error) {
 	return c, nil
 }
 
+// GetOriginalDestination returns the original "<host>:<port>" destination of a connection accepted by a
+// transparent proxy stub, or "" if the connection was not redirected. Implements OriginalDestinationConn.
+func (c *SocketConn) GetOriginalDestination() string {
+	return c.originalDst
+}
+
+// GetSourcePort returns the remote TCP port of the connection, or 0 if it is not a TCP connection.
+// Implements SourcePortConn.
+func (c *SocketConn) GetSourcePort() int {
+	if addr, ok := c.netConn.RemoteAddr().(*net.TCPAddr); ok {
+		return addr.Port
+	}
+	return 0
+}
+
 // CloseWrite shuts down the writing side of the "socket". Corresponds to net.TCPConn.CloseWrite().
 // this method is called when end-of-stream is reached reading from the other ChannelConn of a pair
 // pair are connected via a ChannelPipe. It allows for protocols like HTTP 1.0 in which a client
@@ -40,6 +56,23 @@ func (c *SocketConn) CloseWrite() error {
 	return err
 }
 
+// CloseRead shuts down the reading side of the "socket". Corresponds to net.TCPConn.CloseRead().
+// It is a no-op if the underlying net.Conn does not support read half-close. Implements
+// ReadHalfCloser; it is not part of the ChannelConn interface.
+func (c *SocketConn) CloseRead() error {
+	var err error
+	rhc, _ := c.netConn.(ReadHalfCloser)
+	if rhc != nil {
+		err = rhc.CloseRead()
+		if err != nil {
+			err = c.Errorf("CloseRead failed: %s", err)
+		}
+	} else {
+		c.DLogf("CloseRead() ignored--not implemented by net.Conn implementer")
+	}
+	return err
+}
+
 // HandleOnceShutdown will be called exactly once, in its own goroutine. It should take completionError
 // as an advisory completion value, actually shut down, then return the real completion value.
 func (c *SocketConn) HandleOnceShutdown(completionErr error) error {
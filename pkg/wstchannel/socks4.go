@@ -0,0 +1,204 @@
+package wstchannel
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+
+	socks5 "github.com/armon/go-socks5"
+)
+
+// SOCKS4/4a support is a shim in front of the SOCKS5 server: a SOCKS4 CONNECT request is
+// translated into the equivalent SOCKS5 request, and the SOCKS5 server's reply is translated
+// back, so that name resolution, rules and dialing are exactly as for SOCKS5. SOCKS4 has no
+// authentication, so it is only accepted when explicitly enabled (see Socks4Env).
+
+// Socks4Env may be implemented by a LocalChannelEnv whose socks skeleton endpoints should also
+// accept SOCKS4 and SOCKS4a clients
+type Socks4Env interface {
+	// IsSocks4Enabled returns true if SOCKS4/4a clients are accepted in addition to SOCKS5
+	IsSocks4Enabled() bool
+}
+
+func isSocks4Enabled(env LocalChannelEnv) bool {
+	s4env, ok := env.(Socks4Env)
+	return ok && s4env.IsSocks4Enabled()
+}
+
+const (
+	socks4Version       = 4
+	socks4CmdConnect    = 1
+	socks4ReplyGranted  = 0x5a
+	socks4ReplyRejected = 0x5b
+	socks4MaxField      = 255
+)
+
+// readerConn is a net.Conn that reads from a different reader (e.g., one that has already
+// buffered some of the conn's data)
+type readerConn struct {
+	net.Conn
+	reader io.Reader
+}
+
+func (c *readerConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
+
+// CloseWrite half-closes the underlying conn if it supports it
+func (c *readerConn) CloseWrite() error {
+	if hc, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return hc.CloseWrite()
+	}
+	return nil
+}
+
+// socks4Conn presents a SOCKS4 client connection to a SOCKS5 server
+type socks4Conn struct {
+	readerConn
+
+	// handshake accumulates what the SOCKS5 server writes until its reply is complete
+	handshake []byte
+	done      bool
+}
+
+func (c *socks4Conn) Write(b []byte) (int, error) {
+	if c.done {
+		return c.Conn.Write(b)
+	}
+	c.handshake = append(c.handshake, b...)
+	// method selection (2 bytes), then the reply to the request
+	if len(c.handshake) < 2 {
+		return len(b), nil
+	}
+	if c.handshake[1] != 0 {
+		// the SOCKS5 server requires authentication, which SOCKS4 cannot provide
+		c.done = true
+		c.Conn.Write(socks4Reply(socks4ReplyRejected))
+		return 0, fmt.Errorf("SOCKS4 client rejected: SOCKS server requires authentication")
+	}
+	reply := c.handshake[2:]
+	n, ok := socks5ReplyLen(reply)
+	if !ok {
+		return len(b), nil
+	}
+	c.done = true
+	code := byte(socks4ReplyRejected)
+	if reply[1] == 0 {
+		code = socks4ReplyGranted
+	}
+	_, err := c.Conn.Write(append(socks4Reply(code), reply[n:]...))
+	if err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// socks5ReplyLen returns the length of the SOCKS5 reply at the start of b, if it is complete
+func socks5ReplyLen(b []byte) (int, bool) {
+	if len(b) < 5 {
+		return 0, false
+	}
+	var n int
+	switch b[3] {
+	case 1:
+		n = 4 + 4 + 2
+	case 4:
+		n = 4 + 16 + 2
+	case 3:
+		n = 4 + 1 + int(b[4]) + 2
+	default:
+		n = 4 + 2
+	}
+	return n, len(b) >= n
+}
+
+// socks4Reply builds a SOCKS4 reply. The bound address is not meaningful through the tunnel.
+func socks4Reply(code byte) []byte {
+	return []byte{0, code, 0, 0, 0, 0, 0, 0}
+}
+
+// readSocks4Field reads a NUL-terminated SOCKS4 field
+func readSocks4Field(r *bufio.Reader) ([]byte, error) {
+	var field []byte
+	for {
+		c, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if c == 0 {
+			return field, nil
+		}
+		if len(field) >= socks4MaxField {
+			return nil, fmt.Errorf("SOCKS4 request field too long")
+		}
+		field = append(field, c)
+	}
+}
+
+// translateSocks4Request reads a SOCKS4 or SOCKS4a CONNECT request and returns the equivalent
+// SOCKS5 greeting and request. The user ID is ignored.
+func translateSocks4Request(r *bufio.Reader) ([]byte, error) {
+	var hdr [8]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, err
+	}
+	if hdr[0] != socks4Version {
+		return nil, fmt.Errorf("Unsupported SOCKS version %d", hdr[0])
+	}
+	if hdr[1] != socks4CmdConnect {
+		return nil, fmt.Errorf("Unsupported SOCKS4 command %d", hdr[1])
+	}
+	port := binary.BigEndian.Uint16(hdr[2:4])
+	ip := hdr[4:8]
+	if _, err := readSocks4Field(r); err != nil {
+		return nil, err
+	}
+	var req bytes.Buffer
+	// greeting offering no authentication, then a CONNECT request
+	req.Write([]byte{5, 1, 0, 5, 1, 0})
+	if ip[0] == 0 && ip[1] == 0 && ip[2] == 0 && ip[3] != 0 {
+		// SOCKS4a: the hostname follows the user ID
+		host, err := readSocks4Field(r)
+		if err != nil {
+			return nil, err
+		}
+		if len(host) == 0 {
+			return nil, fmt.Errorf("Empty SOCKS4a hostname")
+		}
+		req.WriteByte(3)
+		req.WriteByte(byte(len(host)))
+		req.Write(host)
+	} else {
+		req.WriteByte(1)
+		req.Write(ip)
+	}
+	binary.Write(&req, binary.BigEndian, port)
+	return req.Bytes(), nil
+}
+
+// serveSocksConn serves a single SOCKS connection with the SOCKS5 server. If allowSocks4 is
+// true, SOCKS4 and SOCKS4a clients are also accepted.
+func serveSocksConn(server *socks5.Server, conn net.Conn, allowSocks4 bool) error {
+	if !allowSocks4 {
+		return server.ServeConn(conn)
+	}
+	r := bufio.NewReader(conn)
+	version, err := r.Peek(1)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	if version[0] != socks4Version {
+		return server.ServeConn(&readerConn{Conn: conn, reader: r})
+	}
+	req, err := translateSocks4Request(r)
+	if err != nil {
+		conn.Write(socks4Reply(socks4ReplyRejected))
+		conn.Close()
+		return err
+	}
+	return server.ServeConn(&socks4Conn{readerConn: readerConn{Conn: conn, reader: io.MultiReader(bytes.NewReader(req), r)}})
+}
@@ -0,0 +1,50 @@
+package wstchannel
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+)
+
+func TestTranslateSocks4Request(t *testing.T) {
+	tests := []struct {
+		req  []byte
+		want []byte
+	}{
+		// SOCKS4 CONNECT 10.1.2.3:80, user "bob"
+		{
+			[]byte{4, 1, 0, 80, 10, 1, 2, 3, 'b', 'o', 'b', 0},
+			[]byte{5, 1, 0, 5, 1, 0, 1, 10, 1, 2, 3, 0, 80},
+		},
+		// SOCKS4a CONNECT example.com:443, no user
+		{
+			append([]byte{4, 1, 1, 187, 0, 0, 0, 1, 0}, []byte("example.com\x00")...),
+			append(append([]byte{5, 1, 0, 5, 1, 0, 3, 11}, []byte("example.com")...), 1, 187),
+		},
+	}
+	for _, tt := range tests {
+		got, err := translateSocks4Request(bufio.NewReader(bytes.NewReader(tt.req)))
+		if err != nil {
+			t.Errorf("translateSocks4Request(%v): unexpected error: %s", tt.req, err)
+			continue
+		}
+		if !bytes.Equal(got, tt.want) {
+			t.Errorf("translateSocks4Request(%v) = %v, want %v", tt.req, got, tt.want)
+		}
+	}
+
+	// BIND is not supported
+	if _, err := translateSocks4Request(bufio.NewReader(bytes.NewReader([]byte{4, 2, 0, 80, 10, 1, 2, 3, 0}))); err == nil {
+		t.Errorf("translateSocks4Request() accepted a BIND request")
+	}
+}
+
+func TestSocks5ReplyLen(t *testing.T) {
+	reply := []byte{5, 0, 0, 1, 127, 0, 0, 1, 0, 80, 'x'}
+	if n, ok := socks5ReplyLen(reply); !ok || n != 10 {
+		t.Errorf("socks5ReplyLen(IPv4 reply) = (%d, %v), want (10, true)", n, ok)
+	}
+	if _, ok := socks5ReplyLen(reply[:7]); ok {
+		t.Errorf("socks5ReplyLen() of a partial reply returned ok")
+	}
+}
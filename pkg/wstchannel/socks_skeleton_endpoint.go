@@ -13,18 +13,23 @@ type SocksSkeletonEndpoint struct {
 	// Implements LocalSkeletonChannelEndpoint
 	BasicEndpoint
 	socksServer *socks5.Server
+	allowSocks4 bool
 }
 
-// NewSocksSkeletonEndpoint creates a new SocksSkeletonEndpoint
+// NewSocksSkeletonEndpoint creates a new SocksSkeletonEndpoint. The endpoint speaks SOCKS5; if
+// allowSocks4 is true, it also accepts SOCKS4 and SOCKS4a clients, which cannot authenticate.
 func NewSocksSkeletonEndpoint(
 	logger Logger,
 	ced *ChannelEndpointDescriptor,
 	socksServer *socks5.Server,
+	allowSocks4 bool,
 ) (*SocksSkeletonEndpoint, error) {
 	ep := &SocksSkeletonEndpoint{
 		BasicEndpoint: BasicEndpoint{
 			ced: ced,
 		},
+		socksServer: socksServer,
+		allowSocks4: allowSocks4,
 	}
 	ep.InitBasicEndpoint(logger, ep, "SocksSkeletonEndpoint: %s", ced)
 	return ep, nil
@@ -61,7 +66,7 @@ func (ep *SocksSkeletonEndpoint) Dial(ctx context.Context, extraData []byte) (Ch
 		return nil, fmt.Errorf("%s: Unable to wrap net.Conn with SocketConn: %s", ep.Logger.Prefix(), err)
 	}
 
-	err = ep.socksServer.ServeConn(socksNetConn)
+	err = serveSocksConn(ep.socksServer, socksNetConn, ep.allowSocks4)
 	if err != nil {
 		socksNetConn.Close()
 		conn.Close()
@@ -69,6 +74,7 @@ func (ep *SocksSkeletonEndpoint) Dial(ctx context.Context, extraData []byte) (Ch
 	}
 
 	ep.AddShutdownChild(conn)
+	ep.notifyDial(conn)
 
 	return conn, nil
 }
@@ -23,7 +23,11 @@ func NewStdioSkeletonEndpoint(
 		},
 	}
 	ep.InitBasicEndpoint(logger, ep, "StdioSkeletonEndpoint")
-	pipeConn, err := NewPipeConn(ep.Logger, os.Stdin, os.Stdout)
+	input, output, err := getStdioFiles(ced)
+	if err != nil {
+		return nil, ep.Errorf("%s", err)
+	}
+	pipeConn, err := NewPipeConn(ep.Logger, input, output)
 	if err != nil {
 		return nil, ep.Errorf("Failed to create stdio PipeConn: %s", err)
 	}
@@ -45,6 +49,7 @@ func (ep *StdioSkeletonEndpoint) HandleOnceShutdown(completionErr error) error {
 // Dial initiates a new connection to a Called Service. Part of the
 // DialerChannelEndpoint interface
 func (ep *StdioSkeletonEndpoint) Dial(ctx context.Context, extraData []byte) (ChannelConn, error) {
+	ep.notifyDial(ep.pipeConn)
 	return ep.pipeConn, nil
 }
 
@@ -2,14 +2,20 @@ package wstchannel
 
 import (
 	"context"
+	"fmt"
 	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
 )
 
-// StdioStubEndpoint implements a local Stdio stub
+// StdioStubEndpoint implements a local Stdio stub. Its single Caller connection is the
+// process's input and output streams, so it is only accepted once.
 type StdioStubEndpoint struct {
 	// Implements LocalStubChannelEndpoint
 	BasicEndpoint
 	pipeConn *PipeConn
+	accepted int32
 }
 
 // NewStdioStubEndpoint creates a new StdioStubEndpoint
@@ -23,7 +29,16 @@ func NewStdioStubEndpoint(
 		},
 	}
 	ep.InitBasicEndpoint(logger, ep, "StdioStubEndpoint")
-	pipeConn, err := NewPipeConn(ep.Logger, os.Stdin, os.Stdout)
+	input, output, err := getStdioFiles(ced)
+	if err != nil {
+		return nil, ep.Errorf("%s", err)
+	}
+	if output == os.Stdout {
+		// Without this, the Go runtime kills the process when the reader of stdout goes away
+		// (e.g., "| head -1"); with it, the write fails with EPIPE, which is a clean close.
+		signal.Ignore(syscall.SIGPIPE)
+	}
+	pipeConn, err := NewPipeConn(ep.Logger, input, output)
 	if err != nil {
 		return nil, ep.Errorf("Failed to create stdio PipeConn: %s", err)
 	}
@@ -51,11 +66,20 @@ func (ep *StdioStubEndpoint) StartListening() error {
 }
 
 // Accept listens for and accepts a single connection from a Caller network client as specified in the
-// endpoint configuration. This call does not return until a new connection is available or a
-// error occurs. There is no way to cancel an Accept() request other than closing the endpoint. Part of
+// endpoint configuration. The first call returns the stdio connection immediately; later calls do not
+// return until ctx is done or the endpoint is closed, since there is only one stdio Caller. Part of
 // the AcceptorChannelEndpoint interface.
 func (ep *StdioStubEndpoint) Accept(ctx context.Context) (ChannelConn, error) {
-	return ep.pipeConn, nil
+	if atomic.CompareAndSwapInt32(&ep.accepted, 0, 1) {
+		ep.notifyAccept(ep.pipeConn)
+		return ep.pipeConn, nil
+	}
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-ep.ShutdownStartedChan():
+		return nil, fmt.Errorf("%s: endpoint is closed", ep.Logger.Prefix())
+	}
 }
 
 // AcceptAndServe listens for and accepts a single connection from a Caller network client as specified in the
@@ -81,3 +105,53 @@ func (ep *StdioStubEndpoint) AcceptAndServe(ctx context.Context, calledServiceCo
 	}
 	return BasicBridgeChannels(ctx, ep.Logger, callerConn, calledServiceConn)
 }
+
+// getStdioFiles returns the input and output files to be used by a stdio endpoint. By default
+// these are os.Stdin and os.Stdout. If the endpoint descriptor params include "fds", it must be
+// an array of two open file descriptor numbers [<input-fd>, <output-fd>], e.g. {"fds":[3,4]};
+// this allows stdout to be used for logging without corrupting the tunnelled stream.
+func getStdioFiles(ced *ChannelEndpointDescriptor) (input *os.File, output *os.File, err error) {
+	params := ced.GetParamsMap()
+	if params["fds"] == nil {
+		return os.Stdin, os.Stdout, nil
+	}
+	inputFd, outputFd, err := getStdioFds(params)
+	if err != nil {
+		return nil, nil, err
+	}
+	files := make([]*os.File, 2)
+	for i, name := range []string{"input", "output"} {
+		fd := []int{inputFd, outputFd}[i]
+		file := os.NewFile(uintptr(fd), fmt.Sprintf("fd%d", fd))
+		if file == nil {
+			return nil, nil, fmt.Errorf("Parameter \"fds\": invalid %s fd %d", name, fd)
+		}
+		if _, err := file.Stat(); err != nil {
+			return nil, nil, fmt.Errorf("Parameter \"fds\": %s fd %d is not open: %s", name, fd, err)
+		}
+		files[i] = file
+	}
+	return files[0], files[1], nil
+}
+
+// getStdioFds returns the input and output file descriptor numbers selected by a stdio endpoint's
+// params, without opening them. If there is no "fds" param, the fds of stdin and stdout are returned.
+func getStdioFds(params map[string]interface{}) (input int, output int, err error) {
+	v, ok := params["fds"]
+	if !ok || v == nil {
+		return int(os.Stdin.Fd()), int(os.Stdout.Fd()), nil
+	}
+	fds, ok := v.([]interface{})
+	if !ok || len(fds) != 2 {
+		return 0, 0, fmt.Errorf("Parameter \"fds\" must be an array of two file descriptor numbers: [<input-fd>, <output-fd>]")
+	}
+	result := make([]int, 2)
+	for i, name := range []string{"input", "output"} {
+		f, ok := fds[i].(float64)
+		if !ok || f != float64(int(f)) || f < 0 {
+			return 0, 0, fmt.Errorf("Parameter \"fds\": %s fd must be a non-negative integer; got %v", name, fds[i])
+		}
+		result[i] = int(f)
+	}
+	return result[0], result[1], nil
+}
@@ -0,0 +1,61 @@
+package wstchannel
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"syscall"
+)
+
+// TCPStubReusePortEnv may be implemented by a LocalChannelEnv to set the default for the "reusePort"
+// param of its TCP stubs. SO_REUSEPORT lets several sockets listen on the same port, with the
+// kernel balancing connections between them, so it is off unless enabled.
+type TCPStubReusePortEnv interface {
+	// IsTCPStubReusePortDefault returns true if TCP stubs are created with SO_REUSEPORT unless
+	// their params say otherwise
+	IsTCPStubReusePortDefault() bool
+}
+
+// isEnvTCPStubReusePortDefault returns the default provided by env, or false if it does not
+// implement TCPStubReusePortEnv
+func isEnvTCPStubReusePortDefault(env LocalChannelEnv) bool {
+	rpEnv, ok := env.(TCPStubReusePortEnv)
+	return ok && rpEnv.IsTCPStubReusePortDefault()
+}
+
+// newTCPListenConfig returns the net.ListenConfig for a TCP stub listener. SO_REUSEADDR is set so
+// that a restarted proxy can rebind a port that still has connections in TIME_WAIT, and
+// SO_REUSEPORT is set if reusePort is true. The options are only applied to TCP sockets.
+func newTCPListenConfig(reusePort bool) *net.ListenConfig {
+	return &net.ListenConfig{
+		Control: func(network, address string, c syscall.RawConn) error {
+			if !strings.HasPrefix(network, "tcp") {
+				return nil
+			}
+			return setTCPListenerSockopts(c, reusePort)
+		},
+	}
+}
+
+// errListenBacklogUnsupported is returned by setTCPListenBacklog on platforms where the backlog of
+// a listening socket cannot be changed
+var errListenBacklogUnsupported = errors.New("Setting the listen backlog is not supported on this platform")
+
+// setTCPListenBacklog sets the kernel accept backlog of a TCP listener. Go always listens with the
+// system's maximum (SOMAXCONN, or net.core.somaxconn on Linux), so the backlog is changed by
+// listening again on the bound socket; the kernel still caps it at its maximum. A backlog <= 0
+// leaves the listener unchanged.
+func setTCPListenBacklog(l net.Listener, backlog int) error {
+	if backlog <= 0 {
+		return nil
+	}
+	tl, ok := l.(*net.TCPListener)
+	if !ok {
+		return nil
+	}
+	c, err := tl.SyscallConn()
+	if err != nil {
+		return err
+	}
+	return relistenWithBacklog(c, backlog)
+}
@@ -0,0 +1,23 @@
+//+build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package wstchannel
+
+import (
+	"fmt"
+	"runtime"
+	"syscall"
+)
+
+// setTCPListenerSockopts leaves the socket options alone: on Windows, SO_REUSEADDR would allow
+// another process to steal a bound port, and SO_REUSEPORT is not available
+func setTCPListenerSockopts(c syscall.RawConn, reusePort bool) error {
+	if reusePort {
+		return fmt.Errorf("SO_REUSEPORT is not supported on %s", runtime.GOOS)
+	}
+	return nil
+}
+
+// relistenWithBacklog cannot change the backlog of a listening socket on this platform
+func relistenWithBacklog(c syscall.RawConn, backlog int) error {
+	return errListenBacklogUnsupported
+}
@@ -0,0 +1,38 @@
+//+build darwin dragonfly freebsd linux netbsd openbsd
+
+package wstchannel
+
+import (
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// setTCPListenerSockopts sets SO_REUSEADDR, and SO_REUSEPORT if reusePort is true, on a TCP
+// listener socket before it is bound
+func setTCPListenerSockopts(c syscall.RawConn, reusePort bool) error {
+	var err error
+	cerr := c.Control(func(fd uintptr) {
+		err = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEADDR, 1)
+		if err == nil && reusePort {
+			err = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1)
+		}
+	})
+	if cerr != nil {
+		return cerr
+	}
+	return err
+}
+
+// relistenWithBacklog changes the backlog of a listening socket by calling listen again, which
+// these platforms allow
+func relistenWithBacklog(c syscall.RawConn, backlog int) error {
+	var err error
+	cerr := c.Control(func(fd uintptr) {
+		err = unix.Listen(int(fd), backlog)
+	})
+	if cerr != nil {
+		return cerr
+	}
+	return err
+}
@@ -0,0 +1,65 @@
+//+build darwin dragonfly freebsd linux netbsd openbsd
+
+package wstchannel
+
+import (
+	"context"
+	"net"
+	"testing"
+)
+
+func TestTCPListenConfigReusePort(t *testing.T) {
+	ctx := context.Background()
+	l1, err := newTCPListenConfig(true).Listen(ctx, "tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() with reusePort returned error: %s", err)
+	}
+	defer l1.Close()
+	addr := l1.Addr().String()
+
+	l2, err := newTCPListenConfig(true).Listen(ctx, "tcp4", addr)
+	if err != nil {
+		t.Fatalf("Second Listen() on %s with reusePort returned error: %s", addr, err)
+	}
+	l2.Close()
+
+	// SO_REUSEPORT is opt-in; without it the port is still exclusive
+	l3, err := newTCPListenConfig(false).Listen(ctx, "tcp4", addr)
+	if err == nil {
+		l3.Close()
+		t.Errorf("Listen() on %s without reusePort succeeded while the port was in use", addr)
+	}
+
+	// the options are only applied to TCP sockets
+	u, err := newTCPListenConfig(true).ListenPacket(ctx, "udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("ListenPacket() returned error: %s", err)
+	}
+	u.Close()
+}
+
+func TestSetTCPListenBacklog(t *testing.T) {
+	l, err := newTCPListenConfig(false).Listen(context.Background(), "tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if err := setTCPListenBacklog(l, 0); err != nil {
+		t.Errorf("setTCPListenBacklog(0) returned error: %s", err)
+	}
+	if err := setTCPListenBacklog(l, 1024); err != nil {
+		t.Fatalf("setTCPListenBacklog(1024) returned error: %s", err)
+	}
+
+	// the listener still accepts connections
+	c, err := net.Dial("tcp4", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	a, err := l.Accept()
+	if err != nil {
+		t.Fatalf("Accept() after setting the backlog returned error: %s", err)
+	}
+	a.Close()
+}
@@ -2,23 +2,101 @@ package wstchannel
 
 import (
 	"context"
+	"fmt"
 	"net"
+	"strings"
 )
 
-// TCPSkeletonEndpoint implements a local TCP skeleton
+// TCPSkeletonTargetSeparator separates the fallback targets of a TCP skeleton path, e.g.,
+// tcp://primary:80|backup:80
+const TCPSkeletonTargetSeparator = "|"
+
+// TCPSkeletonTargets returns the ordered list of "<host>:<port>" targets of a TCP skeleton, given
+// its params path and decoded params. The targets are given either by a "targets" param, e.g.,
+// tcp://{"targets":["primary:80","backup:80"]}, or otherwise by a path of targets separated by
+// TCPSkeletonTargetSeparator. A path with a single target yields a single target.
+func TCPSkeletonTargets(path string, params map[string]interface{}) ([]string, error) {
+	if _, ok := params["targets"]; ok {
+		targets, err := ParamStringList(params, "targets", nil)
+		if err != nil {
+			return nil, err
+		}
+		if len(targets) == 0 {
+			return nil, fmt.Errorf("Parameter \"targets\" must not be empty")
+		}
+		for _, target := range targets {
+			if target == "" {
+				return nil, fmt.Errorf("Parameter \"targets\" contains an empty target")
+			}
+		}
+		return targets, nil
+	}
+	targets := strings.Split(path, TCPSkeletonTargetSeparator)
+	for i, target := range targets {
+		targets[i] = strings.TrimSpace(target)
+		if targets[i] == "" && len(targets) > 1 {
+			return nil, fmt.Errorf("Empty target in TCP skeleton target list \"%s\"", path)
+		}
+	}
+	return targets, nil
+}
+
+// TCPSkeletonHostEnv may be implemented by a LocalChannelEnv to give the host that its TCP
+// skeletons dial for a target with a port but no host (e.g., from a full-form "tcp://:80"
+// descriptor parsed without a default host). Such a target is otherwise dialed on the local host.
+type TCPSkeletonHostEnv interface {
+	// GetDefaultTCPSkeletonHost returns the host to dial for targets that give none, or "" to dial
+	// the local host
+	GetDefaultTCPSkeletonHost() string
+}
+
+// getEnvDefaultTCPSkeletonHost returns the host provided by env, or "" if it does not implement
+// TCPSkeletonHostEnv
+func getEnvDefaultTCPSkeletonHost(env LocalChannelEnv) string {
+	hostEnv, ok := env.(TCPSkeletonHostEnv)
+	if !ok {
+		return ""
+	}
+	return hostEnv.GetDefaultTCPSkeletonHost()
+}
+
+// TCPSkeletonEndpoint implements a local TCP skeleton. If it has several targets (see
+// TCPSkeletonTargets), they are dialed in order until one succeeds. A "prelude" param, if given, is
+// written to each connection before bridging (see skeletonPrelude).
 type TCPSkeletonEndpoint struct {
 	// Implements LocalSkeletonChannelEndpoint
 	BasicEndpoint
+	targets []string
+	prelude []byte
 }
 
-// NewTCPSkeletonEndpoint creates a new TCPSkeletonEndpoint
-func NewTCPSkeletonEndpoint(logger Logger, ced *ChannelEndpointDescriptor) (*TCPSkeletonEndpoint, error) {
+// NewTCPSkeletonEndpoint creates a new TCPSkeletonEndpoint. Targets without a host are given the
+// default host of env, if any (see TCPSkeletonHostEnv).
+func NewTCPSkeletonEndpoint(logger Logger, env LocalChannelEnv, ced *ChannelEndpointDescriptor) (*TCPSkeletonEndpoint, error) {
 	ep := &TCPSkeletonEndpoint{
 		BasicEndpoint: BasicEndpoint{
 			ced: ced,
 		},
 	}
 	ep.InitBasicEndpoint(logger, ep, "TCPSkeletonEndpoint: %s", ced)
+	targets, err := TCPSkeletonTargets(ced.Path, ced.GetParamsMap())
+	if err != nil {
+		return nil, ep.Errorf("%s", err)
+	}
+	defaultHost := getEnvDefaultTCPSkeletonHost(env)
+	for i, target := range targets {
+		// a bare port (e.g., from "tcp://80") is dialed as ":<port>" if there is no default host
+		host, port, err := ParseHostPort(target, "", UnknownPortNumber)
+		if err == nil && host == "" && port != UnknownPortNumber {
+			targets[i] = fmt.Sprintf("%s:%d", defaultHost, port)
+		}
+	}
+	ep.targets = targets
+	ep.prelude, err = skeletonPrelude(ced.GetParamsMap())
+	if err != nil {
+		return nil, ep.Errorf("%s", err)
+	}
+	ep.BeforeBridge = getEnvBeforeBridge(env)
 	return ep, nil
 }
 
@@ -31,18 +109,34 @@ func (ep *TCPSkeletonEndpoint) HandleOnceShutdown(completionErr error) error {
 // Dial initiates a new connection to a Called Service. Part of the
 // DialerChannelEndpoint interface
 func (ep *TCPSkeletonEndpoint) Dial(ctx context.Context, extraData []byte) (ChannelConn, error) {
-	ep.DLogf("Dialing local TCP service at %s", ep.ced.Path)
-
 	if ep.IsStartedShutdown() {
 		err := ep.Errorf("Endpoint is closed: %s", ep.String())
 		return nil, err
 	}
 
-	// TODO: make sure IPV6 works
-	var d net.Dialer
-	netConn, err := d.DialContext(ctx, "tcp", ep.ced.Path)
-	if err != nil {
-		return nil, ep.Errorf("DialContext failed: %s", err)
+	md := ParseChannelMetadata(extraData)
+	var netConn net.Conn
+	var errs []string
+	for _, target := range ep.targets {
+		ep.DLogf("Dialing local TCP service at %s", target)
+		var err error
+		netConn, err = ep.dialTarget(ctx, target, md.SourcePort)
+		if err == nil {
+			break
+		}
+		errs = append(errs, fmt.Sprintf("%s: %s", target, err))
+		if ctx.Err() != nil {
+			break
+		}
+		if len(ep.targets) > 1 {
+			ep.DLogf("Unable to connect to %s: %s", target, err)
+		}
+	}
+	if netConn == nil {
+		if len(ep.targets) == 1 {
+			return nil, ep.Errorf("DialContext failed: %s", strings.Join(errs, "; "))
+		}
+		return nil, ep.Errorf("Unable to connect to any of %d targets: %s", len(ep.targets), strings.Join(errs, "; "))
 	}
 
 	conn, err := NewSocketConn(ep.Logger, netConn)
@@ -53,9 +147,28 @@ func (ep *TCPSkeletonEndpoint) Dial(ctx context.Context, extraData []byte) (Chan
 	ep.AddShutdownChild(conn)
 
 	ep.DLogf("Connected to local TCP service %s", ep.String())
+	ep.notifyDial(conn)
 	return conn, nil
 }
 
+// dialTarget connects to a single target. If sourcePort is not 0, the Caller's source port is used
+// where it is free locally, falling back to an ephemeral port.
+func (ep *TCPSkeletonEndpoint) dialTarget(ctx context.Context, target string, sourcePort int) (net.Conn, error) {
+	// TODO: make sure IPV6 works
+	var d net.Dialer
+	if sourcePort > 0 {
+		// the port may be in use locally; that is not worth failing the connection over
+		d.LocalAddr = &net.TCPAddr{Port: sourcePort}
+		netConn, err := d.DialContext(ctx, "tcp", target)
+		if err == nil || ctx.Err() != nil {
+			return netConn, err
+		}
+		ep.DLogf("Unable to dial from Caller's source port %d, using an ephemeral port: %s", sourcePort, err)
+		d.LocalAddr = nil
+	}
+	return d.DialContext(ctx, "tcp", target)
+}
+
 // DialAndServe initiates a new connection to a Called Service as specified in the
 // endpoint configuration, then services the connection using an already established
 // callerConn as the proxied Caller's end of the session. This call does not return until
@@ -81,5 +194,11 @@ func (ep *TCPSkeletonEndpoint) DialAndServe(
 		callerConn.Close()
 		return 0, 0, err
 	}
+	err = ep.beforeBridge(ctx, calledServiceConn, ep.prelude, extraData)
+	if err != nil {
+		callerConn.Close()
+		calledServiceConn.Close()
+		return 0, 0, ep.Errorf("%s", err)
+	}
 	return BasicBridgeChannels(ctx, ep.Logger, callerConn, calledServiceConn)
 }
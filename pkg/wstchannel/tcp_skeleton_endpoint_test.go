@@ -0,0 +1,98 @@
+package wstchannel
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/sammck-go/logger"
+)
+
+func TestTCPSkeletonTargets(t *testing.T) {
+	tests := []struct {
+		path    string
+		params  map[string]interface{}
+		targets []string
+		isErr   bool
+	}{
+		{path: "localhost:80", targets: []string{"localhost:80"}},
+		{path: "primary:80|backup:80", targets: []string{"primary:80", "backup:80"}},
+		{path: "primary:80 | backup:80|third:81", targets: []string{"primary:80", "backup:80", "third:81"}},
+		{path: "primary:80|", isErr: true},
+		{path: "|backup:80", isErr: true},
+		{
+			params:  map[string]interface{}{"targets": []interface{}{"primary:80", "backup:80"}},
+			targets: []string{"primary:80", "backup:80"},
+		},
+		{params: map[string]interface{}{"targets": []interface{}{}}, isErr: true},
+		{params: map[string]interface{}{"targets": []interface{}{"primary:80", ""}}, isErr: true},
+		{params: map[string]interface{}{"targets": "primary:80"}, isErr: true},
+	}
+	for i, test := range tests {
+		targets, err := TCPSkeletonTargets(test.path, test.params)
+		if test.isErr {
+			if err == nil {
+				t.Errorf("Test #%d (%q): expected an error, got %q", i+1, test.path, targets)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Test #%d (%q): unexpected error: %s", i+1, test.path, err)
+			continue
+		}
+		if !reflect.DeepEqual(targets, test.targets) {
+			t.Errorf("Test #%d (%q): expected %q, got %q", i+1, test.path, test.targets, targets)
+		}
+	}
+}
+
+func TestDefaultTCPSkeletonPathTargets(t *testing.T) {
+	got := defaultTCPSkeletonPath(":80|other:81", "backend")
+	if got != "backend:80|other:81" {
+		t.Errorf("Expected \"backend:80|other:81\", got %q", got)
+	}
+	if got := defaultTCPSkeletonPath(":80|other:81", ""); got != ":80|other:81" {
+		t.Errorf("Expected an unchanged path without a default host, got %q", got)
+	}
+}
+
+// skeletonHostTestEnv is a LocalChannelEnv that gives a default TCP skeleton host
+type skeletonHostTestEnv struct {
+	LocalChannelEnv
+	host string
+}
+
+func (env *skeletonHostTestEnv) GetDefaultTCPSkeletonHost() string {
+	return env.host
+}
+
+func TestTCPSkeletonDefaultHost(t *testing.T) {
+	l, err := logger.New(logger.WithPrefix("TestTCPSkeletonDefaultHost"))
+	if err != nil {
+		t.Fatalf("logger.New() returned error: %s", err)
+	}
+	tests := []struct {
+		skeleton string
+		host     string
+		targets  []string
+	}{
+		{"tcp://:80|other:81", "backend", []string{"backend:80", "other:81"}},
+		{"tcp://80", "backend", []string{"backend:80"}},
+		{"tcp://:80", "", []string{":80"}},
+		{"tcp://80", "", []string{":80"}},
+		{"tcp://localhost:80", "backend", []string{"localhost:80"}},
+	}
+	for _, tt := range tests {
+		chd, _, err := ParseChannelDescriptorPath("tcp://127.0.0.1:3000," + tt.skeleton)
+		if err != nil {
+			t.Fatalf("ParseChannelDescriptorPath(%q): %s", tt.skeleton, err)
+		}
+		ep, err := NewTCPSkeletonEndpoint(l, &skeletonHostTestEnv{host: tt.host}, chd.Skeleton)
+		if err != nil {
+			t.Errorf("NewTCPSkeletonEndpoint(%q): %s", tt.skeleton, err)
+			continue
+		}
+		if !reflect.DeepEqual(ep.targets, tt.targets) {
+			t.Errorf("NewTCPSkeletonEndpoint(%q) with default host %q targets = %q; expected %q", tt.skeleton, tt.host, ep.targets, tt.targets)
+		}
+	}
+}
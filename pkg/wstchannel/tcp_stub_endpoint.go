@@ -2,29 +2,106 @@ package wstchannel
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net"
+	"syscall"
 )
 
 // TCPStubEndpoint implements a local TCP stub
+//
+// If the endpoint descriptor params include {"transparent": true}, the stub acts as a transparent
+// proxy (Linux and IPv4 only): connections redirected to it by an iptables REDIRECT rule in the nat
+// table are accepted along with their original destination (SO_ORIGINAL_DST), which is forwarded
+// to the remote skeleton in place of its configured target. TPROXY rules are not supported, since
+// the listener does not set IP_TRANSPARENT. In this case the bind address is given by the "bind"
+// param, e.g., tcp://{"bind":"0.0.0.0:3128","transparent":true}.
+//
+// If the params include "allowFrom", a list of CIDR blocks or IP addresses (IPv4 or IPv6), only
+// connections from matching source addresses are accepted; others are closed immediately, e.g.,
+// R:tcp://{"bind":"0.0.0.0:2222","allowFrom":["10.0.0.0/8","192.168.0.0/16"]},tcp://localhost:22. This
+// is most useful on reverse stubs, which listen on the server. An empty list accepts everyone.
+//
+// If the params include {"reusePort": true}, the listener is created with SO_REUSEPORT, so that
+// several processes can listen on the same port (the default is given by the environment; see
+// TCPStubReusePortEnv). This is not allowed on the server side, where a client could otherwise
+// share a port with another client's, or the server's own, listener.
+//
+// If the params include "backlog", e.g., {"backlog":4096}, it is the kernel's accept backlog for
+// the listener, for stubs with a high connection rate. The kernel caps it at its maximum (on Linux,
+// net.core.somaxconn). By default, and on platforms where it cannot be set, Go's default of the
+// system maximum is used.
 type TCPStubEndpoint struct {
 	// Implements LocalStubChannelEndpoint
 	BasicEndpoint
-	listenErr error
-	listener  net.Listener
+	bindAddr    string
+	transparent bool
+	allowFrom   []*net.IPNet
+	reusePort   bool
+	backlog     int
+	listenErr   error
+	listener    net.Listener
 }
 
 // NewTCPStubEndpoint creates a new TCPStubEndpoint
-func NewTCPStubEndpoint(logger Logger, ced *ChannelEndpointDescriptor) (*TCPStubEndpoint, error) {
+func NewTCPStubEndpoint(logger Logger, env LocalChannelEnv, ced *ChannelEndpointDescriptor) (*TCPStubEndpoint, error) {
+	reusePortDefault := !env.IsServer() && isEnvTCPStubReusePortDefault(env)
 	ep := &TCPStubEndpoint{
 		BasicEndpoint: BasicEndpoint{
 			ced: ced,
 		},
+		bindAddr:  ced.Path,
+		reusePort: reusePortDefault,
 	}
 	ep.InitBasicEndpoint(logger, ep, "TCPStubEndpoint: %s", ced)
+	if params := ced.GetParamsMap(); params != nil {
+		var err error
+		ep.bindAddr, err = ParamString(params, "bind", "")
+		if err != nil {
+			return nil, ep.Errorf("%s", err)
+		}
+		if ep.bindAddr == "" {
+			return nil, ep.Errorf("TCP stub params require a \"bind\" address")
+		}
+		ep.transparent, err = ParamBool(params, "transparent", false)
+		if err != nil {
+			return nil, ep.Errorf("%s", err)
+		}
+		allowFrom, err := ParamStringList(params, "allowFrom", nil)
+		if err != nil {
+			return nil, ep.Errorf("%s", err)
+		}
+		ep.allowFrom, err = ParseIPNets(allowFrom)
+		if err != nil {
+			return nil, ep.Errorf("Parameter \"allowFrom\": %s", err)
+		}
+		ep.reusePort, err = ParamBool(params, "reusePort", reusePortDefault)
+		if err != nil {
+			return nil, ep.Errorf("%s", err)
+		}
+		if ep.reusePort && env.IsServer() {
+			return nil, ep.Errorf("Parameter \"reusePort\" is not allowed on the server side")
+		}
+		ep.backlog, err = ParamInt(params, "backlog", 0)
+		if err != nil {
+			return nil, ep.Errorf("%s", err)
+		}
+		if ep.backlog < 0 {
+			return nil, ep.Errorf("Parameter \"backlog\" must not be negative")
+		}
+	}
 	return ep, nil
 }
 
+// isAllowedSource returns true if a Caller at addr may connect, according to "allowFrom"
+func (ep *TCPStubEndpoint) isAllowedSource(addr net.Addr) bool {
+	if len(ep.allowFrom) == 0 {
+		return true
+	}
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	return ok && IPNetsContain(ep.allowFrom, tcpAddr.IP)
+}
+
 // HandleOnceShutdown will be called exactly once, in its own goroutine. It should take completionError
 // as an advisory completion value, actually shut down, then return the real completion value.
 func (ep *TCPStubEndpoint) HandleOnceShutdown(completionErr error) error {
@@ -54,11 +131,16 @@ func (ep *TCPStubEndpoint) getListener() (net.Listener, error) {
 		if ep.IsStartedShutdown() {
 			err = fmt.Errorf("%s: Endpoint is closed", ep.Logger.Prefix())
 		} else if ep.listener == nil && ep.listenErr == nil {
+			lc := newTCPListenConfig(ep.reusePort)
 			// TODO: support IPV6
-			listener, err = net.Listen("tcp4", ep.ced.Path)
+			listener, err = lc.Listen(context.Background(), "tcp4", ep.bindAddr)
 			if err != nil {
-				err = fmt.Errorf("%s: TCP listen failed for path '%s': %s", ep.Logger.Prefix(), ep.ced.Path, err)
+				err = fmt.Errorf("%s: TCP listen failed for path '%s': %s (%s)", ep.Logger.Prefix(), ep.bindAddr,
+					describeTCPListenError(ep.bindAddr, err), err)
 			} else {
+				if berr := setTCPListenBacklog(listener, ep.backlog); berr != nil {
+					ep.DLogf("Unable to set listen backlog to %d, using the default: %s", ep.backlog, berr)
+				}
 				ep.listener = listener
 			}
 			ep.listenErr = err
@@ -81,6 +163,23 @@ func (ep *TCPStubEndpoint) StartListening() error {
 	return err
 }
 
+// StopListening closes the listener, so that no further Callers are accepted, without closing the
+// connections already accepted. Subsequent Accept calls fail. Part of the ListenStopper interface.
+func (ep *TCPStubEndpoint) StopListening() error {
+	ep.Lock.Lock()
+	listener := ep.listener
+	ep.listener = nil
+	if ep.listenErr == nil {
+		ep.listenErr = fmt.Errorf("%s: Endpoint has stopped listening", ep.Logger.Prefix())
+	}
+	ep.Lock.Unlock()
+
+	if listener == nil {
+		return nil
+	}
+	return listener.Close()
+}
+
 // Accept listens for and accepts a single connection from a Caller network client as specified in the
 // endpoint configuration. This call does not return until a new connection is available or a
 // error occurs. There is no way to cancel an Accept() request other than closing the endpoint. Part of
@@ -91,16 +190,39 @@ func (ep *TCPStubEndpoint) Accept(ctx context.Context) (ChannelConn, error) {
 		return nil, err
 	}
 
-	netConn, err := listener.Accept()
-	if err != nil {
-		return nil, fmt.Errorf("%s: Accept failed: %s", ep.Logger.Prefix(), err)
+	var netConn net.Conn
+	var originalDst string
+	for {
+		netConn, err = listener.Accept()
+		if err != nil {
+			return nil, fmt.Errorf("%s: Accept failed: %s", ep.Logger.Prefix(), err)
+		}
+		if !ep.isAllowedSource(netConn.RemoteAddr()) {
+			// A disallowed Caller should not stop the listener
+			ep.ILogf("Dropping connection from %s; source address not in allowFrom", netConn.RemoteAddr())
+			netConn.Close()
+			continue
+		}
+		if !ep.transparent {
+			break
+		}
+		originalDst, err = getOriginalDst(netConn)
+		if err == nil {
+			ep.DLogf("Transparent connection from %s to original destination %s", netConn.RemoteAddr(), originalDst)
+			break
+		}
+		// A single connection that can't be resolved should not stop the listener
+		ep.ILogf("Dropping transparent connection from %s; unable to determine original destination: %s", netConn.RemoteAddr(), err)
+		netConn.Close()
 	}
 
 	conn, err := NewSocketConn(ep.Logger, netConn)
 	if err != nil {
 		return nil, fmt.Errorf("%s: Unable to create SocketConn: %s", ep.Logger.Prefix(), err)
 	}
+	conn.originalDst = originalDst
 	ep.AddShutdownChild(conn)
+	ep.notifyAccept(conn)
 	return conn, nil
 }
 
@@ -127,3 +249,21 @@ func (ep *TCPStubEndpoint) AcceptAndServe(ctx context.Context, calledServiceConn
 	}
 	return BasicBridgeChannels(ctx, ep.Logger, callerConn, calledServiceConn)
 }
+
+// describeTCPListenError returns a short explanation of why listening on a TCP bind address
+// failed, distinguishing a port conflict from a permission problem.
+func describeTCPListenError(path string, err error) string {
+	port := path
+	if _, p, splitErr := net.SplitHostPort(path); splitErr == nil {
+		port = p
+	}
+	switch {
+	case errors.Is(err, syscall.EADDRINUSE):
+		return fmt.Sprintf("port %s is already in use", port)
+	case errors.Is(err, syscall.EACCES) || errors.Is(err, syscall.EPERM):
+		return fmt.Sprintf("permission denied binding port %s (ports below 1024 require privileges)", port)
+	case errors.Is(err, syscall.EADDRNOTAVAIL):
+		return fmt.Sprintf("bind address for port %s is not available on this host", port)
+	}
+	return fmt.Sprintf("unable to bind port %s", port)
+}
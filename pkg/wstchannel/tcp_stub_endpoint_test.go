@@ -0,0 +1,100 @@
+package wstchannel
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/sammck-go/logger"
+)
+
+// stubTestEnv is a LocalChannelEnv for creating stub endpoints; only the methods the stubs use
+// at creation are implemented
+type stubTestEnv struct {
+	LocalChannelEnv
+	server    bool
+	reusePort bool
+}
+
+func (env *stubTestEnv) IsServer() bool {
+	return env.server
+}
+
+func (env *stubTestEnv) IsTCPStubReusePortDefault() bool {
+	return env.reusePort
+}
+
+func TestTCPStubReusePort(t *testing.T) {
+	l, err := logger.New(logger.WithPrefix("TestTCPStubReusePort"))
+	if err != nil {
+		t.Fatalf("logger.New() returned error: %s", err)
+	}
+	tests := []struct {
+		stub      string
+		server    bool
+		reusePort bool
+		want      bool
+		errText   string
+	}{
+		{"tcp://127.0.0.1:3000", false, false, false, ""},
+		{"tcp://127.0.0.1:3000", false, true, true, ""},
+		{`tcp://{"bind":"127.0.0.1:3000","reusePort":true}`, false, false, true, ""},
+		{`tcp://{"bind":"127.0.0.1:3000","reusePort":false}`, false, true, false, ""},
+		{"tcp://127.0.0.1:3000", true, true, false, ""},
+		{`tcp://{"bind":"127.0.0.1:3000"}`, true, true, false, ""},
+		{`tcp://{"bind":"127.0.0.1:3000","reusePort":true}`, true, false, false, "not allowed on the server side"},
+	}
+	for _, tt := range tests {
+		chd, _, err := ParseChannelDescriptorPath(tt.stub + ",tcp://localhost:80")
+		if err != nil {
+			t.Fatalf("ParseChannelDescriptorPath(%q): %s", tt.stub, err)
+		}
+		env := &stubTestEnv{server: tt.server, reusePort: tt.reusePort}
+		ep, err := NewTCPStubEndpoint(l, env, chd.Stub)
+		if tt.errText != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.errText) {
+				t.Errorf("NewTCPStubEndpoint(%q, server=%v) error = %v; want %q", tt.stub, tt.server, err, tt.errText)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("NewTCPStubEndpoint(%q, server=%v) failed: %s", tt.stub, tt.server, err)
+			continue
+		}
+		if ep.reusePort != tt.want {
+			t.Errorf("NewTCPStubEndpoint(%q, server=%v, default=%v) reusePort = %v; want %v",
+				tt.stub, tt.server, tt.reusePort, ep.reusePort, tt.want)
+		}
+	}
+}
+
+func TestTCPStubAllowFrom(t *testing.T) {
+	nets, err := ParseIPNets([]string{"10.0.0.0/8", " 192.168.1.5 ", "", "fd00::/8", "2001:db8::1"})
+	if err != nil {
+		t.Fatalf("ParseIPNets(): unexpected error: %s", err)
+	}
+	ep := &TCPStubEndpoint{allowFrom: nets}
+	tests := []struct {
+		ip      string
+		allowed bool
+	}{
+		{"10.1.2.3", true},
+		{"192.168.1.5", true},
+		{"192.168.1.6", false},
+		{"fd12::1", true},
+		{"2001:db8::1", true},
+		{"2001:db8::2", false},
+		{"::ffff:10.1.2.3", true},
+	}
+	for _, tt := range tests {
+		addr := &net.TCPAddr{IP: net.ParseIP(tt.ip), Port: 1234}
+		if got := ep.isAllowedSource(addr); got != tt.allowed {
+			t.Errorf("isAllowedSource(%s) = %v, want %v", tt.ip, got, tt.allowed)
+		}
+	}
+
+	open := &TCPStubEndpoint{}
+	if !open.isAllowedSource(&net.TCPAddr{IP: net.ParseIP("203.0.113.1")}) {
+		t.Errorf("isAllowedSource() with an empty allowFrom rejected a connection")
+	}
+}
@@ -2,27 +2,114 @@ package wstchannel
 
 import (
 	"context"
-	"fmt"
+	"errors"
 	"net"
+	"syscall"
+	"time"
 )
 
-// UnixSkeletonEndpoint implements a local Unix skeleton
+// DefaultUnixDialTimeout is the time allowed for each attempt to connect to a unix socket skeleton,
+// unless overridden with the "dialTimeout" param
+const DefaultUnixDialTimeout = 10 * time.Second
+
+// unixDialRetryInterval is the delay between attempts to connect to a unix socket that does not
+// exist yet or is not yet accepting connections
+const unixDialRetryInterval = 100 * time.Millisecond
+
+// UnixSkeletonEndpoint implements a local Unix skeleton.
+//
+// The path may be given directly, or with JSON params, e.g.,
+// unix://{"path":"/run/app.sock","dialTimeout":"5s","retryFor":"3s"}. "dialTimeout" limits each
+// connection attempt (default DefaultUnixDialTimeout). "retryFor", if given, keeps retrying for up
+// to that long while the socket does not exist or refuses connections, for backends that are slow
+// to create their socket; by default a missing socket fails immediately. "prelude", if given, is
+// written to each connection before bridging (see skeletonPrelude).
 type UnixSkeletonEndpoint struct {
 	// Implements LocalSkeletonChannelEndpoint
 	BasicEndpoint
+	path        string
+	dialTimeout time.Duration
+	retryFor    time.Duration
+	prelude     []byte
 }
 
 // NewUnixSkeletonEndpoint creates a new UnixSkeletonEndpoint
-func NewUnixSkeletonEndpoint(logger Logger, ced *ChannelEndpointDescriptor) (*UnixSkeletonEndpoint, error) {
+func NewUnixSkeletonEndpoint(logger Logger, env LocalChannelEnv, ced *ChannelEndpointDescriptor) (*UnixSkeletonEndpoint, error) {
 	ep := &UnixSkeletonEndpoint{
 		BasicEndpoint: BasicEndpoint{
 			ced: ced,
 		},
+		path:        ced.Path,
+		dialTimeout: DefaultUnixDialTimeout,
 	}
 	ep.InitBasicEndpoint(logger, ep, "UnixSkeletonEndpoint: %s", ced)
+	if params := ced.GetParamsMap(); params != nil {
+		var err error
+		ep.path, err = ParamString(params, "path", "")
+		if err != nil {
+			return nil, ep.Errorf("%s", err)
+		}
+		if ep.path == "" {
+			return nil, ep.Errorf("Unix skeleton params require a \"path\"")
+		}
+		ep.dialTimeout, err = ParamDuration(params, "dialTimeout", DefaultUnixDialTimeout)
+		if err != nil {
+			return nil, ep.Errorf("%s", err)
+		}
+		if ep.dialTimeout <= 0 {
+			return nil, ep.Errorf("Parameter \"dialTimeout\" must be positive")
+		}
+		ep.retryFor, err = ParamDuration(params, "retryFor", 0)
+		if err != nil {
+			return nil, ep.Errorf("%s", err)
+		}
+		ep.prelude, err = skeletonPrelude(params)
+		if err != nil {
+			return nil, ep.Errorf("%s", err)
+		}
+	}
+	ep.BeforeBridge = getEnvBeforeBridge(env)
 	return ep, nil
 }
 
+// isUnixSocketNotReady returns true if a dial error means the socket does not exist yet, or
+// exists but nothing is accepting connections on it yet
+func isUnixSocketNotReady(err error) bool {
+	return errors.Is(err, syscall.ENOENT) || errors.Is(err, syscall.ECONNREFUSED)
+}
+
+// dial connects to the unix socket, retrying while it is not ready if "retryFor" was given. Each
+// attempt is limited to dialTimeout, and the whole dial is aborted as soon as ctx is done.
+func (ep *UnixSkeletonEndpoint) dial(ctx context.Context) (net.Conn, error) {
+	var d net.Dialer
+	deadline := time.Now().Add(ep.retryFor)
+	for {
+		attemptCtx, cancel := context.WithTimeout(ctx, ep.dialTimeout)
+		netConn, err := d.DialContext(attemptCtx, "unix", ep.path)
+		cancel()
+		if err == nil {
+			return netConn, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ep.Errorf("Dial of unix socket %s cancelled: %s", ep.path, ctx.Err())
+		}
+		if !isUnixSocketNotReady(err) || !time.Now().Before(deadline) {
+			if ep.retryFor > 0 && isUnixSocketNotReady(err) {
+				return nil, ep.Errorf("Unix socket %s not ready after retrying for %s: %s", ep.path, ep.retryFor, err)
+			}
+			return nil, ep.Errorf("Unable to connect to unix socket %s: %s", ep.path, err)
+		}
+		ep.DLogf("Unix socket %s not ready, retrying: %s", ep.path, err)
+		timer := time.NewTimer(unixDialRetryInterval)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ep.Errorf("Dial of unix socket %s cancelled: %s", ep.path, ctx.Err())
+		}
+	}
+}
+
 // HandleOnceShutdown will be called exactly once, in its own goroutine. It should take completionError
 // as an advisory completion value, actually shut down, then return the real completion value.
 func (ep *UnixSkeletonEndpoint) HandleOnceShutdown(completionErr error) error {
@@ -38,11 +125,9 @@ func (ep *UnixSkeletonEndpoint) Dial(ctx context.Context, extraData []byte) (Cha
 		return nil, err
 	}
 
-	// TODO: make sure IPV6 works
-	var d net.Dialer
-	netConn, err := d.DialContext(ctx, "unix", ep.ced.Path)
+	netConn, err := ep.dial(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("%s: DialContext failed: %s", ep.Logger.Prefix(), err)
+		return nil, err
 	}
 
 	conn, err := NewSocketConn(ep.Logger, netConn)
@@ -50,6 +135,7 @@ func (ep *UnixSkeletonEndpoint) Dial(ctx context.Context, extraData []byte) (Cha
 		return nil, ep.Errorf("Unable to create SocketConn: %s", err)
 	}
 	ep.AddShutdownChild(conn)
+	ep.notifyDial(conn)
 	return conn, nil
 }
 
@@ -78,5 +164,11 @@ func (ep *UnixSkeletonEndpoint) DialAndServe(
 		callerConn.Close()
 		return 0, 0, err
 	}
+	err = ep.beforeBridge(ctx, calledServiceConn, ep.prelude, extraData)
+	if err != nil {
+		callerConn.Close()
+		calledServiceConn.Close()
+		return 0, 0, ep.Errorf("%s", err)
+	}
 	return BasicBridgeChannels(ctx, ep.Logger, callerConn, calledServiceConn)
 }
@@ -0,0 +1,150 @@
+package wstchannel
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sammck-go/logger"
+)
+
+func TestIsUnixSocketNotReady(t *testing.T) {
+	dir, err := ioutil.TempDir("", "unix-skeleton")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "s.sock")
+
+	// the socket does not exist yet
+	_, err = net.Dial("unix", path)
+	if err == nil || !isUnixSocketNotReady(err) {
+		t.Errorf("isUnixSocketNotReady(%v) = false for a missing socket", err)
+	}
+
+	// the socket exists, but nothing is accepting on it
+	l, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.SetUnlinkOnClose(false)
+	l.Close()
+	_, err = net.Dial("unix", path)
+	if err == nil || !isUnixSocketNotReady(err) {
+		t.Errorf("isUnixSocketNotReady(%v) = false for a socket with no listener", err)
+	}
+}
+
+// newTestUnixSkeleton creates a unix skeleton endpoint for path, with retryFor as its "retryFor"
+// param if it is not ""
+func newTestUnixSkeleton(t *testing.T, path string, retryFor string) *UnixSkeletonEndpoint {
+	l, err := logger.New(logger.WithPrefix(t.Name()))
+	if err != nil {
+		t.Fatalf("logger.New() returned error: %s", err)
+	}
+	params := fmt.Sprintf("{\"path\":%q}", path)
+	if retryFor != "" {
+		params = fmt.Sprintf("{\"path\":%q,\"retryFor\":%q}", path, retryFor)
+	}
+	chd, _, err := ParseChannelDescriptorPath("tcp://127.0.0.1:3000,unix://" + params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ep, err := NewUnixSkeletonEndpoint(l, nil, chd.Skeleton)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ep
+}
+
+func TestUnixSkeletonRetryFor(t *testing.T) {
+	dir, err := ioutil.TempDir("", "unix-skeleton")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "s.sock")
+
+	// without "retryFor", a missing socket fails right away
+	ep := newTestUnixSkeleton(t, path, "")
+	defer ep.Close()
+	if conn, err := ep.Dial(context.Background(), nil); err == nil {
+		conn.Close()
+		t.Fatalf("Dial() of a missing socket succeeded")
+	}
+
+	// with "retryFor", the dial waits for the socket to be created
+	ep = newTestUnixSkeleton(t, path, "10s")
+	defer ep.Close()
+	accepted := make(chan error, 1)
+	go func() {
+		time.Sleep(3 * unixDialRetryInterval)
+		l, err := net.Listen("unix", path)
+		if err != nil {
+			accepted <- err
+			return
+		}
+		defer l.Close()
+		conn, err := l.Accept()
+		if err == nil {
+			conn.Close()
+		}
+		accepted <- err
+	}()
+	start := time.Now()
+	conn, err := ep.Dial(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("Dial() did not retry until the socket was created: %s", err)
+	}
+	conn.Close()
+	if elapsed := time.Since(start); elapsed < 3*unixDialRetryInterval {
+		t.Errorf("Dial() returned after %s, before the socket was created", elapsed)
+	}
+	if err := <-accepted; err != nil {
+		t.Fatal(err)
+	}
+
+	// the retrying gives up once "retryFor" has elapsed
+	os.Remove(path)
+	ep = newTestUnixSkeleton(t, path, "300ms")
+	defer ep.Close()
+	_, err = ep.Dial(context.Background(), nil)
+	if err == nil || !strings.Contains(err.Error(), "not ready after retrying") {
+		t.Errorf("Dial() = %v, want a not ready error", err)
+	}
+}
+
+func TestUnixSkeletonRetryCancelled(t *testing.T) {
+	dir, err := ioutil.TempDir("", "unix-skeleton")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ep := newTestUnixSkeleton(t, filepath.Join(dir, "s.sock"), "1m")
+	defer ep.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(3*unixDialRetryInterval, cancel)
+	result := make(chan error, 1)
+	go func() {
+		conn, err := ep.Dial(ctx, nil)
+		if err == nil {
+			conn.Close()
+		}
+		result <- err
+	}()
+	select {
+	case err := <-result:
+		if err == nil || !strings.Contains(err.Error(), "cancelled") {
+			t.Errorf("Dial() = %v, want a cancelled error", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Dial() kept retrying after its context was cancelled")
+	}
+}
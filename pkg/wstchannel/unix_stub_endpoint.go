@@ -3,6 +3,7 @@ package wstchannel
 import (
 	"context"
 	"fmt"
+	"net"
 )
 
 // UnixStubEndpoint implements a local Unix domain socket stub
@@ -10,7 +11,7 @@ type UnixStubEndpoint struct {
 	// Implements LocalStubChannelEndpoint
 	BasicEndpoint
 	listenErr error
-	listener  *LockedUnixSocketListener
+	listener  net.Listener
 }
 
 // NewUnixStubEndpoint creates a new UnixStubEndpoint
@@ -27,7 +28,7 @@ func NewUnixStubEndpoint(logger Logger, ced *ChannelEndpointDescriptor) (*UnixSt
 // HandleOnceShutdown will be called exactly once, in its own goroutine. It should take completionError
 // as an advisory completion value, actually shut down, then return the real completion value.
 func (ep *UnixStubEndpoint) HandleOnceShutdown(completionErr error) error {
-	var listener *LockedUnixSocketListener
+	var listener net.Listener
 	ep.Lock.Lock()
 	listener = ep.listener
 	ep.listener = nil
@@ -44,8 +45,8 @@ func (ep *UnixStubEndpoint) HandleOnceShutdown(completionErr error) error {
 	return completionErr
 }
 
-func (ep *UnixStubEndpoint) getListener() (*LockedUnixSocketListener, error) {
-	var listener *LockedUnixSocketListener
+func (ep *UnixStubEndpoint) getListener() (net.Listener, error) {
+	var listener net.Listener
 	var err error
 
 	ep.Lock.Lock()
@@ -53,7 +54,7 @@ func (ep *UnixStubEndpoint) getListener() (*LockedUnixSocketListener, error) {
 		if ep.IsStartedShutdown() {
 			err = fmt.Errorf("%s: Endpoint is closed", ep.Logger.Prefix())
 		} else if ep.listener == nil && ep.listenErr == nil {
-			listener, err := NewLockedUnixSocketListener(ep.Logger, ep.ced.Path)
+			listener, err = NewLockedUnixSocketListener(ep.Logger, ep.ced.Path)
 			if err != nil {
 				err = ep.Errorf("Listen failed for path '%s': %s", ep.ced.Path, err)
 			} else {
@@ -79,6 +80,23 @@ func (ep *UnixStubEndpoint) StartListening() error {
 	return err
 }
 
+// StopListening closes the listener, so that no further Callers are accepted, without closing the
+// connections already accepted. Subsequent Accept calls fail. Part of the ListenStopper interface.
+func (ep *UnixStubEndpoint) StopListening() error {
+	ep.Lock.Lock()
+	listener := ep.listener
+	ep.listener = nil
+	if ep.listenErr == nil {
+		ep.listenErr = fmt.Errorf("%s: Endpoint has stopped listening", ep.Logger.Prefix())
+	}
+	ep.Lock.Unlock()
+
+	if listener == nil {
+		return nil
+	}
+	return listener.Close()
+}
+
 // Accept listens for and accepts a single connection from a Caller network client as specified in the
 // endpoint configuration. This call does not return until a new connection is available or a
 // error occurs. There is no way to cancel an Accept() request other than closing the endpoint. Part of
@@ -100,6 +118,7 @@ func (ep *UnixStubEndpoint) Accept(ctx context.Context) (ChannelConn, error) {
 	}
 
 	ep.AddShutdownChild(conn)
+	ep.notifyAccept(conn)
 	return conn, nil
 }
 
@@ -66,6 +66,8 @@
 // used by the Wstunnel Proxy Session; flow control is employed to ensure that a delay
 // in reading from one Channel has no effect on the availability of other channels or on
 // traffic in the opposite direction on the same channel.
+// The memory held for a stalled Channel is bounded by the SSH channel window plus the
+// bridge buffer in each direction; the latter can be set with WithChannelBufferSize.
 //
 // A ChannelEndpoint is described in a serializable form by a ChannelEndpointDescriptor.
 //
@@ -0,0 +1,82 @@
+package chshare
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+)
+
+// AllowedRemotesRequest is the SSH global request with which a client asks the server which
+// remotes its authenticated user may configure, so that it can check its channel descriptors
+// before sending the session config. It may only be sent after the SSH handshake (and therefore
+// user authentication) has completed, and before the "config" request; it may be sent more than
+// once. The request has no payload; the reply payload is a JSON-encoded AllowedRemotes describing
+// only the session's own user.
+const AllowedRemotesRequest = "allowed-remotes"
+
+// AllowedRemotes describes the remotes a server will accept from the authenticated user
+type AllowedRemotes struct {
+	// AllowAll is true if any remote is allowed, because user authentication is disabled or the
+	// user has an unrestricted address list
+	AllowAll bool `json:"allowAll"`
+
+	// Patterns are the regular expressions, matched against ChannelDescriptor.AccessStrings(), of
+	// which each access string of a remote must match at least one. Ignored if AllowAll is true.
+	Patterns []string `json:"patterns,omitempty"`
+}
+
+// NewAllowedRemotes creates the AllowedRemotes for user, which is nil if user authentication is
+// disabled. Only the user's address patterns are included; never the name or password.
+func NewAllowedRemotes(user *User) *AllowedRemotes {
+	a := &AllowedRemotes{}
+	if user == nil {
+		a.AllowAll = true
+		return a
+	}
+	for _, r := range user.Addrs {
+		if r.String() == UserAllowAll.String() {
+			a.AllowAll = true
+			a.Patterns = nil
+			return a
+		}
+		a.Patterns = append(a.Patterns, r.String())
+	}
+	return a
+}
+
+// Marshal serializes an AllowedRemotes for the reply to an AllowedRemotesRequest
+func (a *AllowedRemotes) Marshal() ([]byte, error) {
+	return json.Marshal(a)
+}
+
+// Unmarshal unserializes an AllowedRemotes from the reply to an AllowedRemotesRequest
+func (a *AllowedRemotes) Unmarshal(b []byte) error {
+	err := json.Unmarshal(b, a)
+	if err != nil {
+		return fmt.Errorf("Invalid allowed remotes reply: %s", err)
+	}
+	return nil
+}
+
+// Check returns an error naming the first of chds that the server would deny, using the same
+// matching rules as User.HasChannelAccess
+func (a *AllowedRemotes) Check(chds []*ChannelDescriptor) error {
+	if a.AllowAll {
+		return nil
+	}
+	user := &User{}
+	for _, p := range a.Patterns {
+		r, err := regexp.Compile(p)
+		if err != nil {
+			return fmt.Errorf("Invalid allowed remote pattern \"%s\": %s", p, err)
+		}
+		user.Addrs = append(user.Addrs, r)
+	}
+	for _, chd := range chds {
+		chdString := chd.String()
+		if !user.HasChannelAccess(chd) {
+			return fmt.Errorf("Access to \"%s\" would be denied by the server (allowed: %q)", chdString, a.Patterns)
+		}
+	}
+	return nil
+}
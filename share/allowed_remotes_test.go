@@ -0,0 +1,48 @@
+package chshare
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestAllowedRemotes(t *testing.T) {
+	if a := NewAllowedRemotes(nil); !a.AllowAll || a.Check(nil) != nil {
+		t.Errorf("NewAllowedRemotes(nil) = %+v, want AllowAll", a)
+	}
+	if a := NewAllowedRemotes(&User{Addrs: []*regexp.Regexp{UserAllowAll}}); !a.AllowAll || len(a.Patterns) != 0 {
+		t.Errorf("NewAllowedRemotes(allow all) = %+v, want AllowAll", a)
+	}
+
+	user := &User{Name: "alice", Pass: "secret", Addrs: []*regexp.Regexp{regexp.MustCompile(":80$")}}
+	b, err := NewAllowedRemotes(user).Marshal()
+	if err != nil {
+		t.Fatalf("Marshal() returned error: %s", err)
+	}
+	if regexp.MustCompile("alice|secret").Match(b) {
+		t.Errorf("Marshal() = %s, leaks user name or password", b)
+	}
+	a := &AllowedRemotes{}
+	if err := a.Unmarshal(b); err != nil {
+		t.Fatalf("Unmarshal(%s) returned error: %s", b, err)
+	}
+	if a.AllowAll || len(a.Patterns) != 1 || a.Patterns[0] != ":80$" {
+		t.Errorf("Unmarshal(%s) = %+v", b, a)
+	}
+
+	for _, tc := range []struct {
+		chd  string
+		want bool
+	}{
+		{"3000:localhost:80", true},
+		{"3000:localhost:22", false},
+	} {
+		chd, _, err := ParseChannelDescriptorPath(tc.chd)
+		if err != nil {
+			t.Fatalf("ParseChannelDescriptorPath(%s) returned error: %s", tc.chd, err)
+		}
+		err = a.Check([]*ChannelDescriptor{&chd})
+		if (err == nil) != tc.want || (err == nil) != user.HasAccess(chd.String()) {
+			t.Errorf("Check(%s) = %v, want allowed=%t", tc.chd, err, tc.want)
+		}
+	}
+}
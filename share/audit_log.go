@@ -0,0 +1,201 @@
+package chshare
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+)
+
+// auditLogFlushInterval is how often buffered audit records are flushed to the file
+const auditLogFlushInterval = time.Second
+
+// AuditRecord is a single line in an audit log file
+type AuditRecord struct {
+	Time          time.Time `json:"time"`
+	Event         string    `json:"event"`
+	SessionID     int32     `json:"session_id"`
+	ChannelID     int64     `json:"channel_id"`
+	User          string    `json:"user,omitempty"`
+	RemoteAddr    string    `json:"remote_addr,omitempty"`
+	Descriptor    string    `json:"descriptor"`
+	BytesSent     int64     `json:"bytes_sent"`
+	BytesReceived int64     `json:"bytes_received"`
+	DurationMs    int64     `json:"duration_ms"`
+	Error         string    `json:"error,omitempty"`
+}
+
+// AuditLog is an EventHandler that appends one JSON record per line to a file for each channel
+// open and close. Records are buffered and flushed periodically. Write failures are logged and
+// the affected records are dropped; they never interrupt the server. Reopen may be called (e.g.,
+// on SIGHUP) after the file has been moved aside by a log rotation tool.
+type AuditLog struct {
+	logger Logger
+	path   string
+	lock   sync.Mutex
+	file   *os.File
+	writer *bufio.Writer
+	// failing is true after a write failure has been logged, to avoid flooding the log
+	failing bool
+	done    chan struct{}
+}
+
+// NewAuditLog opens (or creates) an audit log file for appending
+func NewAuditLog(logger Logger, path string) (*AuditLog, error) {
+	a := &AuditLog{
+		logger: logger,
+		path:   path,
+		done:   make(chan struct{}),
+	}
+	err := a.open()
+	if err != nil {
+		return nil, err
+	}
+	go a.flushLoop()
+	return a, nil
+}
+
+// open opens the file. Must be called with the lock held, or before the AuditLog is shared.
+func (a *AuditLog) open() error {
+	f, err := os.OpenFile(a.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return a.logger.Errorf("Unable to open audit log %s: %s", a.path, err)
+	}
+	a.file = f
+	a.writer = bufio.NewWriter(f)
+	return nil
+}
+
+func (a *AuditLog) flushLoop() {
+	ticker := time.NewTicker(auditLogFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			a.lock.Lock()
+			a.flush()
+			a.lock.Unlock()
+		case <-a.done:
+			return
+		}
+	}
+}
+
+// flush writes buffered records to the file. Must be called with the lock held.
+func (a *AuditLog) flush() {
+	if a.writer == nil {
+		return
+	}
+	a.checkError(a.writer.Flush())
+}
+
+// checkError logs the first of a run of write failures, and drops whatever is buffered so a
+// persistent failure does not accumulate memory. Must be called with the lock held.
+func (a *AuditLog) checkError(err error) {
+	if err == nil {
+		if a.failing {
+			a.logger.ILogf("Audit log %s is writable again", a.path)
+			a.failing = false
+		}
+		return
+	}
+	if !a.failing {
+		a.logger.ILogf("WARNING: Audit log %s write failed; dropping records until it recovers: %s", a.path, err)
+		a.failing = true
+	}
+	a.writer.Reset(a.file)
+}
+
+// Reopen flushes and closes the audit log file, then opens it again at the same path
+func (a *AuditLog) Reopen() error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if a.file != nil {
+		a.flush()
+		a.file.Close()
+		a.file = nil
+		a.writer = nil
+	}
+	err := a.open()
+	if err != nil {
+		a.logger.ILogf("WARNING: %s", err)
+		return err
+	}
+	a.logger.ILogf("Reopened audit log %s", a.path)
+	return nil
+}
+
+// Close flushes and closes the audit log file
+func (a *AuditLog) Close() error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	select {
+	case <-a.done:
+		return nil
+	default:
+		close(a.done)
+	}
+	if a.file == nil {
+		return nil
+	}
+	a.flush()
+	err := a.file.Close()
+	a.file = nil
+	a.writer = nil
+	return err
+}
+
+func (a *AuditLog) write(r *AuditRecord) {
+	b, err := json.Marshal(r)
+	if err != nil {
+		a.logger.DLogf("Unable to encode audit record, dropping: %s", err)
+		return
+	}
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if a.writer == nil {
+		return
+	}
+	b = append(b, '\n')
+	_, err = a.writer.Write(b)
+	a.checkError(err)
+}
+
+func newChannelAuditRecord(event string, channel *ChannelInfo) *AuditRecord {
+	r := &AuditRecord{
+		Time:       time.Now(),
+		Event:      event,
+		ChannelID:  channel.ChannelID,
+		Descriptor: channel.Descriptor,
+	}
+	if channel.Session != nil {
+		r.SessionID = channel.Session.SessionID
+		r.User = channel.Session.User
+		r.RemoteAddr = channel.Session.RemoteAddr
+	}
+	return r
+}
+
+// OnSessionStart implements EventHandler; sessions are not audited
+func (a *AuditLog) OnSessionStart(session *SessionInfo) {}
+
+// OnSessionEnd implements EventHandler; sessions are not audited
+func (a *AuditLog) OnSessionEnd(session *SessionInfo, err error) {}
+
+// OnChannelOpen implements EventHandler
+func (a *AuditLog) OnChannelOpen(channel *ChannelInfo) {
+	a.write(newChannelAuditRecord("channel_open", channel))
+}
+
+// OnChannelClose implements EventHandler
+func (a *AuditLog) OnChannelClose(channel *ChannelInfo, err error) {
+	r := newChannelAuditRecord("channel_close", channel)
+	r.BytesSent = channel.BytesSent
+	r.BytesReceived = channel.BytesReceived
+	r.DurationMs = r.Time.Sub(channel.OpenTime).Milliseconds()
+	if err != nil {
+		r.Error = err.Error()
+	}
+	a.write(r)
+}
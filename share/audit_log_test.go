@@ -0,0 +1,102 @@
+package chshare
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func readAuditRecords(t *testing.T, path string) []AuditRecord {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Unable to open %s: %s", path, err)
+	}
+	defer f.Close()
+	var records []AuditRecord
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var r AuditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			t.Fatalf("Invalid audit record %q: %s", scanner.Text(), err)
+		}
+		records = append(records, r)
+	}
+	return records
+}
+
+func TestAuditLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wstunnel-audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.log")
+
+	a, err := NewAuditLog(NewLogger("test", LogLevelInfo), path)
+	if err != nil {
+		t.Fatalf("NewAuditLog() returned error: %s", err)
+	}
+	session := &SessionInfo{SessionID: 3, User: "alice", RemoteAddr: "10.0.0.1:5555"}
+	ch := &ChannelInfo{Session: session, ChannelID: 7, Descriptor: "tcp:localhost:22", OpenTime: time.Now()}
+	a.OnChannelOpen(ch)
+
+	// simulate log rotation
+	rotated := path + ".1"
+	if err := os.Rename(path, rotated); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Reopen(); err != nil {
+		t.Fatalf("Reopen() returned error: %s", err)
+	}
+
+	closed := *ch
+	closed.BytesSent = 100
+	closed.BytesReceived = 200
+	a.OnChannelClose(&closed, errors.New("reset"))
+	if err := a.Close(); err != nil {
+		t.Fatalf("Close() returned error: %s", err)
+	}
+
+	before := readAuditRecords(t, rotated)
+	if len(before) != 1 || before[0].Event != "channel_open" || before[0].User != "alice" || before[0].ChannelID != 7 {
+		t.Errorf("Unexpected records before rotation: %+v", before)
+	}
+	after := readAuditRecords(t, path)
+	if len(after) != 1 || after[0].Event != "channel_close" || after[0].BytesSent != 100 ||
+		after[0].BytesReceived != 200 || after[0].Error != "reset" || after[0].RemoteAddr != "10.0.0.1:5555" {
+		t.Errorf("Unexpected records after rotation: %+v", after)
+	}
+}
+
+func TestAuditLogIsWrittenSynchronously(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wstunnel-audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.log")
+
+	a, err := NewAuditLog(NewLogger("test", LogLevelInfo), path)
+	if err != nil {
+		t.Fatalf("NewAuditLog() returned error: %s", err)
+	}
+	// the handler is stuck, so nothing queued for it is delivered
+	h := &recordingEventHandler{release: make(chan struct{})}
+	defer close(h.release)
+	d := newEventDispatcher(NewLogger("test", LogLevelInfo), h, a)
+	d.channelOpen(&ChannelInfo{ChannelID: 1, OpenTime: time.Now()})
+	d.channelClose(&ChannelInfo{ChannelID: 1, OpenTime: time.Now()}, nil)
+	if err := a.Close(); err != nil {
+		t.Fatalf("Close() returned error: %s", err)
+	}
+
+	records := readAuditRecords(t, path)
+	if len(records) != 2 || records[0].Event != "channel_open" || records[1].Event != "channel_close" {
+		t.Errorf("Audit records behind a stuck event handler = %+v, want channel_open and channel_close", records)
+	}
+}
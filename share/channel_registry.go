@@ -0,0 +1,200 @@
+package chshare
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// ChannelStatus describes an active channel in a session, for operational introspection
+type ChannelStatus struct {
+	// SessionID is the server-unique ID of the session that carries the channel
+	SessionID int32 `json:"session_id"`
+
+	// ChannelID is a session-unique ID for the channel, stable for the channel's lifetime
+	ChannelID int64 `json:"channel_id"`
+
+	// Descriptor is the string form of the local endpoint descriptor requested by the remote proxy,
+	// or, for a reverse channel, of the reverse remote's channel descriptor
+	Descriptor string `json:"descriptor"`
+
+	// Reverse is true for a channel accepted by one of the session's reverse remote listeners, and
+	// false for a channel opened by the remote proxy
+	Reverse bool `json:"reverse"`
+
+	// OpenTime is the time at which the channel was accepted
+	OpenTime time.Time `json:"open_time"`
+}
+
+// activeChannel tracks a channel that is currently being bridged, so it can be listed and
+// individually closed
+type activeChannel struct {
+	status ChannelStatus
+
+	// closers are closed, in order, to tear down the channel's bridge
+	closers []io.Closer
+
+	// done is closed when the channel's bridge has ended
+	done chan struct{}
+}
+
+// close closes the channel's closers, returning the first error
+func (ac *activeChannel) close() error {
+	var err error
+	for _, c := range ac.closers {
+		if cerr := c.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// StartShutdown tears down the channel's bridge, so an activeChannel can be shut down in the
+// channels phase of a session's PhasedShutdown
+func (ac *activeChannel) StartShutdown(completionErr error) {
+	ac.close()
+}
+
+// WaitShutdown waits for the channel's bridge to end
+func (ac *activeChannel) WaitShutdown() error {
+	<-ac.done
+	return nil
+}
+
+// addActiveChannel registers a channel that is about to be bridged. Closing the closers must
+// cause the bridge to end.
+func (s *SSHSession) addActiveChannel(status ChannelStatus, closers ...io.Closer) {
+	ac := &activeChannel{status: status, closers: closers, done: make(chan struct{})}
+	s.channelsLock.Lock()
+	if s.channels == nil {
+		s.channels = make(map[int64]*activeChannel)
+	}
+	s.channels[status.ChannelID] = ac
+	s.channelsLock.Unlock()
+	if s.phases != nil {
+		s.phases.AddChild(ShutdownPhaseChannels, ac)
+	}
+}
+
+// proxyChannelRegistry is implemented by a LocalChannelEnv that keeps a registry of its active
+// channels, so that the channels bridged by its stub-side proxies are listed, can be closed
+// individually, and drain with the rest, like those it accepts
+type proxyChannelRegistry interface {
+	// registerProxyChannel registers a channel that a proxy is about to bridge, returning a
+	// function to be called when the bridge has ended. Closing the closers must cause the bridge
+	// to end.
+	registerProxyChannel(descriptor string, closers ...io.Closer) func()
+}
+
+// registerProxyChannel registers a channel that a reverse proxy of the session is about to
+// bridge. Implements proxyChannelRegistry.
+func (s *SSHSession) registerProxyChannel(descriptor string, closers ...io.Closer) func() {
+	channelID := atomic.AddInt64(&s.lastChannelID, 1)
+	s.addActiveChannel(ChannelStatus{
+		SessionID:  s.id,
+		ChannelID:  channelID,
+		Descriptor: descriptor,
+		Reverse:    true,
+		OpenTime:   time.Now(),
+	}, closers...)
+	return func() {
+		s.removeActiveChannel(channelID)
+	}
+}
+
+func (s *SSHSession) removeActiveChannel(channelID int64) {
+	s.channelsLock.Lock()
+	ac, ok := s.channels[channelID]
+	delete(s.channels, channelID)
+	s.channelsLock.Unlock()
+	if ok {
+		close(ac.done)
+		if s.phases != nil {
+			s.phases.RemoveChild(ShutdownPhaseChannels, ac)
+		}
+	}
+}
+
+// ListChannels returns the channels currently active in the session, ordered by ID
+func (s *SSHSession) ListChannels() []ChannelStatus {
+	s.channelsLock.Lock()
+	result := make([]ChannelStatus, 0, len(s.channels))
+	for _, ac := range s.channels {
+		result = append(result, ac.status)
+	}
+	s.channelsLock.Unlock()
+	sort.Slice(result, func(i, j int) bool { return result[i].ChannelID < result[j].ChannelID })
+	return result
+}
+
+// CloseChannel tears down a single active channel without affecting the rest of the session.
+// The channel's bridge ends as if either side had disconnected, so counters and events are
+// updated as usual. It does not wait for the bridge to finish.
+func (s *SSHSession) CloseChannel(channelID int64) error {
+	s.channelsLock.Lock()
+	ac, ok := s.channels[channelID]
+	s.channelsLock.Unlock()
+	if !ok {
+		return s.Errorf("No active channel with ID %d", channelID)
+	}
+	s.ILogf("Closing channel #%d (%s) on request", channelID, ac.status.Descriptor)
+	return ac.close()
+}
+
+// ListChannels returns the channels currently active in all sessions, ordered by session and channel ID
+func (s *Server) ListChannels() []ChannelStatus {
+	sessions := s.getActiveSessions()
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].id < sessions[j].id })
+	var result []ChannelStatus
+	for _, session := range sessions {
+		result = append(result, session.ListChannels()...)
+	}
+	return result
+}
+
+// CloseChannel tears down a single active channel in the given session; see SSHSession.CloseChannel
+func (s *Server) CloseChannel(sessionID int32, channelID int64) error {
+	for _, session := range s.getActiveSessions() {
+		if session.id == sessionID {
+			return session.CloseChannel(channelID)
+		}
+	}
+	return s.Errorf("No active session with ID %d", sessionID)
+}
+
+// GetProxyByName returns the reverse proxy for the named tunnel in the given session; see
+// ServerSSHSession.GetProxyByName. Tunnel names are only unique within a session.
+func (s *Server) GetProxyByName(sessionID int32, name string) *TCPProxy {
+	for _, session := range s.getActiveSessions() {
+		if session.id == sessionID {
+			return session.GetProxyByName(name)
+		}
+	}
+	return nil
+}
+
+// logChannels logs the named reverse tunnels and the channels currently active in all sessions
+func (s *Server) logChannels() {
+	sessions := s.getActiveSessions()
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].id < sessions[j].id })
+	for _, session := range sessions {
+		proxies := session.proxyNames.all()
+		sort.Slice(proxies, func(i, j int) bool { return proxies[i].Name() < proxies[j].Name() })
+		for _, proxy := range proxies {
+			s.ILogf("  session %d tunnel \"%s\": %s", session.id, proxy.Name(), proxy.Descriptor())
+		}
+	}
+	channels := s.ListChannels()
+	s.ILogf("%d active channel(s)", len(channels))
+	now := time.Now()
+	for _, ch := range channels {
+		s.ILogf("  session %d channel %d: %s (open %s)", ch.SessionID, ch.ChannelID, ch.Descriptor,
+			now.Sub(ch.OpenTime).Round(time.Second))
+	}
+}
+
+func (ch ChannelStatus) String() string {
+	return fmt.Sprintf("session %d channel %d: %s", ch.SessionID, ch.ChannelID, ch.Descriptor)
+}
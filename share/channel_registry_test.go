@@ -0,0 +1,101 @@
+package chshare
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestServerChannelsEndpointRequiresAuth(t *testing.T) {
+	s, err := NewServer(&ProxyServerConfig{KeySeed: "channels-test", ExpvarPath: "/debug/vars", Auth: "admin:secret", NoLoop: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	noUsers, err := NewServer(&ProxyServerConfig{KeySeed: "channels-test", ExpvarPath: "/debug/vars", NoLoop: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer noUsers.Close()
+
+	tests := []struct {
+		server     *Server
+		user, pass string
+		status     int
+	}{
+		{s, "", "", 401},
+		{s, "admin", "wrong", 401},
+		{s, "nobody", "secret", 401},
+		{s, "admin", "secret", 200},
+		{noUsers, "", "", 403},
+		{noUsers, "admin", "secret", 403},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/debug/vars/channels", nil)
+		if tt.user != "" {
+			r.SetBasicAuth(tt.user, tt.pass)
+		}
+		w := httptest.NewRecorder()
+		tt.server.handleClientHandler(context.Background(), w, r)
+		if w.Code != tt.status {
+			t.Errorf("GET /debug/vars/channels as %q:%q returned %d; expected %d", tt.user, tt.pass, w.Code, tt.status)
+		}
+	}
+}
+
+func TestListChannelsIncludesReverseChannels(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	echoPort, stopEcho := testEchoService(t)
+	defer stopEcho()
+
+	s, err := NewServer(&ProxyServerConfig{KeySeed: "channels-test", Reverse: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	serverPort := testLocalPort(t)
+	go s.Run(ctx, "127.0.0.1", strconv.Itoa(serverPort))
+
+	stubAddr := "127.0.0.1:" + strconv.Itoa(testLocalPort(t))
+	c, err := NewClient(&Config{
+		Server:        fmt.Sprintf("http://127.0.0.1:%d", serverPort),
+		Fingerprint:   s.GetFingerprint(),
+		ChdStrings:    []string{fmt.Sprintf("R:%s:127.0.0.1:%d", stubAddr, echoPort)},
+		MaxRetryCount: -1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if err := c.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	conn := testDialUntil(t, stubAddr, true)
+	defer conn.Close()
+	testEchoConn(t, conn, "listed")
+
+	channels := s.ListChannels()
+	if len(channels) != 1 || !channels[0].Reverse {
+		t.Fatalf("ListChannels() = %v; expected one reverse channel", channels)
+	}
+	if err := s.CloseChannel(channels[0].SessionID, channels[0].ChannelID); err != nil {
+		t.Fatalf("CloseChannel() failed: %s", err)
+	}
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	if _, err := ioutil.ReadAll(conn); err != nil {
+		t.Errorf("Closed reverse channel ended with an error: %s", err)
+	}
+	for deadline := time.Now().Add(5 * time.Second); len(s.ListChannels()) != 0; {
+		if time.Now().After(deadline) {
+			t.Fatalf("Closed reverse channel is still listed: %v", s.ListChannels())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,50 @@
+package chshare
+
+import (
+	"context"
+	"fmt"
+)
+
+// ChannelTraceEnv may be implemented by a LocalChannelEnv to enable tracing of the bytes bridged
+// by its channels (see WithBridgeTrace). Tracing is off unless enabled.
+type ChannelTraceEnv interface {
+	// GetChannelTrace returns the capture file for channels of the given descriptor ("" for none),
+	// and whether their traffic is hex dumped at trace log level. For a proxy, descriptor is the
+	// string form of its ChannelDescriptor; for a channel opened by the remote proxy, it is the
+	// string form of the requested skeleton endpoint.
+	GetChannelTrace(descriptor string) (path string, hexDump bool)
+}
+
+// withEnvChannelTrace returns a context that traces the bridge of a channel of the given
+// descriptor as configured by env, or ctx unchanged if env does not implement ChannelTraceEnv
+func withEnvChannelTrace(ctx context.Context, env LocalChannelEnv, descriptor string) context.Context {
+	tenv, ok := env.(ChannelTraceEnv)
+	if !ok {
+		return ctx
+	}
+	path, hexDump := tenv.GetChannelTrace(descriptor)
+	return WithBridgeTrace(ctx, path, hexDump)
+}
+
+// channelTraceFiles returns the capture files named by the "trace" param of the client-side
+// endpoints of chds, e.g., tcp://{"bind":"localhost:5432","trace":"/tmp/chan.dump"}, keyed as in
+// ChannelTraceEnv. Only the client honors the param, since only there was the descriptor
+// configured locally; a server never writes files named by a client.
+func channelTraceFiles(chds []*ChannelDescriptor) (map[string]string, error) {
+	files := make(map[string]string)
+	for _, chd := range chds {
+		path, err := ParamString(chd.GetClientEndpoint().GetParamsMap(), "trace", "")
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", chd.String(), err)
+		}
+		if path == "" {
+			continue
+		}
+		if chd.Reverse {
+			files[chd.Skeleton.String()] = path
+		} else {
+			files[chd.String()] = path
+		}
+	}
+	return files, nil
+}
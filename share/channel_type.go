@@ -0,0 +1,31 @@
+package chshare
+
+// ChannelTypeWstunnel is the SSH channel type used to open tunnelled channels between wstunnel
+// proxies. It is the single source of truth for both the opening and accepting side.
+const ChannelTypeWstunnel = "wstunnel"
+
+// ChannelTypeRequest is the SSH global request with which a client proposes an alternate channel
+// type (e.g., for interop with a fork that uses a different name). The payload is the proposed
+// type; the server replies true if it agrees, after which either type is accepted in either
+// direction for the rest of the session.
+const ChannelTypeRequest = "channel-type"
+
+// SupportedChannelTypes lists the channel types that a server will agree to use
+var SupportedChannelTypes = []string{ChannelTypeWstunnel, "chisel"}
+
+// IsSupportedChannelType returns true if channelType is in SupportedChannelTypes
+func IsSupportedChannelType(channelType string) bool {
+	for _, t := range SupportedChannelTypes {
+		if t == channelType {
+			return true
+		}
+	}
+	return false
+}
+
+// isAcceptableChannelType returns true if an incoming channel of type channelType may be accepted
+// by a session that agreed on agreedType. The default type is always accepted, since channels may
+// be opened before an alternate type has been agreed.
+func isAcceptableChannelType(channelType string, agreedType string) bool {
+	return channelType == agreedType || channelType == ChannelTypeWstunnel
+}
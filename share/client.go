@@ -2,13 +2,18 @@ package chshare
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
 	"net/url"
+	"os"
 	"regexp"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	socks5 "github.com/armon/go-socks5"
@@ -17,19 +22,150 @@ import (
 	"golang.org/x/crypto/ssh"
 )
 
+// DefaultKeepAliveMaxFailures is the number of consecutive unanswered keepalive pings
+// after which the client declares the connection dead, if Config.KeepAliveMaxFailures is 0.
+const DefaultKeepAliveMaxFailures = 3
+
 //Config represents a client configuration
 type Config struct {
-	shared           *SessionConfigRequest
-	Debug            bool
-	Fingerprint      string
-	Auth             string
-	KeepAlive        time.Duration
-	MaxRetryCount    int
-	MaxRetryInterval time.Duration
-	Server           string
-	HTTPProxy        string
-	ChdStrings       []string
-	HostHeader       string
+	shared               *SessionConfigRequest
+	Debug                bool
+	Fingerprint          string
+	Auth                 string
+	KeepAlive            time.Duration
+	KeepAliveMaxFailures int
+	MaxRetryCount        int
+	MaxRetryInterval     time.Duration
+	Server               string
+	HTTPProxy            string
+	HTTPProxyAuthScheme  string
+	HTTPProxyAuth        string
+	HTTPProxyHeaders     http.Header
+	ChdStrings           []string
+	HostHeader           string
+	ChannelType          string
+
+	// DescriptorDefaults, if not nil, are applied to what the ChdStrings (and the remotes given to
+	// ReconfigureReverse and StopRemote) omit, instead of the built-in defaults
+	DescriptorDefaults *ChannelDescriptorDefaults
+
+	// FailFastOnRemoteError determines what happens when some remotes cannot be set up at startup
+	// (e.g., a local port is already in use, or the server cannot bind a reverse port). If true,
+	// the client fails as a whole. If false, failed remotes are logged and reported by
+	// Client.FailedRemotes, and the client continues with the remaining ones. If nil, defaults to true.
+	FailFastOnRemoteError *bool
+
+	// HTTP2 enables the HTTP/2 transport (h2c for http:// servers), falling back to websocket over
+	// HTTP/1.1 for H2RetryInterval if the server or an intermediary does not support it
+	HTTP2 bool
+
+	// TLSCert and TLSKey, if set, are the PEM files of a TLS client certificate presented to a
+	// server that terminates TLS itself, e.g., to log in as the user it names (see
+	// ProxyServerConfig.TLSClientCA)
+	TLSCert string
+	TLSKey  string
+
+	// BackoffResetAfter, if nonzero, is how long a connection must stay up before the reconnect
+	// backoff (and the attempt count checked against MaxRetryCount) is reset, so that a link that
+	// flaps after brief successful connects keeps backing off. If 0, the backoff is reset as soon
+	// as a connection is established.
+	BackoffResetAfter time.Duration
+
+	// ReconnectDrainGrace, if nonzero, lets channels in flight finish when the server disconnects
+	// because it is draining: the client reconnects right away, new channels use the new
+	// connection, and the old connection is closed once its channels have finished or after
+	// this long, whichever comes first. If 0, the old connection's channels end with it.
+	ReconnectDrainGrace time.Duration
+
+	// PreserveSourcePort asks the server to propagate each Caller's source port on reverse remotes.
+	// TCP targets are then dialed from the same source port where it is free, falling back to an
+	// ephemeral port otherwise.
+	PreserveSourcePort bool
+
+	// UserAgent, if not empty, is sent as the User-Agent header of the websocket (or HTTP/2)
+	// handshake, replacing any User-Agent in Headers
+	UserAgent string
+
+	// Headers are additional headers sent with the websocket (or HTTP/2) handshake. A header with
+	// several values is sent once per value, in order. Host (see HostHeader) and the headers that
+	// the websocket handshake sets itself may not be given.
+	Headers http.Header
+
+	// MinFingerprintLength is the minimum length of Fingerprint, which may be a prefix of the
+	// server's fingerprint. If 0, the complete fingerprint is required. If negative, any prefix is
+	// accepted, which weakens protection against man-in-the-middle attacks.
+	MinFingerprintLength int
+
+	// StateChange, if not nil, is called at each change in the client's connection state (see
+	// ClientState). Calls are made in order, one at a time, on a separate goroutine, so a slow
+	// callback does not hold up the client.
+	StateChange func(ClientState)
+
+	// MaxConcurrentAccepts limits the number of connections each local stub listener handles at
+	// once; excess connections wait in the listen backlog. 0 means unlimited.
+	MaxConcurrentAccepts int
+
+	// ReusePort creates local TCP stub listeners with SO_REUSEPORT, unless a stub's "reusePort"
+	// param says otherwise
+	ReusePort bool
+
+	// CheckRemotes asks the server, before sending the session config, which remotes the client's
+	// user is allowed, and fails the connection with a clear error if any remote is not. Requires
+	// a server that supports AllowedRemotesRequest.
+	CheckRemotes bool
+
+	// TraceChannels hex dumps the traffic of every channel at trace log level, for debugging.
+	// Independently, a remote's client-side endpoint may name a capture file with a "trace" param.
+	TraceChannels bool
+
+	// MaxLifetime, if not 0, is the time after which the client shuts itself down cleanly, with
+	// completion error ErrMaxLifetime (e.g., for an ephemeral tunnel in a CI job)
+	MaxLifetime time.Duration
+
+	// LogRingSize, if not 0, is the number of recent log records retained in memory; they are
+	// written to stderr on SIGUSR2, and available from RecentLogRecords
+	LogRingSize int
+
+	// ReverseOnly requires every remote to be a reverse remote, so that the client never listens
+	// locally. A forward remote is an error.
+	ReverseOnly bool
+
+	// PathPrefix, if not empty, is appended to the path of the Server URL (preserving any path
+	// already in it), for a server configured with the same ProxyServerConfig.PathPrefix
+	PathPrefix string
+
+	// ForwardOnly requires every remote to be a forward remote, so that the server never listens
+	// for the client; ReconfigureReverse is refused. A reverse remote is an error.
+	ForwardOnly bool
+
+	// RekeyThreshold is the number of bytes sent or received on the SSH connection after which a new
+	// key is negotiated. 0 selects the ssh library's default, which suits most uses. A lower value
+	// changes keys more often, at the cost of a stall of all channels during each key exchange; a
+	// value below MinRecommendedRekeyThreshold is warned about.
+	RekeyThreshold uint64
+
+	// ExitAfterOnce shuts the client down cleanly once every forward remote with a one-shot stub
+	// (a "once" param, e.g., tcp://{"bind":"127.0.0.1:3000","once":true}) has accepted and
+	// bridged its single connection. At least one such remote is required.
+	ExitAfterOnce bool
+
+	// MaxLogRecordLength, if not 0, is the maximum length in bytes of the client's log messages;
+	// longer messages are truncated (see LimitLogger)
+	MaxLogRecordLength int
+
+	// ChannelBuffer, if not 0, is the size of the buffer used in each direction of each channel the
+	// client bridges, which bounds the bytes held in flight for a stalled channel. It must be at
+	// least MinChannelBufferSize. If 0, the standard copy buffering is used.
+	ChannelBuffer int
+
+	// Clock, if not nil, replaces RealClock as the source of time for the reconnect backoff,
+	// keepalive pings, the reconnect drain grace and MaxLifetime (e.g., a MockClock in tests)
+	Clock Clock
+
+	// BeforeBridge, if not nil, is called with each connection dialed by the client's TCP and unix
+	// skeletons (i.e., for reverse remotes), before bridging starts, so that it can prepare the
+	// Called Service (see BasicEndpoint.BeforeBridge)
+	BeforeBridge func(ctx context.Context, calledServiceConn ChannelConn, extraData []byte) error
 }
 
 //Client represents a client instance
@@ -37,16 +173,69 @@ type Client struct {
 	ShutdownHelper
 	config       *Config
 	sshConfig    *ssh.ClientConfig
+	sshConnLock  sync.Mutex
 	sshConn      ssh.Conn
 	sshConnReady chan struct{}
 	sshConnErr   error
 	httpProxyURL *url.URL
-	server       string
-	running      bool
-	runningc     chan error
-	connStats    ConnStats
-	socksServer  *socks5.Server
-	loopServer   *LoopServer
+	httpProxyHdr http.Header
+
+	// socksProxyDial, if not nil, dials through the SOCKS5 proxy in httpProxyURL
+	socksProxyDial func(network, addr string) (net.Conn, error)
+
+	server      string
+	running     bool
+	runningc    chan error
+	connStats   ConnStats
+	socksServer *socks5.Server
+	loopServer  *LoopServer
+	metrics     Metrics
+	metricsName string
+	channelType string
+	failFast    bool
+	proxies     []*TCPProxy
+	proxyNames  proxyNameIndex
+
+	// tlsConfig, if not nil, is the TLS client config for wss:// servers (see Config.TLSCert)
+	tlsConfig *tls.Config
+
+	// h2RetryAt, if not zero, is the time until which the HTTP/2 transport is not tried, because it
+	// failed where a websocket succeeded (see H2RetryInterval)
+	h2RetryAt time.Time
+
+	failedRemotesLock    sync.Mutex
+	localFailedRemotes   []RemoteFailure
+	reverseFailedRemotes []RemoteFailure
+
+	// generations counts the channels in flight on each SSH connection to the server
+	generationsLock sync.Mutex
+	generations     map[ssh.Conn]*connGeneration
+
+	// stateNotifier delivers connection state changes to Config.StateChange; nil if there is none
+	stateNotifier *stateNotifier
+
+	// stateLock protects state, the most recent connection state, and lastErr, the most recent
+	// connection error, for Status
+	stateLock sync.Mutex
+	state     ClientState
+	lastErr   error
+
+	// traceFiles holds the capture files named by "trace" params, keyed as in ChannelTraceEnv
+	traceFiles map[string]string
+
+	// logRing retains recent log records; nil if Config.LogRingSize is 0
+	logRing *LogRing
+
+	// sharedLock protects the channel descriptors of the session config, which ReconfigureReverse
+	// and StopRemote may replace while the client is running
+	sharedLock sync.Mutex
+
+	// proxiesLock protects proxies, from which StopRemote may remove a proxy
+	proxiesLock sync.Mutex
+
+	// shutdownProxies holds the local proxies that are shut down with the client; StopRemote
+	// drops a proxy from it
+	shutdownProxies proxySet
 }
 
 //NewClient creates a new client instance
@@ -56,12 +245,19 @@ func NewClient(config *Config) (*Client, error) {
 	if config.Debug {
 		logLevel = LogLevelDebug
 	}
+	if config.TraceChannels {
+		logLevel = LogLevelTrace
+	}
 
-	logger := NewLogger("client", logLevel)
+	logRing := NewLogRing(config.LogRingSize)
+	logger := LimitLogger(RingLogger(NewLogger("client", logLevel), logRing), config.MaxLogRecordLength)
 
 	if !strings.HasPrefix(config.Server, "http") {
 		config.Server = "http://" + config.Server
 	}
+	if config.Clock == nil {
+		config.Clock = RealClock
+	}
 	if config.MaxRetryInterval < time.Second {
 		config.MaxRetryInterval = 5 * time.Minute
 	}
@@ -79,28 +275,70 @@ func NewClient(config *Config) (*Client, error) {
 	}
 	//swap to websockets scheme
 	u.Scheme = strings.Replace(u.Scheme, "http", "ws", 1)
+	pathPrefix, err := normalizePathPrefix(config.PathPrefix)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", logger.Prefix(), err)
+	}
+	appendURLPath(u, pathPrefix)
+	if err := ValidateChannelBufferSize(config.ChannelBuffer); err != nil {
+		return nil, fmt.Errorf("%s: %s", logger.Prefix(), err)
+	}
 	shared := &SessionConfigRequest{}
 	for _, s := range config.ChdStrings {
-		chd, err := ParseChannelDescriptor(s)
+		// accepts both the legacy shorthand and the full "<stub>,<skeleton>" form with JSON params
+		chd, _, err := ParseChannelDescriptorPathWithDefaults(s, config.DescriptorDefaults)
 		if err != nil {
 			return nil, fmt.Errorf("%s: Failed to parse channel descriptor string '%s': %s", logger.Prefix(), s, err)
 		}
-		shared.ChannelDescriptors = append(shared.ChannelDescriptors, chd)
+		shared.ChannelDescriptors = append(shared.ChannelDescriptors, &chd)
+	}
+	err = ValidateChannelDescriptors(shared.ChannelDescriptors)
+	if err != nil {
+		return nil, fmt.Errorf("%s: Invalid channel descriptors: %s", logger.Prefix(), err)
 	}
+	err = checkRemoteDirections(shared.ChannelDescriptors, config.ReverseOnly, config.ForwardOnly)
+	if err != nil {
+		return nil, fmt.Errorf("%s: Invalid channel descriptors: %s", logger.Prefix(), err)
+	}
+	err = checkOnceStubs(shared.ChannelDescriptors, config.ExitAfterOnce)
+	if err != nil {
+		return nil, fmt.Errorf("%s: Invalid channel descriptors: %s", logger.Prefix(), err)
+	}
+	for _, chd := range shared.ChannelDescriptors {
+		// the client runs no SOCKS5 server; the server checks its own side of forward socks remotes
+		if err := checkSocksRemote(chd, true, false); err != nil {
+			return nil, fmt.Errorf("%s: Invalid channel descriptors: %s", logger.Prefix(), err)
+		}
+	}
+	traceFiles, err := channelTraceFiles(shared.ChannelDescriptors)
+	if err != nil {
+		return nil, fmt.Errorf("%s: Invalid channel descriptors: %s", logger.Prefix(), err)
+	}
+	shared.AllowPartial = config.FailFastOnRemoteError != nil && !*config.FailFastOnRemoteError
+	shared.PreserveSourcePort = config.PreserveSourcePort
 	config.shared = shared
 	loopServer, err := NewLoopServer(logger)
 	if err != nil {
 		return nil, fmt.Errorf("%s: Failed to start loop server", logger.Prefix())
 	}
+	channelType := config.ChannelType
+	if channelType == "" {
+		channelType = ChannelTypeWstunnel
+	}
 	client := &Client{
 		config:       config,
 		sshConnReady: make(chan struct{}),
 		server:       u.String(),
+		channelType:  channelType,
+		failFast:     config.FailFastOnRemoteError == nil || *config.FailFastOnRemoteError,
+		traceFiles:   traceFiles,
+		logRing:      logRing,
 		//running:      true,
 		//runningc:     make(chan error, 1),
 		loopServer: loopServer,
 	}
 	client.InitShutdownHelper(logger, client)
+	client.stateNotifier = newStateNotifier(config.StateChange)
 	client.PanicOnError(client.PauseShutdown())
 	defer client.ResumeShutdown()
 
@@ -109,11 +347,51 @@ func NewClient(config *Config) (*Client, error) {
 		if err != nil {
 			return nil, fmt.Errorf("%s: Invalid proxy URL (%s)", logger.Prefix(), err)
 		}
+		if IsSOCKS5ProxyURL(client.httpProxyURL) {
+			if config.HTTPProxyAuthScheme != "" || len(config.HTTPProxyHeaders) > 0 {
+				return nil, fmt.Errorf("%s: Proxy auth scheme and headers are not supported with a SOCKS5 proxy", logger.Prefix())
+			}
+			client.socksProxyDial, err = NewSOCKS5ProxyDialer(client.httpProxyURL, 45*time.Second)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s", logger.Prefix(), err)
+			}
+		} else if config.HTTPProxyAuthScheme != "" || len(config.HTTPProxyHeaders) > 0 {
+			client.httpProxyHdr, err = NewHTTPProxyConnectHeader(client.httpProxyURL,
+				config.HTTPProxyAuthScheme, config.HTTPProxyAuth, config.HTTPProxyHeaders)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s", logger.Prefix(), err)
+			}
+		}
+	} else if config.HTTPProxyAuthScheme != "" || len(config.HTTPProxyHeaders) > 0 {
+		return nil, fmt.Errorf("%s: Proxy auth scheme or headers provided without a proxy URL", logger.Prefix())
+	}
+
+	if err := ValidateFingerprintLength(config.Fingerprint, config.MinFingerprintLength); err != nil {
+		return nil, fmt.Errorf("%s: %s", logger.Prefix(), err)
+	}
+
+	if err := validateHandshakeHeaders(config.Headers); err != nil {
+		return nil, fmt.Errorf("%s: %s", logger.Prefix(), err)
+	}
+
+	if config.TLSCert != "" || config.TLSKey != "" {
+		if config.TLSCert == "" || config.TLSKey == "" {
+			return nil, fmt.Errorf("%s: Both a TLS client certificate and key are required", logger.Prefix())
+		}
+		cert, err := tls.LoadX509KeyPair(config.TLSCert, config.TLSKey)
+		if err != nil {
+			return nil, fmt.Errorf("%s: Unable to load TLS client certificate '%s' and key '%s': %s",
+				logger.Prefix(), config.TLSCert, config.TLSKey, err)
+		}
+		client.tlsConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
 	}
 
 	user, pass := ParseAuth(config.Auth)
 
+	// Compression is not configurable; x/crypto/ssh only supports the "none" method.
+	checkRekeyThreshold(client.Logger, config.RekeyThreshold)
 	client.sshConfig = &ssh.ClientConfig{
+		Config:          ssh.Config{RekeyThreshold: config.RekeyThreshold},
 		User:            user,
 		Auth:            []ssh.AuthMethod{ssh.Password(pass)},
 		ClientVersion:   "SSH-" + ProtocolVersion + "-client",
@@ -121,6 +399,8 @@ func NewClient(config *Config) (*Client, error) {
 		Timeout:         30 * time.Second,
 	}
 
+	//registered last, so that no error path above leaves the metrics registered
+	client.metricsName = registerMetrics("client", &client.metrics)
 	return client, nil
 }
 
@@ -135,10 +415,89 @@ func (c *Client) IsServer() bool {
 // communicate with the remote proxy. It is possible that goroutines servicing
 // local stub sockets will ask for this before it is available (if for example
 // a listener on the client accepts a connection before the server has ackknowledged
-// configuration.
+// configuration, or while the client is reconnecting after a disconnect).
 func (c *Client) GetSSHConn() (ssh.Conn, error) {
-	<-c.sshConnReady
-	return c.sshConn, c.sshConnErr
+	return c.GetSSHConnContext(context.Background())
+}
+
+// GetSSHConnContext is like GetSSHConn, but gives up and returns an error if ctx is
+// cancelled or reaches its deadline before the ssh.Conn is available.
+func (c *Client) GetSSHConnContext(ctx context.Context) (ssh.Conn, error) {
+	for {
+		ready := c.getSSHConnReady()
+		select {
+		case <-ready:
+		case <-ctx.Done():
+			return nil, c.Errorf("Gave up waiting for SSH connection: %s", ctx.Err())
+		}
+		sshConn, current, err := c.getSSHConnStatus()
+		if current == ready {
+			return sshConn, err
+		}
+		// disconnected again before we got a look at it; wait for the next connection
+	}
+}
+
+// getSSHConnReady returns the channel that will be closed when the current connection attempt
+// either succeeds or is abandoned. A new channel is installed each time the client disconnects.
+func (c *Client) getSSHConnReady() chan struct{} {
+	c.sshConnLock.Lock()
+	defer c.sshConnLock.Unlock()
+	return c.sshConnReady
+}
+
+// getSSHConnStatus atomically returns the current ssh.Conn (nil if not connected), the
+// ready channel it belongs to, and the connection error.
+func (c *Client) getSSHConnStatus() (ssh.Conn, chan struct{}, error) {
+	c.sshConnLock.Lock()
+	defer c.sshConnLock.Unlock()
+	return c.sshConn, c.sshConnReady, c.sshConnErr
+}
+
+// setSSHConnReady publishes the result of a connection attempt and wakes up anyone waiting for it.
+// Exactly one of sshConn and err should be nil.
+func (c *Client) setSSHConnReady(sshConn ssh.Conn, err error) {
+	c.sshConnLock.Lock()
+	defer c.sshConnLock.Unlock()
+	c.sshConn = sshConn
+	c.sshConnErr = err
+	close(c.sshConnReady)
+}
+
+// resetSSHConn forgets a disconnected ssh.Conn, so that subsequent callers of GetSSHConn
+// will wait for the client to reconnect
+func (c *Client) resetSSHConn() {
+	c.sshConnLock.Lock()
+	defer c.sshConnLock.Unlock()
+	c.sshConn = nil
+	c.sshConnErr = nil
+	c.sshConnReady = make(chan struct{})
+}
+
+// WaitReady blocks until the SSH connection to the server is established and the server has
+// acknowledged the session configuration, and returns nil. If the connection attempt fails,
+// the client shuts down before becoming ready, or ctx is cancelled or reaches its deadline
+// first, an error is returned. If the client is currently reconnecting, WaitReady waits for
+// the reconnect. It is safe to call before or after Run, and from multiple goroutines.
+func (c *Client) WaitReady(ctx context.Context) error {
+	for {
+		ready := c.getSSHConnReady()
+		select {
+		case <-ready:
+		case <-c.ShutdownStartedChan():
+			select {
+			case <-ready:
+			default:
+				return c.Errorf("Client shut down before the connection was ready")
+			}
+		case <-ctx.Done():
+			return c.Errorf("Gave up waiting for the connection to be ready: %s", ctx.Err())
+		}
+		_, current, err := c.getSSHConnStatus()
+		if current == ready {
+			return err
+		}
+	}
 }
 
 // GetLoopServer returns the shared LoopServer if loop protocol is enabled; nil otherwise
@@ -152,6 +511,81 @@ func (c *Client) GetSocksServer() *socks5.Server {
 	return c.socksServer
 }
 
+// GetChannelType returns the SSH channel type used to open tunnelled channels to the server
+func (c *Client) GetChannelType() string {
+	return c.channelType
+}
+
+// GetMaxConcurrentAccepts returns the maximum number of connections each local stub listener
+// handles at once, or 0 for no limit. Implements ConcurrentAcceptEnv.
+func (c *Client) GetMaxConcurrentAccepts() int {
+	return c.config.MaxConcurrentAccepts
+}
+
+// GetChannelBufferSize returns the channel buffer size for the client's channels, or 0 for the
+// default. Implements ChannelBufferEnv.
+func (c *Client) GetChannelBufferSize() int {
+	return c.config.ChannelBuffer
+}
+
+// GetBeforeBridge returns the callback that prepares each connection dialed by the client's
+// skeletons, or nil for none. Implements BeforeBridgeEnv.
+func (c *Client) GetBeforeBridge() func(ctx context.Context, calledServiceConn ChannelConn, extraData []byte) error {
+	return c.config.BeforeBridge
+}
+
+// IsTCPStubReusePortDefault returns true if local TCP stub listeners are created with
+// SO_REUSEPORT by default. Implements TCPStubReusePortEnv.
+func (c *Client) IsTCPStubReusePortDefault() bool {
+	return c.config.ReusePort
+}
+
+// RecentLogRecords returns the log records retained in memory (see Config.LogRingSize), oldest
+// first, or nil if none are retained
+func (c *Client) RecentLogRecords() []LogRecord {
+	if c.logRing == nil {
+		return nil
+	}
+	return c.logRing.Records()
+}
+
+// GetChannelTrace returns the capture file named by the "trace" param of the remote with the given
+// descriptor, if any, and whether channel traffic is hex dumped. Implements ChannelTraceEnv.
+func (c *Client) GetChannelTrace(descriptor string) (string, bool) {
+	return c.traceFiles[descriptor], c.config.TraceChannels
+}
+
+// FailedRemotes returns the remotes that could not be set up, in descriptor order. Local (forward)
+// failures are reported from Start; reverse failures are reported by the server for the current
+// session. Always empty if FailFastOnRemoteError is in effect, since any failure is then fatal.
+func (c *Client) FailedRemotes() []RemoteFailure {
+	c.failedRemotesLock.Lock()
+	defer c.failedRemotesLock.Unlock()
+	result := make([]RemoteFailure, 0, len(c.localFailedRemotes)+len(c.reverseFailedRemotes))
+	result = append(result, c.localFailedRemotes...)
+	result = append(result, c.reverseFailedRemotes...)
+	sort.Slice(result, func(i, j int) bool { return result[i].Index < result[j].Index })
+	return result
+}
+
+func (c *Client) addLocalFailedRemote(f RemoteFailure) {
+	c.failedRemotesLock.Lock()
+	defer c.failedRemotesLock.Unlock()
+	c.localFailedRemotes = append(c.localFailedRemotes, f)
+}
+
+func (c *Client) setReverseFailedRemotes(failures []RemoteFailure) {
+	c.failedRemotesLock.Lock()
+	defer c.failedRemotesLock.Unlock()
+	c.reverseFailedRemotes = failures
+}
+
+// GetMetrics returns the client's introspection counters, including reconnect attempts and
+// whether the client is currently connected. They are also published with expvar.
+func (c *Client) GetMetrics() *Metrics {
+	return &c.metrics
+}
+
 //Run starts client and blocks while connected
 func (c *Client) Run(ctx context.Context) error {
 	subCtx, cancel := context.WithCancel(ctx)
@@ -173,7 +607,7 @@ func (c *Client) verifyServer(hostname string, remote net.Addr, key ssh.PublicKe
 	expect := c.config.Fingerprint
 	got := FingerprintKey(key)
 	if expect != "" && !strings.HasPrefix(got, expect) {
-		return fmt.Errorf("Invalid fingerprint (%s)", got)
+		return fmt.Errorf("%s (%s)", invalidFingerprintMessage, got)
 	}
 	//overwrite with complete fingerprint
 	c.ILogf("Fingerprint %s", got)
@@ -183,20 +617,43 @@ func (c *Client) verifyServer(hostname string, remote net.Addr, key ssh.PublicKe
 //Start client and does not block
 func (c *Client) Start(ctx context.Context) error {
 	c.ShutdownOnContext(ctx)
+	shutdownAfterMaxLifetime(c.Logger, c.config.Clock, c.config.MaxLifetime, c.ShutdownStartedChan(), c.StartShutdown)
 	via := ""
 	if c.httpProxyURL != nil {
-		via = " via " + c.httpProxyURL.String()
+		via = " via " + c.httpProxyURL.Redacted()
 	}
 	//prepare non-reverse proxies (other than stdio proxy, which we defer til we have a good connection)
+	var onceProxies []*TCPProxy
 	for i, chd := range c.config.shared.ChannelDescriptors {
 		if !chd.Reverse && chd.Stub.Type != ChannelEndpointProtocolStdio {
-			proxy := NewTCPProxy(c.Logger, c, i, chd)
-			c.AddShutdownChild(proxy)
+			proxy := NewTCPProxy(c.Logger, c, i, chd, &c.metrics)
+			if err := c.addProxy(proxy); err != nil {
+				return c.Errorf("%s", err)
+			}
 			if err := proxy.Start(ctx); err != nil {
-				return err
+				if c.failFast {
+					return err
+				}
+				c.ILogf("Unable to start remote #%d \"%s\": %s; continuing with remaining remotes", i+1, chd.String(), err)
+				c.addLocalFailedRemote(RemoteFailure{Index: i, Descriptor: chd.String(), Error: err.Error()})
+				continue
+			}
+			if once, _ := isOnceStub(chd); once {
+				onceProxies = append(onceProxies, proxy)
 			}
 		}
 	}
+	if c.config.ExitAfterOnce {
+		if len(onceProxies) == 0 {
+			return c.Errorf("No one-shot remote could be started, so the client would never exit")
+		}
+		go c.exitAfterOnceProxies(onceProxies)
+	}
+	// list the local proxies along with the process stats on SIGUSR2
+	go OnStatsSignal(ctx, c.logProxies)
+	if c.logRing != nil {
+		go OnStatsSignal(ctx, func() { c.logRing.Dump(os.Stderr) })
+	}
 	c.ILogf("Connecting to %s%s\n", c.server, via)
 	//optional keepalive loop
 	if c.config.KeepAlive > 0 {
@@ -207,32 +664,218 @@ func (c *Client) Start(ctx context.Context) error {
 	return nil
 }
 
+// GetProxyByName returns the local proxy for the named tunnel (see ChannelDescriptor.Name), or
+// nil if there is none. Reverse tunnels are proxied by the server, not the client.
+func (c *Client) GetProxyByName(name string) *TCPProxy {
+	return c.proxyNames.get(name)
+}
+
+// addProxy registers a local proxy to be shut down with the client, and indexes it
+func (c *Client) addProxy(proxy *TCPProxy) error {
+	if !c.shutdownProxies.add(proxy) {
+		return fmt.Errorf("Client is shutting down")
+	}
+	c.proxiesLock.Lock()
+	c.proxies = append(c.proxies, proxy)
+	c.proxiesLock.Unlock()
+	return c.proxyNames.add(proxy)
+}
+
+// getProxies returns a copy of the list of local proxies
+func (c *Client) getProxies() []*TCPProxy {
+	c.proxiesLock.Lock()
+	defer c.proxiesLock.Unlock()
+	return append([]*TCPProxy(nil), c.proxies...)
+}
+
+// StopRemote stops a forward remote of the running client, given its tunnel name (see
+// ChannelDescriptor.Name) or its descriptor, in any form that parses to the same descriptor. The
+// remote's listener is closed, and StopRemote waits for its channels to finish before returning.
+// The remote is also dropped from the session config, so it is not sent to the server again on
+// reconnect. The stopped proxy has completed, so the client's own shutdown does not wait for it.
+// A remote with a stdio stub cannot be stopped on its own, since it determines the client's
+// lifetime.
+func (c *Client) StopRemote(descriptorOrName string) error {
+	chdString := ""
+	if chd, _, err := ParseChannelDescriptorPathWithDefaults(descriptorOrName, c.config.DescriptorDefaults); err == nil {
+		chdString = chd.String()
+	}
+	named := c.proxyNames.get(descriptorOrName)
+
+	c.proxiesLock.Lock()
+	var proxy *TCPProxy
+	for i, p := range c.proxies {
+		if p == named || (chdString != "" && p.Descriptor().String() == chdString) {
+			if p.Descriptor().Stub.Type == ChannelEndpointProtocolStdio {
+				c.proxiesLock.Unlock()
+				return c.Errorf("Remote \"%s\" has a stdio stub, and cannot be stopped without stopping the client", descriptorOrName)
+			}
+			proxy = p
+			c.proxies = append(c.proxies[:i:i], c.proxies[i+1:]...)
+			break
+		}
+	}
+	c.proxiesLock.Unlock()
+	if proxy == nil {
+		return c.Errorf("No running forward remote \"%s\"", descriptorOrName)
+	}
+	c.proxyNames.remove(proxy)
+	c.shutdownProxies.remove(proxy)
+
+	c.sharedLock.Lock()
+	var chds []*ChannelDescriptor
+	for _, chd := range c.config.shared.ChannelDescriptors {
+		if chd != proxy.Descriptor() {
+			chds = append(chds, chd)
+		}
+	}
+	c.config.shared.ChannelDescriptors = chds
+	c.sharedLock.Unlock()
+
+	c.ILogf("Stopping forward remote %s", proxy)
+	proxy.StartShutdown(nil)
+	if err := proxy.WaitShutdown(); err != nil {
+		c.DLogf("Forward remote %s stopped with: %s", proxy, err)
+	}
+	return nil
+}
+
+// logProxies logs the local proxies and whether they are still running
+func (c *Client) logProxies() {
+	proxies := c.getProxies()
+	c.ILogf("%d local proxies", len(proxies))
+	for _, proxy := range proxies {
+		state := "running"
+		if proxy.IsStartedShutdown() {
+			state = "stopped"
+		}
+		c.ILogf("  %s (%s)", proxy, state)
+	}
+}
+
+// startStdioProxies starts the proxies for forward remotes with a stdio stub, once the first
+// connection to the server has been established. Stdin is sent to the remote until end-of-stream,
+// which is passed on as a half close, and the response is written to stdout until the remote
+// closes. The client then shuts down with the proxy's completion status.
+func (c *Client) startStdioProxies(ctx context.Context) {
+	c.sharedLock.Lock()
+	chds := c.config.shared.ChannelDescriptors
+	c.sharedLock.Unlock()
+	for i, chd := range chds {
+		if chd.Reverse || chd.Stub.Type != ChannelEndpointProtocolStdio {
+			continue
+		}
+		proxy := NewTCPProxy(c.Logger, c, i, chd, &c.metrics)
+		err := c.addProxy(proxy)
+		if err == nil {
+			err = proxy.Start(ctx)
+		}
+		if err != nil {
+			c.StartShutdown(err)
+			return
+		}
+		go func() {
+			err := proxy.WaitShutdown()
+			c.DLogf("stdio proxy finished: %v", err)
+			c.StartShutdown(err)
+		}()
+	}
+}
+
+// keepAliveLoop periodically pings the server. A ping that is not answered within
+// the keepalive interval counts as a failure; after KeepAliveMaxFailures consecutive
+// failures the SSH connection is closed, which causes the client to treat the server
+// as disconnected and reconnect. Any successful reply resets the failure count, so transient
+// packet loss is tolerated.
 func (c *Client) keepAliveLoop() {
-	pingDelay := time.NewTimer(c.config.KeepAlive)
+	maxFailures := c.config.KeepAliveMaxFailures
+	if maxFailures <= 0 {
+		maxFailures = DefaultKeepAliveMaxFailures
+	}
+	failures := 0
+	var lastConn ssh.Conn
+	clock := c.config.Clock
+	pingDelay := clock.NewTimer(c.config.KeepAlive)
 	defer pingDelay.Stop()
 	for {
 		select {
 		case <-c.ShutdownStartedChan():
 			return
-		case <-pingDelay.C:
-			if c.sshConn != nil {
-				c.sshConn.SendRequest("ping", true, nil)
+		case <-pingDelay.C():
+			nextPing := c.config.KeepAlive
+			sshConn, _, _ := c.getSSHConnStatus()
+			if sshConn != lastConn {
+				// reconnected; start counting afresh
+				failures = 0
+				lastConn = sshConn
 			}
-			pingDelay.Reset(c.config.KeepAlive)
+			if sshConn != nil {
+				t0 := clock.Now()
+				err := c.sendKeepAlivePing(sshConn, c.config.KeepAlive)
+				if err != nil {
+					failures++
+					c.DLogf("Keepalive ping failed (%d/%d consecutive failures): %s", failures, maxFailures, err)
+					if failures >= maxFailures {
+						c.ILogf("No keepalive reply after %d consecutive pings; closing connection", failures)
+						sshConn.Close()
+						failures = 0
+					}
+				} else {
+					failures = 0
+				}
+				// time spent waiting for the reply counts toward the next interval
+				nextPing -= clock.Now().Sub(t0)
+				if nextPing < 0 {
+					nextPing = 0
+				}
+			}
+			pingDelay.Reset(nextPing)
 		}
 	}
 }
 
+// sendKeepAlivePing sends a single ping request to the server and waits up to timeout for
+// the reply. Returns nil if a reply (positive or negative) was received in time.
+func (c *Client) sendKeepAlivePing(sshConn ssh.Conn, timeout time.Duration) error {
+	replied := make(chan error, 1)
+	go func() {
+		_, _, err := sshConn.SendRequest("ping", true, nil)
+		replied <- err
+	}()
+	timer := c.config.Clock.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case err := <-replied:
+		return err
+	case <-timer.C():
+		return fmt.Errorf("No reply within %s", timeout)
+	case <-c.ShutdownStartedChan():
+		return nil
+	}
+}
+
 func (c *Client) connectionLoop(ctx context.Context) {
 	//connection loop!
 	var connerr error
-	// stdioStarted := false
+	// failErr is the reason we gave up, if we did
+	var failErr error
+	stdioStarted := false
 	b := &backoff.Backoff{Max: c.config.MaxRetryInterval}
 	for !c.IsStartedShutdown() {
 		if connerr != nil {
 			attempt := int(b.Attempt())
 			maxAttempt := c.config.MaxRetryCount
 			d := b.Duration()
+			var reason *DisconnectReason
+			if errors.As(connerr, &reason) && reason.ReconnectLater() && d < DrainReconnectDelay {
+				// the server is going away on purpose; don't hammer it while it restarts
+				d = DrainReconnectDelay
+			}
+			var retryAfter *RetryAfterError
+			if errors.As(connerr, &retryAfter) && d < retryAfter.Delay {
+				// the server told us how long to stay away; this still counts as an attempt
+				d = retryAfter.Delay
+			}
 			//show error and attempt counts
 			msg := fmt.Sprintf("Connection error: %s", connerr)
 			if attempt > 0 {
@@ -245,107 +888,448 @@ func (c *Client) connectionLoop(ctx context.Context) {
 			c.DLogf(msg)
 			//give up?
 			if maxAttempt >= 0 && attempt >= maxAttempt {
+				failErr = connerr
 				break
 			}
+			// b.Duration() has counted the failed attempt
+			c.notifyState(ClientRetrying, int(b.Attempt()), connerr)
 			c.ILogf("Retrying in %s...", d)
+			c.metrics.ReconnectAttempt()
 			connerr = nil
-			SleepSignal(d)
-		}
-		d := websocket.Dialer{
-			ReadBufferSize:   1024,
-			WriteBufferSize:  1024,
-			HandshakeTimeout: 45 * time.Second,
-			Subprotocols:     []string{ProtocolVersion},
-		}
-		//optionally CONNECT proxy
-		if c.httpProxyURL != nil {
-			d.Proxy = func(*http.Request) (*url.URL, error) {
-				return c.httpProxyURL, nil
-			}
+			sleepSignalClock(c.config.Clock, d)
 		}
-		wsHeaders := http.Header{}
-		if c.config.HostHeader != "" {
-			wsHeaders = http.Header{
-				"Host": {c.config.HostHeader},
-			}
-		}
-		wsConn, _, err := d.Dial(c.server, wsHeaders)
+		c.notifyState(ClientConnecting, int(b.Attempt()), nil)
+		conn, err := c.dialServer(ctx)
 		if err != nil {
 			connerr = err
 			continue
 		}
-		conn := NewWebSocketConn(wsConn)
-		// perform SSH handshake on net.Conn
-		c.DLogf("Handshaking...")
-		sshConn, chans, reqs, err := ssh.NewClientConn(conn, "", c.sshConfig)
+		sshConn, chans, reqs, reply, err := c.handshake(conn)
 		if err != nil {
-			c.sshConnErr = err
-			if strings.Contains(err.Error(), "unable to authenticate") {
-				c.ILogf("Authentication failed")
-				c.DLogf(err.Error())
+			failErr = err
+			break
+		}
+		c.setReverseFailedRemotes(reply.FailedRemotes)
+		//connected
+		connectedAt := c.config.Clock.Now()
+		if c.config.BackoffResetAfter <= 0 {
+			b.Reset()
+		}
+		disconnectReasonc := make(chan *DisconnectReason, 1)
+		go c.handleSSHRequests(reqs, disconnectReasonc)
+
+		// wake up anyone waiting for our ssh connection to be ready
+		c.setSSHConnReady(sshConn, nil)
+		c.metrics.Sessions.New()
+		c.metrics.Sessions.Open()
+		c.metrics.SetConnected(true)
+		c.notifyState(ClientConnected, 0, nil)
+		if !stdioStarted {
+			stdioStarted = true
+			c.startStdioProxies(ctx)
+		}
+
+		go c.connectStreams(ctx, sshConn, chans)
+		waitc := make(chan error, 1)
+		go func() {
+			waitc <- sshConn.Wait()
+		}()
+		var reason *DisconnectReason
+		draining := false
+		select {
+		case err = <-waitc:
+		case reason = <-disconnectReasonc:
+			if c.config.ReconnectDrainGrace > 0 && reason.ReconnectLater() {
+				// reconnect now, leaving the old connection up while its channels finish
+				draining = true
 			} else {
-				c.ILogf(err.Error())
+				err = <-waitc
 			}
+		}
+		//disconnected
+		c.endSession(sshConn, draining, reason)
+		if c.config.BackoffResetAfter > 0 {
+			if up := c.config.Clock.Now().Sub(connectedAt); up >= c.config.BackoffResetAfter {
+				b.Reset()
+			} else {
+				c.DLogf("Connection was up for %s, less than %s; not resetting the reconnect backoff", up, c.config.BackoffResetAfter)
+			}
+		}
+		if c.IsStartedShutdown() {
 			break
 		}
-		c.config.shared.Version = BuildVersion
-		conf, _ := c.config.shared.Marshal()
-		c.DLogf("Sending session config request")
-		t0 := time.Now()
-		_, configerr, err := sshConn.SendRequest("config", true, conf)
+		if reason == nil {
+			select {
+			case reason = <-disconnectReasonc:
+			default:
+			}
+		}
+		if reason != nil {
+			if !draining {
+				c.ILogf("Disconnected: %s", reason)
+			}
+			err = reason
+		} else {
+			c.ILogf("Disconnected\n")
+		}
+		if err == nil {
+			err = c.Errorf("Proxy Server disconnected")
+		}
+		connerr = err
+	}
+	// we are never connected here; wake up anyone waiting for our ssh connection with the failure
+	if failErr != nil {
+		c.setSSHConnReady(nil, failErr)
+		c.notifyState(ClientFailed, int(b.Attempt()), failErr)
+	} else {
+		c.setSSHConnReady(nil, c.Errorf("Client shut down while not connected to server"))
+		c.notifyState(ClientStopped, 0, nil)
+	}
+	c.Shutdown(failErr)
+}
+
+// endSession tears down the resources scoped to a session with the server that has ended,
+// leaving those of the client for the next session. Forward stub listeners are owned by the client
+// and stay up across reconnects; connections they accept while we are disconnected wait in
+// GetSSHConn for the new session. Reverse stubs live on the server and are re-created from the
+// config request when the new session is established. The ssh.Conn is forgotten before it is
+// closed, so that GetSSHConn never returns it once the disconnect has been noticed. Closing it
+// closes the channels that were in flight on it, which tears down their bridged local connections.
+// If draining, the connection is instead left up until its channels have finished (see
+// ReconnectDrainGrace), while new channels wait for the new session.
+func (c *Client) endSession(sshConn ssh.Conn, draining bool, reason *DisconnectReason) {
+	c.metrics.SetConnected(false)
+	c.metrics.Sessions.Close()
+	c.resetSSHConn()
+	if draining {
+		c.ILogf("Server is draining (%s); reconnecting while channels in flight finish on the previous connection (grace %s)", reason, c.config.ReconnectDrainGrace)
+		go c.drainGeneration(sshConn, c.config.ReconnectDrainGrace)
+	} else {
+		sshConn.Close()
+		c.forgetGeneration(sshConn)
+	}
+}
+
+// dialServer connects to the server with the HTTP/2 transport if enabled and available, or a
+// websocket otherwise
+func (c *Client) dialServer(ctx context.Context) (net.Conn, error) {
+	useH2 := c.useH2()
+	if useH2 {
+		conn, err := c.dialH2(ctx)
+		if err == nil {
+			c.DLogf("Using HTTP/2 transport")
+			return conn, nil
+		}
+		c.DLogf("HTTP/2 transport failed, trying websocket over HTTP/1.1: %s", err)
+	}
+	conn, err := c.dialWebsocket()
+	if err != nil {
+		return nil, err
+	}
+	if useH2 {
+		// don't keep paying for a failed attempt on every reconnect
+		c.ILogf("Server does not support the HTTP/2 transport; using websocket over HTTP/1.1 for %s", H2RetryInterval)
+		c.h2RetryAt = c.config.Clock.Now().Add(H2RetryInterval)
+	}
+	return conn, nil
+}
+
+// useH2 returns true if the next connection to the server should try the HTTP/2 transport
+func (c *Client) useH2() bool {
+	return c.config.HTTP2 && !c.config.Clock.Now().Before(c.h2RetryAt)
+}
+
+// handshake performs the SSH handshake on a new connection to the server and exchanges the
+// session config. Failures are returned as a *ConnectError, classified by cause; on failure,
+// the connection has been closed.
+func (c *Client) handshake(conn net.Conn) (ssh.Conn, <-chan ssh.NewChannel, <-chan *ssh.Request, *SessionConfigReply, error) {
+	// perform SSH handshake on net.Conn
+	c.DLogf("Handshaking...")
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, "", c.sshConfig)
+	if err != nil {
+		conn.Close()
+		if strings.Contains(err.Error(), "unable to authenticate") {
+			c.ILogf("Authentication failed")
+			c.DLogf(err.Error())
+			return nil, nil, nil, nil, &ConnectError{Kind: ConnectErrorAuth, Err: err}
+		}
+		c.ILogf(err.Error())
+		if strings.Contains(err.Error(), invalidFingerprintMessage) {
+			return nil, nil, nil, nil, &ConnectError{Kind: ConnectErrorAuth, Err: err}
+		}
+		return nil, nil, nil, nil, &ConnectError{Kind: ConnectErrorNetwork, Err: err}
+	}
+	if c.config.CheckRemotes {
+		err = c.checkAllowedRemotes(sshConn)
 		if err != nil {
-			c.sshConnErr = err
-			c.ILogf("Session config verification failed")
-			break
+			sshConn.Close()
+			c.ILogf("%s", err)
+			return nil, nil, nil, nil, &ConnectError{Kind: ConnectErrorRejected, Err: err}
 		}
-		if len(configerr) > 0 {
-			c.ILogf(string(configerr))
-			c.sshConnErr = fmt.Errorf("SSH server returned binary config error: %v", configerr)
-			break
+	}
+	c.sharedLock.Lock()
+	c.config.shared.Version = BuildVersion
+	conf, _ := c.config.shared.Marshal()
+	c.sharedLock.Unlock()
+	c.DLogf("Sending session config request")
+	t0 := time.Now()
+	configok, configreply, err := sshConn.SendRequest("config", true, conf)
+	if err != nil {
+		sshConn.Close()
+		c.ILogf("Session config verification failed")
+		return nil, nil, nil, nil, &ConnectError{Kind: ConnectErrorNetwork, Err: err}
+	}
+	if !configok {
+		sshConn.Close()
+		c.ILogf(string(configreply))
+		err = fmt.Errorf("SSH server returned binary config error: %v", configreply)
+		return nil, nil, nil, nil, &ConnectError{Kind: ConnectErrorRejected, Err: err}
+	}
+	reply := &SessionConfigReply{}
+	err = reply.Unmarshal(configreply)
+	if err != nil {
+		sshConn.Close()
+		err = c.Errorf("%s", err)
+		c.ILogf("%s", err)
+		return nil, nil, nil, nil, &ConnectError{Kind: ConnectErrorRejected, Err: err}
+	}
+	for _, f := range reply.FailedRemotes {
+		c.ILogf("Server was unable to start reverse remote #%d \"%s\": %s", f.Index+1, f.Descriptor, f.Error)
+	}
+	if c.channelType != ChannelTypeWstunnel {
+		ok, reply, err := sshConn.SendRequest(ChannelTypeRequest, true, []byte(c.channelType))
+		if err == nil && !ok {
+			err = fmt.Errorf("%s", reply)
 		}
-		c.ILogf("Connected (Latency %s)", time.Since(t0))
-		//connected
-		b.Reset()
-		go ssh.DiscardRequests(reqs)
-		c.sshConn = sshConn
+		if err != nil {
+			sshConn.Close()
+			err = c.Errorf("Server refused channel type \"%s\": %s", c.channelType, err)
+			c.ILogf("%s", err)
+			return nil, nil, nil, nil, &ConnectError{Kind: ConnectErrorRejected, Err: err}
+		}
+	}
+	c.ILogf("Connected (Latency %s)", time.Since(t0))
+	return sshConn, chans, reqs, reply, nil
+}
 
-		// wake up anyone waiting for our ssh connection to be ready
-		close(c.sshConnReady)
+// checkAllowedRemotes asks the server which remotes the authenticated user may configure, and
+// returns an error if any of the client's remotes would be denied
+func (c *Client) checkAllowedRemotes(sshConn ssh.Conn) error {
+	c.DLogf("Requesting allowed remotes")
+	ok, payload, err := sshConn.SendRequest(AllowedRemotesRequest, true, nil)
+	if err == nil && !ok {
+		err = fmt.Errorf("%s", payload)
+	}
+	if err != nil {
+		return c.Errorf("Unable to get allowed remotes from server: %s", err)
+	}
+	allowed := &AllowedRemotes{}
+	err = allowed.Unmarshal(payload)
+	if err != nil {
+		return c.Errorf("%s", err)
+	}
+	c.DLogf("Allowed remotes: all=%t patterns=%q", allowed.AllowAll, allowed.Patterns)
+	c.sharedLock.Lock()
+	err = allowed.Check(c.config.shared.ChannelDescriptors)
+	c.sharedLock.Unlock()
+	if err != nil {
+		return c.Errorf("%s", err)
+	}
+	return nil
+}
 
-		go c.connectStreams(ctx, chans)
-		err = sshConn.Wait()
+// ReconfigureReverse replaces the client's reverse remotes with those in chdStrings, without
+// reconnecting; forward remotes are not affected. The server starts the added remotes and stops
+// the removed ones, and reports what it did. The new set (less any remotes the server could not
+// start) is also used for later reconnects. Fails if the client is not connected before ctx is
+// done, or if the server does not support ReverseConfigRequest.
+func (c *Client) ReconfigureReverse(ctx context.Context, chdStrings []string) (*ReverseConfigReply, error) {
+	if c.config.ForwardOnly {
+		return nil, c.Errorf("Reverse remotes cannot be configured on a forward-only client")
+	}
+	rc := &ReverseConfig{}
+	for _, s := range chdStrings {
+		chd, _, err := ParseChannelDescriptorPathWithDefaults(s, c.config.DescriptorDefaults)
+		if err != nil {
+			return nil, c.Errorf("Failed to parse channel descriptor string '%s': %s", s, err)
+		}
+		if !chd.Reverse {
+			return nil, c.Errorf("Remote '%s' is not a reverse remote", s)
+		}
+		rc.ChannelDescriptors = append(rc.ChannelDescriptors, &chd)
+	}
 
-		//disconnected
+	// wait for a connection before locking the session config, which the handshake needs
+	sshConn, err := c.GetSSHConnContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c.sharedLock.Lock()
+	defer c.sharedLock.Unlock()
+	var chds []*ChannelDescriptor
+	for _, chd := range c.config.shared.ChannelDescriptors {
+		if !chd.Reverse {
+			chds = append(chds, chd)
+		}
+	}
+	chds = append(chds, rc.ChannelDescriptors...)
+	err = ValidateChannelDescriptors(chds)
+	if err != nil {
+		return nil, c.Errorf("Invalid channel descriptors: %s", err)
+	}
+
+	payload, err := rc.Marshal()
+	if err != nil {
+		return nil, c.Errorf("Failed to encode reverse config: %s", err)
+	}
+	ok, replyPayload, err := sshConn.SendRequest(ReverseConfigRequest, true, payload)
+	if err == nil && !ok {
+		err = fmt.Errorf("%s", replyPayload)
+	}
+	if err != nil {
+		return nil, c.Errorf("Server refused reverse config: %s", err)
+	}
+	reply := &ReverseConfigReply{}
+	err = reply.Unmarshal(replyPayload)
+	if err != nil {
+		return nil, c.Errorf("%s", err)
+	}
+
+	failed := make(map[string]bool)
+	for _, f := range reply.FailedRemotes {
+		c.ILogf("Server was unable to start reverse remote \"%s\": %s", f.Descriptor, f.Error)
+		failed[f.Descriptor] = true
+	}
+	c.ILogf("Reverse remotes reconfigured: %d started, %d stopped, %d unchanged, %d failed",
+		len(reply.Started), len(reply.Stopped), len(reply.Unchanged), len(reply.FailedRemotes))
+	chds = chds[:len(chds)-len(rc.ChannelDescriptors)]
+	for _, chd := range rc.ChannelDescriptors {
+		if !failed[chd.String()] {
+			chds = append(chds, chd)
+		}
+	}
+	c.config.shared.ChannelDescriptors = chds
+	return reply, nil
+}
 
-		// sammck: it is *not* ok to reset c.sshConn to nil after we have stub endpoints running
-		//    The safest thing is to shut down here
-		// c.sshConn = nil
-		// if err != nil && err != io.EOF {
-		//   connerr = err
-		//   continue
-		//   }
-		c.ILogf("Disconnected\n")
-		c.Shutdown(c.Errorf("Proxy Server disconnected"))
+// dialServerTCP establishes a TCP connection to addr (the server), through the configured
+// SOCKS5 or HTTP CONNECT proxy if any
+func (c *Client) dialServerTCP(network, addr string) (net.Conn, error) {
+	timeout := 45 * time.Second
+	if c.socksProxyDial != nil {
+		return c.socksProxyDial(network, addr)
+	}
+	if c.httpProxyURL != nil {
+		return DialHTTPProxyConnect(c.httpProxyURL, c.httpProxyHdr, addr, timeout)
+	}
+	return net.DialTimeout(network, addr, timeout)
+}
 
-		break
+// dialWebsocket opens a websocket to the server, through the configured proxy if any
+func (c *Client) dialWebsocket() (net.Conn, error) {
+	d := websocket.Dialer{
+		ReadBufferSize:   1024,
+		WriteBufferSize:  1024,
+		HandshakeTimeout: 45 * time.Second,
+		Subprotocols:     []string{ProtocolVersion},
+		TLSClientConfig:  c.tlsConfig,
+	}
+	//optionally SOCKS5 or CONNECT proxy
+	if c.socksProxyDial != nil {
+		d.NetDial = c.socksProxyDial
+	} else if c.httpProxyHdr != nil {
+		// explicit auth scheme or custom headers; issue the CONNECT ourselves
+		d.NetDial = func(network, addr string) (net.Conn, error) {
+			return DialHTTPProxyConnect(c.httpProxyURL, c.httpProxyHdr, addr, d.HandshakeTimeout)
+		}
+	} else if c.httpProxyURL != nil {
+		d.Proxy = func(*http.Request) (*url.URL, error) {
+			return c.httpProxyURL, nil
+		}
+	}
+	wsHeaders := c.handshakeHeaders()
+	if c.config.HostHeader != "" {
+		wsHeaders.Set("Host", c.config.HostHeader)
+	}
+	wsConn, resp, err := d.Dial(c.server, wsHeaders)
+	if err != nil {
+		return nil, withRetryAfter(err, resp)
+	}
+	return NewWebSocketConn(wsConn), nil
+}
+
+// PendingShutdownChildren describes the local proxies and SSH connection that have not finished
+// shutting down. Implements PendingShutdownReporter for ShutdownWithTimeout.
+func (c *Client) PendingShutdownChildren() []string {
+	var result []string
+	for _, proxy := range c.getProxies() {
+		if !proxy.IsDoneShutdown() {
+			result = append(result, proxy.String())
+		}
+	}
+	if sshConn, _, _ := c.getSSHConnStatus(); sshConn != nil {
+		result = append(result, "SSH connection to "+c.server)
+	}
+	return result
+}
+
+// ForceClose closes the SSH connection to the server, if any, unblocking any channels still
+// bridging traffic, and force closes the local proxies and their listeners. Implements ForceCloser
+// for ShutdownWithTimeout.
+func (c *Client) ForceClose() error {
+	var err error
+	if sshConn, _, _ := c.getSSHConnStatus(); sshConn != nil {
+		err = sshConn.Close()
+	}
+	if cerr := c.closeGenerations(); cerr != nil && err == nil {
+		err = cerr
+	}
+	c.shutdownProxies.forceCloseAll()
+	return err
+}
+
+// handleSSHRequests services global SSH requests from the server for a single connection. A
+// DisconnectRequest is passed to disconnectReasonc so the connection loop can report why the
+// server ended the session; all other requests are refused.
+func (c *Client) handleSSHRequests(reqs <-chan *ssh.Request, disconnectReasonc chan<- *DisconnectReason) {
+	for req := range reqs {
+		if req.Type == DisconnectRequest {
+			reason := &DisconnectReason{}
+			err := reason.Unmarshal(req.Payload)
+			if err != nil {
+				c.DLogf("Ignoring malformed disconnect request: %s", err)
+			} else {
+				c.DLogf("Received disconnect request: %s", reason)
+				select {
+				case disconnectReasonc <- reason:
+				default:
+				}
+			}
+		}
+		if req.WantReply {
+			req.Reply(false, nil)
+		}
 	}
-	c.Close()
 }
 
 // HandleOnceShutdown will be called exactly once, in its own goroutine. It should take completionError
 // as an advisory completion value, actually shut down, then return the real completion value.
 func (c *Client) HandleOnceShutdown(completionErr error) error {
 	var err error
-	if c.sshConn != nil {
-		err = c.sshConn.Close()
+	if sshConn, _, _ := c.getSSHConnStatus(); sshConn != nil {
+		err = sshConn.Close()
 	}
+	// old connections that are still draining
+	c.closeGenerations()
+	c.shutdownProxies.shutdownAll(completionErr)
+	unregisterMetrics(c.metricsName)
 	if completionErr == nil {
 		completionErr = err
 	}
 	return completionErr
 }
 
-func (c *Client) connectStreams(ctx context.Context, chans <-chan ssh.NewChannel) {
+func (c *Client) connectStreams(ctx context.Context, sshPrimaryConn ssh.Conn, chans <-chan ssh.NewChannel) {
 	for ch := range chans {
 		reject := func(reason ssh.RejectionReason, err error) error {
 			c.DLogf("Sending SSH NewChannel rejection (reason=%v): %s", reason, err)
@@ -357,6 +1341,11 @@ func (c *Client) connectStreams(ctx context.Context, chans <-chan ssh.NewChannel
 			return err
 		}
 
+		if !isAcceptableChannelType(ch.ChannelType(), c.channelType) {
+			reject(ssh.UnknownChannelType, c.Errorf("Unknown channel type \"%s\"; expected \"%s\"", ch.ChannelType(), c.channelType))
+			continue
+		}
+
 		epdJSON := ch.ExtraData()
 		epd := &ChannelEndpointDescriptor{}
 		err := json.Unmarshal(epdJSON, &epd)
@@ -375,6 +1364,7 @@ func (c *Client) connectStreams(ctx context.Context, chans <-chan ssh.NewChannel
 
 		ep, err := NewLocalSkeletonChannelEndpoint(c.Logger, c, epd)
 		if err != nil {
+			c.metrics.DialError()
 			reject(ssh.Prohibited, c.Errorf("Failed to create skeleton endpoint for SSH NewChannel: %s", err))
 			continue
 		}
@@ -386,6 +1376,7 @@ func (c *Client) connectStreams(ctx context.Context, chans <-chan ssh.NewChannel
 		sshChannel, reqs, err := ch.Accept()
 		if err != nil {
 			c.DLogf("Failed to accept remote SSH Channel: %s", err)
+			c.metrics.AcceptError()
 			continue
 		}
 
@@ -403,11 +1394,19 @@ func (c *Client) connectStreams(ctx context.Context, chans <-chan ssh.NewChannel
 
 		// sshChannel is now wrapped by sshConn, and will be closed when sshConn is closed
 
-		var extraData []byte
+		c.metrics.Channels.New()
+		c.metrics.Channels.Open()
+
+		channelDone := c.trackChannel(sshPrimaryConn)
+		extraData := ExtractChannelMetadata(epdJSON)
 		numSent, numReceived, err := ep.DialAndServe(ctx, sshConn, extraData)
+		channelDone()
 
 		// sshConn and sshChannel have now been closed
 
+		c.metrics.Channels.Close()
+		c.metrics.recordChannelEnd(numSent, numReceived, err)
+
 		if err != nil {
 			c.DLogf("NewChannel session ended with error after %d bytes (caller->called), %d bytes (called->caller): %s", numSent, numReceived, err)
 		} else {
@@ -0,0 +1,128 @@
+package chshare
+
+import (
+	"sync"
+)
+
+// ClientConnState is the state of a client's connection to the server
+type ClientConnState int
+
+const (
+	// ClientConnecting means the client is connecting to the server
+	ClientConnecting ClientConnState = iota
+
+	// ClientConnected means the client is connected, and the session config has been accepted
+	ClientConnected
+
+	// ClientRetrying means a connection attempt failed, or the connection was lost, and the
+	// client will try again after a delay
+	ClientRetrying
+
+	// ClientFailed means the client has given up; this is the final state
+	ClientFailed
+
+	// ClientStopped means the client was shut down; this is the final state
+	ClientStopped
+)
+
+func (s ClientConnState) String() string {
+	switch s {
+	case ClientConnecting:
+		return "connecting"
+	case ClientConnected:
+		return "connected"
+	case ClientRetrying:
+		return "retrying"
+	case ClientFailed:
+		return "failed"
+	case ClientStopped:
+		return "stopped"
+	default:
+		return "unknown"
+	}
+}
+
+// ClientState describes a change in a client's connection state, for Config.StateChange
+type ClientState struct {
+	// State is the new connection state
+	State ClientConnState
+
+	// Attempt is the number of consecutive failed connection attempts so far (0 once connected)
+	Attempt int
+
+	// Err is the error that caused the change, for ClientRetrying and ClientFailed; nil otherwise
+	Err error
+}
+
+// stateNotifier calls a state change callback with each state in order, one at a time, on its
+// own goroutine, without blocking the notifier
+type stateNotifier struct {
+	f       func(ClientState)
+	lock    sync.Mutex
+	queue   []ClientState
+	running bool
+}
+
+// newStateNotifier returns a stateNotifier for f, or nil if f is nil
+func newStateNotifier(f func(ClientState)) *stateNotifier {
+	if f == nil {
+		return nil
+	}
+	return &stateNotifier{f: f}
+}
+
+func (n *stateNotifier) notify(state ClientState) {
+	n.lock.Lock()
+	n.queue = append(n.queue, state)
+	if n.running {
+		n.lock.Unlock()
+		return
+	}
+	n.running = true
+	n.lock.Unlock()
+	go n.run()
+}
+
+func (n *stateNotifier) run() {
+	for {
+		n.lock.Lock()
+		if len(n.queue) == 0 {
+			n.running = false
+			n.lock.Unlock()
+			return
+		}
+		state := n.queue[0]
+		n.queue = n.queue[1:]
+		n.lock.Unlock()
+		n.f(state)
+	}
+}
+
+// notifyState reports a connection state change to Config.StateChange, if set
+func (c *Client) notifyState(state ClientConnState, attempt int, err error) {
+	cs := ClientState{State: state, Attempt: attempt, Err: err}
+	c.stateLock.Lock()
+	c.state = cs
+	if err != nil {
+		c.lastErr = err
+	}
+	c.stateLock.Unlock()
+	if c.stateNotifier == nil {
+		return
+	}
+	c.stateNotifier.notify(cs)
+}
+
+// Status returns a snapshot of the client's connection state and traffic, for a status file (see
+// StartStatusFile)
+func (c *Client) Status() *Status {
+	c.stateLock.Lock()
+	state := c.state.State
+	lastErr := c.lastErr
+	c.stateLock.Unlock()
+	st := newStatus("client", state.String(), &c.metrics)
+	if lastErr != nil {
+		st.LastError = lastErr.Error()
+	}
+	return st
+}
@@ -0,0 +1,87 @@
+package chshare
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestStateNotifier(t *testing.T) {
+	if newStateNotifier(nil) != nil {
+		t.Errorf("newStateNotifier(nil) is not nil")
+	}
+
+	got := make(chan ClientState, 10)
+	release := make(chan struct{})
+	n := newStateNotifier(func(s ClientState) {
+		<-release
+		got <- s
+	})
+	lost := errors.New("lost")
+	want := []ClientState{
+		{State: ClientConnecting},
+		{State: ClientConnected},
+		{State: ClientRetrying, Attempt: 1, Err: lost},
+		{State: ClientStopped},
+	}
+	// notify must not block on a slow callback
+	for _, s := range want {
+		n.notify(s)
+	}
+	close(release)
+	for i, w := range want {
+		select {
+		case s := <-got:
+			if s != w {
+				t.Errorf("State #%d = %+v, want %+v", i, s, w)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("State #%d was not delivered", i)
+		}
+	}
+}
+
+func TestClientRetryingAttempts(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	got := make(chan ClientState, 20)
+	c, err := NewClient(&Config{
+		// nothing listens here, so every attempt fails
+		Server:        fmt.Sprintf("http://127.0.0.1:%d", testLocalPort(t)),
+		ChdStrings:    []string{fmt.Sprintf("127.0.0.1:%d:127.0.0.1:80", testLocalPort(t))},
+		MaxRetryCount: 2,
+		StateChange:   func(s ClientState) { got <- s },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if err := c.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []struct {
+		state   ClientConnState
+		attempt int
+	}{
+		{ClientConnecting, 0},
+		{ClientRetrying, 1},
+		{ClientConnecting, 1},
+		{ClientRetrying, 2},
+		{ClientConnecting, 2},
+		{ClientFailed, 3},
+	}
+	for i, w := range want {
+		select {
+		case s := <-got:
+			if s.State != w.state || s.Attempt != w.attempt {
+				t.Errorf("State #%d = %s (attempt %d), want %s (attempt %d)", i, s.State, s.Attempt, w.state, w.attempt)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatalf("State #%d was not delivered", i)
+		}
+	}
+}
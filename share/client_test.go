@@ -0,0 +1,219 @@
+package chshare
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestClientStopRemote(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	echoPort, stopEcho := testEchoService(t)
+	defer stopEcho()
+
+	s, err := NewServer(&ProxyServerConfig{KeySeed: "stop-remote-test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	serverPort := testLocalPort(t)
+	go s.Run(ctx, "127.0.0.1", strconv.Itoa(serverPort))
+
+	stoppedAddr := "127.0.0.1:" + strconv.Itoa(testLocalPort(t))
+	keptAddr := "127.0.0.1:" + strconv.Itoa(testLocalPort(t))
+	c, err := NewClient(&Config{
+		Server:      fmt.Sprintf("http://127.0.0.1:%d", serverPort),
+		Fingerprint: s.GetFingerprint(),
+		ChdStrings: []string{
+			fmt.Sprintf("%s:127.0.0.1:%d", stoppedAddr, echoPort),
+			fmt.Sprintf("%s:127.0.0.1:%d", keptAddr, echoPort),
+		},
+		MaxRetryCount: -1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	waitCtx, waitCancel := context.WithTimeout(ctx, 20*time.Second)
+	defer waitCancel()
+	if _, err := c.GetSSHConnContext(waitCtx); err != nil {
+		t.Fatalf("Client did not connect: %s", err)
+	}
+	testEcho(t, stoppedAddr, "before stop")
+	testEcho(t, keptAddr, "before stop")
+
+	if err := c.StopRemote(fmt.Sprintf("%s:127.0.0.1:%d", stoppedAddr, echoPort)); err != nil {
+		t.Fatalf("StopRemote() returned error: %s", err)
+	}
+	if conn, err := net.DialTimeout("tcp4", stoppedAddr, time.Second); err == nil {
+		conn.Close()
+		t.Errorf("Stopped remote still accepts connections")
+	}
+	testEcho(t, keptAddr, "after stop")
+	if n := len(c.getProxies()); n != 1 {
+		t.Errorf("Client has %d local proxies after StopRemote, want 1", n)
+	}
+	c.sharedLock.Lock()
+	n := len(c.config.shared.ChannelDescriptors)
+	c.sharedLock.Unlock()
+	if n != 1 {
+		t.Errorf("Session config has %d remotes after StopRemote, want 1", n)
+	}
+	if err := c.StopRemote(fmt.Sprintf("%s:127.0.0.1:%d", stoppedAddr, echoPort)); err == nil {
+		t.Errorf("StopRemote() of a stopped remote did not return an error")
+	}
+
+	closed := make(chan struct{})
+	go func() {
+		c.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-waitCtx.Done():
+		t.Fatalf("Client did not shut down after StopRemote")
+	}
+	if conn, err := net.DialTimeout("tcp4", keptAddr, time.Second); err == nil {
+		conn.Close()
+		t.Errorf("Remaining remote still accepts connections after the client shut down")
+	}
+}
+
+func TestClientRetryTiming(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	clock := NewMockClock(time.Now())
+	got := make(chan ClientState, 20)
+	c, err := NewClient(&Config{
+		// nothing listens here, so every attempt fails
+		Server:        fmt.Sprintf("http://127.0.0.1:%d", testLocalPort(t)),
+		ChdStrings:    []string{fmt.Sprintf("127.0.0.1:%d:127.0.0.1:80", testLocalPort(t))},
+		MaxRetryCount: 2,
+		StateChange:   func(s ClientState) { got <- s },
+		Clock:         clock,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	expectState := func(state ClientConnState, attempt int) {
+		select {
+		case s := <-got:
+			if s.State != state || s.Attempt != attempt {
+				t.Fatalf("State = %s (attempt %d), want %s (attempt %d)", s.State, s.Attempt, state, attempt)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatalf("State %s (attempt %d) was not delivered", state, attempt)
+		}
+	}
+	if err := c.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	expectState(ClientConnecting, 0)
+	// the reconnect backoff starts at 100ms, and doubles after each failed attempt
+	for attempt, delay := 1, 100*time.Millisecond; attempt <= 2; attempt, delay = attempt+1, delay*2 {
+		expectState(ClientRetrying, attempt)
+		waitForTimers(t, clock, 1)
+		clock.Advance(delay - time.Millisecond)
+		select {
+		case s := <-got:
+			t.Fatalf("State %s (attempt %d) delivered before the %s backoff elapsed", s.State, s.Attempt, delay)
+		case <-time.After(20 * time.Millisecond):
+		}
+		clock.Advance(time.Millisecond)
+		expectState(ClientConnecting, attempt)
+	}
+	expectState(ClientFailed, 3)
+}
+
+// unansweredSSHConn is an ssh.Conn whose pings are never answered; SendRequest blocks until it is
+// closed
+type unansweredSSHConn struct {
+	ssh.Conn
+	pings     chan struct{}
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func (c *unansweredSSHConn) SendRequest(name string, wantReply bool, payload []byte) (bool, []byte, error) {
+	c.pings <- struct{}{}
+	<-c.closed
+	return false, nil, io.EOF
+}
+
+func (c *unansweredSSHConn) Close() error {
+	c.closeOnce.Do(func() { close(c.closed) })
+	return nil
+}
+
+func TestClientKeepAliveTiming(t *testing.T) {
+	clock := NewMockClock(time.Now())
+	c, err := NewClient(&Config{
+		Server:               fmt.Sprintf("http://127.0.0.1:%d", testLocalPort(t)),
+		ChdStrings:           []string{fmt.Sprintf("127.0.0.1:%d:127.0.0.1:80", testLocalPort(t))},
+		KeepAlive:            30 * time.Second,
+		KeepAliveMaxFailures: 2,
+		Clock:                clock,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	conn := &unansweredSSHConn{pings: make(chan struct{}, 10), closed: make(chan struct{})}
+	c.setSSHConnReady(conn, nil)
+	go c.keepAliveLoop()
+
+	expectPing := func(want bool) {
+		select {
+		case <-conn.pings:
+			if !want {
+				t.Fatalf("Keepalive ping sent early")
+			}
+		case <-time.After(20 * time.Millisecond):
+			if want {
+				t.Fatalf("Keepalive ping was not sent")
+			}
+		}
+	}
+	waitForTimers(t, clock, 1)
+	clock.Advance(29 * time.Second)
+	expectPing(false)
+	clock.Advance(time.Second)
+	expectPing(true)
+
+	// the time spent waiting for the reply counts toward the next interval, so after the first
+	// ping times out the second is sent right away
+	waitForTimers(t, clock, 1)
+	clock.Advance(29 * time.Second)
+	expectPing(false)
+	clock.Advance(time.Second)
+	expectPing(true)
+
+	// the connection is closed once KeepAliveMaxFailures consecutive pings have timed out
+	waitForTimers(t, clock, 1)
+	clock.Advance(29 * time.Second)
+	select {
+	case <-conn.closed:
+		t.Fatalf("Connection closed before the second ping timed out")
+	case <-time.After(20 * time.Millisecond):
+	}
+	clock.Advance(time.Second)
+	select {
+	case <-conn.closed:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Connection was not closed after 2 unanswered pings")
+	}
+}
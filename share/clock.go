@@ -0,0 +1,159 @@
+package chshare
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock is the source of time for the client's reconnect backoff, keepalive pings and reconnect
+// drain grace, so that tests can control the passing of time (see MockClock). RealClock is used
+// unless Config.Clock is set.
+type Clock interface {
+	// Now returns the current time
+	Now() time.Time
+
+	// NewTimer creates a Timer that fires once, after d
+	NewTimer(d time.Duration) Timer
+
+	// After waits for d to elapse, then sends the current time on the returned channel
+	After(d time.Duration) <-chan time.Time
+}
+
+// Timer is a single-shot timer created by a Clock, with the semantics of time.Timer
+type Timer interface {
+	// C returns the channel on which the time is delivered when the timer fires
+	C() <-chan time.Time
+
+	// Stop prevents the timer from firing. Returns false if it had already fired or been stopped.
+	Stop() bool
+
+	// Reset changes the timer to fire after d. Returns true if it had been active.
+	Reset(d time.Duration) bool
+}
+
+// RealClock is the Clock that uses the time package
+var RealClock Clock = realClock{}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) NewTimer(d time.Duration) Timer {
+	return realTimer{time.NewTimer(d)}
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+type realTimer struct {
+	*time.Timer
+}
+
+func (t realTimer) C() <-chan time.Time {
+	return t.Timer.C
+}
+
+// MockClock is a Clock for testing, whose time only moves when advanced with Advance. Timers fire,
+// in order of their deadlines, as Advance reaches them. It is safe for concurrent use.
+type MockClock struct {
+	lock   sync.Mutex
+	now    time.Time
+	timers []*mockTimer
+}
+
+// NewMockClock creates a MockClock whose time starts at now
+func NewMockClock(now time.Time) *MockClock {
+	return &MockClock{now: now}
+}
+
+// Now returns the mock time
+func (c *MockClock) Now() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.now
+}
+
+// NewTimer creates a Timer that fires once Advance has moved the mock time on by d
+func (c *MockClock) NewTimer(d time.Duration) Timer {
+	t := &mockTimer{clock: c, c: make(chan time.Time, 1)}
+	t.Reset(d)
+	return t
+}
+
+// After returns a channel that receives the mock time once Advance has moved it on by d
+func (c *MockClock) After(d time.Duration) <-chan time.Time {
+	return c.NewTimer(d).C()
+}
+
+// Advance moves the mock time on by d, firing the timers whose deadlines are reached
+func (c *MockClock) Advance(d time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.now = c.now.Add(d)
+	c.fireLocked()
+}
+
+// PendingTimers returns the number of timers that have not yet fired or been stopped, so that a
+// test can wait until the code under test is waiting on the clock before advancing it
+func (c *MockClock) PendingTimers() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return len(c.timers)
+}
+
+// fireLocked fires and removes the timers whose deadlines have been reached. c.lock must be held.
+func (c *MockClock) fireLocked() {
+	sort.SliceStable(c.timers, func(i, j int) bool { return c.timers[i].deadline.Before(c.timers[j].deadline) })
+	n := 0
+	for n < len(c.timers) && !c.timers[n].deadline.After(c.now) {
+		select {
+		case c.timers[n].c <- c.now:
+		default:
+		}
+		n++
+	}
+	c.timers = c.timers[n:]
+}
+
+// removeLocked removes t from the pending timers, returning true if it was pending. c.lock must be
+// held.
+func (c *MockClock) removeLocked(t *mockTimer) bool {
+	for i, other := range c.timers {
+		if other == t {
+			c.timers = append(c.timers[:i], c.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+type mockTimer struct {
+	clock    *MockClock
+	c        chan time.Time
+	deadline time.Time
+}
+
+func (t *mockTimer) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *mockTimer) Stop() bool {
+	t.clock.lock.Lock()
+	defer t.clock.lock.Unlock()
+	return t.clock.removeLocked(t)
+}
+
+func (t *mockTimer) Reset(d time.Duration) bool {
+	c := t.clock
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	active := c.removeLocked(t)
+	t.deadline = c.now.Add(d)
+	c.timers = append(c.timers, t)
+	c.fireLocked()
+	return active
+}
@@ -0,0 +1,103 @@
+package chshare
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMockClockTimers(t *testing.T) {
+	start := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	c := NewMockClock(start)
+	t1 := c.NewTimer(2 * time.Second)
+	t2 := c.NewTimer(time.Second)
+	after := c.After(3 * time.Second)
+	if n := c.PendingTimers(); n != 3 {
+		t.Fatalf("PendingTimers() = %d, want 3", n)
+	}
+
+	c.Advance(time.Second)
+	if got := c.Now(); !got.Equal(start.Add(time.Second)) {
+		t.Errorf("Now() = %s, want %s", got, start.Add(time.Second))
+	}
+	select {
+	case <-t2.C():
+	default:
+		t.Errorf("Timer for 1s did not fire after 1s")
+	}
+	select {
+	case <-t1.C():
+		t.Errorf("Timer for 2s fired after 1s")
+	default:
+	}
+
+	if !t1.Stop() {
+		t.Errorf("Stop() of a pending timer returned false")
+	}
+	if t2.Stop() {
+		t.Errorf("Stop() of a fired timer returned true")
+	}
+	if t1.Reset(5 * time.Second) {
+		t.Errorf("Reset() of a stopped timer returned true")
+	}
+
+	c.Advance(2 * time.Second)
+	select {
+	case got := <-after:
+		if !got.Equal(start.Add(3 * time.Second)) {
+			t.Errorf("After(3s) delivered %s, want %s", got, start.Add(3*time.Second))
+		}
+	default:
+		t.Errorf("After(3s) did not fire after 3s")
+	}
+	select {
+	case <-t1.C():
+		t.Errorf("Reset timer fired early")
+	default:
+	}
+
+	c.Advance(3 * time.Second)
+	select {
+	case <-t1.C():
+	default:
+		t.Errorf("Reset timer did not fire")
+	}
+	if n := c.PendingTimers(); n != 0 {
+		t.Errorf("PendingTimers() = %d after all fired, want 0", n)
+	}
+}
+
+func TestSleepSignalClock(t *testing.T) {
+	c := NewMockClock(time.Now())
+	done := make(chan struct{})
+	go func() {
+		sleepSignalClock(c, 5*time.Second)
+		close(done)
+	}()
+	for c.PendingTimers() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	c.Advance(4 * time.Second)
+	select {
+	case <-done:
+		t.Fatalf("Sleep of 5s ended after 4s")
+	case <-time.After(10 * time.Millisecond):
+	}
+	c.Advance(time.Second)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Sleep of 5s did not end after 5s")
+	}
+}
+
+// waitForTimers waits until c has n pending timers, i.e., until the code under test is waiting on
+// the clock, so that advancing it fires the expected timers
+func waitForTimers(t *testing.T, c *MockClock, n int) {
+	deadline := time.Now().Add(5 * time.Second)
+	for c.PendingTimers() != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d timers pending, want %d", c.PendingTimers(), n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,168 @@
+package chshare
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// recordingConn records the bytes read from and written to a net.Conn
+type recordingConn struct {
+	net.Conn
+	lock    sync.Mutex
+	read    bytes.Buffer
+	written bytes.Buffer
+}
+
+func (c *recordingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.lock.Lock()
+	c.read.Write(b[:n])
+	c.lock.Unlock()
+	return n, err
+}
+
+func (c *recordingConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.lock.Lock()
+	c.written.Write(b[:n])
+	c.lock.Unlock()
+	return n, err
+}
+
+// recorded returns copies of the bytes read and written so far
+func (c *recordingConn) recorded() (read []byte, written []byte) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return append([]byte(nil), c.read.Bytes()...), append([]byte(nil), c.written.Bytes()...)
+}
+
+// parseKexInitCompressions returns the client-to-server and server-to-client compression
+// algorithms listed in the KEXINIT message that starts an SSH stream, after the version line
+func parseKexInitCompressions(stream []byte) (c2s []string, s2c []string, err error) {
+	r := bufio.NewReader(bytes.NewReader(stream))
+	version, err := r.ReadString('\n')
+	if err != nil || !strings.HasPrefix(version, "SSH-") {
+		return nil, nil, fmt.Errorf("Missing SSH version line: %q", version)
+	}
+	var header [5]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, nil, err
+	}
+	packetLen := binary.BigEndian.Uint32(header[:4])
+	payload := make([]byte, int(packetLen)-1-int(header[4]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, nil, err
+	}
+	// message type, then a 16-byte cookie
+	if len(payload) < 17 || payload[0] != 20 {
+		return nil, nil, fmt.Errorf("First packet is not a KEXINIT")
+	}
+	payload = payload[17:]
+	var lists [][]string
+	for i := 0; i < 8; i++ {
+		if len(payload) < 4 {
+			return nil, nil, fmt.Errorf("Truncated KEXINIT")
+		}
+		n := binary.BigEndian.Uint32(payload)
+		if uint32(len(payload)-4) < n {
+			return nil, nil, fmt.Errorf("Truncated KEXINIT")
+		}
+		lists = append(lists, strings.Split(string(payload[4:4+n]), ","))
+		payload = payload[4+n:]
+	}
+	// kex, host key, ciphers and MACs (each direction) precede the compressions
+	return lists[6], lists[7], nil
+}
+
+// negotiatedAlgorithm returns the first client algorithm that the server also supports, as SSH
+// key exchange chooses it
+func negotiatedAlgorithm(client []string, server []string) string {
+	for _, c := range client {
+		for _, s := range server {
+			if c == s {
+				return c
+			}
+		}
+	}
+	return ""
+}
+
+func TestSessionCompressionIsNone(t *testing.T) {
+	s, err := NewServer(&ProxyServerConfig{KeySeed: "compression-test", NoLoop: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	// both ends send their version line before reading, so the connection must be buffered
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		serverConn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		s.handleSSHConn(context.Background(), serverConn, func() {})
+	}()
+	clientConn, err := net.Dial("tcp4", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := &recordingConn{Conn: clientConn}
+	defer conn.Close()
+
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, l.Addr().String(), &ssh.ClientConfig{
+		User:            "test",
+		Auth:            []ssh.AuthMethod{ssh.Password("")},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		ClientVersion:   "SSH-" + ProtocolVersion + "-client",
+		Timeout:         10 * time.Second,
+	})
+	if err != nil {
+		t.Fatalf("SSH handshake failed: %s", err)
+	}
+	go ssh.DiscardRequests(reqs)
+	go func() {
+		for ch := range chans {
+			ch.Reject(ssh.Prohibited, "unexpected channel")
+		}
+	}()
+	read, written := conn.recorded()
+	sshConn.Close()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Session did not end when the client closed")
+	}
+
+	serverC2S, serverS2C, err := parseKexInitCompressions(read)
+	if err != nil {
+		t.Fatalf("Unable to parse the server's KEXINIT: %s", err)
+	}
+	clientC2S, clientS2C, err := parseKexInitCompressions(written)
+	if err != nil {
+		t.Fatalf("Unable to parse the client's KEXINIT: %s", err)
+	}
+	if got := negotiatedAlgorithm(clientC2S, serverC2S); got != "none" {
+		t.Errorf("Negotiated client-to-server compression = %q, want \"none\" (client %v, server %v)", got, clientC2S, serverC2S)
+	}
+	if got := negotiatedAlgorithm(clientS2C, serverS2C); got != "none" {
+		t.Errorf("Negotiated server-to-client compression = %q, want \"none\" (client %v, server %v)", got, clientS2C, serverS2C)
+	}
+}
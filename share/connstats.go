@@ -29,3 +29,13 @@ func (c *ConnStats) Close() {
 func (c *ConnStats) String() string {
 	return fmt.Sprintf("[%d/%d]", atomic.LoadInt32(&c.open), atomic.LoadInt32(&c.count))
 }
+
+// Total returns the total connection count
+func (c *ConnStats) Total() int32 {
+	return atomic.LoadInt32(&c.count)
+}
+
+// Active returns the current open connection count
+func (c *ConnStats) Active() int32 {
+	return atomic.LoadInt32(&c.open)
+}
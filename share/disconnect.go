@@ -0,0 +1,77 @@
+package chshare
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// DisconnectRequest is the SSH global request type sent by the server, immediately before it
+// closes a session, to tell the client why. The payload is a JSON-encoded DisconnectReason. The
+// request does not want a reply; clients that do not understand it simply ignore it.
+const DisconnectRequest = "disconnect"
+
+// DisconnectCode is a machine-readable classification of why a server ended a session. A session
+// that ends with a CodedError (see Errorcf) is reported to the client with the error's code.
+type DisconnectCode string
+
+const (
+	// DisconnectCodeDraining means the server is shutting down or restarting; the client should
+	// reconnect later, after a short delay
+	DisconnectCodeDraining DisconnectCode = "draining"
+
+	// DisconnectCodeLimit means a server-side limit was exceeded
+	DisconnectCodeLimit DisconnectCode = "limit"
+
+	// DisconnectCodeAuthRevoked means the session's user credentials are no longer valid
+	DisconnectCodeAuthRevoked DisconnectCode = "auth-revoked"
+
+	// DisconnectCodeError means the session failed for some other reason
+	DisconnectCodeError DisconnectCode = "error"
+)
+
+// DrainReconnectDelay is the minimum time a client waits before reconnecting after the server
+// disconnected it with DisconnectCodeDraining, to give the server time to go away and come back.
+var DrainReconnectDelay = 5 * time.Second
+
+// DisconnectReason describes why a server ended a session. It implements error, so it can be
+// used as the completion error of a session (on the server) or of a connection (on the client).
+type DisconnectReason struct {
+	// Code is the machine-readable reason
+	Code DisconnectCode `json:"code"`
+
+	// Message is a human-readable description
+	Message string `json:"message"`
+}
+
+// NewDisconnectReason creates a DisconnectReason
+func NewDisconnectReason(code DisconnectCode, format string, args ...interface{}) *DisconnectReason {
+	return &DisconnectReason{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+func (r *DisconnectReason) Error() string {
+	return fmt.Sprintf("Server disconnected (%s): %s", r.Code, r.Message)
+}
+
+// ReconnectLater returns true if the disconnect was a normal, temporary condition, and the
+// client should wait a bit before reconnecting rather than treat it as a failure
+func (r *DisconnectReason) ReconnectLater() bool {
+	return r.Code == DisconnectCodeDraining
+}
+
+// Marshal serializes a DisconnectReason for the payload of a DisconnectRequest
+func (r *DisconnectReason) Marshal() ([]byte, error) {
+	return json.Marshal(r)
+}
+
+// Unmarshal unserializes a DisconnectReason from the payload of a DisconnectRequest
+func (r *DisconnectReason) Unmarshal(b []byte) error {
+	err := json.Unmarshal(b, r)
+	if err != nil {
+		return fmt.Errorf("Invalid disconnect reason: %s", err)
+	}
+	if r.Code == "" {
+		r.Code = DisconnectCodeError
+	}
+	return nil
+}
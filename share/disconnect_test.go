@@ -0,0 +1,51 @@
+package chshare
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestDisconnectReasonRoundTrip(t *testing.T) {
+	r := NewDisconnectReason(DisconnectCodeDraining, "server draining, reconnect later")
+	b, err := r.Marshal()
+	if err != nil {
+		t.Fatalf("Marshal() returned error: %s", err)
+	}
+	r2 := &DisconnectReason{}
+	if err := r2.Unmarshal(b); err != nil {
+		t.Fatalf("Unmarshal(%s) returned error: %s", b, err)
+	}
+	if *r2 != *r {
+		t.Errorf("Unmarshal(%s) = %+v, want %+v", b, r2, r)
+	}
+	if !r2.ReconnectLater() {
+		t.Errorf("ReconnectLater() = false for code %s", r2.Code)
+	}
+
+	// must be recoverable from a wrapped completion error
+	var reason *DisconnectReason
+	if !errors.As(fmt.Errorf("connection lost: %w", r2), &reason) || reason != r2 {
+		t.Errorf("errors.As() did not find the DisconnectReason")
+	}
+
+	r3 := &DisconnectReason{}
+	if err := r3.Unmarshal([]byte(`{"message":"bye"}`)); err != nil {
+		t.Fatalf("Unmarshal() returned error: %s", err)
+	}
+	if r3.Code != DisconnectCodeError || r3.ReconnectLater() {
+		t.Errorf("Unmarshal() without code = %+v, want code %s", r3, DisconnectCodeError)
+	}
+	if err := r3.Unmarshal([]byte(`not json`)); err == nil {
+		t.Errorf("Unmarshal() of invalid payload did not return an error")
+	}
+}
+
+func TestLimitErrorDisconnectReason(t *testing.T) {
+	s := &ServerSSHSession{}
+	err := DLogErrorcf(NewLogger("test", LogLevelInfo), string(DisconnectCodeLimit), "Too many forward channel descriptors")
+	reason := s.getDisconnectReason(fmt.Errorf("session failed: %w", err))
+	if reason == nil || reason.Code != DisconnectCodeLimit || reason.ReconnectLater() {
+		t.Errorf("getDisconnectReason() of a limit error = %+v, want code %s", reason, DisconnectCodeLimit)
+	}
+}
@@ -0,0 +1,76 @@
+package chshare
+
+import (
+	"context"
+	"fmt"
+)
+
+// invalidFingerprintMessage begins the error returned when the server's host key does not
+// match the expected fingerprint
+const invalidFingerprintMessage = "Invalid fingerprint"
+
+// ConnectErrorKind classifies why a connection to the server failed
+type ConnectErrorKind int
+
+const (
+	// ConnectErrorNetwork means the server could not be reached, or the connection failed
+	ConnectErrorNetwork ConnectErrorKind = iota
+
+	// ConnectErrorAuth means the client failed to authenticate, or the server's fingerprint
+	// did not match
+	ConnectErrorAuth
+
+	// ConnectErrorRejected means the server refused the session config, or some of its remotes
+	ConnectErrorRejected
+)
+
+func (k ConnectErrorKind) String() string {
+	switch k {
+	case ConnectErrorAuth:
+		return "authentication"
+	case ConnectErrorRejected:
+		return "rejected"
+	default:
+		return "network"
+	}
+}
+
+// ConnectError is a failure to establish a session with the server, classified by Kind
+type ConnectError struct {
+	Kind ConnectErrorKind
+	Err  error
+}
+
+func (e *ConnectError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ConnectError) Unwrap() error {
+	return e.Err
+}
+
+// TestConnection connects to the server once, authenticates, verifies the fingerprint and
+// exchanges the session config, then disconnects without starting any proxies or forwarding
+// any traffic. The server tries to bind every reverse remote, reporting failures in the
+// returned reply rather than rejecting the session; if any failed, the reply is returned along
+// with a ConnectError of kind ConnectErrorRejected. Other failures are also returned as a
+// *ConnectError. TestConnection must not be combined with Start or Run on the same Client.
+func (c *Client) TestConnection(ctx context.Context) (*SessionConfigReply, error) {
+	c.ILogf("Testing connection to %s", c.server)
+	conn, err := c.dialServer(ctx)
+	if err != nil {
+		return nil, &ConnectError{Kind: ConnectErrorNetwork, Err: err}
+	}
+	// collect every reverse remote's outcome instead of stopping at the first failure
+	c.config.shared.AllowPartial = true
+	sshConn, _, _, reply, err := c.handshake(conn)
+	if err != nil {
+		return nil, err
+	}
+	sshConn.Close()
+	if len(reply.FailedRemotes) > 0 {
+		err = fmt.Errorf("Server was unable to start %d of the reverse remotes", len(reply.FailedRemotes))
+		return reply, &ConnectError{Kind: ConnectErrorRejected, Err: err}
+	}
+	return reply, nil
+}
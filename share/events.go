@@ -0,0 +1,155 @@
+package chshare
+
+import (
+	"time"
+)
+
+// SessionInfo describes a client proxy session on the server, for EventHandler callbacks
+type SessionInfo struct {
+	// SessionID is the server-unique ID of the session (the same ID used in log prefixes)
+	SessionID int32
+
+	// User is the authenticated SSH username, or "" if user authentication is disabled
+	User string
+
+	// RemoteAddr is the network address of the connecting client (or the last proxy in front of it)
+	RemoteAddr string
+
+	// ClientVersion is the version string reported by the client
+	ClientVersion string
+
+	// ChannelDescriptors are the string forms of the channel descriptors requested by the client
+	ChannelDescriptors []string
+
+	// StartTime is the time at which the session configuration was accepted
+	StartTime time.Time
+}
+
+// ChannelInfo describes a single channel opened through a session, for EventHandler callbacks
+type ChannelInfo struct {
+	// Session is the session that carries the channel
+	Session *SessionInfo
+
+	// ChannelID is a session-unique ID for the channel
+	ChannelID int64
+
+	// Descriptor is the string form of the local endpoint descriptor requested by the remote proxy
+	Descriptor string
+
+	// OpenTime is the time at which the channel was accepted
+	OpenTime time.Time
+
+	// BytesSent is the number of bytes sent from the remote caller to the local service. Only valid in OnChannelClose.
+	BytesSent int64
+
+	// BytesReceived is the number of bytes sent from the local service to the remote caller. Only valid in OnChannelClose.
+	BytesReceived int64
+}
+
+// EventHandler receives notification of session lifecycle events on a Server, e.g., for auditing.
+// Callbacks are invoked sequentially from a single dispatch goroutine, never from the data path, so
+// a slow handler cannot stall tunnelled traffic. If a handler falls far enough behind, events are
+// dropped (and a warning is logged) rather than blocking.
+type EventHandler interface {
+	// OnSessionStart is called after a client session has been authenticated and its configuration accepted
+	OnSessionStart(session *SessionInfo)
+
+	// OnSessionEnd is called when a session that was previously started ends. err is the completion status.
+	OnSessionEnd(session *SessionInfo, err error)
+
+	// OnChannelOpen is called when a channel is opened to a local endpoint on behalf of the remote proxy
+	OnChannelOpen(channel *ChannelInfo)
+
+	// OnChannelClose is called when a previously opened channel is closed. err is the completion status.
+	OnChannelClose(channel *ChannelInfo, err error)
+}
+
+// eventQueueSize is the number of undelivered events that may be queued before events are dropped
+const eventQueueSize = 1024
+
+// eventDispatcher delivers events to an EventHandler on a dedicated goroutine. Channel events
+// are also written to an AuditLog, if any, synchronously, so that audit records are never dropped
+// when the handler falls behind.
+type eventDispatcher struct {
+	logger  Logger
+	handler EventHandler
+	audit   *AuditLog
+	queue   chan func()
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+// newEventDispatcher creates a dispatcher for handler and audit, either of which may be nil
+func newEventDispatcher(logger Logger, handler EventHandler, audit *AuditLog) *eventDispatcher {
+	d := &eventDispatcher{
+		logger:  logger,
+		handler: handler,
+		audit:   audit,
+		queue:   make(chan func(), eventQueueSize),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	go d.run()
+	return d
+}
+
+func (d *eventDispatcher) run() {
+	defer close(d.stopped)
+	for {
+		select {
+		case f := <-d.queue:
+			f()
+		case <-d.done:
+			// deliver what was queued before close
+			for {
+				select {
+				case f := <-d.queue:
+					f()
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// post queues an event for delivery to the handler without blocking
+func (d *eventDispatcher) post(f func()) {
+	if d.handler == nil {
+		return
+	}
+	select {
+	case d.queue <- f:
+	default:
+		d.logger.WLog("Event handler queue full; dropping event")
+	}
+}
+
+// close delivers the events already queued, then stops the dispatcher. Events posted afterwards
+// are never delivered.
+func (d *eventDispatcher) close() {
+	close(d.done)
+	<-d.stopped
+}
+
+func (d *eventDispatcher) sessionStart(session *SessionInfo) {
+	d.post(func() { d.handler.OnSessionStart(session) })
+}
+
+func (d *eventDispatcher) sessionEnd(session *SessionInfo, err error) {
+	d.post(func() { d.handler.OnSessionEnd(session, err) })
+}
+
+func (d *eventDispatcher) channelOpen(channel *ChannelInfo) {
+	if d.audit != nil {
+		d.audit.OnChannelOpen(channel)
+	}
+	d.post(func() { d.handler.OnChannelOpen(channel) })
+}
+
+func (d *eventDispatcher) channelClose(channel *ChannelInfo, err error) {
+	if d.audit != nil {
+		d.audit.OnChannelClose(channel, err)
+	}
+	d.post(func() { d.handler.OnChannelClose(channel, err) })
+}
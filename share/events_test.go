@@ -0,0 +1,54 @@
+package chshare
+
+import (
+	"sync"
+	"testing"
+)
+
+// recordingEventHandler records the IDs of the channels it is notified of, blocking on the first
+// notification until release is closed
+type recordingEventHandler struct {
+	release chan struct{}
+	once    sync.Once
+	lock    sync.Mutex
+	opened  []int64
+}
+
+func (h *recordingEventHandler) OnSessionStart(session *SessionInfo) {}
+
+func (h *recordingEventHandler) OnSessionEnd(session *SessionInfo, err error) {}
+
+func (h *recordingEventHandler) OnChannelOpen(channel *ChannelInfo) {
+	h.once.Do(func() { <-h.release })
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.opened = append(h.opened, channel.ChannelID)
+}
+
+func (h *recordingEventHandler) OnChannelClose(channel *ChannelInfo, err error) {}
+
+func TestEventDispatcherCloseDeliversQueuedEvents(t *testing.T) {
+	h := &recordingEventHandler{release: make(chan struct{})}
+	d := newEventDispatcher(NewLogger("test", LogLevelInfo), h, nil)
+	for id := int64(1); id <= 5; id++ {
+		d.channelOpen(&ChannelInfo{ChannelID: id})
+	}
+	closed := make(chan struct{})
+	go func() {
+		d.close()
+		close(closed)
+	}()
+	close(h.release)
+	<-closed
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if len(h.opened) != 5 {
+		t.Fatalf("close() returned after %d of 5 queued events were delivered", len(h.opened))
+	}
+	for i, id := range h.opened {
+		if id != int64(i+1) {
+			t.Errorf("Event #%d delivered for channel %d, want %d", i, id, i+1)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package chshare
 
 import (
+	"context"
 	"log"
 	"os"
 	"os/signal"
@@ -27,3 +28,20 @@ func GoStats() {
 			sizestr.ToString(int64(memStats.Alloc)))
 	}
 }
+
+//OnStatsSignal calls f each time a SIGUSR2
+//is received (posix-only), until ctx is done
+func OnStatsSignal(ctx context.Context, f func()) {
+	const SIGUSR2 = syscall.Signal(0x1f)
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, SIGUSR2)
+	defer signal.Stop(c)
+	for {
+		select {
+		case <-c:
+			f()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,218 @@
+package chshare
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+// The HTTP/2 transport is an alternative to websockets for carrying the SSH connection between
+// client and server, for environments (e.g., HTTP/2-only ingress) where a websocket upgrade is not
+// possible. Websockets over HTTP/2 require RFC 8441 extended CONNECT, which is not widely available,
+// so instead the client sends a single POST request whose body streams client->server traffic, and
+// the server replies with a streaming response body carrying server->client traffic. The resulting
+// full-duplex stream is presented to the SSH layer as a net.Conn, exactly like a websocket.
+//
+// The server accepts the HTTP/2 transport over TLS (h2) or cleartext (h2c, with prior knowledge).
+
+// H2TransportHeader is the request header that identifies an HTTP/2 transport stream. Its value
+// must be ProtocolVersion, as with the websocket subprotocol.
+const H2TransportHeader = "X-Wstunnel-Protocol"
+
+// H2RetryInterval is how long a client keeps to websockets after the HTTP/2 transport failed where a
+// websocket succeeded, before it tries the HTTP/2 transport again (e.g., after an ingress change)
+const H2RetryInterval = 10 * time.Minute
+
+// h2HandshakeTimeout is the time allowed for the server to respond to an HTTP/2 transport request
+const h2HandshakeTimeout = 45 * time.Second
+
+// h2Addr is a net.Addr for one end of an HTTP/2 transport stream
+type h2Addr string
+
+func (a h2Addr) Network() string {
+	return "h2"
+}
+
+func (a h2Addr) String() string {
+	return string(a)
+}
+
+// h2StreamConn adapts a full-duplex HTTP/2 request/response stream to net.Conn. Deadlines
+// are not supported; the SSH layer does not use them.
+type h2StreamConn struct {
+	reader     io.ReadCloser
+	writer     io.Writer
+	flush      func()
+	closeWrite func() error
+	localAddr  net.Addr
+	remoteAddr net.Addr
+	writeLock  sync.Mutex
+	closeOnce  sync.Once
+	closed     chan struct{}
+}
+
+func newH2StreamConn(reader io.ReadCloser, writer io.Writer, flush func(), closeWrite func() error, localAddr, remoteAddr net.Addr) *h2StreamConn {
+	return &h2StreamConn{
+		reader:     reader,
+		writer:     writer,
+		flush:      flush,
+		closeWrite: closeWrite,
+		localAddr:  localAddr,
+		remoteAddr: remoteAddr,
+		closed:     make(chan struct{}),
+	}
+}
+
+func (c *h2StreamConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
+
+func (c *h2StreamConn) Write(b []byte) (int, error) {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	select {
+	case <-c.closed:
+		return 0, io.ErrClosedPipe
+	default:
+	}
+	n, err := c.writer.Write(b)
+	if err == nil && c.flush != nil {
+		c.flush()
+	}
+	return n, err
+}
+
+func (c *h2StreamConn) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		c.writeLock.Lock()
+		if c.closeWrite != nil {
+			err = c.closeWrite()
+		}
+		c.writeLock.Unlock()
+		rerr := c.reader.Close()
+		if err == nil {
+			err = rerr
+		}
+	})
+	return err
+}
+
+// Done returns a chan that is closed when the connection is closed
+func (c *h2StreamConn) Done() <-chan struct{} {
+	return c.closed
+}
+
+func (c *h2StreamConn) LocalAddr() net.Addr {
+	return c.localAddr
+}
+
+func (c *h2StreamConn) RemoteAddr() net.Addr {
+	return c.remoteAddr
+}
+
+func (c *h2StreamConn) SetDeadline(t time.Time) error {
+	return nil
+}
+
+func (c *h2StreamConn) SetReadDeadline(t time.Time) error {
+	return nil
+}
+
+func (c *h2StreamConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
+
+// isH2TransportRequest returns true if r is a request to open an HTTP/2 transport stream
+func isH2TransportRequest(r *http.Request) bool {
+	return r.ProtoMajor == 2 && r.Method == http.MethodPost && r.Header.Get(H2TransportHeader) != ""
+}
+
+// newH2ServerConn starts the response to an HTTP/2 transport request and returns the resulting
+// stream as a net.Conn. The handler must not return until the conn is closed.
+func newH2ServerConn(w http.ResponseWriter, r *http.Request) (*h2StreamConn, error) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return nil, fmt.Errorf("HTTP/2 response writer does not support flushing")
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	return newH2StreamConn(r.Body, w, flusher.Flush, nil, h2Addr(r.Host), h2Addr(r.RemoteAddr)), nil
+}
+
+// dialH2 opens an HTTP/2 transport stream to the server, through the configured proxy if any
+func (c *Client) dialH2(ctx context.Context) (net.Conn, error) {
+	u, err := url.Parse(c.server)
+	if err != nil {
+		return nil, err
+	}
+	useTLS := u.Scheme == "wss"
+	u.Scheme = strings.Replace(u.Scheme, "ws", "http", 1)
+
+	transport := &http2.Transport{
+		// cleartext h2c, with prior knowledge, for http:// servers
+		AllowHTTP:       true,
+		TLSClientConfig: c.tlsConfig,
+		DialTLS: func(network, addr string, cfg *tls.Config) (net.Conn, error) {
+			conn, err := c.dialServerTCP(network, addr)
+			if err != nil || !useTLS {
+				return conn, err
+			}
+			tlsConn := tls.Client(conn, cfg)
+			if err := tlsConn.Handshake(); err != nil {
+				conn.Close()
+				return nil, err
+			}
+			return tlsConn, nil
+		},
+	}
+
+	pr, pw := io.Pipe()
+	req, err := http.NewRequest(http.MethodPost, u.String(), pr)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range c.handshakeHeaders() {
+		req.Header[k] = v
+	}
+	req.Header.Set(H2TransportHeader, ProtocolVersion)
+	req.Header.Set("Content-Type", "application/octet-stream")
+	if c.config.HostHeader != "" {
+		req.Host = c.config.HostHeader
+	}
+
+	// the request context governs the whole stream, not just the handshake, so it is only
+	// cancelled early if the response headers don't arrive in time
+	reqCtx, reqCancel := context.WithCancel(ctx)
+	handshakeTimer := time.AfterFunc(h2HandshakeTimeout, reqCancel)
+	resp, err := transport.RoundTrip(req.WithContext(reqCtx))
+	handshakeTimer.Stop()
+	if err == nil && resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		err = withRetryAfter(fmt.Errorf("HTTP/2 transport refused by server: %s", resp.Status), resp)
+	}
+	if err != nil {
+		reqCancel()
+		pw.Close()
+		transport.CloseIdleConnections()
+		return nil, err
+	}
+	closeWrite := func() error {
+		err := pw.Close()
+		reqCancel()
+		transport.CloseIdleConnections()
+		return err
+	}
+	return newH2StreamConn(resp.Body, pw, nil, closeWrite, h2Addr("client"), h2Addr(u.Host)), nil
+}
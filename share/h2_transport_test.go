@@ -0,0 +1,73 @@
+package chshare
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestH2CTransportRoundTrip(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	echoPort, stopEcho := testEchoService(t)
+	defer stopEcho()
+
+	s, err := NewServer(&ProxyServerConfig{KeySeed: "h2c-test", HTTP2: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	serverPort := testLocalPort(t)
+	go s.Run(ctx, "127.0.0.1", strconv.Itoa(serverPort))
+
+	stubAddr := "127.0.0.1:" + strconv.Itoa(testLocalPort(t))
+	c, err := NewClient(&Config{
+		Server:        fmt.Sprintf("http://127.0.0.1:%d", serverPort),
+		Fingerprint:   s.GetFingerprint(),
+		ChdStrings:    []string{fmt.Sprintf("%s:127.0.0.1:%d", stubAddr, echoPort)},
+		HTTP2:         true,
+		MaxRetryCount: -1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if err := c.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	waitCtx, waitCancel := context.WithTimeout(ctx, 20*time.Second)
+	defer waitCancel()
+	sshConn, err := c.GetSSHConnContext(waitCtx)
+	if err != nil {
+		t.Fatalf("Client did not connect: %s", err)
+	}
+	if network := sshConn.LocalAddr().Network(); network != "h2" {
+		t.Errorf("Client connected over %q, want the HTTP/2 transport", network)
+	}
+	testEcho(t, stubAddr, "over h2c")
+	testEcho(t, stubAddr, "and again")
+}
+
+func TestH2TransportRetriedAfterCoolDown(t *testing.T) {
+	clock := NewMockClock(time.Unix(1000, 0))
+	c := &Client{config: &Config{HTTP2: true, Clock: clock}}
+	if !c.useH2() {
+		t.Fatalf("useH2() = false before any failure")
+	}
+	c.h2RetryAt = clock.Now().Add(H2RetryInterval)
+	clock.Advance(H2RetryInterval - time.Second)
+	if c.useH2() {
+		t.Errorf("useH2() = true during the cool-down")
+	}
+	clock.Advance(time.Second)
+	if !c.useH2() {
+		t.Errorf("useH2() = false after the cool-down")
+	}
+	c.config.HTTP2 = false
+	if c.useH2() {
+		t.Errorf("useH2() = true with HTTP2 disabled")
+	}
+}
@@ -0,0 +1,47 @@
+package chshare
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// reservedHandshakeHeaders are set by the websocket handshake itself (or, for Host, by
+// Config.HostHeader), and cannot be overridden with Config.Headers
+var reservedHandshakeHeaders = []string{
+	"Host",
+	"Upgrade",
+	"Connection",
+	"Sec-Websocket-Key",
+	"Sec-Websocket-Version",
+	"Sec-Websocket-Extensions",
+	"Sec-Websocket-Protocol",
+	H2TransportHeader,
+}
+
+// validateHandshakeHeaders ensures that extra handshake headers do not include reserved headers
+func validateHandshakeHeaders(h http.Header) error {
+	for k := range h {
+		for _, r := range reservedHandshakeHeaders {
+			if http.CanonicalHeaderKey(k) == http.CanonicalHeaderKey(r) {
+				if r == "Host" {
+					return fmt.Errorf("The Host header cannot be given as an extra header; use the host header option instead")
+				}
+				return fmt.Errorf("The \"%s\" header is set by the handshake and cannot be given as an extra header", k)
+			}
+		}
+	}
+	return nil
+}
+
+// handshakeHeaders returns a new copy of the extra headers to send with the websocket or HTTP/2
+// transport handshake, with the configured User-Agent
+func (c *Client) handshakeHeaders() http.Header {
+	h := http.Header{}
+	for k, v := range c.config.Headers {
+		h[http.CanonicalHeaderKey(k)] = append([]string(nil), v...)
+	}
+	if c.config.UserAgent != "" {
+		h.Set("User-Agent", c.config.UserAgent)
+	}
+	return h
+}
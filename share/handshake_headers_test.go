@@ -0,0 +1,37 @@
+package chshare
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestHandshakeHeaders(t *testing.T) {
+	c := &Client{config: &Config{
+		UserAgent: "Mozilla/5.0",
+		Headers: http.Header{
+			"X-Team":     {"a", "b"},
+			"User-Agent": {"ignored"},
+		},
+	}}
+	h := c.handshakeHeaders()
+	if got := h["User-Agent"]; !reflect.DeepEqual(got, []string{"Mozilla/5.0"}) {
+		t.Errorf("User-Agent = %v, want the configured UserAgent only", got)
+	}
+	if got := h["X-Team"]; !reflect.DeepEqual(got, []string{"a", "b"}) {
+		t.Errorf("X-Team = %v, want both values in order", got)
+	}
+	h.Add("X-Team", "c")
+	if len(c.config.Headers["X-Team"]) != 2 {
+		t.Errorf("handshakeHeaders() did not return a copy")
+	}
+
+	for _, k := range []string{"host", "Sec-WebSocket-Key", "Connection", H2TransportHeader} {
+		if err := validateHandshakeHeaders(http.Header{k: {"x"}}); err == nil {
+			t.Errorf("validateHandshakeHeaders() accepted reserved header %s", k)
+		}
+	}
+	if err := validateHandshakeHeaders(http.Header{"X-Team": {"a"}}); err != nil {
+		t.Errorf("validateHandshakeHeaders() rejected an ordinary header: %s", err)
+	}
+}
@@ -0,0 +1,64 @@
+package chshare
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// handshakeLimiter bounds the number of client connections that may be in the handshake phase
+// (transport upgrade and SSH key exchange, which is CPU-heavy) at the same time, so that a flood
+// of connection attempts cannot exhaust the server's CPU. Established sessions do not hold a slot.
+// A nil *handshakeLimiter imposes no limit.
+type handshakeLimiter struct {
+	slots        chan struct{}
+	queueTimeout time.Duration
+}
+
+// newHandshakeLimiter creates a limiter that allows up to limit concurrent handshakes. A connection
+// that finds no free slot waits up to queueTimeout for one, or is shed immediately if queueTimeout
+// is 0. Returns nil (unbounded) if limit <= 0.
+func newHandshakeLimiter(limit int, queueTimeout time.Duration) *handshakeLimiter {
+	if limit <= 0 {
+		return nil
+	}
+	return &handshakeLimiter{
+		slots:        make(chan struct{}, limit),
+		queueTimeout: queueTimeout,
+	}
+}
+
+// acquire obtains a handshake slot. On success it returns a release function, which may safely be
+// called more than once, and true. If no slot became available in time, or ctx is done first, it
+// returns false and the connection should be shed.
+func (l *handshakeLimiter) acquire(ctx context.Context) (func(), bool) {
+	if l == nil {
+		return func() {}, true
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return l.releaser(), true
+	default:
+	}
+	if l.queueTimeout <= 0 {
+		return nil, false
+	}
+	timer := time.NewTimer(l.queueTimeout)
+	defer timer.Stop()
+	select {
+	case l.slots <- struct{}{}:
+		return l.releaser(), true
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+	return nil, false
+}
+
+func (l *handshakeLimiter) releaser() func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			<-l.slots
+		})
+	}
+}
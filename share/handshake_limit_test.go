@@ -0,0 +1,49 @@
+package chshare
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestHandshakeLimiter(t *testing.T) {
+	var unbounded *handshakeLimiter
+	for i := 0; i < 100; i++ {
+		if _, ok := unbounded.acquire(context.Background()); !ok {
+			t.Fatalf("acquire() on an unbounded limiter failed")
+		}
+	}
+	if l := newHandshakeLimiter(0, time.Second); l != nil {
+		t.Errorf("newHandshakeLimiter(0) = %v, want nil (unbounded)", l)
+	}
+
+	l := newHandshakeLimiter(2, 0)
+	release1, ok1 := l.acquire(context.Background())
+	_, ok2 := l.acquire(context.Background())
+	if !ok1 || !ok2 {
+		t.Fatalf("acquire() failed within the limit")
+	}
+	if _, ok := l.acquire(context.Background()); ok {
+		t.Fatalf("acquire() beyond the limit was not shed")
+	}
+	release1()
+	release1()
+	if _, ok := l.acquire(context.Background()); !ok {
+		t.Fatalf("acquire() failed after a release")
+	}
+	if _, ok := l.acquire(context.Background()); ok {
+		t.Fatalf("double release freed more than one slot")
+	}
+
+	queued := newHandshakeLimiter(1, time.Second)
+	release, _ := queued.acquire(context.Background())
+	time.AfterFunc(10*time.Millisecond, release)
+	if _, ok := queued.acquire(context.Background()); !ok {
+		t.Errorf("queued acquire() did not get the released slot")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, ok := queued.acquire(ctx); ok {
+		t.Errorf("queued acquire() succeeded with a cancelled context")
+	}
+}
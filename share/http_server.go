@@ -2,8 +2,14 @@ package chshare
 
 import (
 	"context"
+	"crypto/tls"
+	"fmt"
 	"net"
 	"net/http"
+	"strings"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 )
 
 //HTTPServer extends net/http Server and
@@ -11,14 +17,15 @@ import (
 type HTTPServer struct {
 	ShutdownHelper
 	*http.Server
-	listener net.Listener
+	listeners []net.Listener
+	h2c       bool
 }
 
 //NewHTTPServer creates a new HTTPServer
 func NewHTTPServer(logger Logger) *HTTPServer {
 	h := &HTTPServer{
-		Server:   &http.Server{},
-		listener: nil,
+		Server:    &http.Server{},
+		listeners: nil,
 	}
 	h.InitShutdownHelper(logger, h)
 	return h
@@ -28,36 +35,105 @@ func NewHTTPServer(logger Logger) *HTTPServer {
 // as an advisory completion value, actually shut down, then return the real completion value.
 func (h *HTTPServer) HandleOnceShutdown(completionErr error) error {
 	h.DLogf("HandleOnceShutdown")
-	err := h.listener.Close()
-	if err != nil {
-		h.DLogf("HTTPserver: close of listener failed, ignoring: %s", err)
-	}
-	if completionErr == nil {
-		completionErr = err
+	for _, l := range h.listeners {
+		err := l.Close()
+		if err != nil {
+			h.DLogf("HTTPserver: close of listener %s failed, ignoring: %s", l.Addr(), err)
+			if completionErr == nil {
+				completionErr = err
+			}
+		}
 	}
 	return completionErr
 }
 
+// ParseListenAddrs splits a comma-separated list of "<host>:<port>" listen addresses
+func ParseListenAddrs(s string) ([]string, error) {
+	var addrs []string
+	for _, a := range strings.Split(s, ",") {
+		a = strings.TrimSpace(a)
+		if a == "" {
+			continue
+		}
+		if _, _, err := net.SplitHostPort(a); err != nil {
+			return nil, fmt.Errorf("Invalid listen address \"%s\": %s", a, err)
+		}
+		addrs = append(addrs, a)
+	}
+	return addrs, nil
+}
+
+// EnableH2C enables cleartext HTTP/2 (h2c), in addition to HTTP/1.1, on non-TLS connections.
+// Must be called before ListenAndServe.
+func (h *HTTPServer) EnableH2C() {
+	h.h2c = true
+}
+
+// EnableTLS makes the server terminate TLS itself using cfg, which must provide certificates
+// (via Certificates or GetCertificate). Must be called before ListenAndServe.
+func (h *HTTPServer) EnableTLS(cfg *tls.Config) {
+	h.TLSConfig = cfg
+}
+
 // ListenAndServe Runs the HTTP server
 // on the given bind address, invoking the provided handler for each
 // request. It returns after the server has shutdown. The server can be
 // shutdown either by cancelling the context or by calling Shutdown().
 func (h *HTTPServer) ListenAndServe(ctx context.Context, addr string, handler http.Handler) error {
+	return h.ListenAndServeMulti(ctx, []string{addr}, handler, false)
+}
+
+// ListenAndServeMulti is like ListenAndServe, but listens on several bind addresses at once, all
+// served by the same handler. Shutting down the server closes all of the listeners, and a failure
+// serving any of them shuts down the server. If one of the addresses cannot be bound, the server
+// fails to start, unless allowPartial is true, in which case the failure is logged and the server
+// runs on the remaining addresses, as long as there is at least one.
+func (h *HTTPServer) ListenAndServeMulti(ctx context.Context, addrs []string, handler http.Handler, allowPartial bool) error {
 
 	err := h.DoOnceActivate(
 		func() error {
 			h.ShutdownOnContext(ctx)
 
-			l, err := net.Listen("tcp", addr)
-			if err != nil {
-				return h.DLogErrorf("Listen failed: %s", err)
+			var listeners []net.Listener
+			var listenErr error
+			for _, addr := range addrs {
+				l, err := net.Listen("tcp", addr)
+				if err != nil {
+					err = fmt.Errorf("Listen on %s failed: %s", addr, err)
+					if !allowPartial {
+						for _, l := range listeners {
+							l.Close()
+						}
+						return h.DLogErrorf("%s", err)
+					}
+					h.ILogf("WARNING: %s; continuing with the remaining addresses", err)
+					listenErr = err
+					continue
+				}
+				listeners = append(listeners, l)
+			}
+			if len(listeners) == 0 {
+				if listenErr == nil {
+					listenErr = fmt.Errorf("No listen addresses")
+				}
+				return h.DLogErrorf("%s", listenErr)
+			}
+			if h.h2c {
+				handler = h2c.NewHandler(handler, &http2.Server{})
 			}
 			h.Handler = handler
-			h.listener = l
+			h.listeners = listeners
 
-			go func() {
-				h.Shutdown(h.Serve(l))
-			}()
+			for _, l := range listeners {
+				go func(l net.Listener) {
+					if h.TLSConfig != nil {
+						// certificates come from TLSConfig
+						h.Shutdown(h.ServeTLS(l, "", ""))
+					} else {
+						h.Shutdown(h.Serve(l))
+					}
+				}(l)
+			}
 
 			return nil
 		},
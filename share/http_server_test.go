@@ -0,0 +1,22 @@
+package chshare
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseListenAddrs(t *testing.T) {
+	addrs, err := ParseListenAddrs(" 0.0.0.0:8080, [::]:8080 ,")
+	if err != nil {
+		t.Fatalf("ParseListenAddrs() returned error: %s", err)
+	}
+	if want := []string{"0.0.0.0:8080", "[::]:8080"}; !reflect.DeepEqual(addrs, want) {
+		t.Errorf("ParseListenAddrs() = %v, want %v", addrs, want)
+	}
+	if addrs, err := ParseListenAddrs(""); err != nil || addrs != nil {
+		t.Errorf("ParseListenAddrs(\"\") = %v, %v; want nil, nil", addrs, err)
+	}
+	if _, err := ParseListenAddrs("0.0.0.0:8080,8081"); err == nil {
+		t.Errorf("ParseListenAddrs() accepted an address without a host")
+	}
+}
@@ -0,0 +1,100 @@
+package chshare
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// IPFilter decides whether an incoming HTTP request should be allowed to reach the websocket
+// endpoint, based on the client's source IP address. It is enforced before, and independently
+// of, SSH user authentication.
+//
+// A request whose source IP matches any deny entry is rejected. Otherwise, if the allow list is
+// empty the request is accepted; if not, the source IP must match an allow entry. Entries are
+// CIDR blocks ("10.0.0.0/8", "2001:db8::/32") or bare IP addresses.
+//
+// By default the source IP is taken from the TCP peer address (http.Request.RemoteAddr). If
+// trustXFF is set, the last address in the X-Forwarded-For header is used instead when present,
+// i.e., the address as seen by the nearest proxy. This must only be enabled when the server is
+// reachable exclusively through a trusted proxy that sets the header; otherwise any client can
+// spoof its source address.
+type IPFilter struct {
+	allow    []*net.IPNet
+	deny     []*net.IPNet
+	trustXFF bool
+}
+
+// NewIPFilter creates an IPFilter from lists of allowed and denied CIDR blocks or IP addresses.
+// Empty entries and surrounding whitespace are ignored.
+func NewIPFilter(allow []string, deny []string, trustXFF bool) (*IPFilter, error) {
+	f := &IPFilter{trustXFF: trustXFF}
+	var err error
+	f.allow, err = ParseIPNets(allow)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid allowed CIDR: %s", err)
+	}
+	f.deny, err = ParseIPNets(deny)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid denied CIDR: %s", err)
+	}
+	return f, nil
+}
+
+// ParseCIDRList splits a comma-separated list of CIDR blocks or IP addresses
+func ParseCIDRList(s string) []string {
+	if strings.TrimSpace(s) == "" {
+		return nil
+	}
+	return strings.Split(s, ",")
+}
+
+// IsPermissive returns true if all source addresses are accepted
+func (f *IPFilter) IsPermissive() bool {
+	return len(f.allow) == 0 && len(f.deny) == 0
+}
+
+// SourceIP returns the source IP address of a request, as described for IPFilter, or nil if it
+// cannot be determined
+func (f *IPFilter) SourceIP(r *http.Request) net.IP {
+	if f.trustXFF {
+		if xff := r.Header.Values("X-Forwarded-For"); len(xff) > 0 {
+			// multiple headers are equivalent to a single comma-separated list; the last
+			// entry was appended by the nearest (trusted) proxy
+			parts := strings.Split(xff[len(xff)-1], ",")
+			return net.ParseIP(strings.TrimSpace(parts[len(parts)-1]))
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		// no port
+		host = r.RemoteAddr
+	}
+	// strip an IPv6 zone, which net.ParseIP does not accept
+	if i := strings.LastIndex(host, "%"); i >= 0 {
+		host = host[:i]
+	}
+	return net.ParseIP(host)
+}
+
+// Allowed returns true if ip is permitted by the filter
+func (f *IPFilter) Allowed(ip net.IP) bool {
+	if f.IsPermissive() {
+		return true
+	}
+	if ip == nil {
+		// fail closed if we can't tell who it is
+		return false
+	}
+	if IPNetsContain(f.deny, ip) {
+		return false
+	}
+	return len(f.allow) == 0 || IPNetsContain(f.allow, ip)
+}
+
+// CheckRequest returns the request's source IP and whether it is permitted by the filter
+func (f *IPFilter) CheckRequest(r *http.Request) (net.IP, bool) {
+	ip := f.SourceIP(r)
+	return ip, f.Allowed(ip)
+}
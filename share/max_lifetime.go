@@ -0,0 +1,30 @@
+package chshare
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrMaxLifetime is the completion error of a client or server that shut itself down because its
+// maximum lifetime (see Config.MaxLifetime and ProxyServerConfig.MaxLifetime) was reached
+var ErrMaxLifetime = errors.New("Max lifetime reached")
+
+// shutdownAfterMaxLifetime starts a clean shutdown, with completion error ErrMaxLifetime, once
+// lifetime has elapsed on clock. It gives up if shutdownStarted is closed first, so it composes
+// with shutdown for any other reason (e.g., ShutdownOnContext). Nothing is done if lifetime <= 0.
+func shutdownAfterMaxLifetime(logger Logger, clock Clock, lifetime time.Duration, shutdownStarted <-chan struct{}, startShutdown func(error)) {
+	if lifetime <= 0 {
+		return
+	}
+	logger.ILogf("Shutting down after max lifetime of %s", lifetime)
+	go func() {
+		timer := clock.NewTimer(lifetime)
+		defer timer.Stop()
+		select {
+		case <-timer.C():
+			logger.ILogf("Max lifetime of %s reached; shutting down", lifetime)
+			startShutdown(ErrMaxLifetime)
+		case <-shutdownStarted:
+		}
+	}()
+}
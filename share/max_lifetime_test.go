@@ -0,0 +1,44 @@
+package chshare
+
+import (
+	"testing"
+	"time"
+)
+
+func TestShutdownAfterMaxLifetime(t *testing.T) {
+	logger := NewLogger("test", LogLevelInfo)
+	clock := NewMockClock(time.Now())
+
+	result := make(chan error, 1)
+	shutdownAfterMaxLifetime(logger, clock, time.Hour, make(chan struct{}), func(err error) { result <- err })
+	waitForTimers(t, clock, 1)
+	clock.Advance(time.Hour - time.Second)
+	select {
+	case err := <-result:
+		t.Fatalf("Shut down with %v before the max lifetime", err)
+	case <-time.After(10 * time.Millisecond):
+	}
+	clock.Advance(time.Second)
+	select {
+	case err := <-result:
+		if err != ErrMaxLifetime {
+			t.Errorf("Shut down with %v, want %v", err, ErrMaxLifetime)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Max lifetime did not shut down")
+	}
+
+	// shutdown for another reason first cancels the timer
+	started := make(chan struct{})
+	shutdownAfterMaxLifetime(logger, clock, time.Hour, started, func(err error) { result <- err })
+	waitForTimers(t, clock, 1)
+	close(started)
+	waitForTimers(t, clock, 0)
+	shutdownAfterMaxLifetime(logger, clock, 0, make(chan struct{}), func(err error) { result <- err })
+	clock.Advance(2 * time.Hour)
+	select {
+	case err := <-result:
+		t.Errorf("Unexpected shutdown with %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -0,0 +1,141 @@
+package chshare
+
+import (
+	"expvar"
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// ExpvarName is the name under which all wstunnel counters are published with expvar
+const ExpvarName = "wstunnel"
+
+// Metrics holds lightweight counters for a proxy client or server, for introspection
+// via expvar. All methods are safe for concurrent use.
+type Metrics struct {
+	// Sessions counts SSH sessions with the remote proxy (on a client, one per successful connection)
+	Sessions ConnStats
+
+	// Channels counts tunnelled channels handled locally, in either direction
+	Channels ConnStats
+
+	// bytesSent is the number of bytes forwarded from callers (stub side) to services (skeleton side)
+	bytesSent int64
+
+	// bytesReceived is the number of bytes forwarded from services (skeleton side) back to callers
+	bytesReceived int64
+
+	// acceptErrors counts failures accepting connections on stub listeners or accepting SSH channels
+	acceptErrors int64
+
+	// dialErrors counts failures to connect a channel to its skeleton endpoint
+	dialErrors int64
+
+	// reconnectAttempts counts connection retries (client only)
+	reconnectAttempts int64
+
+	// connected is 1 while a client has an established SSH connection (client only)
+	connected int32
+
+	// handshakesShed counts client connections refused because too many handshakes were in progress (server only)
+	handshakesShed int64
+}
+
+// AddBytes adds to the per-direction byte counts
+func (m *Metrics) AddBytes(sent int64, received int64) {
+	atomic.AddInt64(&m.bytesSent, sent)
+	atomic.AddInt64(&m.bytesReceived, received)
+}
+
+// AcceptError records a failure to accept a connection or channel
+func (m *Metrics) AcceptError() {
+	atomic.AddInt64(&m.acceptErrors, 1)
+}
+
+// DialError records a failure to connect a channel to its skeleton endpoint
+func (m *Metrics) DialError() {
+	atomic.AddInt64(&m.dialErrors, 1)
+}
+
+// ReconnectAttempt records a client connection retry
+func (m *Metrics) ReconnectAttempt() {
+	atomic.AddInt64(&m.reconnectAttempts, 1)
+}
+
+// HandshakeShed records a client connection refused because too many handshakes were in progress
+func (m *Metrics) HandshakeShed() {
+	atomic.AddInt64(&m.handshakesShed, 1)
+}
+
+// SetConnected records whether a client currently has an established SSH connection
+func (m *Metrics) SetConnected(connected bool) {
+	var v int32
+	if connected {
+		v = 1
+	}
+	atomic.StoreInt32(&m.connected, v)
+}
+
+// Snapshot returns the current counter values in a form suitable for JSON encoding
+func (m *Metrics) Snapshot() map[string]interface{} {
+	return map[string]interface{}{
+		"sessions_total":     m.Sessions.Total(),
+		"sessions_active":    m.Sessions.Active(),
+		"channels_total":     m.Channels.Total(),
+		"channels_active":    m.Channels.Active(),
+		"bytes_sent":         atomic.LoadInt64(&m.bytesSent),
+		"bytes_received":     atomic.LoadInt64(&m.bytesReceived),
+		"accept_errors":      atomic.LoadInt64(&m.acceptErrors),
+		"dial_errors":        atomic.LoadInt64(&m.dialErrors),
+		"reconnect_attempts": atomic.LoadInt64(&m.reconnectAttempts),
+		"connected":          atomic.LoadInt32(&m.connected) != 0,
+		"handshakes_shed":    atomic.LoadInt64(&m.handshakesShed),
+	}
+}
+
+// recordChannelEnd accounts for a completed channel. A channel that failed without
+// transferring any data is counted as a dial error.
+func (m *Metrics) recordChannelEnd(sent int64, received int64, err error) {
+	m.AddBytes(sent, received)
+	if err != nil && sent == 0 && received == 0 {
+		m.DialError()
+	}
+}
+
+var (
+	expvarOnce    sync.Once
+	metricsLock   sync.Mutex
+	metricsByName = map[string]*Metrics{}
+	lastMetricsID int32
+)
+
+// registerMetrics makes m visible under the wstunnel expvar, with a unique name derived
+// from prefix. The wstunnel expvar itself is published exactly once per process, no matter
+// how many clients and servers are created. Returns the name, for unregisterMetrics.
+func registerMetrics(prefix string, m *Metrics) string {
+	expvarOnce.Do(func() {
+		expvar.Publish(ExpvarName, expvar.Func(metricsSnapshot))
+	})
+	name := fmt.Sprintf("%s#%d", prefix, atomic.AddInt32(&lastMetricsID, 1))
+	metricsLock.Lock()
+	defer metricsLock.Unlock()
+	metricsByName[name] = m
+	return name
+}
+
+// unregisterMetrics removes metrics previously added with registerMetrics
+func unregisterMetrics(name string) {
+	metricsLock.Lock()
+	defer metricsLock.Unlock()
+	delete(metricsByName, name)
+}
+
+func metricsSnapshot() interface{} {
+	metricsLock.Lock()
+	defer metricsLock.Unlock()
+	result := map[string]interface{}{}
+	for name, m := range metricsByName {
+		result[name] = m.Snapshot()
+	}
+	return result
+}
@@ -0,0 +1,77 @@
+package chshare
+
+import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRegisterMetricsMultipleInstances(t *testing.T) {
+	var m0, m1 Metrics
+	// registering more than once must not panic with a duplicate expvar registration
+	name0 := registerMetrics("server", &m0)
+	name1 := registerMetrics("server", &m1)
+	defer unregisterMetrics(name0)
+	defer unregisterMetrics(name1)
+	if name0 == name1 {
+		t.Fatalf("registerMetrics() returned duplicate name %s", name0)
+	}
+
+	m1.Channels.New()
+	m1.AddBytes(10, 20)
+
+	v := expvar.Get(ExpvarName)
+	if v == nil {
+		t.Fatalf("expvar %s was not published", ExpvarName)
+	}
+	all := map[string]map[string]interface{}{}
+	if err := json.Unmarshal([]byte(v.String()), &all); err != nil {
+		t.Fatalf("expvar %s is not valid JSON: %s", ExpvarName, err)
+	}
+	snap, ok := all[name1]
+	if !ok {
+		t.Fatalf("expvar %s does not include %s", ExpvarName, name1)
+	}
+	if snap["channels_total"] != float64(1) || snap["bytes_sent"] != float64(10) || snap["bytes_received"] != float64(20) {
+		t.Errorf("Unexpected counters for %s: %v", name1, snap)
+	}
+
+	unregisterMetrics(name0)
+	all = map[string]map[string]interface{}{}
+	if err := json.Unmarshal([]byte(v.String()), &all); err == nil {
+		if _, ok := all[name0]; ok {
+			t.Errorf("expvar %s still includes %s after unregisterMetrics()", ExpvarName, name0)
+		}
+	}
+}
+
+func TestServerExpvarPathServesOnlyWstunnel(t *testing.T) {
+	s, err := NewServer(&ProxyServerConfig{KeySeed: "expvar-test", ExpvarPath: "/debug/vars", NoLoop: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	w := httptest.NewRecorder()
+	s.handleClientHandler(context.Background(), w, httptest.NewRequest("GET", "/debug/vars", nil))
+	all := map[string]interface{}{}
+	if err := json.Unmarshal(w.Body.Bytes(), &all); err != nil {
+		t.Fatalf("Expvar path did not serve valid JSON: %s", err)
+	}
+	if _, ok := all[ExpvarName]; !ok || len(all) != 1 {
+		t.Errorf("Expvar path served %v; expected only %s", all, ExpvarName)
+	}
+}
+
+func TestNewServerErrorUnregistersMetrics(t *testing.T) {
+	before := len(metricsSnapshot().(map[string]interface{}))
+	_, err := NewServer(&ProxyServerConfig{KeySeed: "expvar-test", Proxy: "http://a", ProxyFile: "b", NoLoop: true})
+	if err == nil {
+		t.Fatalf("NewServer() with --proxy and --proxy-file did not fail")
+	}
+	if after := len(metricsSnapshot().(map[string]interface{})); after != before {
+		t.Errorf("Failed NewServer() left metrics registered: %d before, %d after", before, after)
+	}
+}
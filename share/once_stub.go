@@ -0,0 +1,47 @@
+package chshare
+
+import "fmt"
+
+// A stub with a "once" param, e.g., tcp://{"bind":"127.0.0.1:3000","once":true}, is one-shot: its
+// proxy accepts a single Caller connection, then stops listening, and completes when that
+// connection has been bridged. Other stubs keep listening until they are shut down. A client with
+// Config.ExitAfterOnce shuts down once all of its one-shot forward remotes have completed.
+
+// isOnceStub returns true if the stub of chd is one-shot
+func isOnceStub(chd *ChannelDescriptor) (bool, error) {
+	once, err := ParamBool(chd.Stub.GetParamsMap(), "once", false)
+	if err != nil {
+		return false, fmt.Errorf("Remote \"%s\": %s", chd.String(), err)
+	}
+	return once, nil
+}
+
+// checkOnceStubs checks the "once" params of the remotes of a client. If exitAfterOnce is true
+// (see Config.ExitAfterOnce), at least one forward remote must have a one-shot stub, since the
+// client would otherwise never exit on its own.
+func checkOnceStubs(chds []*ChannelDescriptor, exitAfterOnce bool) error {
+	count := 0
+	for _, chd := range chds {
+		once, err := isOnceStub(chd)
+		if err != nil {
+			return err
+		}
+		if once && !chd.Reverse {
+			count++
+		}
+	}
+	if exitAfterOnce && count == 0 {
+		return fmt.Errorf("Exiting after one-shot remotes requires a forward remote with a one-shot stub, e.g. tcp://{\"bind\":\"127.0.0.1:3000\",\"once\":true}")
+	}
+	return nil
+}
+
+// exitAfterOnceProxies shuts the client down cleanly once all of proxies, the one-shot forward
+// proxies that were started, have completed
+func (c *Client) exitAfterOnceProxies(proxies []*TCPProxy) {
+	for _, proxy := range proxies {
+		proxy.WaitShutdown()
+	}
+	c.ILogf("All one-shot remotes have completed; shutting down")
+	c.StartShutdown(nil)
+}
@@ -0,0 +1,94 @@
+package chshare
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckOnceStubs(t *testing.T) {
+	tests := []struct {
+		remotes       []string
+		exitAfterOnce bool
+		errText       string
+	}{
+		{[]string{"3000:localhost:80"}, false, ""},
+		{[]string{`tcp://{"bind":"127.0.0.1:3000","once":true},tcp://localhost:80`}, false, ""},
+		{[]string{`tcp://{"bind":"127.0.0.1:3000","once":true},tcp://localhost:80`, "3001:localhost:81"}, true, ""},
+		{[]string{"3000:localhost:80"}, true, "requires a forward remote with a one-shot stub"},
+		{[]string{`R:tcp://{"bind":"0.0.0.0:3000","once":true},tcp://localhost:80`}, true, "requires a forward remote with a one-shot stub"},
+		{[]string{`tcp://{"bind":"127.0.0.1:3000","once":"yes"},tcp://localhost:80`}, false, "must be a boolean"},
+	}
+	for _, tt := range tests {
+		var chds []*ChannelDescriptor
+		for _, s := range tt.remotes {
+			chd, _, err := ParseChannelDescriptorPath(s)
+			if err != nil {
+				t.Fatalf("ParseChannelDescriptorPath(%q): %s", s, err)
+			}
+			chds = append(chds, &chd)
+		}
+		err := checkOnceStubs(chds, tt.exitAfterOnce)
+		if tt.errText == "" {
+			if err != nil {
+				t.Errorf("checkOnceStubs(%q, %v): unexpected error: %s", tt.remotes, tt.exitAfterOnce, err)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), tt.errText) {
+			t.Errorf("checkOnceStubs(%q, %v) = %v, want error containing %q", tt.remotes, tt.exitAfterOnce, err, tt.errText)
+		}
+	}
+}
+
+func TestOnceStubBridgesItsConnection(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	echoPort, stopEcho := testEchoService(t)
+	defer stopEcho()
+
+	s, err := NewServer(&ProxyServerConfig{KeySeed: "once-test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	serverPort := testLocalPort(t)
+	go s.Run(ctx, "127.0.0.1", strconv.Itoa(serverPort))
+
+	stubAddr := "127.0.0.1:" + strconv.Itoa(testLocalPort(t))
+	c, err := NewClient(&Config{
+		Server:        fmt.Sprintf("http://127.0.0.1:%d", serverPort),
+		Fingerprint:   s.GetFingerprint(),
+		ChdStrings:    []string{fmt.Sprintf(`tcp://{"bind":"%s","once":true},tcp://127.0.0.1:%d`, stubAddr, echoPort)},
+		ExitAfterOnce: true,
+		MaxRetryCount: -1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if err := c.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	waitCtx, waitCancel := context.WithTimeout(ctx, 20*time.Second)
+	defer waitCancel()
+	if _, err := c.GetSSHConnContext(waitCtx); err != nil {
+		t.Fatalf("Client did not connect: %s", err)
+	}
+
+	// the accepted connection must survive the stub closing its listener
+	testEcho(t, stubAddr, "only once")
+
+	if conn, err := net.DialTimeout("tcp4", stubAddr, time.Second); err == nil {
+		conn.Close()
+		t.Errorf("One-shot stub accepted a second connection")
+	}
+	select {
+	case <-c.ShutdownStartedChan():
+	case <-waitCtx.Done():
+		t.Fatalf("Client did not exit after its one-shot remote completed")
+	}
+}
@@ -0,0 +1,91 @@
+package chshare
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// OriginChecker decides whether a websocket upgrade request should be accepted based on
+// its Origin header. An empty allowlist accepts all origins, which preserves the historical
+// permissive behavior. Requests without an Origin header (i.e., non-browser clients such as
+// the wstunnel client) are always accepted.
+//
+// Each allowlist entry is one of:
+//
+//	"*"                          matches any origin
+//	"example.com"                matches origin host example.com with any scheme
+//	"*.example.com"              matches any subdomain of example.com (but not example.com itself)
+//	"https://example.com"        matches origin host example.com with scheme https
+//	"https://*.example.com"      matches any https subdomain of example.com
+//
+// A port, if present in an entry, must match the origin's port exactly. Matching is
+// case-insensitive.
+type OriginChecker struct {
+	allowed []string
+}
+
+// NewOriginChecker creates an OriginChecker from a list of allowed origin patterns.
+// Empty entries and surrounding whitespace are ignored.
+func NewOriginChecker(allowed []string) *OriginChecker {
+	oc := &OriginChecker{}
+	for _, a := range allowed {
+		a = strings.ToLower(strings.TrimSpace(a))
+		if a != "" {
+			oc.allowed = append(oc.allowed, a)
+		}
+	}
+	return oc
+}
+
+// ParseAllowedOrigins splits a comma-separated list of allowed origin patterns
+func ParseAllowedOrigins(s string) []string {
+	if strings.TrimSpace(s) == "" {
+		return nil
+	}
+	return strings.Split(s, ",")
+}
+
+// IsPermissive returns true if all origins are accepted
+func (oc *OriginChecker) IsPermissive() bool {
+	return len(oc.allowed) == 0
+}
+
+// CheckOrigin returns true if the request's Origin header is allowed. It has the
+// signature required by websocket.Upgrader.CheckOrigin.
+func (oc *OriginChecker) CheckOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" || oc.IsPermissive() {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	scheme := strings.ToLower(u.Scheme)
+	host := strings.ToLower(u.Host)
+	for _, a := range oc.allowed {
+		if originPatternMatch(a, scheme, host) {
+			return true
+		}
+	}
+	return false
+}
+
+func originPatternMatch(pattern string, scheme string, host string) bool {
+	if pattern == "*" {
+		return true
+	}
+	if i := strings.Index(pattern, "://"); i >= 0 {
+		if pattern[:i] != scheme {
+			return false
+		}
+		pattern = pattern[i+3:]
+	}
+	pattern = strings.TrimSuffix(pattern, "/")
+	if strings.HasPrefix(pattern, "*.") {
+		suffix := pattern[1:]
+		return len(host) > len(suffix) && strings.HasSuffix(host, suffix)
+	}
+	return pattern == host
+}
@@ -0,0 +1,71 @@
+package chshare
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestOriginCheckerCheckOrigin(t *testing.T) {
+	tests := []struct {
+		allowed []string
+		origin  string
+		want    bool
+	}{
+		// exact match
+		{[]string{"example.com"}, "https://example.com", true},
+		{[]string{"example.com"}, "http://EXAMPLE.com", true},
+		{[]string{"example.com"}, "https://other.com", false},
+		{[]string{" https://example.com/ "}, "https://example.com", true},
+
+		// subdomain wildcard
+		{[]string{"*.example.com"}, "https://www.example.com", true},
+		{[]string{"*.example.com"}, "https://a.b.example.com", true},
+		{[]string{"*.example.com"}, "https://example.com", false},
+		{[]string{"*.example.com"}, "https://evilexample.com", false},
+		{[]string{"*.example.com"}, "https://www.example.com.evil.com", false},
+		{[]string{"https://*.example.com"}, "http://www.example.com", false},
+
+		// scheme mismatch
+		{[]string{"https://example.com"}, "https://example.com", true},
+		{[]string{"https://example.com"}, "http://example.com", false},
+
+		// port mismatch
+		{[]string{"example.com:8443"}, "https://example.com:8443", true},
+		{[]string{"example.com:8443"}, "https://example.com:9443", false},
+		{[]string{"example.com:8443"}, "https://example.com", false},
+		{[]string{"https://example.com:8443"}, "https://example.com:9443", false},
+
+		// no Origin header, as sent by non-browser clients
+		{[]string{"example.com"}, "", true},
+
+		// empty allowlist and "*" accept anything
+		{nil, "https://evil.com", true},
+		{[]string{"*"}, "https://evil.com", true},
+
+		// unparseable or host-less origins
+		{[]string{"example.com"}, "null", false},
+		{[]string{"example.com"}, "https://%zz", false},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "http://wstunnel.test/", nil)
+		if test.origin != "" {
+			r.Header.Set("Origin", test.origin)
+		}
+		if got := NewOriginChecker(test.allowed).CheckOrigin(r); got != test.want {
+			t.Errorf("NewOriginChecker(%q).CheckOrigin(Origin: %q) = %v, want %v", test.allowed, test.origin, got, test.want)
+		}
+	}
+}
+
+func TestParseAllowedOrigins(t *testing.T) {
+	if got := ParseAllowedOrigins("  "); got != nil {
+		t.Errorf("ParseAllowedOrigins(\"  \") = %q, want nil", got)
+	}
+	oc := NewOriginChecker(ParseAllowedOrigins("example.com, ,*.example.org"))
+	if oc.IsPermissive() {
+		t.Errorf("NewOriginChecker(ParseAllowedOrigins()) is permissive")
+	}
+	if len(oc.allowed) != 2 {
+		t.Errorf("NewOriginChecker(ParseAllowedOrigins()) = %q, want 2 patterns", oc.allowed)
+	}
+}
@@ -0,0 +1,40 @@
+package chshare
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// normalizePathPrefix returns the canonical form of a tunnel path prefix ("/tunnel/ws"), with a
+// single leading slash and no trailing slash. An empty prefix, or "/", yields "" (the root).
+func normalizePathPrefix(prefix string) (string, error) {
+	if strings.ContainsAny(prefix, "?# ") {
+		return "", fmt.Errorf("Invalid path prefix \"%s\"", prefix)
+	}
+	trimmed := strings.Trim(prefix, "/")
+	if trimmed == "" {
+		return "", nil
+	}
+	return "/" + trimmed, nil
+}
+
+// pathHasPrefix returns true if the URL path p is at or below the normalized prefix. Every path
+// is below the root prefix "".
+func pathHasPrefix(p string, prefix string) bool {
+	if prefix == "" {
+		return true
+	}
+	return p == prefix || strings.HasPrefix(p, prefix+"/")
+}
+
+// appendURLPath appends the normalized prefix to the path of u, preserving any path already in u
+func appendURLPath(u *url.URL, prefix string) {
+	if prefix == "" {
+		return
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + prefix
+	if u.RawPath != "" {
+		u.RawPath = strings.TrimSuffix(u.RawPath, "/") + prefix
+	}
+}
@@ -0,0 +1,75 @@
+package chshare
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestNormalizePathPrefix(t *testing.T) {
+	tests := []struct {
+		prefix   string
+		expected string
+		isErr    bool
+	}{
+		{"", "", false},
+		{"/", "", false},
+		{"tunnel/ws", "/tunnel/ws", false},
+		{"/tunnel/ws/", "/tunnel/ws", false},
+		{"/tunnel?x=1", "", true},
+	}
+	for _, test := range tests {
+		got, err := normalizePathPrefix(test.prefix)
+		if test.isErr {
+			if err == nil {
+				t.Errorf("normalizePathPrefix(%q): expected an error", test.prefix)
+			}
+			continue
+		}
+		if err != nil || got != test.expected {
+			t.Errorf("normalizePathPrefix(%q) = %q, %v; expected %q", test.prefix, got, err, test.expected)
+		}
+	}
+}
+
+func TestPathHasPrefix(t *testing.T) {
+	tests := []struct {
+		path     string
+		prefix   string
+		expected bool
+	}{
+		{"/", "", true},
+		{"/anything", "", true},
+		{"/tunnel/ws", "/tunnel/ws", true},
+		{"/tunnel/ws/x", "/tunnel/ws", true},
+		{"/tunnel/wsx", "/tunnel/ws", false},
+		{"/", "/tunnel/ws", false},
+	}
+	for _, test := range tests {
+		if got := pathHasPrefix(test.path, test.prefix); got != test.expected {
+			t.Errorf("pathHasPrefix(%q, %q) = %v; expected %v", test.path, test.prefix, got, test.expected)
+		}
+	}
+}
+
+func TestAppendURLPath(t *testing.T) {
+	tests := []struct {
+		server   string
+		prefix   string
+		expected string
+	}{
+		{"ws://example.com:80", "", "ws://example.com:80"},
+		{"ws://example.com:80", "/tunnel/ws", "ws://example.com:80/tunnel/ws"},
+		{"ws://example.com:80/", "/tunnel/ws", "ws://example.com:80/tunnel/ws"},
+		{"ws://example.com:80/base/", "/tunnel/ws", "ws://example.com:80/base/tunnel/ws"},
+	}
+	for _, test := range tests {
+		u, err := url.Parse(test.server)
+		if err != nil {
+			t.Fatal(err)
+		}
+		appendURLPath(u, test.prefix)
+		if got := u.String(); got != test.expected {
+			t.Errorf("appendURLPath(%q, %q) = %q; expected %q", test.server, test.prefix, got, test.expected)
+		}
+	}
+}
@@ -3,10 +3,35 @@ package chshare
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"golang.org/x/crypto/ssh"
+	"sync"
+	"time"
 )
 
+// SSHConnWaitTimeout is the maximum time that a connection accepted by a stub will wait for the
+// primary SSH connection to the remote proxy to become available before the caller is disconnected.
+const SSHConnWaitTimeout = 60 * time.Second
+
+// ConcurrentAcceptEnv may be implemented by a LocalChannelEnv to limit the number of connections
+// that each of its stub listeners handles at the same time
+type ConcurrentAcceptEnv interface {
+	// GetMaxConcurrentAccepts returns the maximum number of connections a stub listener handles at
+	// once, or 0 for no limit
+	GetMaxConcurrentAccepts() int
+}
+
+// getEnvMaxConcurrentAccepts returns the limit provided by env, or 0 if it does not implement
+// ConcurrentAcceptEnv
+func getEnvMaxConcurrentAccepts(env LocalChannelEnv) int {
+	caEnv, ok := env.(ConcurrentAcceptEnv)
+	if !ok {
+		return 0
+	}
+	return caEnv.GetMaxConcurrentAccepts()
+}
+
 // GetSSHConn is a callback that is used to defer fetching of the ssh.Conn
 // until after it is established
 type GetSSHConn func() ssh.Conn
@@ -17,22 +42,39 @@ type TCPProxy struct {
 	ShutdownHelper
 	localChannelEnv LocalChannelEnv
 	id              int
+	name            string
 	strname         string
 	count           int
 	chd             *ChannelDescriptor
 	ep              LocalStubChannelEndpoint
+	metrics         *Metrics
+	// once is true for a one-shot stub (see isOnceStub)
+	once bool
+
+	// epLock protects ep, for stopListening, and listenStopped, which is true once the stub
+	// endpoint has been asked to stop listening
+	epLock        sync.Mutex
+	listenStopped bool
 }
 
-// NewTCPProxy creates a new TCPProxy
-func NewTCPProxy(logger Logger, localChannelEnv LocalChannelEnv, index int, chd *ChannelDescriptor) *TCPProxy {
+// NewTCPProxy creates a new TCPProxy. metrics, if not nil, receives channel counters. A proxy
+// for a named descriptor (see ChannelDescriptor.Name) includes its name in its log prefix.
+func NewTCPProxy(logger Logger, localChannelEnv LocalChannelEnv, index int, chd *ChannelDescriptor, metrics *Metrics) *TCPProxy {
 	id := index + 1
+	// the descriptor has been validated, so the name is well formed
+	name, _ := chd.Name()
 	strname := fmt.Sprintf("proxy#%d:%s", id, chd)
+	if name != "" {
+		strname = fmt.Sprintf("proxy#%d(%s):%s", id, name, chd)
+	}
 	myLogger := logger.Fork("%s", strname)
 	p := &TCPProxy{
 		localChannelEnv: localChannelEnv,
 		id:              id,
+		name:            name,
 		strname:         strname,
 		chd:             chd,
+		metrics:         metrics,
 	}
 	p.InitShutdownHelper(myLogger, p)
 	return p
@@ -42,6 +84,16 @@ func (p *TCPProxy) String() string {
 	return p.strname
 }
 
+// Name returns the name of the proxy's tunnel, or "" if it is unnamed
+func (p *TCPProxy) Name() string {
+	return p.name
+}
+
+// Descriptor returns the channel descriptor of the proxy's tunnel
+func (p *TCPProxy) Descriptor() *ChannelDescriptor {
+	return p.chd
+}
+
 // HandleOnceShutdown will be called exactly once, in its own goroutine. It should take completionError
 // as an advisory completion value, actually shut down, then return the real completion value.
 func (p *TCPProxy) HandleOnceShutdown(completionErr error) error {
@@ -54,6 +106,11 @@ func (p *TCPProxy) Start(ctx context.Context) error {
 	// acceptLoop should not be included
 	err := p.DoOnceActivate(
 		func() error {
+			once, err := isOnceStub(p.chd)
+			if err != nil {
+				return p.Errorf("%s", err)
+			}
+			p.once = once
 			ep, err := NewLocalStubChannelEndpoint(p.Logger, p.localChannelEnv, p.chd.Stub)
 			if err != nil {
 				return p.Errorf("Unable to create Stub endpoint from descriptor %s: %s", p.chd.Stub, err)
@@ -64,7 +121,10 @@ func (p *TCPProxy) Start(ctx context.Context) error {
 			if err != nil {
 				return p.Errorf("StartListening failed for %s: %s", p.chd.Stub, err)
 			}
+			p.epLock.Lock()
 			p.ep = ep
+			p.epLock.Unlock()
+			p.ILogf("Remote set up as %s", p.chd.CanonicalString())
 
 			go p.acceptLoop(ctx)
 
@@ -75,6 +135,11 @@ func (p *TCPProxy) Start(ctx context.Context) error {
 	return err
 }
 
+// acceptLoop accepts connections on the stub listener and handles each in its own goroutine. If
+// the environment limits concurrent accepts (see ConcurrentAcceptEnv), no more connections are
+// accepted while the limit is reached, so that further connections wait in the listen backlog
+// rather than each consuming a goroutine. A one-shot stub closes its listener after the first
+// connection, and the proxy completes when that connection does.
 func (p *TCPProxy) acceptLoop(ctx context.Context) {
 	done := make(chan struct{})
 	go func() {
@@ -86,22 +151,143 @@ func (p *TCPProxy) acceptLoop(ctx context.Context) {
 		case <-done:
 		}
 	}()
+	var slots chan struct{}
+	if limit := getEnvMaxConcurrentAccepts(p.localChannelEnv); limit > 0 {
+		slots = make(chan struct{}, limit)
+	}
+	atLimit := false
 	for {
+		if slots != nil {
+			select {
+			case slots <- struct{}{}:
+				atLimit = false
+			default:
+				if !atLimit {
+					// logged once each time the limit is reached, not once per waiting connection
+					atLimit = true
+					p.ILogf("Limit of %d concurrent connections reached; new connections wait in the listen backlog", cap(slots))
+				}
+				select {
+				case slots <- struct{}{}:
+				case <-ctx.Done():
+					close(done)
+					return
+				case <-p.ShutdownStartedChan():
+					close(done)
+					return
+				}
+			}
+		}
 		callerConn, err := p.ep.Accept(ctx)
 		if err != nil {
 			select {
 			case <-ctx.Done():
 				//listener closed
+			case <-p.ShutdownStartedChan():
+				//proxy shutting down
 			default:
+				if p.isListenStopped() {
+					//listener stopped, e.g. in the listeners phase of a session's shutdown
+					break
+				}
 				p.ILogf("Accept error from %s, shutting down accept loop: %s", p.chd.Stub, err)
+				if p.metrics != nil {
+					p.metrics.AcceptError()
+				}
 			}
 			close(done)
 			return
 		}
-		go p.runWithLocalCallerConn(ctx, callerConn)
+		release := func() {}
+		if slots != nil {
+			release = func() { <-slots }
+		}
+		if p.chd.Stub.Type == ChannelEndpointProtocolStdio {
+			// stdio has exactly one Caller; when it is done, so is the proxy
+			go func() {
+				defer release()
+				p.StartShutdown(p.runWithLocalCallerConn(ctx, callerConn))
+			}()
+			continue
+		}
+		if p.once {
+			// only the listener is closed here; closing the endpoint would close callerConn too
+			p.ILogf("One-shot stub accepted its connection; no longer listening")
+			close(done)
+			p.stopListening()
+			go func() {
+				defer release()
+				err := p.runWithLocalCallerConn(ctx, callerConn)
+				p.ep.Close()
+				p.StartShutdown(err)
+			}()
+			return
+		}
+		go func() {
+			defer release()
+			p.runWithLocalCallerConn(ctx, callerConn)
+		}()
+	}
+}
+
+// stopListening stops the stub endpoint from accepting new Callers, without closing the
+// connections it has already accepted. An endpoint that cannot do this (see ListenStopper) keeps
+// listening until it is closed.
+func (p *TCPProxy) stopListening() {
+	p.epLock.Lock()
+	ep := p.ep
+	p.listenStopped = true
+	p.epLock.Unlock()
+	if ep == nil {
+		// not started
+		return
+	}
+	ls, ok := ep.(ListenStopper)
+	if !ok {
+		p.DLogf("Stub endpoint %s cannot stop listening without closing; it listens until the proxy shuts down", p.chd.Stub)
+		return
+	}
+	if err := ls.StopListening(); err != nil {
+		p.DLogf("Unable to stop listening on %s: %s", p.chd.Stub, err)
 	}
 }
 
+// ForceClose starts the shutdown of the proxy and of its stub endpoint, closing its listener
+// without waiting for the channels it is bridging. Implements ForceCloser for ShutdownWithTimeout.
+func (p *TCPProxy) ForceClose() error {
+	p.epLock.Lock()
+	ep := p.ep
+	p.epLock.Unlock()
+	if ep != nil {
+		ep.StartShutdown(nil)
+	}
+	p.StartShutdown(nil)
+	return nil
+}
+
+func (p *TCPProxy) isListenStopped() bool {
+	p.epLock.Lock()
+	defer p.epLock.Unlock()
+	return p.listenStopped
+}
+
+// proxyListener is the stub listener of a TCPProxy, as a PhasedShutdownChild. Shutting it down
+// stops the proxy accepting new Callers, without closing the channels it is already bridging, so
+// that they can drain in a later phase.
+type proxyListener struct {
+	p *TCPProxy
+}
+
+// StartShutdown stops the proxy's stub listener
+func (l proxyListener) StartShutdown(completionErr error) {
+	l.p.stopListening()
+}
+
+// WaitShutdown returns immediately; the listener is closed by StartShutdown
+func (l proxyListener) WaitShutdown() error {
+	return nil
+}
+
 func (p *TCPProxy) runWithLocalCallerConn(ctx context.Context, callerConn ChannelConn) error {
 	subCtx, subCtxCancel := context.WithCancel(ctx)
 	defer subCtxCancel()
@@ -109,9 +295,12 @@ func (p *TCPProxy) runWithLocalCallerConn(ctx context.Context, callerConn Channe
 	p.count++
 
 	p.DLogf("TCPProxy Open, getting remote connection")
-	sshPrimaryConn, err := p.localChannelEnv.GetSSHConn()
+	waitCtx, waitCtxCancel := context.WithTimeout(subCtx, SSHConnWaitTimeout)
+	sshPrimaryConn, err := p.localChannelEnv.GetSSHConnContext(waitCtx)
+	waitCtxCancel()
 	if err != nil {
-		return p.DLogErrorf("Unable to fetch sshPrimaryConn , exiting proxy: %s", err)
+		callerConn.Close()
+		return p.DLogErrorf("Unable to fetch sshPrimaryConn, closing caller connection: %s", err)
 	}
 
 	if sshPrimaryConn == nil {
@@ -119,17 +308,40 @@ func (p *TCPProxy) runWithLocalCallerConn(ctx context.Context, callerConn Channe
 		return p.DLogErrorf("SSH primary connection, exiting proxy")
 	}
 
+	// a connection accepted by a transparent stub goes to its original destination rather
+	// than to the configured skeleton target
+	skeleton := p.chd.Skeleton
+	if odc, ok := callerConn.(OriginalDestinationConn); ok {
+		if dst := odc.GetOriginalDestination(); dst != "" {
+			if skeleton.Type != ChannelEndpointProtocolTCP {
+				callerConn.Close()
+				return p.DLogErrorf("Transparent stub requires a TCP skeleton, got %s", skeleton)
+			}
+			override := *skeleton
+			override.Path = dst
+			skeleton = &override
+		}
+	}
+
 	//ssh request for tcp connection for this proxy's remote skeleton endpoint
-	skeletonEndpointJSON, err := json.Marshal(p.chd.Skeleton)
+	skeletonEndpointJSON, err := json.Marshal(skeleton)
 	if err != nil {
 		callerConn.Close()
-		return p.DLogErrorf("Unable to serialize endpoint descriptor '%s': %s", p.chd.Skeleton, err)
+		return p.DLogErrorf("Unable to serialize endpoint descriptor '%s': %s", skeleton, err)
+	}
+	skeletonEndpointJSON, err = AddChannelMetadata(skeletonEndpointJSON, NewChannelMetadata(p.localChannelEnv, callerConn))
+	if err != nil {
+		callerConn.Close()
+		return p.DLogErrorf("Unable to add channel metadata for '%s': %s", skeleton, err)
 	}
 
-	serviceSSHConn, reqs, err := sshPrimaryConn.OpenChannel("wstunnel", skeletonEndpointJSON)
+	serviceSSHConn, reqs, err := sshPrimaryConn.OpenChannel(p.localChannelEnv.GetChannelType(), skeletonEndpointJSON)
 	if err != nil {
+		if p.metrics != nil {
+			p.metrics.DialError()
+		}
 		callerConn.Close()
-		return p.DLogErrorf("SSH open channel to remote endpoint %s failed: %s", p.chd.Skeleton, err)
+		return p.DLogErrorf("SSH open channel to remote endpoint %s failed: %s", skeleton, err)
 	}
 
 	// will terminate when serviceSSHConn is closed
@@ -145,7 +357,31 @@ func (p *TCPProxy) runWithLocalCallerConn(ctx context.Context, callerConn Channe
 		return p.DLogErrorf("SSH open channel to remote endpoint %s failed: %s", p.chd.Skeleton, err)
 	}
 
-	callerToService, serviceToCaller, err := BasicBridgeChannels(subCtx, p.Logger, callerConn, serviceConn)
+	if tracker, ok := p.localChannelEnv.(channelTracker); ok {
+		defer tracker.trackChannel(sshPrimaryConn)()
+	}
+	if registry, ok := p.localChannelEnv.(proxyChannelRegistry); ok {
+		defer registry.registerProxyChannel(p.chd.String(), callerConn, serviceConn)()
+	}
+
+	if p.metrics != nil {
+		p.metrics.Channels.New()
+		p.metrics.Channels.Open()
+	}
+	bridgeCtx := WithHalfCloseLinger(subCtx, GetEnvHalfCloseLinger(p.localChannelEnv))
+	bridgeCtx = WithChannelBufferSize(bridgeCtx, GetEnvChannelBufferSize(p.localChannelEnv))
+	bridgeCtx = withEnvChannelTrace(bridgeCtx, p.localChannelEnv, p.chd.String())
+	callerToService, serviceToCaller, err := BasicBridgeChannels(bridgeCtx, p.Logger, callerConn, serviceConn)
+	if p.metrics != nil {
+		p.metrics.Channels.Close()
+		p.metrics.AddBytes(callerToService, serviceToCaller)
+	}
+	if errors.Is(err, ErrOutputClosed) {
+		// the Caller's output was closed by its reader (e.g., the next command in a pipeline
+		// exited); there is no one left to deliver to, which is a normal end
+		p.DLogf("Caller output closed by its reader")
+		err = nil
+	}
 	if err == nil {
 		p.DLogf("Proxy Connection for %s ended normally, caller sent %d bytes, service sent %d bytes",
 			p.chd, callerToService, serviceToCaller)
@@ -0,0 +1,63 @@
+package chshare
+
+import (
+	"fmt"
+	"sync"
+)
+
+// proxyNameIndex indexes the named proxies of a client or server session (see
+// ChannelDescriptor.Name), so they can be looked up without relying on their index.
+// Unnamed proxies are not indexed, and keep only their index-based identity.
+type proxyNameIndex struct {
+	lock   sync.Mutex
+	byName map[string]*TCPProxy
+}
+
+// add indexes a proxy by its name, if it has one. It is an error for the name to be in use.
+func (x *proxyNameIndex) add(proxy *TCPProxy) error {
+	name := proxy.Name()
+	if name == "" {
+		return nil
+	}
+	x.lock.Lock()
+	defer x.lock.Unlock()
+	if x.byName == nil {
+		x.byName = make(map[string]*TCPProxy)
+	}
+	if other, ok := x.byName[name]; ok {
+		return fmt.Errorf("Tunnel name \"%s\" of %s is already used by %s", name, proxy, other)
+	}
+	x.byName[name] = proxy
+	return nil
+}
+
+// remove removes a proxy from the index, if it is indexed
+func (x *proxyNameIndex) remove(proxy *TCPProxy) {
+	name := proxy.Name()
+	if name == "" {
+		return
+	}
+	x.lock.Lock()
+	defer x.lock.Unlock()
+	if x.byName[name] == proxy {
+		delete(x.byName, name)
+	}
+}
+
+// get returns the proxy with the given name, or nil if there is none
+func (x *proxyNameIndex) get(name string) *TCPProxy {
+	x.lock.Lock()
+	defer x.lock.Unlock()
+	return x.byName[name]
+}
+
+// all returns the named proxies, in no particular order
+func (x *proxyNameIndex) all() []*TCPProxy {
+	x.lock.Lock()
+	defer x.lock.Unlock()
+	result := make([]*TCPProxy, 0, len(x.byName))
+	for _, proxy := range x.byName {
+		result = append(result, proxy)
+	}
+	return result
+}
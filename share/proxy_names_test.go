@@ -0,0 +1,30 @@
+package chshare
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestProxyNameIndex(t *testing.T) {
+	var x proxyNameIndex
+	db := &TCPProxy{name: "db", strname: "proxy#1(db)"}
+	unnamed := &TCPProxy{strname: "proxy#2"}
+	for _, p := range []*TCPProxy{db, unnamed, {strname: "proxy#3"}} {
+		if err := x.add(p); err != nil {
+			t.Fatalf("add(%s): unexpected error: %s", p, err)
+		}
+	}
+	if got := x.get("db"); got != db {
+		t.Errorf("get(\"db\") = %v, want %s", got, db)
+	}
+	if got := x.get(""); got != nil {
+		t.Errorf("get(\"\") = %s, want nil; unnamed proxies are not indexed", got)
+	}
+	err := x.add(&TCPProxy{name: "db", strname: "proxy#4(db)"})
+	if err == nil || !strings.Contains(err.Error(), "already used by proxy#1(db)") {
+		t.Errorf("add() of a duplicate name = %v, want collision error", err)
+	}
+	if n := len(x.all()); n != 1 {
+		t.Errorf("all() returned %d proxies, want 1", n)
+	}
+}
@@ -0,0 +1,77 @@
+package chshare
+
+import (
+	"sync"
+)
+
+// proxySet holds the running proxies of a client or server session that its owner shuts down,
+// and waits for, from its HandleOnceShutdown. Unlike a child added with AddShutdownChild, a proxy
+// can be dropped from the set when it is stopped on its own, so the owner neither holds on to it
+// nor waits for it again.
+type proxySet struct {
+	lock     sync.Mutex
+	shutdown bool
+	proxies  map[*TCPProxy]struct{}
+}
+
+// add adds a proxy to the set. If the set has already been shut down, the proxy is not added, its
+// shutdown is started immediately, and false is returned.
+func (ps *proxySet) add(proxy *TCPProxy) bool {
+	ps.lock.Lock()
+	shutdown := ps.shutdown
+	if !shutdown {
+		if ps.proxies == nil {
+			ps.proxies = make(map[*TCPProxy]struct{})
+		}
+		ps.proxies[proxy] = struct{}{}
+	}
+	ps.lock.Unlock()
+	if shutdown {
+		proxy.StartShutdown(nil)
+		return false
+	}
+	return true
+}
+
+// remove drops a proxy from the set; its owner is responsible for shutting it down
+func (ps *proxySet) remove(proxy *TCPProxy) {
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+	delete(ps.proxies, proxy)
+}
+
+// forceCloseAll force closes the proxies in the set (see TCPProxy.ForceClose), without removing
+// them or waiting for them
+func (ps *proxySet) forceCloseAll() {
+	ps.lock.Lock()
+	proxies := make([]*TCPProxy, 0, len(ps.proxies))
+	for proxy := range ps.proxies {
+		proxies = append(proxies, proxy)
+	}
+	ps.lock.Unlock()
+
+	for _, proxy := range proxies {
+		proxy.ForceClose()
+	}
+}
+
+// shutdownAll shuts down the proxies in the set concurrently, with completionErr as the advisory
+// completion error, and waits for them to complete. Proxies added afterwards are shut down
+// immediately.
+func (ps *proxySet) shutdownAll(completionErr error) {
+	ps.lock.Lock()
+	ps.shutdown = true
+	proxies := make([]*TCPProxy, 0, len(ps.proxies))
+	for proxy := range ps.proxies {
+		proxies = append(proxies, proxy)
+	}
+	ps.proxies = nil
+	ps.lock.Unlock()
+
+	for _, proxy := range proxies {
+		proxy.StartShutdown(completionErr)
+	}
+	for _, proxy := range proxies {
+		proxy.WaitShutdown()
+	}
+}
@@ -0,0 +1,43 @@
+package chshare
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http/httputil"
+	"strings"
+)
+
+// SetProxyTarget replaces the target of the reverse proxy that serves normal HTTP requests (as
+// set with --proxy). Requests in flight complete against the old target. An empty rawURL disables
+// the reverse proxy, so the server responds to normal HTTP requests itself. If rawURL is invalid,
+// an error is returned and the old target remains in effect.
+func (s *Server) SetProxyTarget(rawURL string) error {
+	var reverseProxy *httputil.ReverseProxy
+	if rawURL != "" {
+		var err error
+		reverseProxy, err = newSingleHostReverseProxy(rawURL)
+		if err != nil {
+			return fmt.Errorf("Invalid reverse proxy target \"%s\": %s", rawURL, err)
+		}
+	}
+	s.proxyLock.Lock()
+	s.reverseProxy = reverseProxy
+	s.proxyLock.Unlock()
+	return nil
+}
+
+// getReverseProxy returns the current reverse proxy, or nil if there is none
+func (s *Server) getReverseProxy() *httputil.ReverseProxy {
+	s.proxyLock.RLock()
+	defer s.proxyLock.RUnlock()
+	return s.reverseProxy
+}
+
+// loadProxyFile sets the reverse proxy target from the contents of the --proxy-file
+func (s *Server) loadProxyFile() error {
+	b, err := ioutil.ReadFile(s.proxyFile)
+	if err != nil {
+		return fmt.Errorf("Unable to read reverse proxy target file: %s", err)
+	}
+	return s.SetProxyTarget(strings.TrimSpace(string(b)))
+}
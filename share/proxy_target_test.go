@@ -0,0 +1,34 @@
+package chshare
+
+import (
+	"testing"
+)
+
+func TestSetProxyTarget(t *testing.T) {
+	s := &Server{}
+	if err := s.SetProxyTarget("http://blue.example.com:8080"); err != nil {
+		t.Fatalf("SetProxyTarget() returned error: %s", err)
+	}
+	blue := s.getReverseProxy()
+	if blue == nil {
+		t.Fatalf("SetProxyTarget() did not enable the reverse proxy")
+	}
+
+	if err := s.SetProxyTarget("green.example.com"); err == nil {
+		t.Errorf("SetProxyTarget() accepted a URL without a protocol")
+	}
+	if s.getReverseProxy() != blue {
+		t.Errorf("SetProxyTarget() with an invalid URL replaced the previous target")
+	}
+
+	if err := s.SetProxyTarget("http://green.example.com:8080"); err != nil {
+		t.Fatalf("SetProxyTarget() returned error: %s", err)
+	}
+	if rp := s.getReverseProxy(); rp == nil || rp == blue {
+		t.Errorf("SetProxyTarget() did not replace the previous target")
+	}
+
+	if err := s.SetProxyTarget(""); err != nil || s.getReverseProxy() != nil {
+		t.Errorf("SetProxyTarget(\"\") = %v; want the reverse proxy disabled", err)
+	}
+}
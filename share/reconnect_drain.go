@@ -0,0 +1,113 @@
+package chshare
+
+import (
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// When the server disconnects a client because it is draining (see DisconnectCodeDraining), the
+// client can reconnect right away and let the channels in flight on the old connection finish,
+// rather than cutting them off. Each SSH connection to the server is a generation, and the client
+// counts the channels in flight on each one. New channels always use the current generation;
+// an old generation is closed once its channels have finished or Config.ReconnectDrainGrace has
+// passed, whichever comes first.
+
+// channelTracker is implemented by a LocalChannelEnv that counts the channels in flight on each
+// of its SSH connections
+type channelTracker interface {
+	// trackChannel records a channel opened on sshConn, returning a function to be called
+	// when the channel has ended
+	trackChannel(sshConn ssh.Conn) func()
+}
+
+// connGeneration counts the channels in flight on one SSH connection to the server
+type connGeneration struct {
+	active int
+
+	// idle, if not nil, is closed when active drops to 0
+	idle chan struct{}
+}
+
+// trackChannel records a channel opened on sshConn, returning a function to be called when the
+// channel has ended. Implements channelTracker.
+func (c *Client) trackChannel(sshConn ssh.Conn) func() {
+	c.generationsLock.Lock()
+	defer c.generationsLock.Unlock()
+	if c.generations == nil {
+		c.generations = make(map[ssh.Conn]*connGeneration)
+	}
+	gen := c.generations[sshConn]
+	if gen == nil {
+		gen = &connGeneration{}
+		c.generations[sshConn] = gen
+	}
+	gen.active++
+	return func() {
+		c.generationsLock.Lock()
+		defer c.generationsLock.Unlock()
+		gen.active--
+		if gen.active == 0 && gen.idle != nil {
+			close(gen.idle)
+			gen.idle = nil
+		}
+	}
+}
+
+// forgetGeneration stops tracking a closed SSH connection
+func (c *Client) forgetGeneration(sshConn ssh.Conn) {
+	c.generationsLock.Lock()
+	defer c.generationsLock.Unlock()
+	delete(c.generations, sshConn)
+}
+
+// closeGenerations closes all SSH connections that are still being tracked, including old
+// connections that are draining
+func (c *Client) closeGenerations() error {
+	c.generationsLock.Lock()
+	conns := make([]ssh.Conn, 0, len(c.generations))
+	for sshConn := range c.generations {
+		conns = append(conns, sshConn)
+	}
+	c.generationsLock.Unlock()
+	var err error
+	for _, sshConn := range conns {
+		if cerr := sshConn.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// drainGeneration waits up to grace for the channels in flight on an old SSH connection to
+// finish, then closes it. Channels still active when the grace expires are cut off.
+func (c *Client) drainGeneration(sshConn ssh.Conn, grace time.Duration) {
+	c.generationsLock.Lock()
+	var idle chan struct{}
+	if gen := c.generations[sshConn]; gen != nil && gen.active > 0 {
+		if gen.idle == nil {
+			gen.idle = make(chan struct{})
+		}
+		idle = gen.idle
+	}
+	c.generationsLock.Unlock()
+
+	if idle != nil {
+		timer := c.config.Clock.NewTimer(grace)
+		select {
+		case <-idle:
+			c.DLogf("Channels on the previous connection finished within the reconnect drain grace")
+		case <-timer.C():
+			c.generationsLock.Lock()
+			active := 0
+			if gen := c.generations[sshConn]; gen != nil {
+				active = gen.active
+			}
+			c.generationsLock.Unlock()
+			c.ILogf("Reconnect drain grace of %s exceeded with %d channel(s) still active on the previous connection; force closing them", grace, active)
+		}
+		timer.Stop()
+	}
+	sshConn.Close()
+	c.forgetGeneration(sshConn)
+}
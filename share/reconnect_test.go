@@ -0,0 +1,122 @@
+package chshare
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// testLocalPort returns a TCP port on the loopback interface that was free when checked
+func testLocalPort(t *testing.T) int {
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+// testEcho sends a message through the stub at addr to an echo service, and checks the reply
+func testEcho(t *testing.T, addr string, msg string) {
+	conn, err := net.DialTimeout("tcp4", addr, 5*time.Second)
+	if err != nil {
+		t.Fatalf("Unable to connect to stub %s: %s", addr, err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	if _, err := conn.Write([]byte(msg)); err != nil {
+		t.Fatalf("Write through tunnel failed: %s", err)
+	}
+	reply := make([]byte, len(msg))
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		t.Fatalf("Read through tunnel failed: %s", err)
+	}
+	if string(reply) != msg {
+		t.Fatalf("Expected echo %q, got %q", msg, reply)
+	}
+}
+
+// testEchoService starts a TCP echo service on the loopback interface, returning its port and a
+// function that stops it
+func testEchoService(t *testing.T) (int, func()) {
+	echo, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := echo.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(conn, conn)
+				conn.Close()
+			}()
+		}
+	}()
+	return echo.Addr().(*net.TCPAddr).Port, func() { echo.Close() }
+}
+
+func TestClientReconnectKeepsForwardListeners(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	echoPort, stopEcho := testEchoService(t)
+	defer stopEcho()
+
+	s, err := NewServer(&ProxyServerConfig{KeySeed: "reconnect-test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	serverPort := testLocalPort(t)
+	go s.Run(ctx, "127.0.0.1", strconv.Itoa(serverPort))
+
+	stubPort := testLocalPort(t)
+	c, err := NewClient(&Config{
+		Server:        fmt.Sprintf("http://127.0.0.1:%d", serverPort),
+		Fingerprint:   s.GetFingerprint(),
+		ChdStrings:    []string{fmt.Sprintf("127.0.0.1:%d:127.0.0.1:%d", stubPort, echoPort)},
+		MaxRetryCount: -1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if err := c.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	waitCtx, waitCancel := context.WithTimeout(ctx, 20*time.Second)
+	defer waitCancel()
+	first, err := c.GetSSHConnContext(waitCtx)
+	if err != nil {
+		t.Fatalf("Client did not connect: %s", err)
+	}
+	stubAddr := "127.0.0.1:" + strconv.Itoa(stubPort)
+	testEcho(t, stubAddr, "before")
+
+	// drop the connection, as a network failure would
+	first.Close()
+	for {
+		next, err := c.GetSSHConnContext(waitCtx)
+		if err != nil {
+			t.Fatalf("Client did not reconnect: %s", err)
+		}
+		if next != first {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if c.IsStartedShutdown() {
+		t.Fatalf("Client shut down after a disconnect")
+	}
+
+	// the forward listener stayed up, and uses the new session
+	testEcho(t, stubAddr, "after")
+}
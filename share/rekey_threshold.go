@@ -0,0 +1,23 @@
+package chshare
+
+import (
+	"fmt"
+
+	"github.com/jpillora/sizestr"
+)
+
+// MinRecommendedRekeyThreshold is the smallest SSH rekey threshold (see Config.RekeyThreshold and
+// ProxyServerConfig.RekeyThreshold), in bytes, that is set without a warning. Each rekey is a key
+// exchange during which the session's channels stall for at least a round trip, so a lower
+// threshold trades throughput on busy sessions for more frequent key changes.
+const MinRecommendedRekeyThreshold = 16 * 1024 * 1024
+
+// checkRekeyThreshold logs a warning if a configured SSH rekey threshold is so low that rekeying
+// would hold up a busy session. 0 selects the default of the ssh library (about 1 GiB for the
+// usual ciphers), and is not warned about.
+func checkRekeyThreshold(logger Logger, threshold uint64) {
+	if threshold > 0 && threshold < MinRecommendedRekeyThreshold {
+		logger.WLog(fmt.Sprintf("SSH rekey threshold of %d bytes is below %s; frequent rekeying will limit throughput",
+			threshold, sizestr.ToString(MinRecommendedRekeyThreshold)))
+	}
+}
@@ -0,0 +1,32 @@
+package chshare
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheckRekeyThreshold(t *testing.T) {
+	tests := []struct {
+		threshold uint64
+		warned    bool
+	}{
+		{0, false},
+		{256, true},
+		{MinRecommendedRekeyThreshold - 1, true},
+		{MinRecommendedRekeyThreshold, false},
+		{1 << 30, false},
+	}
+	for _, tt := range tests {
+		ring := NewLogRing(10)
+		checkRekeyThreshold(RingLogger(NewLogger("test", LogLevelInfo), ring), tt.threshold)
+		warned := false
+		for _, rec := range ring.Records() {
+			if rec.Level == "WARN" && strings.Contains(rec.Message, "rekey threshold") {
+				warned = true
+			}
+		}
+		if warned != tt.warned {
+			t.Errorf("checkRekeyThreshold(%d): warned=%v, expected %v", tt.threshold, warned, tt.warned)
+		}
+	}
+}
@@ -0,0 +1,21 @@
+package chshare
+
+import "fmt"
+
+// checkRemoteDirections checks the remotes of a client restricted to one direction by
+// Config.ReverseOnly or Config.ForwardOnly, returning an error naming the first remote in the
+// other direction. It is an error for both restrictions to be set.
+func checkRemoteDirections(chds []*ChannelDescriptor, reverseOnly bool, forwardOnly bool) error {
+	if reverseOnly && forwardOnly {
+		return fmt.Errorf("A client cannot be both reverse-only and forward-only")
+	}
+	for _, chd := range chds {
+		if reverseOnly && !chd.Reverse {
+			return fmt.Errorf("Remote \"%s\" is a forward remote, but the client is reverse-only", chd.String())
+		}
+		if forwardOnly && chd.Reverse {
+			return fmt.Errorf("Remote \"%s\" is a reverse remote, but the client is forward-only", chd.String())
+		}
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+package chshare
+
+import "testing"
+
+func TestCheckRemoteDirections(t *testing.T) {
+	var chds []*ChannelDescriptor
+	for _, s := range []string{"3000:localhost:80", "R:4000:localhost:80"} {
+		chd, _, err := ParseChannelDescriptorPath(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		chds = append(chds, &chd)
+	}
+	forward, reverse := chds[:1], chds[1:]
+
+	tests := []struct {
+		chds        []*ChannelDescriptor
+		reverseOnly bool
+		forwardOnly bool
+		isErr       bool
+	}{
+		{chds: chds},
+		{chds: reverse, reverseOnly: true},
+		{chds: forward, forwardOnly: true},
+		{chds: chds, reverseOnly: true, isErr: true},
+		{chds: chds, forwardOnly: true, isErr: true},
+		{chds: forward, reverseOnly: true, isErr: true},
+		{chds: reverse, forwardOnly: true, isErr: true},
+		{chds: nil, reverseOnly: true, forwardOnly: true, isErr: true},
+	}
+	for i, test := range tests {
+		err := checkRemoteDirections(test.chds, test.reverseOnly, test.forwardOnly)
+		if test.isErr && err == nil {
+			t.Errorf("Test #%d: expected an error", i+1)
+		} else if !test.isErr && err != nil {
+			t.Errorf("Test #%d: unexpected error: %s", i+1, err)
+		}
+	}
+}
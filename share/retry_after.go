@@ -0,0 +1,66 @@
+package chshare
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RetryAfterError is returned when the server refuses a connection with a Retry-After
+// hint (e.g., 503 Service Unavailable while it is draining or at its session limit). The
+// client waits at least Delay before its next connection attempt.
+type RetryAfterError struct {
+	// Err is the underlying connection error
+	Err error
+
+	// StatusCode is the HTTP status of the refused upgrade request
+	StatusCode int
+
+	// Delay is the minimum time to wait before retrying
+	Delay time.Duration
+}
+
+func (e *RetryAfterError) Error() string {
+	return fmt.Sprintf("%s (HTTP %d, retry after %s)", e.Err, e.StatusCode, e.Delay)
+}
+
+func (e *RetryAfterError) Unwrap() error {
+	return e.Err
+}
+
+// parseRetryAfter parses the value of a Retry-After header, which is either a number of
+// seconds or an HTTP date. Returns false if the value is missing or malformed. A date in
+// the past yields a zero delay.
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+	if secs, err := strconv.ParseUint(value, 10, 31); err == nil {
+		return time.Duration(secs) * time.Second, true
+	}
+	t, err := http.ParseTime(value)
+	if err != nil {
+		return 0, false
+	}
+	d := t.Sub(now)
+	if d < 0 {
+		d = 0
+	}
+	return d, true
+}
+
+// withRetryAfter wraps a failed websocket dial error in a RetryAfterError if the server's
+// response carried a valid Retry-After header; otherwise err is returned unchanged.
+func withRetryAfter(err error, resp *http.Response) error {
+	if resp == nil {
+		return err
+	}
+	delay, ok := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+	if !ok {
+		return err
+	}
+	return &RetryAfterError{Err: err, StatusCode: resp.StatusCode, Delay: delay}
+}
@@ -0,0 +1,51 @@
+package chshare
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value string
+		delay time.Duration
+		ok    bool
+	}{
+		{"120", 120 * time.Second, true},
+		{" 5 ", 5 * time.Second, true},
+		{"0", 0, true},
+		{now.Add(30 * time.Second).Format(http.TimeFormat), 30 * time.Second, true},
+		{now.Add(-time.Hour).Format(http.TimeFormat), 0, true},
+		{"", 0, false},
+		{"-5", 0, false},
+		{"1.5", 0, false},
+		{"soon", 0, false},
+		{"99999999999999", 0, false},
+	}
+	for _, tt := range tests {
+		delay, ok := parseRetryAfter(tt.value, now)
+		if ok != tt.ok || delay != tt.delay {
+			t.Errorf("parseRetryAfter(%q) = (%s, %v), want (%s, %v)", tt.value, delay, ok, tt.delay, tt.ok)
+		}
+	}
+}
+
+func TestWithRetryAfter(t *testing.T) {
+	dialErr := errors.New("websocket: bad handshake")
+	if err := withRetryAfter(dialErr, nil); err != dialErr {
+		t.Errorf("withRetryAfter() with no response = %v, want %v", err, dialErr)
+	}
+	resp := &http.Response{StatusCode: http.StatusServiceUnavailable, Header: http.Header{}}
+	if err := withRetryAfter(dialErr, resp); err != dialErr {
+		t.Errorf("withRetryAfter() with no Retry-After = %v, want %v", err, dialErr)
+	}
+	resp.Header.Set("Retry-After", "10")
+	var ra *RetryAfterError
+	err := withRetryAfter(dialErr, resp)
+	if !errors.As(err, &ra) || ra.Delay != 10*time.Second || !errors.Is(err, dialErr) {
+		t.Errorf("withRetryAfter() with Retry-After = %v, want 10s RetryAfterError wrapping %v", err, dialErr)
+	}
+}
@@ -0,0 +1,93 @@
+package chshare
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// ReverseConfigRequest is the SSH global request with which a client replaces the set of reverse
+// remotes of a running session, without reconnecting. The payload is a JSON-encoded
+// ReverseConfig. The server diffs it against the reverse remotes it is running: it stops the
+// stub listeners of remotes that were removed (closing their connections), starts listeners for
+// remotes that were added, and leaves unchanged remotes, forward remotes and their connections
+// alone. A remote whose listener cannot be started is reported, and does not affect the others.
+// The reply payload is a JSON-encoded ReverseConfigReply.
+const ReverseConfigRequest = "reverse-config"
+
+// ReverseConfig is the complete new set of reverse remotes for a session
+type ReverseConfig struct {
+	ChannelDescriptors []*ChannelDescriptor `json:"-"`
+}
+
+// reverseConfigJSON is the wire form of a ReverseConfig; each remote is in the form produced by
+// ChannelDescriptor.ToJSON
+type reverseConfigJSON struct {
+	Remotes []json.RawMessage `json:"remotes"`
+}
+
+// Marshal serializes a ReverseConfig for the payload of a ReverseConfigRequest
+func (c *ReverseConfig) Marshal() ([]byte, error) {
+	j := &reverseConfigJSON{Remotes: []json.RawMessage{}}
+	for _, chd := range c.ChannelDescriptors {
+		b, err := chd.ToJSON()
+		if err != nil {
+			return nil, err
+		}
+		j.Remotes = append(j.Remotes, b)
+	}
+	return json.Marshal(j)
+}
+
+// Unmarshal unserializes a ReverseConfig from the payload of a ReverseConfigRequest. Every remote
+// must be a reverse remote.
+func (c *ReverseConfig) Unmarshal(b []byte) error {
+	var j reverseConfigJSON
+	err := json.Unmarshal(b, &j)
+	if err != nil {
+		return fmt.Errorf("Invalid reverse config request: %s", err)
+	}
+	c.ChannelDescriptors = nil
+	for i, rb := range j.Remotes {
+		chd, err := ParseChannelDescriptorJSON(rb)
+		if err != nil {
+			return fmt.Errorf("Invalid reverse remote #%d: %s", i+1, err)
+		}
+		if !chd.Reverse {
+			return fmt.Errorf("Remote #%d \"%s\" is not a reverse remote", i+1, chd.String())
+		}
+		c.ChannelDescriptors = append(c.ChannelDescriptors, chd)
+	}
+	return nil
+}
+
+// ReverseConfigReply reports how a server applied a ReverseConfig. Remotes are identified by the
+// string form of their channel descriptors.
+type ReverseConfigReply struct {
+	// Started lists the remotes whose stub listeners were started
+	Started []string `json:"started,omitempty"`
+
+	// Stopped lists the remotes whose stub listeners were stopped
+	Stopped []string `json:"stopped,omitempty"`
+
+	// Unchanged lists the remotes that were already running and were left alone
+	Unchanged []string `json:"unchanged,omitempty"`
+
+	// FailedRemotes lists the added remotes whose stub listeners could not be started. Index is
+	// the remote's position in the ReverseConfig.
+	FailedRemotes []RemoteFailure `json:"failed_remotes,omitempty"`
+}
+
+// Marshal serializes a ReverseConfigReply
+func (r *ReverseConfigReply) Marshal() ([]byte, error) {
+	return json.Marshal(r)
+}
+
+// Unmarshal unserializes a ReverseConfigReply
+func (r *ReverseConfigReply) Unmarshal(b []byte) error {
+	*r = ReverseConfigReply{}
+	err := json.Unmarshal(b, r)
+	if err != nil {
+		return fmt.Errorf("Invalid reverse config reply: %s", err)
+	}
+	return nil
+}
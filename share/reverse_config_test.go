@@ -0,0 +1,44 @@
+package chshare
+
+import (
+	"testing"
+)
+
+func TestReverseConfigRoundTrip(t *testing.T) {
+	c := &ReverseConfig{}
+	for _, s := range []string{"R:3000:localhost:80", "R:3001:localhost:81"} {
+		chd, _, err := ParseChannelDescriptorPath(s)
+		if err != nil {
+			t.Fatalf("ParseChannelDescriptorPath(%s) returned error: %s", s, err)
+		}
+		c.ChannelDescriptors = append(c.ChannelDescriptors, &chd)
+	}
+	b, err := c.Marshal()
+	if err != nil {
+		t.Fatalf("Marshal() returned error: %s", err)
+	}
+	c2 := &ReverseConfig{}
+	if err := c2.Unmarshal(b); err != nil {
+		t.Fatalf("Unmarshal(%s) returned error: %s", b, err)
+	}
+	if len(c2.ChannelDescriptors) != len(c.ChannelDescriptors) {
+		t.Fatalf("Unmarshal(%s) = %d remotes, want %d", b, len(c2.ChannelDescriptors), len(c.ChannelDescriptors))
+	}
+	for i, chd := range c2.ChannelDescriptors {
+		if chd.String() != c.ChannelDescriptors[i].String() {
+			t.Errorf("Unmarshal(%s) remote #%d = %s, want %s", b, i+1, chd, c.ChannelDescriptors[i])
+		}
+	}
+
+	fwd, _, err := ParseChannelDescriptorPath("3000:localhost:80")
+	if err != nil {
+		t.Fatalf("ParseChannelDescriptorPath() returned error: %s", err)
+	}
+	b, err = (&ReverseConfig{ChannelDescriptors: []*ChannelDescriptor{&fwd}}).Marshal()
+	if err != nil {
+		t.Fatalf("Marshal() returned error: %s", err)
+	}
+	if err := c2.Unmarshal(b); err == nil {
+		t.Errorf("Unmarshal() of a forward remote did not return an error")
+	}
+}
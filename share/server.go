@@ -12,43 +12,185 @@ import (
 	"log"
 	"net/http"
 	"net/http/httputil"
-	"net/url"
 	"os"
 	"regexp"
+	"strings"
+	"sync"
+	"time"
 )
 
 // ProxyServerConfig is the configuration for the wstunnel service
 type ProxyServerConfig struct {
-	KeySeed  string
-	AuthFile string
-	Auth     string
-	Proxy    string
-	Socks5   bool
-	NoLoop   bool
-	Reverse  bool
-	Debug    bool
+	KeySeed                         string
+	KeyGenerateTo                   string
+	AllowedOrigins                  []string
+	AllowCIDRs                      []string
+	DenyCIDRs                       []string
+	TrustXFF                        bool
+	ExpvarPath                      string
+	HTTP2                           bool
+	TLSCert                         string
+	TLSKey                          string
+	TLSDomains                      []string
+	TLSCacheDir                     string
+	TLSClientCA                     string
+	SNIRoutes                       map[string]string
+	AuditLog                        string
+	AuthFile                        string
+	Auth                            string
+	Proxy                           string
+	ProxyFile                       string
+	Socks5                          bool
+	Socks4                          bool
+	SocksResolver                   string
+	NoLoop                          bool
+	Reverse                         bool
+	MaxDescriptorsPerSession        int
+	MaxForwardDescriptorsPerSession int
+	MaxConfigSize                   int
+	DrainTimeout                    time.Duration
+	HalfCloseLinger                 time.Duration
+	Debug                           bool
+
+	// MaxHandshakes limits the number of client connections that may be upgrading and SSH
+	// handshaking at the same time. Excess connections are refused with 503. 0 means unlimited.
+	MaxHandshakes int
+
+	// HandshakeQueueTimeout is how long a connection in excess of MaxHandshakes waits for a
+	// handshake slot before it is refused. 0 refuses it immediately.
+	HandshakeQueueTimeout time.Duration
+
+	// HandshakeTimeout is the time a client connection has to complete the SSH handshake before
+	// it is dropped. 0 selects DefaultHandshakeTimeout.
+	HandshakeTimeout time.Duration
+
+	// MaxConcurrentAccepts limits the number of connections each reverse remote listener handles
+	// at once; excess connections wait in the listen backlog. 0 means unlimited.
+	MaxConcurrentAccepts int
+
+	// TraceChannels hex dumps the traffic of every channel at trace log level, for debugging.
+	// Capture files named by clients' "trace" params are never written by the server.
+	TraceChannels bool
+
+	// MaxLifetime, if not 0, is the time after which the server shuts itself down cleanly, with
+	// completion error ErrMaxLifetime (e.g., for an ephemeral tunnel in a CI job)
+	MaxLifetime time.Duration
+
+	// LogSessionConfig logs a one-line summary of each client's session config at info level,
+	// after access checks, with every remote marked as forward or reverse and allowed or denied
+	LogSessionConfig bool
+
+	// LogRingSize, if not 0, is the number of recent log records retained in memory; they are
+	// written to stderr on SIGUSR2, and served as JSON at <ExpvarPath>/log, to users only, if
+	// ExpvarPath is set
+	LogRingSize int
+
+	// PathPrefix, if not empty, is the URL path (e.g., "/tunnel/ws") at or below which clients
+	// connect, for hosting behind a shared ingress. Requests for other paths are not upgraded, and
+	// go to the reverse proxy, if any. The default is the root.
+	PathPrefix string
+
+	// RekeyThreshold is the number of bytes sent or received on each client's SSH connection after
+	// which a new key is negotiated. 0 selects the ssh library's default. See Config.RekeyThreshold.
+	RekeyThreshold uint64
+
+	// DefaultSkeletonHost, if not empty, is the host dialed for a forward remote's TCP target that
+	// gives a port but no host (e.g., "tcp://:80" from a client with no default host of its own).
+	// If empty, such targets are dialed on the server's own host.
+	DefaultSkeletonHost string
+
+	// MaxLogRecordLength, if not 0, is the maximum length in bytes of the server's log messages;
+	// longer messages are truncated (see LimitLogger)
+	MaxLogRecordLength int
+
+	// ChannelBuffer, if not 0, is the size of the buffer used in each direction of each channel the
+	// server bridges, which bounds the bytes held in flight for a stalled channel. It must be at
+	// least MinChannelBufferSize. If 0, the standard copy buffering is used.
+	ChannelBuffer int
+
+	// Clock, if not nil, replaces RealClock as the source of time for MaxLifetime (e.g., a
+	// MockClock in tests)
+	Clock Clock
+
+	// BeforeBridge, if not nil, is called with each connection dialed by the server's TCP and unix
+	// skeletons (i.e., for forward remotes), before bridging starts, so that it can prepare the
+	// Called Service (see BasicEndpoint.BeforeBridge)
+	BeforeBridge func(ctx context.Context, calledServiceConn ChannelConn, extraData []byte) error
 }
 
+// Default per-session channel descriptor limits, used when the corresponding ProxyServerConfig
+// field is 0. A negative limit disables the check.
+const (
+	// DefaultMaxDescriptorsPerSession limits reverse descriptors, each of which allocates a listener on the server
+	DefaultMaxDescriptorsPerSession = 256
+
+	// DefaultMaxForwardDescriptorsPerSession limits forward descriptors, which allocate nothing on the server
+	// until a channel is opened
+	DefaultMaxForwardDescriptorsPerSession = 4096
+
+	// DefaultMaxConfigSize limits the size in bytes of the payload of a session config (or reverse
+	// config) request. It is the largest SSH packet that x/crypto/ssh accepts, so by default only the
+	// transport limits the size; a smaller limit may be configured.
+	DefaultMaxConfigSize = 256 * 1024
+)
+
+// DefaultHandshakeTimeout is the time a client connection has to complete the SSH handshake, when
+// ProxyServerConfig.HandshakeTimeout is 0
+const DefaultHandshakeTimeout = 30 * time.Second
+
 // Server respresent a wstunnel service
 type Server struct {
 	ShutdownHelper
 	connStats    ConnStats
 	fingerprint  string
 	httpServer   *HTTPServer
+	proxyLock    sync.RWMutex
 	reverseProxy *httputil.ReverseProxy
+	proxyFile    string
+	sniRouter    *sniRouter
 	sessions     *Users
 	socksServer  *socks5.Server
+	socks4       bool
 	loopServer   *LoopServer
 	sshConfig    *ssh.ServerConfig
 	users        *UserIndex
 	reverseOk    bool
+	maxReverse   int
+	maxForward   int
+	maxConfig    int
+	drainTimeout time.Duration
+	linger       time.Duration
+	bufferSize   int
+	handshakes   *handshakeLimiter
+	handshakeTO  time.Duration
+	maxAccepts   int
+	skeletonHost string
+	trace        bool
+	maxLifetime  time.Duration
+	clock        Clock
+	beforeBridge func(ctx context.Context, calledServiceConn ChannelConn, extraData []byte) error
+	logConfigs   bool
+	logRing      *LogRing
 	httpHandler  http.Handler
-}
+	origins      *OriginChecker
+	ipFilter     *IPFilter
+	upgrader     websocket.Upgrader
+	events       *eventDispatcher
+	eventHandler EventHandler
+	auditLog     *AuditLog
+	metrics      Metrics
+	metricsName  string
+	expvarPath   string
+	pathPrefix   string
+	http2        bool
+	tlsEnabled   bool
+	certAuth     bool
+	tlsReload    func() error
 
-var upgrader = websocket.Upgrader{
-	ReadBufferSize:  1024,
-	WriteBufferSize: 1024,
-	CheckOrigin:     func(r *http.Request) bool { return true },
+	activeSessionsLock sync.Mutex
+	activeSessions     map[*ServerSSHSession]struct{}
+	// activeSessionsCond is signaled when a session is removed from activeSessions
+	activeSessionsCond *sync.Cond
 }
 
 // NewServer creates and returns a new wstunnel server
@@ -57,14 +199,131 @@ func NewServer(config *ProxyServerConfig) (*Server, error) {
 	if config.Debug {
 		logLevel = LogLevelDebug
 	}
-	logger := NewLogger("server", logLevel)
+	if config.TraceChannels {
+		logLevel = LogLevelTrace
+	}
+	logRing := NewLogRing(config.LogRingSize)
+	logger := LimitLogger(RingLogger(NewLogger("server", logLevel), logRing), config.MaxLogRecordLength)
+	// created is set once nothing can fail, for the cleanup of error paths
+	created := false
 	s := &Server{
 		httpServer: NewHTTPServer(logger),
 		sessions:   NewUsers(),
 		reverseOk:  config.Reverse,
+		origins:    NewOriginChecker(config.AllowedOrigins),
+
+		activeSessions: make(map[*ServerSSHSession]struct{}),
+	}
+	s.activeSessionsCond = sync.NewCond(&s.activeSessionsLock)
+	s.maxReverse = config.MaxDescriptorsPerSession
+	if s.maxReverse == 0 {
+		s.maxReverse = DefaultMaxDescriptorsPerSession
+	}
+	s.maxForward = config.MaxForwardDescriptorsPerSession
+	if s.maxForward == 0 {
+		s.maxForward = DefaultMaxForwardDescriptorsPerSession
+	}
+	s.maxConfig = config.MaxConfigSize
+	if s.maxConfig == 0 {
+		s.maxConfig = DefaultMaxConfigSize
+	}
+	s.drainTimeout = config.DrainTimeout
+	s.linger = config.HalfCloseLinger
+	s.bufferSize = config.ChannelBuffer
+	s.handshakes = newHandshakeLimiter(config.MaxHandshakes, config.HandshakeQueueTimeout)
+	s.handshakeTO = config.HandshakeTimeout
+	if s.handshakeTO == 0 {
+		s.handshakeTO = DefaultHandshakeTimeout
+	}
+	s.maxAccepts = config.MaxConcurrentAccepts
+	s.trace = config.TraceChannels
+	s.maxLifetime = config.MaxLifetime
+	s.clock = config.Clock
+	if s.clock == nil {
+		s.clock = RealClock
+	}
+	s.beforeBridge = config.BeforeBridge
+	s.logConfigs = config.LogSessionConfig
+	s.logRing = logRing
+	s.upgrader = websocket.Upgrader{
+		ReadBufferSize:  1024,
+		WriteBufferSize: 1024,
+		CheckOrigin:     s.origins.CheckOrigin,
 	}
 	s.InitShutdownHelper(logger, s)
+	s.expvarPath = config.ExpvarPath
+	if config.DefaultSkeletonHost != "" {
+		skeletonHost, err := NormalizeSkeletonHost(config.DefaultSkeletonHost)
+		if err != nil {
+			return nil, s.Errorf("%s", err)
+		}
+		s.skeletonHost = skeletonHost
+	}
+	if err := ValidateChannelBufferSize(s.bufferSize); err != nil {
+		return nil, s.Errorf("%s", err)
+	}
+	pathPrefix, err := normalizePathPrefix(config.PathPrefix)
+	if err != nil {
+		return nil, s.Errorf("%s", err)
+	}
+	s.pathPrefix = pathPrefix
+	s.http2 = config.HTTP2
+	if s.http2 {
+		s.httpServer.EnableH2C()
+	}
+	if len(config.SNIRoutes) > 0 {
+		router, err := newSNIRouter(config.SNIRoutes)
+		if err != nil {
+			return nil, s.Errorf("%s", err)
+		}
+		s.sniRouter = router
+	}
+	if config.TLSCert != "" || config.TLSKey != "" || len(config.TLSDomains) > 0 {
+		domains := config.TLSDomains
+		if len(domains) > 0 && s.sniRouter != nil {
+			// routed hostnames need certificates too
+			domains = append(append([]string{}, domains...), s.sniRouter.exactHosts()...)
+		}
+		tlsConfig, tlsReload, err := NewServerTLSConfig(config.TLSCert, config.TLSKey, domains, config.TLSCacheDir)
+		if err != nil {
+			return nil, s.Errorf("%s", err)
+		}
+		if config.TLSClientCA != "" {
+			err = setTLSClientCA(tlsConfig, config.TLSClientCA)
+			if err != nil {
+				return nil, s.Errorf("%s", err)
+			}
+			s.certAuth = true
+		}
+		s.httpServer.EnableTLS(tlsConfig)
+		s.tlsEnabled = true
+		s.tlsReload = tlsReload
+	} else if s.sniRouter != nil {
+		return nil, s.Errorf("SNI routes require TLS termination (--tls-cert and --tls-key, or --tls-domain)")
+	} else if config.TLSClientCA != "" {
+		return nil, s.Errorf("A TLS client CA requires TLS termination (--tls-cert and --tls-key, or --tls-domain)")
+	}
+	ipFilter, err := NewIPFilter(config.AllowCIDRs, config.DenyCIDRs, config.TrustXFF)
+	if err != nil {
+		return nil, s.Errorf("%s", err)
+	}
+	s.ipFilter = ipFilter
+	if config.AuditLog != "" {
+		s.auditLog, err = NewAuditLog(s.Logger, config.AuditLog)
+		if err != nil {
+			return nil, err
+		}
+		// closed if a later step fails, so that neither the file nor its flush goroutine leaks
+		defer func() {
+			if !created {
+				s.auditLog.Close()
+			}
+		}()
+		s.updateEventDispatcher()
+		s.ILogf("Auditing channels to %s", config.AuditLog)
+	}
 	s.users = NewUserIndex(s.Logger)
+	s.users.OnReload = s.revokeUsers
 	if config.AuthFile != "" {
 		if err := s.users.LoadUsers(config.AuthFile); err != nil {
 			return nil, err
@@ -77,8 +336,23 @@ func NewServer(config *ProxyServerConfig) (*Server, error) {
 			s.users.AddUser(u)
 		}
 	}
-	//generate private key (optionally using seed)
-	key, _ := GenerateKey(config.KeySeed)
+	//generate private key (optionally using seed, or persisted in a key file)
+	var key []byte
+	if config.KeySeed == "" && config.KeyGenerateTo != "" {
+		var generated bool
+		var err error
+		key, generated, err = LoadOrGenerateKeyFile(config.KeyGenerateTo)
+		if err != nil {
+			return nil, err
+		}
+		if generated {
+			s.ILogf("Generated new server key and saved it to %s", config.KeyGenerateTo)
+		} else {
+			s.ILogf("Loaded server key from %s", config.KeyGenerateTo)
+		}
+	} else {
+		key, _ = GenerateKey(config.KeySeed)
+	}
 	//convert into ssh.PrivateKey
 	private, err := ssh.ParsePrivateKey(key)
 	if err != nil {
@@ -86,32 +360,40 @@ func NewServer(config *ProxyServerConfig) (*Server, error) {
 	}
 	//fingerprint this key
 	s.fingerprint = FingerprintKey(private.PublicKey())
-	//create ssh config
+	//create ssh config. Compression is not configurable; x/crypto/ssh only supports "none".
+	checkRekeyThreshold(s.Logger, config.RekeyThreshold)
 	s.sshConfig = &ssh.ServerConfig{
+		Config:           ssh.Config{RekeyThreshold: config.RekeyThreshold},
 		ServerVersion:    "SSH-" + ProtocolVersion + "-server",
 		PasswordCallback: s.authUser,
 	}
 	s.sshConfig.AddHostKey(private)
 	//setup reverse proxy
+	if config.Proxy != "" && config.ProxyFile != "" {
+		return nil, s.Errorf("--proxy and --proxy-file cannot be combined")
+	}
 	if config.Proxy != "" {
-		u, err := url.Parse(config.Proxy)
+		s.reverseProxy, err = newSingleHostReverseProxy(config.Proxy)
 		if err != nil {
-			return nil, err
+			return nil, s.Errorf("%s", err)
 		}
-		if u.Host == "" {
-			return nil, s.Errorf("Missing protocol (%s)", u)
-		}
-		s.reverseProxy = httputil.NewSingleHostReverseProxy(u)
-		//always use proxy host
-		s.reverseProxy.Director = func(r *http.Request) {
-			r.URL.Scheme = u.Scheme
-			r.URL.Host = u.Host
-			r.Host = u.Host
+	}
+	if config.ProxyFile != "" {
+		s.proxyFile = config.ProxyFile
+		if err := s.loadProxyFile(); err != nil {
+			return nil, s.Errorf("%s", err)
 		}
 	}
 	//setup socks server (not listening on any port!)
 	if config.Socks5 {
 		socksConfig := &socks5.Config{}
+		socksConfig.Resolver, err = NewSocksResolver(config.SocksResolver)
+		if err != nil {
+			return nil, err
+		}
+		if socksConfig.Resolver != nil {
+			s.ILogf("SOCKS5 hostname resolution: %s", config.SocksResolver)
+		}
 		if s.GetLogLevel() >= LogLevelDebug {
 			socksConfig.Logger = log.New(os.Stdout, "[socks]", log.Ldate|log.Ltime)
 		} else {
@@ -122,6 +404,12 @@ func NewServer(config *ProxyServerConfig) (*Server, error) {
 			return nil, err
 		}
 		s.ILogf("SOCKS5 server enabled")
+		if config.Socks4 {
+			s.socks4 = true
+			s.ILogf("WARNING: SOCKS4/4a clients are also accepted by the SOCKS proxy; SOCKS4 has no authentication")
+		}
+	} else if config.Socks4 {
+		return nil, s.Errorf("SOCKS4 requires the SOCKS5 proxy to be enabled")
 	}
 	//setup socks server (not listening on any port!)
 	if config.NoLoop {
@@ -137,14 +425,25 @@ func NewServer(config *ProxyServerConfig) (*Server, error) {
 	if config.Reverse {
 		s.ILogf("Reverse tunnelling enabled")
 	}
+	//registered last, so that no error path above leaves the metrics registered
+	s.metricsName = registerMetrics("server", &s.metrics)
+	created = true
 	return s, nil
 }
 
 // Run is responsible for starting the wstunnel service
 func (s *Server) Run(ctx context.Context, host, port string) error {
+	return s.RunMulti(ctx, []string{host + ":" + port}, false)
+}
+
+// RunMulti is like Run, but listens on several "<host>:<port>" addresses at once (e.g., for dual-stack or
+// multi-interface deployments). If allowPartial is true, addresses that cannot be bound are logged
+// and skipped, rather than failing the server; see HTTPServer.ListenAndServeMulti.
+func (s *Server) RunMulti(ctx context.Context, addrs []string, allowPartial bool) error {
 	err := s.DoOnceActivate(
 		func() error {
 			s.ShutdownOnContext(ctx)
+			shutdownAfterMaxLifetime(s.Logger, s.clock, s.maxLifetime, s.ShutdownStartedChan(), s.StartShutdown)
 
 			s.ILogf("Fingerprint %s", s.fingerprint)
 
@@ -152,11 +451,39 @@ func (s *Server) Run(ctx context.Context, host, port string) error {
 				s.ILogf("User authentication enabled")
 			}
 
-			if s.reverseProxy != nil {
+			if s.getReverseProxy() != nil {
 				s.ILogf("Reverse proxy enabled")
 			}
 
-			s.ILogf("Listening on %s:%s...", host, port)
+			if s.expvarPath != "" {
+				s.ILogf("Serving expvar counters at %s", s.expvarPath)
+			}
+
+			if s.http2 {
+				s.ILogf("HTTP/2 transport enabled")
+			}
+
+			// list active channels along with the process stats on SIGUSR2
+			go OnStatsSignal(ctx, s.logChannels)
+			if s.logRing != nil {
+				go OnStatsSignal(ctx, func() { s.logRing.Dump(os.Stderr) })
+			}
+
+			if s.tlsEnabled {
+				s.ILogf("TLS enabled; clients must connect with https:// or wss://")
+			}
+
+			if s.sniRouter != nil {
+				s.ILogf("SNI routing enabled for %d hostname(s)", len(s.sniRouter.routes))
+			}
+
+			if s.auditLog != nil || s.tlsReload != nil || s.proxyFile != "" {
+				// reopen the audit log and reload the TLS certificate on SIGHUP, for rotation, and
+				// re-read the reverse proxy target
+				go OnHangup(ctx, s.handleHangup)
+			}
+
+			s.ILogf("Listening on %s...", strings.Join(addrs, ", "))
 
 			h := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 				s.handleClientHandler(ctx, w, r)
@@ -177,7 +504,11 @@ func (s *Server) Run(ctx context.Context, host, port string) error {
 		return err
 	}
 
-	s.httpServer.ListenAndServe(ctx, host+":"+port, s.httpHandler)
+	err = s.httpServer.ListenAndServeMulti(ctx, addrs, s.httpHandler, allowPartial)
+	if err != nil && ctx.Err() == nil {
+		// e.g., a listen address could not be bound
+		s.Shutdown(err)
+	}
 
 	return s.Close()
 }
@@ -187,6 +518,18 @@ func (s *Server) Run(ctx context.Context, host, port string) error {
 func (s *Server) HandleOnceShutdown(completionErr error) error {
 	s.DLogf("HandleOnceShutdown")
 	err := s.httpServer.Close()
+	// sessions post their final events as they end, so the dispatcher is only closed after them
+	for _, session := range s.getActiveSessions() {
+		session.StartShutdown(completionErr)
+	}
+	s.waitActiveSessions()
+	if s.events != nil {
+		s.events.close()
+	}
+	if s.auditLog != nil {
+		s.auditLog.Close()
+	}
+	unregisterMetrics(s.metricsName)
 
 	if completionErr == nil {
 		completionErr = err
@@ -194,6 +537,129 @@ func (s *Server) HandleOnceShutdown(completionErr error) error {
 	return completionErr
 }
 
+func (s *Server) addActiveSession(session *ServerSSHSession) {
+	s.activeSessionsLock.Lock()
+	defer s.activeSessionsLock.Unlock()
+	s.activeSessions[session] = struct{}{}
+}
+
+func (s *Server) removeActiveSession(session *ServerSSHSession) {
+	s.activeSessionsLock.Lock()
+	defer s.activeSessionsLock.Unlock()
+	delete(s.activeSessions, session)
+	s.activeSessionsCond.Broadcast()
+}
+
+// waitActiveSessions waits until every active session has ended and been removed
+func (s *Server) waitActiveSessions() {
+	s.activeSessionsLock.Lock()
+	defer s.activeSessionsLock.Unlock()
+	for len(s.activeSessions) > 0 {
+		s.activeSessionsCond.Wait()
+	}
+}
+
+func (s *Server) getActiveSessions() []*ServerSSHSession {
+	s.activeSessionsLock.Lock()
+	defer s.activeSessionsLock.Unlock()
+	result := make([]*ServerSSHSession, 0, len(s.activeSessions))
+	for session := range s.activeSessions {
+		result = append(result, session)
+	}
+	return result
+}
+
+// PendingShutdownChildren describes the sessions that have not finished shutting down.
+// Implements PendingShutdownReporter for ShutdownWithTimeout.
+func (s *Server) PendingShutdownChildren() []string {
+	var result []string
+	for _, session := range s.getActiveSessions() {
+		if !session.IsDoneShutdown() {
+			result = append(result, session.String())
+		}
+	}
+	return result
+}
+
+// ForceClose closes the server's listeners and the SSH connections of all remaining sessions,
+// unblocking any channels still bridging traffic, and force closes the sessions' reverse proxies
+// and their listeners. Implements ForceCloser for ShutdownWithTimeout.
+func (s *Server) ForceClose() error {
+	err := s.httpServer.Server.Close()
+	for _, session := range s.getActiveSessions() {
+		if cerr := session.ForceClose(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// Status returns a snapshot of the server's sessions and traffic, for a status file (see
+// StartStatusFile)
+func (s *Server) Status() *Status {
+	state := "running"
+	if s.IsStartedShutdown() {
+		state = "stopping"
+	}
+	return newStatus("server", state, &s.metrics)
+}
+
+// GetMetrics returns the server's introspection counters
+func (s *Server) GetMetrics() *Metrics {
+	return &s.metrics
+}
+
+// SetEventHandler sets a handler that will be notified of session lifecycle events (e.g., for
+// auditing). It must be called before Run. If never called (or called with nil), no events are
+// generated.
+func (s *Server) SetEventHandler(handler EventHandler) {
+	s.eventHandler = handler
+	s.updateEventDispatcher()
+}
+
+// updateEventDispatcher recreates the event dispatcher for the current event handler and audit log
+func (s *Server) updateEventDispatcher() {
+	if s.events != nil {
+		s.events.close()
+		s.events = nil
+	}
+	if s.eventHandler != nil || s.auditLog != nil {
+		s.events = newEventDispatcher(s.Logger, s.eventHandler, s.auditLog)
+	}
+}
+
+// handleHangup reopens the audit log and reloads the TLS certificate, after rotation, and
+// re-reads the reverse proxy target file
+func (s *Server) handleHangup() {
+	if s.proxyFile != "" {
+		err := s.loadProxyFile()
+		if err != nil {
+			s.ILogf("WARNING: %s; continuing with the previous reverse proxy target", err)
+		} else {
+			s.ILogf("Reloaded reverse proxy target from %s", s.proxyFile)
+		}
+	}
+	if s.auditLog != nil {
+		s.ReopenAuditLog()
+	}
+	if s.tlsReload != nil {
+		err := s.tlsReload()
+		if err != nil {
+			s.ILogf("WARNING: %s; continuing with the previous certificate", err)
+		} else {
+			s.ILogf("Reloaded TLS certificate")
+		}
+	}
+}
+
+// ReopenAuditLog closes and reopens the audit log file, if any, e.g., after log rotation
+func (s *Server) ReopenAuditLog() error {
+	if s.auditLog == nil {
+		return nil
+	}
+	return s.auditLog.Reopen()
+}
+
 // GetFingerprint is used to access the server fingerprint
 func (s *Server) GetFingerprint() string {
 	return s.fingerprint
@@ -236,7 +702,23 @@ func (s *Server) AddUser(user, pass string, addrs ...string) error {
 	return nil
 }
 
-// DeleteUser removes a user from the server user index
+// DeleteUser removes a user from the server user index, and disconnects the user's sessions
 func (s *Server) DeleteUser(user string) {
 	s.users.Del(user)
+	s.revokeUsers()
+}
+
+// revokeUsers disconnects, with DisconnectCodeAuthRevoked, the sessions whose user has been
+// removed from the user index or whose password has changed
+func (s *Server) revokeUsers() {
+	for _, session := range s.getActiveSessions() {
+		user := session.getUser()
+		if user == nil {
+			continue
+		}
+		if current, found := s.users.Get(user.Name); !found || current.Pass != user.Pass {
+			session.ILogf("Credentials of user %s are no longer valid; disconnecting", user.Name)
+			session.Disconnect(DisconnectCodeAuthRevoked, "credentials of user %s are no longer valid", user.Name)
+		}
+	}
 }
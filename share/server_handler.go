@@ -2,30 +2,67 @@ package chshare
 
 import (
 	"context"
+	"encoding/json"
 	"github.com/gorilla/websocket"
 	"io"
+	"net"
 	"net/http"
 	"strings"
 )
 
 // handleClientHandler is the main http websocket handler for the wstunnel server
 func (s *Server) handleClientHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	//requests for a TLS server name routed to another service
+	if s.sniRouter != nil {
+		if proxy := s.sniRouter.route(r); proxy != nil {
+			proxy.ServeHTTP(w, r)
+			return
+		}
+	}
+
+	//tunnel connections are only accepted at or below the path prefix
+	tunnelPath := pathHasPrefix(r.URL.Path, s.pathPrefix)
+	//a verified TLS client certificate may authenticate the SSH user
+	ctx = s.withTLSClientCert(ctx, r)
+
+	//HTTP/2 transport stream, if enabled
+	if tunnelPath && s.http2 && isH2TransportRequest(r) {
+		s.handleH2Transport(ctx, w, r)
+		return
+	}
+
 	//websockets upgrade AND has wstunnel prefix
 	upgrade := strings.ToLower(r.Header.Get("Upgrade"))
-	if upgrade == "websocket" {
+	if tunnelPath && upgrade == "websocket" {
 		protocol := r.Header.Get("Sec-WebSocket-Protocol")
 		if strings.HasPrefix(protocol, "sammck-wstunnel-") {
 			if protocol == ProtocolVersion {
+				if ip, ok := s.ipFilter.CheckRequest(r); !ok {
+					s.ILogf("Rejecting websocket connection from disallowed source address '%s' (remote '%s')", ip, r.RemoteAddr)
+					http.Error(w, "Forbidden", 403)
+					return
+				}
+				if !s.origins.CheckOrigin(r) {
+					s.ILogf("Rejecting websocket connection from disallowed origin '%s'", r.Header.Get("Origin"))
+					http.Error(w, "Forbidden", 403)
+					return
+				}
+				release, ok := s.acquireHandshake(ctx, r)
+				if !ok {
+					http.Error(w, "Too many connections in progress", 503)
+					return
+				}
 				s.DLogf("Upgrading to websocket, URL tail=\"%s\", protocol=\"%s\"", r.URL.String(), protocol)
-				wsConn, err := upgrader.Upgrade(w, r, nil)
+				wsConn, err := s.upgrader.Upgrade(w, r, nil)
 				if err != nil {
+					release()
 					err = s.DLogErrorf("Failed to upgrade to websocket: %s", err)
 					http.Error(w, err.Error(), 503)
 					return
 				}
 
 				go func() {
-					s.handleWebsocket(ctx, wsConn)
+					s.handleWebsocket(ctx, wsConn, release)
 					wsConn.Close()
 				}()
 
@@ -40,9 +77,34 @@ func (s *Server) handleClientHandler(ctx context.Context, w http.ResponseWriter,
 		}
 	}
 
+	//expvar counters and active channel list, if enabled. Only the wstunnel counters are served;
+	//the process's other expvars (e.g., cmdline, which may hold credentials) are not.
+	if s.expvarPath != "" && r.URL.Path == s.expvarPath {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		json.NewEncoder(w).Encode(map[string]interface{}{ExpvarName: metricsSnapshot()})
+		return
+	}
+	if s.expvarPath != "" && r.URL.Path == strings.TrimSuffix(s.expvarPath, "/")+"/channels" {
+		if !s.checkIntrospectionAuth(w, r) {
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		json.NewEncoder(w).Encode(s.ListChannels())
+		return
+	}
+	if s.expvarPath != "" && s.logRing != nil && r.URL.Path == strings.TrimSuffix(s.expvarPath, "/")+"/log" {
+		// the log may hold tunnelled data (e.g., with --trace-channels)
+		if !s.checkIntrospectionAuth(w, r) {
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		json.NewEncoder(w).Encode(s.logRing.Records())
+		return
+	}
+
 	//proxy target was provided
-	if s.reverseProxy != nil {
-		s.reverseProxy.ServeHTTP(w, r)
+	if reverseProxy := s.getReverseProxy(); reverseProxy != nil {
+		reverseProxy.ServeHTTP(w, r)
 		return
 	}
 
@@ -59,20 +121,103 @@ func (s *Server) handleClientHandler(ctx context.Context, w http.ResponseWriter,
 	http.Error(w, "Not Found", 404)
 }
 
+// checkIntrospectionAuth returns true if r may read the server's introspection endpoints beyond
+// the counters (e.g., the active channels): it must carry the HTTP basic auth credentials of one
+// of the server's users. If user authentication is disabled, those endpoints are not served.
+// Otherwise an error response is sent and false is returned.
+func (s *Server) checkIntrospectionAuth(w http.ResponseWriter, r *http.Request) bool {
+	if s.users.Len() == 0 {
+		http.Error(w, "Forbidden", 403)
+		return false
+	}
+	name, pass, ok := r.BasicAuth()
+	if ok {
+		if user, found := s.users.Get(name); found && user.Pass == pass {
+			return true
+		}
+		s.DLogf("Introspection login failed for user: %s", name)
+	}
+	w.Header().Set("WWW-Authenticate", `Basic realm="wstunnel"`)
+	http.Error(w, "Unauthorized", 401)
+	return false
+}
+
 // handleWebsocket handles an incoming client request that is intended tois responsible for handling the websocket connection
 // It upgrades . It is guaranteed on return
 //
-func (s *Server) handleWebsocket(ctx context.Context, wsConn *websocket.Conn) {
+func (s *Server) handleWebsocket(ctx context.Context, wsConn *websocket.Conn, handshakeDone func()) {
+	conn := NewWebSocketConn(wsConn)
+	s.handleSSHConn(ctx, conn, handshakeDone)
+	conn.Close() // closes the websocket too
+}
+
+// handleH2Transport handles an incoming HTTP/2 transport request (see H2TransportHeader) by running
+// an SSH session over the request and response bodies. It does not return until the session ends.
+func (s *Server) handleH2Transport(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	protocol := r.Header.Get(H2TransportHeader)
+	if protocol != ProtocolVersion {
+		s.ILogf("Client HTTP/2 connection using unsupported protocol '%s', expected '%s'", protocol, ProtocolVersion)
+		http.Error(w, "Not Found", 404)
+		return
+	}
+	if ip, ok := s.ipFilter.CheckRequest(r); !ok {
+		s.ILogf("Rejecting HTTP/2 connection from disallowed source address '%s' (remote '%s')", ip, r.RemoteAddr)
+		http.Error(w, "Forbidden", 403)
+		return
+	}
+	if !s.origins.CheckOrigin(r) {
+		s.ILogf("Rejecting HTTP/2 connection from disallowed origin '%s'", r.Header.Get("Origin"))
+		http.Error(w, "Forbidden", 403)
+		return
+	}
+	release, ok := s.acquireHandshake(ctx, r)
+	if !ok {
+		http.Error(w, "Too many connections in progress", 503)
+		return
+	}
+	s.DLogf("Starting HTTP/2 transport stream, URL tail=\"%s\"", r.URL.String())
+	conn, err := newH2ServerConn(w, r)
+	if err != nil {
+		release()
+		err = s.DLogErrorf("Failed to start HTTP/2 transport stream: %s", err)
+		http.Error(w, err.Error(), 503)
+		return
+	}
+	s.handleSSHConn(ctx, conn, release)
+	conn.Close()
+}
+
+// acquireHandshake obtains a handshake slot for an incoming client connection (see
+// ProxyServerConfig.MaxHandshakes). If the connection must be shed, this is logged and counted,
+// and false is returned.
+func (s *Server) acquireHandshake(ctx context.Context, r *http.Request) (func(), bool) {
+	release, ok := s.handshakes.acquire(ctx)
+	if !ok {
+		s.metrics.HandshakeShed()
+		s.ILogf("Refusing connection from '%s': too many handshakes in progress", r.RemoteAddr)
+	}
+	return release, ok
+}
+
+// handleSSHConn runs an SSH session with a client over an established transport connection
+// (websocket or HTTP/2 stream). handshakeDone is called once the SSH handshake is over. It does
+// not return until the session ends.
+func (s *Server) handleSSHConn(ctx context.Context, conn net.Conn, handshakeDone func()) {
+	defer handshakeDone()
 	session, err := NewServerSSHSession(s)
 	if err != nil {
 		session.DLogf("Failed to create ServerSSHSession: %s", err)
 		return
 	}
+	session.handshakeDone = handshakeDone
+	s.metrics.Sessions.New()
+	s.metrics.Sessions.Open()
+	defer s.metrics.Sessions.Close()
 	s.AddShutdownChild(session)
+	s.addActiveSession(session)
+	defer s.removeActiveSession(session)
 	session.ShutdownOnContext(ctx)
-	conn := NewWebSocketConn(wsConn)
 	session.Run(ctx, conn)
-	conn.Close() // closes the websocket too
 	session.Close()
 }
 
@@ -0,0 +1,28 @@
+package chshare
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestServerLogEndpointRequiresAuth(t *testing.T) {
+	s, err := NewServer(&ProxyServerConfig{KeySeed: "log-test", ExpvarPath: "/debug/vars", Auth: "admin:secret", LogRingSize: 10, NoLoop: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	w := httptest.NewRecorder()
+	s.handleClientHandler(context.Background(), w, httptest.NewRequest("GET", "/debug/vars/log", nil))
+	if w.Code != 401 {
+		t.Errorf("GET /debug/vars/log without credentials returned %d; expected 401", w.Code)
+	}
+	r := httptest.NewRequest("GET", "/debug/vars/log", nil)
+	r.SetBasicAuth("admin", "secret")
+	w = httptest.NewRecorder()
+	s.handleClientHandler(context.Background(), w, r)
+	if w.Code != 200 {
+		t.Errorf("GET /debug/vars/log with credentials returned %d; expected 200", w.Code)
+	}
+}
@@ -2,9 +2,13 @@ package chshare
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	socks5 "github.com/armon/go-socks5"
 	"golang.org/x/crypto/ssh"
 	"net"
+	"sort"
+	"sync"
 	"time"
 )
 
@@ -14,6 +18,33 @@ type ServerSSHSession struct {
 
 	// Server is the wstunnel proxy server on which this session is running
 	server *Server
+
+	// started is true if the session configuration was accepted and a session start event was generated
+	started bool
+
+	// preserveSourcePort is true if the client asked for Callers' source ports to be propagated
+	// on reverse remotes
+	preserveSourcePort bool
+
+	// handshakeDone, if not nil, is called once the SSH handshake has completed or failed, to
+	// release the session's handshake slot
+	handshakeDone func()
+
+	// proxyNames indexes the session's named reverse proxies
+	proxyNames proxyNameIndex
+
+	// user is the session's authenticated user, or nil if user authentication is disabled.
+	// userLock protects it from readers other than the session itself (see getUser).
+	userLock sync.Mutex
+	user     *User
+
+	// reverseProxies holds the session's running reverse proxies, by descriptor string, so that
+	// a ReverseConfigRequest can be diffed against them. reverseLock also serializes those requests.
+	reverseLock    sync.Mutex
+	reverseProxies map[string]*TCPProxy
+
+	// nextProxyIndex is the index of the next reverse proxy to be created
+	nextProxyIndex int
 }
 
 // NewServerSSHSession creates a server-side proxy session object
@@ -22,9 +53,250 @@ func NewServerSSHSession(server *Server) (*ServerSSHSession, error) {
 		server: server,
 	}
 	s.InitSSHSession(server.Logger, s)
+	s.metrics = &server.metrics
+	s.disconnectReason = s.getDisconnectReason
+	s.handleRequest = s.handleSessionRequest
+	s.reverseProxies = make(map[string]*TCPProxy)
+	if server.drainTimeout > 0 {
+		s.phases = NewPhasedShutdown()
+		s.phases.SetDrainTimeout(ShutdownPhaseChannels, server.drainTimeout)
+	}
 	return s, nil
 }
 
+// GetProxyByName returns the session's reverse proxy for the named tunnel (see
+// ChannelDescriptor.Name), or nil if there is none
+func (s *ServerSSHSession) GetProxyByName(name string) *TCPProxy {
+	return s.proxyNames.get(name)
+}
+
+// newReverseProxy creates the proxy for a reverse remote, without starting it, and registers it
+// with the session
+func (s *ServerSSHSession) newReverseProxy(index int, chd *ChannelDescriptor) (*TCPProxy, error) {
+	proxy := NewTCPProxy(s.Logger, s, index, chd, s.metrics)
+	s.proxies.add(proxy)
+	if s.phases != nil {
+		// only the listener stops in the first phase; the proxy's channels drain with the others
+		s.phases.AddChild(ShutdownPhaseListeners, proxyListener{proxy})
+	}
+	if err := s.proxyNames.add(proxy); err != nil {
+		s.dropReverseProxy(proxy, err)
+		return nil, err
+	}
+	return proxy, nil
+}
+
+// dropReverseProxy unregisters a reverse proxy from the session and starts its shutdown, without
+// waiting for it. Its stub listener is closed first, so that its address can be reused at once;
+// channels it is still bridging remain registered with the session until they end.
+func (s *ServerSSHSession) dropReverseProxy(proxy *TCPProxy, completionErr error) {
+	s.proxyNames.remove(proxy)
+	s.proxies.remove(proxy)
+	if s.phases != nil {
+		s.phases.RemoveChild(ShutdownPhaseListeners, proxyListener{proxy})
+	}
+	proxy.stopListening()
+	proxy.StartShutdown(completionErr)
+}
+
+// checkDuplicateReverseRemotes rejects a reverse remote that is repeated in chds. A session keys
+// its running reverse remotes by descriptor string, so a repeated one could never be started.
+func checkDuplicateReverseRemotes(chds []*ChannelDescriptor) error {
+	seen := make(map[string]bool)
+	for i, chd := range chds {
+		if !chd.Reverse {
+			continue
+		}
+		chdString := chd.String()
+		if seen[chdString] {
+			return fmt.Errorf("Duplicate reverse remote #%d \"%s\"", i+1, chdString)
+		}
+		seen[chdString] = true
+	}
+	return nil
+}
+
+// diffReverseRemotes compares a session's running reverse remotes, by descriptor string, with the
+// descriptors of a ReverseConfigRequest. It returns the running remotes to stop, sorted, the
+// indexes of the descriptors to start, and the descriptors that are already running, in request
+// order. A descriptor repeated in the request is only considered once.
+func diffReverseRemotes(running map[string]*TCPProxy, chds []*ChannelDescriptor) (stop []string, start []int, unchanged []string) {
+	wanted := make(map[string]bool, len(chds))
+	for i, chd := range chds {
+		chdString := chd.String()
+		if wanted[chdString] {
+			continue
+		}
+		wanted[chdString] = true
+		if _, ok := running[chdString]; ok {
+			unchanged = append(unchanged, chdString)
+		} else {
+			start = append(start, i)
+		}
+	}
+	for chdString := range running {
+		if !wanted[chdString] {
+			stop = append(stop, chdString)
+		}
+	}
+	sort.Strings(stop)
+	return stop, start, unchanged
+}
+
+// handleSessionRequest handles the SSH requests that are specific to server sessions
+func (s *ServerSSHSession) handleSessionRequest(ctx context.Context, r *ssh.Request) bool {
+	switch r.Type {
+	case ReverseConfigRequest:
+		reply, err := s.reconfigureReverse(ctx, r.Payload)
+		if err == nil {
+			var payload []byte
+			payload, err = reply.Marshal()
+			if err == nil {
+				err = s.sendSSHReply(ctx, r, true, payload)
+			}
+		} else {
+			err = s.sendSSHErrorReply(ctx, r, err)
+		}
+		if err != nil {
+			s.DLogf("SSH reverse config reply send failed, ignoring: %s", err)
+		}
+		return true
+	}
+	return false
+}
+
+// reconfigureReverse replaces the session's reverse remotes with those in a ReverseConfigRequest
+// payload. The new set is checked as a whole, as at session start, before anything is changed.
+// Removed remotes stop listening before added ones are started, so that an address can be reused;
+// their channels are left to finish in the background. An added remote that fails to start is
+// reported in the reply, and does not affect the others.
+func (s *ServerSSHSession) reconfigureReverse(ctx context.Context, payload []byte) (*ReverseConfigReply, error) {
+	if s.server.maxConfig >= 0 && len(payload) > s.server.maxConfig {
+		return nil, s.DLogErrorf("Reverse config request too large: %d bytes (limit %d)", len(payload), s.server.maxConfig)
+	}
+	c := &ReverseConfig{}
+	err := c.Unmarshal(payload)
+	if err != nil {
+		return nil, s.DLogErrorf("%s", err)
+	}
+	if len(c.ChannelDescriptors) > 0 && !s.server.reverseOk {
+		return nil, s.DLogErrorf("Reverse port forwarding not enabled on server")
+	}
+	if s.server.maxReverse >= 0 && len(c.ChannelDescriptors) > s.server.maxReverse {
+		return nil, s.DLogErrorf("Too many reverse channel descriptors in reverse config: %d (limit %d)", len(c.ChannelDescriptors), s.server.maxReverse)
+	}
+	err = ValidateChannelDescriptors(c.ChannelDescriptors)
+	if err != nil {
+		return nil, s.DLogErrorf("Invalid reverse config: %s", err)
+	}
+	for i, chd := range c.ChannelDescriptors {
+		if !chd.Reverse {
+			return nil, s.DLogErrorf("Invalid reverse config: remote #%d \"%s\" is not a reverse remote", i+1, chd.String())
+		}
+		if err := checkSocksRemote(chd, s.server.socksServer != nil, true); err != nil {
+			return nil, s.DLogErrorf("Invalid reverse config: %s", err)
+		}
+	}
+	if err := checkDuplicateReverseRemotes(c.ChannelDescriptors); err != nil {
+		return nil, s.DLogErrorf("Invalid reverse config: %s", err)
+	}
+	denied := make(map[int]bool)
+	if s.user != nil {
+		for i, chd := range c.ChannelDescriptors {
+			if !s.user.HasChannelAccess(chd) {
+				denied[i] = true
+			}
+		}
+	}
+	if s.server.logConfigs && s.sshConn != nil {
+		s.ILogf("Reverse config: %s", sessionConfigSummary(s.sshConn.User(), s.sshConn.RemoteAddr().String(), c.ChannelDescriptors, denied))
+	}
+	for i, chd := range c.ChannelDescriptors {
+		if denied[i] {
+			return nil, s.DLogErrorf("Access to \"%s\" denied", chd.String())
+		}
+	}
+
+	s.reverseLock.Lock()
+	defer s.reverseLock.Unlock()
+
+	stop, start, unchanged := diffReverseRemotes(s.reverseProxies, c.ChannelDescriptors)
+	reply := &ReverseConfigReply{Unchanged: unchanged}
+	for _, chdString := range stop {
+		proxy := s.reverseProxies[chdString]
+		s.ILogf("Stopping removed reverse remote \"%s\"", chdString)
+		delete(s.reverseProxies, chdString)
+		s.dropReverseProxy(proxy, nil)
+		go func() {
+			if err := proxy.WaitShutdown(); err != nil {
+				s.DLogf("Removed reverse remote %s stopped with: %s", proxy, err)
+			}
+		}()
+		reply.Stopped = append(reply.Stopped, chdString)
+	}
+	for _, i := range start {
+		chd := c.ChannelDescriptors[i]
+		chdString := chd.String()
+		s.ILogf("Starting added reverse remote \"%s\"", chdString)
+		proxy, err := s.newReverseProxy(s.nextProxyIndex, chd)
+		s.nextProxyIndex++
+		if err == nil {
+			err = proxy.Start(ctx)
+			if err != nil {
+				s.dropReverseProxy(proxy, err)
+			}
+		}
+		if err != nil {
+			err = s.DLogErrorf("Unable to start server-side stub listener for reverse remote \"%s\": %s", chdString, err)
+			reply.FailedRemotes = append(reply.FailedRemotes, RemoteFailure{Index: i, Descriptor: chdString, Error: err.Error()})
+			continue
+		}
+		s.reverseProxies[chdString] = proxy
+		reply.Started = append(reply.Started, chdString)
+	}
+	return reply, nil
+}
+
+// replyAllowedRemotes replies to an AllowedRemotesRequest with the remotes allowed for user, the
+// session's authenticated user (nil if user authentication is disabled). Other users' address
+// lists are never consulted.
+func (s *ServerSSHSession) replyAllowedRemotes(ctx context.Context, r *ssh.Request, user *User) error {
+	payload, err := NewAllowedRemotes(user).Marshal()
+	if err != nil {
+		return s.Errorf("Failed to encode allowed remotes reply: %s", err)
+	}
+	s.DLogf("Sending allowed remotes")
+	return s.sendSSHReply(ctx, r, true, payload)
+}
+
+// getUser returns the session's authenticated user, or nil if user authentication is disabled or
+// the session config has not been accepted yet
+func (s *ServerSSHSession) getUser() *User {
+	s.userLock.Lock()
+	defer s.userLock.Unlock()
+	return s.user
+}
+
+// getDisconnectReason determines what to tell the client about why its session is ending,
+// given the session's completion error. A session that ends because the server is shutting
+// down is reported as draining, so the client knows to simply reconnect later.
+func (s *ServerSSHSession) getDisconnectReason(completionErr error) *DisconnectReason {
+	var reason *DisconnectReason
+	if errors.As(completionErr, &reason) {
+		return reason
+	}
+	if code := GetErrorCode(completionErr); code != "" {
+		return NewDisconnectReason(DisconnectCode(code), "%s", completionErr)
+	}
+	if s.server.IsStartedShutdown() || errors.Is(completionErr, context.Canceled) {
+		return NewDisconnectReason(DisconnectCodeDraining, "server draining, reconnect later")
+	}
+	if completionErr != nil {
+		return NewDisconnectReason(DisconnectCodeError, "%s", completionErr)
+	}
+	return nil
+}
+
 // Implement LocalChannelEnv
 
 // IsServer returns true if this is a proxy server; false if it is a cliet
@@ -43,6 +315,54 @@ func (s *ServerSSHSession) GetSocksServer() *socks5.Server {
 	return s.server.socksServer
 }
 
+// IsSocks4Enabled returns true if the SOCKS proxy also accepts SOCKS4/4a clients. Implements
+// Socks4Env.
+func (s *ServerSSHSession) IsSocks4Enabled() bool {
+	return s.server.socks4
+}
+
+// GetHalfCloseLinger returns the server's half-close linger timeout for the session's channels.
+// Implements HalfCloseLingerEnv.
+func (s *ServerSSHSession) GetHalfCloseLinger() time.Duration {
+	return s.server.linger
+}
+
+// GetChannelBufferSize returns the server's channel buffer size for the session's channels, or 0
+// for the default. Implements ChannelBufferEnv.
+func (s *ServerSSHSession) GetChannelBufferSize() int {
+	return s.server.bufferSize
+}
+
+// GetMaxConcurrentAccepts returns the maximum number of connections each reverse remote listener
+// handles at once, or 0 for no limit. Implements ConcurrentAcceptEnv.
+func (s *ServerSSHSession) GetMaxConcurrentAccepts() int {
+	return s.server.maxAccepts
+}
+
+// GetChannelTrace returns no capture file, since the descriptor comes from the client, and whether
+// the server hex dumps channel traffic. Implements ChannelTraceEnv.
+func (s *ServerSSHSession) GetChannelTrace(descriptor string) (string, bool) {
+	return "", s.server.trace
+}
+
+// GetBeforeBridge returns the server's callback that prepares each connection dialed by the
+// session's skeletons, or nil for none. Implements BeforeBridgeEnv.
+func (s *ServerSSHSession) GetBeforeBridge() func(ctx context.Context, calledServiceConn ChannelConn, extraData []byte) error {
+	return s.server.beforeBridge
+}
+
+// GetDefaultTCPSkeletonHost returns the host dialed for TCP targets that give none. Implements
+// TCPSkeletonHostEnv.
+func (s *ServerSSHSession) GetDefaultTCPSkeletonHost() string {
+	return s.server.skeletonHost
+}
+
+// IsSourcePortPreserved returns true if reverse remote stubs propagate the Caller's source port
+// to the client. Implements SourcePortEnv.
+func (s *ServerSSHSession) IsSourcePortPreserved() bool {
+	return s.preserveSourcePort
+}
+
 // GetSSHConn waits for and returns the main ssh.Conn that this proxy is using to
 // communicate with the remote proxy. It is possible that goroutines servicing
 // local stub sockets will ask for this before it is available (if for example
@@ -52,6 +372,13 @@ func (s *ServerSSHSession) GetSSHConn() (ssh.Conn, error) {
 	return s.sshConn, nil
 }
 
+// GetSSHConnContext is like GetSSHConn, but gives up and returns an error if ctx is
+// cancelled or reaches its deadline before the ssh.Conn is available. On the server,
+// the ssh.Conn is always available before any local stubs are started.
+func (s *ServerSSHSession) GetSSHConnContext(ctx context.Context) (ssh.Conn, error) {
+	return s.GetSSHConn()
+}
+
 // startWithSSHConn startss a proxy session runing in the background, given
 // an incoming ssh.ServerConn.
 func (s *ServerSSHSession) startWithSSHConn(
@@ -84,7 +411,17 @@ func (s *ServerSSHSession) startWithSSHConn(
 	// wait for configuration request, with timeout
 	cfgCtx, cfgCtxCancel := context.WithTimeout(ctx, 10*time.Second)
 	r, err := s.receiveSSHRequest(cfgCtx)
+	// the client may first ask which remotes its (already authenticated) user is allowed
+	for err == nil && r != nil && r.Type == AllowedRemotesRequest {
+		err = s.replyAllowedRemotes(ctx, r, user)
+		if err == nil {
+			r, err = s.receiveSSHRequest(cfgCtx)
+		}
+	}
 	cfgCtxCancel()
+	if err == nil && r == nil {
+		err = s.Errorf("SSH request stream closed before config request")
+	}
 	if err != nil {
 		err = s.DLogErrorf("receiveSSHRequest failed: %s", err)
 		s.StartShutdown(err)
@@ -106,6 +443,9 @@ func (s *ServerSSHSession) startWithSSHConn(
 		return failed(s.DLogErrorf("Expecting \"config\" request, got \"%s\"", r.Type))
 	}
 
+	if s.server.maxConfig >= 0 && len(r.Payload) > s.server.maxConfig {
+		return failed(DLogErrorcf(s.Logger, string(DisconnectCodeLimit), "Session config request too large: %d bytes (limit %d)", len(r.Payload), s.server.maxConfig))
+	}
 	c := &SessionConfigRequest{}
 	err = c.Unmarshal(r.Payload)
 	if err != nil {
@@ -121,45 +461,119 @@ func (s *ServerSSHSession) startWithSSHConn(
 		s.ILogf("WARNING: Wstunnel Client version (%s) differs from server version (%s)", v, BuildVersion)
 	}
 
+	//enforce descriptor count limits before anything is allocated
+	numReverse := 0
+	for _, chd := range c.ChannelDescriptors {
+		if chd.Reverse {
+			numReverse++
+		}
+	}
+	numForward := len(c.ChannelDescriptors) - numReverse
+	if s.server.maxReverse >= 0 && numReverse > s.server.maxReverse {
+		return failed(DLogErrorcf(s.Logger, string(DisconnectCodeLimit), "Too many reverse channel descriptors in session config: %d (limit %d)", numReverse, s.server.maxReverse))
+	}
+	if s.server.maxForward >= 0 && numForward > s.server.maxForward {
+		return failed(DLogErrorcf(s.Logger, string(DisconnectCodeLimit), "Too many forward channel descriptors in session config: %d (limit %d)", numForward, s.server.maxForward))
+	}
+
 	//confirm reverse tunnels are allowed
 	for _, chd := range c.ChannelDescriptors {
+		if err := chd.Validate(); err != nil {
+			return failed(s.DLogErrorf("Invalid channel descriptor \"%s\": %s", chd.String(), err))
+		}
 		if chd.Reverse && !s.server.reverseOk {
 			return failed(s.DLogErrorf("Reverse port forwarding not enabled on server"))
 		}
+		// the client checks its own side of reverse socks remotes
+		if err := checkSocksRemote(chd, s.server.socksServer != nil, true); err != nil {
+			return failed(s.DLogErrorf("%s", err))
+		}
+	}
+	if err := checkDuplicateReverseRemotes(c.ChannelDescriptors); err != nil {
+		return failed(s.DLogErrorf("Invalid session config: %s", err))
 	}
 	//if user is provided, ensure they have
 	//access to the desired remotes
+	denied := make(map[int]bool)
 	if user != nil {
-		for _, chd := range c.ChannelDescriptors {
-			chdString := chd.String()
-			if !user.HasAccess(chdString) {
-				return failed(s.DLogErrorf("Access to \"%s\" denied", chdString))
+		for i, chd := range c.ChannelDescriptors {
+			if !user.HasChannelAccess(chd) {
+				denied[i] = true
 			}
 		}
 	}
+	if s.server.logConfigs {
+		s.ILogf("Session config: %s", sessionConfigSummary(sshConn.User(), sshConn.RemoteAddr().String(), c.ChannelDescriptors, denied))
+	}
+	for i, chd := range c.ChannelDescriptors {
+		if denied[i] {
+			return failed(s.DLogErrorf("Access to \"%s\" denied", chd.String()))
+		}
+	}
+
+	s.preserveSourcePort = c.PreserveSourcePort
+	s.userLock.Lock()
+	s.user = user
+	s.userLock.Unlock()
+	s.nextProxyIndex = len(c.ChannelDescriptors)
 
 	//set up reverse port forwarding
+	reply := &SessionConfigReply{}
 	for i, chd := range c.ChannelDescriptors {
 		if chd.Reverse {
-			s.DLogf("Reverse-mode route[%d] %s; starting stub listener", i, chd.String())
-			proxy := NewTCPProxy(s.Logger, s, i, chd)
-			s.AddShutdownChild(proxy)
+			// The stub may be any listening endpoint type (tcp, unix, loop); NewLocalStubChannelEndpoint
+			// dispatches on the descriptor type and rejects types not allowed on the server. The endpoint
+			// is a shutdown child of the session, so e.g. unix socket files are removed at session end.
+			s.DLogf("Reverse-mode route[%d] %s; starting %s stub listener", i, chd.String(), chd.Stub.Type)
+			proxy, err := s.newReverseProxy(i, chd)
+			if err != nil {
+				return failed(s.DLogErrorf("%s", err))
+			}
 			if err := proxy.Start(ctx); err != nil {
-				return failed(s.DLogErrorf("Unable to start stub listener %s: %s", chd.String(), err))
+				err = s.DLogErrorf("Unable to start server-side stub listener for reverse remote #%d \"%s\": %s", i+1, chd.String(), err)
+				if !c.AllowPartial {
+					return failed(err)
+				}
+				s.ILogf("%s; continuing with remaining remotes", err)
+				s.dropReverseProxy(proxy, err)
+				reply.FailedRemotes = append(reply.FailedRemotes, RemoteFailure{Index: i, Descriptor: chd.String(), Error: err.Error()})
+				continue
 			}
+			s.reverseProxies[chd.String()] = proxy
 		} else {
 			s.DLogf("Forward-mode route[%d] %s; connections will be created on demand", i, chd.String())
 		}
 	}
 
 	//success!
-	err = s.sendSSHReply(ctx, r, true, nil)
+	replyPayload, err := reply.Marshal()
+	if err != nil {
+		return failed(s.DLogErrorf("Failed to encode SSH config response: %s", err))
+	}
+	err = s.sendSSHReply(ctx, r, true, replyPayload)
 	if err != nil {
 		err = s.DLogErrorf("Failed to send SSH config success response: %s", err)
 		s.StartShutdown(err)
 		return err
 	}
 
+	if s.server.events != nil {
+		info := &SessionInfo{
+			SessionID:     s.id,
+			User:          sshConn.User(),
+			RemoteAddr:    sshConn.RemoteAddr().String(),
+			ClientVersion: c.Version,
+			StartTime:     time.Now(),
+		}
+		for _, chd := range c.ChannelDescriptors {
+			info.ChannelDescriptors = append(info.ChannelDescriptors, chd.String())
+		}
+		s.sessionInfo = info
+		s.events = s.server.events
+		s.started = true
+		s.events.sessionStart(info)
+	}
+
 	go s.handleSSHRequests(ctx, sshRequests)
 	go s.handleSSHChannels(ctx, newSSHChannels)
 
@@ -198,7 +612,13 @@ func (s *ServerSSHSession) Run(ctx context.Context, conn net.Conn) error {
 	}
 
 	s.DLogf("SSH Handshaking...")
-	sshConn, newSSHChannels, sshRequests, err := ssh.NewServerConn(conn, s.server.sshConfig)
+	// a client that stalls the handshake must not hold its handshake slot forever
+	conn.SetDeadline(time.Now().Add(s.server.handshakeTO))
+	sshConn, newSSHChannels, sshRequests, err := ssh.NewServerConn(conn, s.server.sshConfigFor(ctx))
+	conn.SetDeadline(time.Time{})
+	if s.handshakeDone != nil {
+		s.handshakeDone()
+	}
 	if err != nil {
 		return s.ResumeAndShutdown(s.DLogErrorf("Failed to handshake (%s)", err))
 	}
@@ -207,9 +627,14 @@ func (s *ServerSSHSession) Run(ctx context.Context, conn net.Conn) error {
 
 	err = s.runWithSSHConn(ctx, sshConn, newSSHChannels, sshRequests)
 	if err != nil {
-		return s.Shutdown(s.DLogErrorf("SSH session failed: %s", err))
+		err = s.Shutdown(s.DLogErrorf("SSH session failed: %s", err))
+	} else {
+		s.DLogf("Closing SSH connection")
+		err = s.Close()
 	}
 
-	s.DLogf("Closing SSH connection")
-	return s.Close()
+	if s.started {
+		s.events.sessionEnd(s.sessionInfo, err)
+	}
+	return err
 }
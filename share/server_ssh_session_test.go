@@ -0,0 +1,224 @@
+package chshare
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// testEchoConn sends a message over an open connection to an echo service, and checks the reply
+func testEchoConn(t *testing.T, conn net.Conn, msg string) {
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	if _, err := conn.Write([]byte(msg)); err != nil {
+		t.Fatalf("Write through tunnel failed: %s", err)
+	}
+	reply := make([]byte, len(msg))
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		t.Fatalf("Read through tunnel failed: %s", err)
+	}
+	if string(reply) != msg {
+		t.Fatalf("Expected echo %q, got %q", msg, reply)
+	}
+}
+
+// testDialUntil dials addr until the dial result matches wantOK, failing the test if it does not
+// within a few seconds. A successful connection is returned, open.
+func testDialUntil(t *testing.T, addr string, wantOK bool) net.Conn {
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		conn, err := net.DialTimeout("tcp4", addr, time.Second)
+		if (err == nil) == wantOK {
+			return conn
+		}
+		if conn != nil {
+			conn.Close()
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Dial %s: expected success=%v, last error: %v", addr, wantOK, err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestServerDrainKeepsReverseChannels(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	echoPort, stopEcho := testEchoService(t)
+	defer stopEcho()
+
+	s, err := NewServer(&ProxyServerConfig{KeySeed: "drain-test", Reverse: true, DrainTimeout: 10 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	serverPort := testLocalPort(t)
+	go s.Run(ctx, "127.0.0.1", strconv.Itoa(serverPort))
+
+	stubAddr := "127.0.0.1:" + strconv.Itoa(testLocalPort(t))
+	c, err := NewClient(&Config{
+		Server:        fmt.Sprintf("http://127.0.0.1:%d", serverPort),
+		Fingerprint:   s.GetFingerprint(),
+		ChdStrings:    []string{fmt.Sprintf("R:%s:127.0.0.1:%d", stubAddr, echoPort)},
+		MaxRetryCount: -1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if err := c.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	conn := testDialUntil(t, stubAddr, true)
+	defer conn.Close()
+	testEchoConn(t, conn, "before")
+
+	s.StartShutdown(nil)
+
+	// the reverse listener stops, but the channel in flight keeps working while it drains
+	testDialUntil(t, stubAddr, false)
+	testEchoConn(t, conn, "during")
+	if s.IsDoneShutdown() {
+		t.Fatalf("Server finished shutting down with a channel in flight")
+	}
+
+	conn.Close()
+	done := make(chan struct{})
+	go func() {
+		s.WaitShutdown()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Server did not finish shutting down once its last channel ended")
+	}
+}
+
+func TestServerHandshakeTimeoutDropsSilentClient(t *testing.T) {
+	s, err := NewServer(&ProxyServerConfig{KeySeed: "handshake-test", HandshakeTimeout: 200 * time.Millisecond, NoLoop: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	// the client end never reads or writes
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+	released := make(chan struct{}, 2)
+	done := make(chan struct{})
+	go func() {
+		s.handleSSHConn(context.Background(), serverConn, func() { released <- struct{}{} })
+		close(done)
+	}()
+	select {
+	case <-released:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Handshake slot of a silent client was not released")
+	}
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Session with a silent client did not end")
+	}
+}
+
+func TestDiffReverseRemotes(t *testing.T) {
+	var chds []*ChannelDescriptor
+	for _, s := range []string{"R:2001:localhost:22", "R:2002:localhost:22", "R:2003:localhost:22", "R:2002:localhost:22"} {
+		chd, _, err := ParseChannelDescriptorPath(s)
+		if err != nil {
+			t.Fatalf("ParseChannelDescriptorPath(%q): %s", s, err)
+		}
+		chds = append(chds, &chd)
+	}
+	removed, _, err := ParseChannelDescriptorPath("R:2000:localhost:22")
+	if err != nil {
+		t.Fatalf("ParseChannelDescriptorPath(): %s", err)
+	}
+	running := map[string]*TCPProxy{
+		removed.String(): nil,
+		chds[1].String(): nil,
+	}
+	stop, start, unchanged := diffReverseRemotes(running, chds)
+	if want := []string{removed.String()}; !reflect.DeepEqual(stop, want) {
+		t.Errorf("diffReverseRemotes() stop = %v, want %v", stop, want)
+	}
+	if want := []int{0, 2}; !reflect.DeepEqual(start, want) {
+		t.Errorf("diffReverseRemotes() start = %v, want %v", start, want)
+	}
+	if want := []string{chds[1].String()}; !reflect.DeepEqual(unchanged, want) {
+		t.Errorf("diffReverseRemotes() unchanged = %v, want %v", unchanged, want)
+	}
+
+	stop, start, unchanged = diffReverseRemotes(map[string]*TCPProxy{}, nil)
+	if len(stop) != 0 || len(start) != 0 || len(unchanged) != 0 {
+		t.Errorf("diffReverseRemotes() of nothing = %v, %v, %v; want nothing", stop, start, unchanged)
+	}
+}
+
+func TestCheckDuplicateReverseRemotes(t *testing.T) {
+	tests := []struct {
+		chdStrings []string
+		wantErr    bool
+	}{
+		{[]string{"R:2001:localhost:22", "R:2002:localhost:22"}, false},
+		{[]string{"R:2001:localhost:22", "R:2002:localhost:22", "R:2001:localhost:22"}, true},
+		// only reverse remotes have a server-side listener
+		{[]string{"2001:localhost:22", "2001:localhost:22"}, false},
+		{[]string{"2001:localhost:22", "R:2001:localhost:22"}, false},
+		{nil, false},
+	}
+	for _, test := range tests {
+		var chds []*ChannelDescriptor
+		for _, s := range test.chdStrings {
+			chd, _, err := ParseChannelDescriptorPath(s)
+			if err != nil {
+				t.Fatalf("ParseChannelDescriptorPath(%q): %s", s, err)
+			}
+			chds = append(chds, &chd)
+		}
+		err := checkDuplicateReverseRemotes(chds)
+		if (err != nil) != test.wantErr {
+			t.Errorf("checkDuplicateReverseRemotes(%v) = %v, want error %v", test.chdStrings, err, test.wantErr)
+		}
+	}
+}
+
+func TestReconfigureReverseRejectsDuplicates(t *testing.T) {
+	s, err := NewServer(&ProxyServerConfig{KeySeed: "reconfigure-test", Reverse: true, NoLoop: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	session, err := NewServerSSHSession(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stubAddr := "127.0.0.1:" + strconv.Itoa(testLocalPort(t))
+	c := &ReverseConfig{}
+	for i := 0; i < 2; i++ {
+		chd, _, err := ParseChannelDescriptorPath("R:" + stubAddr + ":localhost:22")
+		if err != nil {
+			t.Fatalf("ParseChannelDescriptorPath(): %s", err)
+		}
+		c.ChannelDescriptors = append(c.ChannelDescriptors, &chd)
+	}
+	payload, err := c.Marshal()
+	if err != nil {
+		t.Fatalf("Marshal() returned error: %s", err)
+	}
+	if _, err := session.reconfigureReverse(context.Background(), payload); err == nil {
+		t.Errorf("reconfigureReverse() with a duplicate reverse remote did not return an error")
+	}
+	if len(session.reverseProxies) != 0 {
+		t.Errorf("reconfigureReverse() with a duplicate reverse remote started %d remotes", len(session.reverseProxies))
+	}
+}
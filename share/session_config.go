@@ -1,6 +1,7 @@
 package chshare
 
 import (
+	"encoding/json"
 	"fmt"
 
 	"github.com/golang/protobuf/proto"
@@ -12,6 +13,55 @@ import (
 type SessionConfigRequest struct {
 	Version            string
 	ChannelDescriptors []*ChannelDescriptor
+
+	// AllowPartial asks the server to continue with the remaining reverse remotes if
+	// some of them cannot be set up, rather than failing the whole session. Failed
+	// remotes are reported back in the SessionConfigReply.
+	AllowPartial bool
+
+	// PreserveSourcePort asks the server to propagate each Caller's source port on reverse remotes,
+	// so that the client can dial the target from the same port where possible
+	PreserveSourcePort bool
+}
+
+// RemoteFailure describes a remote that could not be set up at startup
+type RemoteFailure struct {
+	// Index is the zero-based position of the remote's channel descriptor in the session config
+	Index int `json:"index"`
+
+	// Descriptor is the string form of the remote's channel descriptor
+	Descriptor string `json:"descriptor"`
+
+	// Error describes why the remote could not be set up
+	Error string `json:"error"`
+}
+
+// SessionConfigReply is the payload of a successful reply to a session config request. It
+// is empty unless the session was configured with AllowPartial and some reverse remotes failed.
+type SessionConfigReply struct {
+	FailedRemotes []RemoteFailure `json:"failed_remotes,omitempty"`
+}
+
+// Marshal serializes a SessionConfigReply. An empty reply serializes to no bytes, which is
+// what servers that predate SessionConfigReply send.
+func (r *SessionConfigReply) Marshal() ([]byte, error) {
+	if len(r.FailedRemotes) == 0 {
+		return nil, nil
+	}
+	return json.Marshal(r)
+}
+
+// Unmarshal unserializes a SessionConfigReply
+func (r *SessionConfigReply) Unmarshal(b []byte) error {
+	*r = SessionConfigReply{}
+	if len(b) == 0 {
+		return nil
+	}
+	err := json.Unmarshal(b, r)
+	if err != nil {
+		return fmt.Errorf("Invalid session config reply: %s", err)
+	}
+	return nil
 }
 
 // ToPb converts a SessionConfigRequest to its protobuf value
@@ -24,12 +74,14 @@ func (c *SessionConfigRequest) ToPb() *interproxy.PbSessionConfigRequest {
 	return &api / interproxy.PbSessionConfigRequest{
 		ClientVersion:      c.Version,
 		ChannelDescriptors: pbcds,
+		AllowPartial:       c.AllowPartial,
 	}
 }
 
 // FromPb initializes a SessionConfigRequest from its protobuf value
 func (c *SessionConfigRequest) FromPb(pb *interproxy.PbSessionConfigRequest) {
 	c.Version = pb.GetClientVersion()
+	c.AllowPartial = pb.GetAllowPartial()
 	numChannels := len(pb.ChannelDescriptors)
 	c.ChannelDescriptors = make([]*ChannelDescriptor, numChannels)
 	for i, pbcd := range pb.ChannelDescriptors {
@@ -47,6 +99,7 @@ func PbToSessionConfigRequest(pb *interproxy.PbSessionConfigRequest) *SessionCon
 	return &SessionConfigRequest{
 		Version:            pb.GetClientVersion(),
 		ChannelDescriptors: cds,
+		AllowPartial:       pb.GetAllowPartial(),
 	}
 }
 
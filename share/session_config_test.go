@@ -0,0 +1,53 @@
+package chshare
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSessionConfigRequestAllowPartial(t *testing.T) {
+	chd, _, err := ParseChannelDescriptorPath("R:8080:localhost:80")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, allowPartial := range []bool{false, true} {
+		c := &SessionConfigRequest{Version: "test", ChannelDescriptors: []*ChannelDescriptor{&chd}, AllowPartial: allowPartial}
+		b, err := c.Marshal()
+		if err != nil {
+			t.Fatalf("Marshal() returned error: %s", err)
+		}
+		// field 3, varint 1, encoded last; omitted when false, as by clients that predate AllowPartial
+		if hasField := bytes.HasSuffix(b, []byte{0x18, 0x01}); hasField != allowPartial {
+			t.Errorf("Marshal() with AllowPartial=%v encoded the field: %v", allowPartial, hasField)
+		}
+		c2 := &SessionConfigRequest{}
+		if err := c2.Unmarshal(b); err != nil {
+			t.Fatalf("Unmarshal() returned error: %s", err)
+		}
+		if c2.AllowPartial != allowPartial || len(c2.ChannelDescriptors) != 1 || c2.ChannelDescriptors[0].String() != chd.String() {
+			t.Errorf("Unmarshal(Marshal()) = %+v, want AllowPartial=%v and descriptor %s", c2, allowPartial, chd.String())
+		}
+	}
+}
+
+func TestSessionConfigReplyFailedRemotes(t *testing.T) {
+	b, err := (&SessionConfigReply{}).Marshal()
+	if err != nil || len(b) != 0 {
+		t.Errorf("Marshal() of an empty reply = (%q, %v), want no bytes", b, err)
+	}
+	r := &SessionConfigReply{FailedRemotes: []RemoteFailure{{Index: 1, Descriptor: "R:tcp://0.0.0.0:8080,tcp://localhost:80", Error: "address in use"}}}
+	b, err = r.Marshal()
+	if err != nil {
+		t.Fatalf("Marshal() returned error: %s", err)
+	}
+	r2 := &SessionConfigReply{}
+	if err := r2.Unmarshal(b); err != nil {
+		t.Fatalf("Unmarshal(%s) returned error: %s", b, err)
+	}
+	if len(r2.FailedRemotes) != 1 || r2.FailedRemotes[0] != r.FailedRemotes[0] {
+		t.Errorf("Unmarshal(%s) = %+v, want %+v", b, r2, r)
+	}
+	if err := r2.Unmarshal(nil); err != nil || len(r2.FailedRemotes) != 0 {
+		t.Errorf("Unmarshal() of an empty reply = (%+v, %v), want no failures", r2, err)
+	}
+}
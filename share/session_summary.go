@@ -0,0 +1,30 @@
+package chshare
+
+import (
+	"fmt"
+	"strings"
+)
+
+// sessionConfigSummary formats a single line summarizing a client's session config for audit,
+// e.g.:
+//
+//	user="alice" peer=192.0.2.1:51234 remotes=2 fwd:allowed:"3000:localhost:80" rev:denied:"R:2222:localhost:22"
+//
+// Each remote is marked "fwd" or "rev", then "allowed" or "denied", then its quoted descriptor.
+// user is "" if user authentication is disabled. denied holds the indexes of denied remotes.
+func sessionConfigSummary(user string, peer string, chds []*ChannelDescriptor, denied map[int]bool) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "user=%q peer=%s remotes=%d", user, peer, len(chds))
+	for i, chd := range chds {
+		direction := "fwd"
+		if chd.Reverse {
+			direction = "rev"
+		}
+		access := "allowed"
+		if denied[i] {
+			access = "denied"
+		}
+		fmt.Fprintf(&b, " %s:%s:%q", direction, access, chd.String())
+	}
+	return b.String()
+}
@@ -0,0 +1,21 @@
+package chshare
+
+import (
+	"testing"
+)
+
+func TestSessionConfigSummary(t *testing.T) {
+	var chds []*ChannelDescriptor
+	for _, s := range []string{"3000:localhost:80", "R:2222:localhost:22"} {
+		chd, _, err := ParseChannelDescriptorPath(s)
+		if err != nil {
+			t.Fatalf("ParseChannelDescriptorPath(%s) returned error: %s", s, err)
+		}
+		chds = append(chds, &chd)
+	}
+	got := sessionConfigSummary("alice", "192.0.2.1:51234", chds, map[int]bool{1: true})
+	want := `user="alice" peer=192.0.2.1:51234 remotes=2 fwd:allowed:"` + chds[0].String() + `" rev:denied:"` + chds[1].String() + `"`
+	if got != want {
+		t.Errorf("sessionConfigSummary() = %s, want %s", got, want)
+	}
+}
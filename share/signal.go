@@ -3,6 +3,7 @@
 package chshare
 
 import (
+	"context"
 	"os"
 	"os/signal"
 	"syscall"
@@ -12,13 +13,34 @@ import (
 //SleepSignal sleeps for the given duration,
 //or until a SIGHUP is received
 func SleepSignal(d time.Duration) {
+	sleepSignalClock(RealClock, d)
+}
+
+//sleepSignalClock is SleepSignal, timed by clock
+func sleepSignalClock(clock Clock, d time.Duration) {
 	//during this time, also listen for SIGHUP
 	//(this uses 0xc to allow windows to compile)
 	sig := make(chan os.Signal, 1)
 	signal.Notify(sig, syscall.SIGHUP)
 	select {
-	case <-time.After(d):
+	case <-clock.After(d):
 	case <-sig:
 	}
 	signal.Stop(sig)
 }
+
+//OnHangup calls f each time a SIGHUP is received,
+//until ctx is done
+func OnHangup(ctx context.Context, f func()) {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGHUP)
+	defer signal.Stop(sig)
+	for {
+		select {
+		case <-sig:
+			f()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -2,9 +2,22 @@
 
 package chshare
 
-import "time"
+import (
+	"context"
+	"time"
+)
 
 //Sleep unless Signal
 func SleepSignal(d time.Duration) {
 	time.Sleep(d) //not supported
 }
+
+//sleepSignalClock is SleepSignal, timed by clock
+func sleepSignalClock(clock Clock, d time.Duration) {
+	<-clock.After(d) //not supported
+}
+
+//OnHangup is not supported; waits for ctx
+func OnHangup(ctx context.Context, f func()) {
+	<-ctx.Done()
+}
@@ -0,0 +1,106 @@
+package chshare
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+)
+
+// SNI routing lets one TLS-terminating server port be shared between the tunnel and other
+// HTTP services. Requests whose TLS ServerName (SNI) matches a route are reverse-proxied to that
+// route's target, whatever they are; all other requests, including every request made without
+// TLS, are handled as usual (tunnel connections, --proxy, health checks). Routing is by SNI
+// rather than by Host header, so it is decided by the hostname the client actually connected to.
+
+// ParseSNIRoutes parses a comma-separated list of "<hostname>=<target-url>" routes. A hostname
+// of the form "*.<domain>" matches any single-label subdomain of <domain>.
+func ParseSNIRoutes(s string) (map[string]string, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+	routes := make(map[string]string)
+	for _, spec := range strings.Split(s, ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		pair := strings.SplitN(spec, "=", 2)
+		host := strings.ToLower(strings.TrimSpace(pair[0]))
+		if len(pair) != 2 || host == "" || strings.TrimSpace(pair[1]) == "" {
+			return nil, fmt.Errorf("Invalid SNI route \"%s\"; expected \"<hostname>=<target-url>\"", spec)
+		}
+		if _, ok := routes[host]; ok {
+			return nil, fmt.Errorf("Duplicate SNI route for \"%s\"", host)
+		}
+		routes[host] = strings.TrimSpace(pair[1])
+	}
+	return routes, nil
+}
+
+// newSingleHostReverseProxy creates a reverse proxy that sends all requests to the host in
+// targetURL, rewriting the Host header to match
+func newSingleHostReverseProxy(targetURL string) (*httputil.ReverseProxy, error) {
+	u, err := url.Parse(targetURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("Missing protocol (%s)", u)
+	}
+	proxy := httputil.NewSingleHostReverseProxy(u)
+	//always use proxy host
+	proxy.Director = func(r *http.Request) {
+		r.URL.Scheme = u.Scheme
+		r.URL.Host = u.Host
+		r.Host = u.Host
+	}
+	return proxy, nil
+}
+
+// sniRouter maps TLS server names to reverse proxies
+type sniRouter struct {
+	routes map[string]*httputil.ReverseProxy
+}
+
+func newSNIRouter(routes map[string]string) (*sniRouter, error) {
+	r := &sniRouter{routes: make(map[string]*httputil.ReverseProxy)}
+	for host, target := range routes {
+		proxy, err := newSingleHostReverseProxy(target)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid target for SNI route \"%s\": %s", host, err)
+		}
+		r.routes[strings.ToLower(host)] = proxy
+	}
+	return r, nil
+}
+
+// route returns the reverse proxy for the request's TLS server name, or nil if the request was
+// not made over TLS, sent no server name, or no route matches
+func (r *sniRouter) route(req *http.Request) *httputil.ReverseProxy {
+	if req.TLS == nil || req.TLS.ServerName == "" {
+		return nil
+	}
+	name := strings.ToLower(req.TLS.ServerName)
+	if proxy, ok := r.routes[name]; ok {
+		return proxy
+	}
+	if i := strings.Index(name, "."); i > 0 {
+		if proxy, ok := r.routes["*"+name[i:]]; ok {
+			return proxy
+		}
+	}
+	return nil
+}
+
+// exactHosts returns the non-wildcard hostnames that have routes
+func (r *sniRouter) exactHosts() []string {
+	var hosts []string
+	for host := range r.routes {
+		if !strings.HasPrefix(host, "*.") {
+			hosts = append(hosts, host)
+		}
+	}
+	return hosts
+}
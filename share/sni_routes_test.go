@@ -0,0 +1,52 @@
+package chshare
+
+import (
+	"crypto/tls"
+	"net/http"
+	"testing"
+)
+
+func TestParseSNIRoutes(t *testing.T) {
+	routes, err := ParseSNIRoutes(" App.example.com=http://127.0.0.1:8081, *.svc.example.com=http://127.0.0.1:8082 ")
+	if err != nil {
+		t.Fatalf("ParseSNIRoutes() returned error: %s", err)
+	}
+	if len(routes) != 2 || routes["app.example.com"] != "http://127.0.0.1:8081" || routes["*.svc.example.com"] != "http://127.0.0.1:8082" {
+		t.Errorf("ParseSNIRoutes() = %v", routes)
+	}
+	for _, s := range []string{"app.example.com", "=http://x", "a=http://x,a=http://y"} {
+		if _, err := ParseSNIRoutes(s); err == nil {
+			t.Errorf("ParseSNIRoutes(%q) did not return an error", s)
+		}
+	}
+}
+
+func TestSNIRouterRoute(t *testing.T) {
+	r, err := newSNIRouter(map[string]string{
+		"app.example.com":   "http://127.0.0.1:8081",
+		"*.svc.example.com": "http://127.0.0.1:8082",
+	})
+	if err != nil {
+		t.Fatalf("newSNIRouter() returned error: %s", err)
+	}
+	request := func(serverName string) *http.Request {
+		req, _ := http.NewRequest("GET", "https://tunnel.example.com/", nil)
+		req.TLS = &tls.ConnectionState{ServerName: serverName}
+		return req
+	}
+	if r.route(request("APP.example.com")) != r.routes["app.example.com"] {
+		t.Errorf("route() did not match exact hostname")
+	}
+	if r.route(request("a.svc.example.com")) != r.routes["*.svc.example.com"] {
+		t.Errorf("route() did not match wildcard hostname")
+	}
+	for _, name := range []string{"tunnel.example.com", "a.b.svc.example.com", ""} {
+		if r.route(request(name)) != nil {
+			t.Errorf("route() matched %q, expected fall through", name)
+		}
+	}
+	plain, _ := http.NewRequest("GET", "http://app.example.com/", nil)
+	if r.route(plain) != nil {
+		t.Errorf("route() matched a request without TLS")
+	}
+}
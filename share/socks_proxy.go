@@ -0,0 +1,43 @@
+package chshare
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+// IsSOCKS5ProxyURL returns true if proxyURL names a SOCKS5 proxy (socks5:// or socks5h://)
+// rather than an HTTP CONNECT proxy
+func IsSOCKS5ProxyURL(proxyURL *url.URL) bool {
+	return proxyURL.Scheme == "socks5" || proxyURL.Scheme == "socks5h"
+}
+
+// NewSOCKS5ProxyDialer returns a dial function that establishes TCP connections through the
+// SOCKS5 proxy at proxyURL. Username/password authentication is used if credentials are
+// embedded in the URL. Destination hostnames are always resolved by the proxy. The port
+// defaults to 1080.
+func NewSOCKS5ProxyDialer(proxyURL *url.URL, timeout time.Duration) (func(network, addr string) (net.Conn, error), error) {
+	if !IsSOCKS5ProxyURL(proxyURL) {
+		return nil, fmt.Errorf("Unsupported SOCKS proxy URL scheme '%s'", proxyURL.Scheme)
+	}
+	if proxyURL.Hostname() == "" {
+		return nil, fmt.Errorf("Missing host in SOCKS proxy URL '%s'", proxyURL.Redacted())
+	}
+	proxyAddr := proxyURL.Host
+	if proxyURL.Port() == "" {
+		proxyAddr = net.JoinHostPort(proxyURL.Hostname(), "1080")
+	}
+	var auth *proxy.Auth
+	if proxyURL.User != nil {
+		auth = &proxy.Auth{User: proxyURL.User.Username()}
+		auth.Password, _ = proxyURL.User.Password()
+	}
+	dialer, err := proxy.SOCKS5("tcp", proxyAddr, auth, &net.Dialer{Timeout: timeout})
+	if err != nil {
+		return nil, fmt.Errorf("Invalid SOCKS proxy '%s': %s", proxyURL.Redacted(), err)
+	}
+	return dialer.Dial, nil
+}
@@ -0,0 +1,24 @@
+package chshare
+
+import "fmt"
+
+// checkSocksRemote returns an error if chd uses a socks endpoint that cannot work, so that it is
+// rejected when the session is configured rather than when a connection is first attempted. A
+// socks endpoint can only be a skeleton, and needs a SOCKS5 server on the proxy that runs it:
+// forwardSocks tells whether the server runs one (for forward remotes), and reverseSocks whether
+// the client does (for reverse remotes). Each side passes true for the other side, which checks it.
+func checkSocksRemote(chd *ChannelDescriptor, forwardSocks bool, reverseSocks bool) error {
+	if chd.Stub.Type == ChannelEndpointProtocolSocks {
+		return fmt.Errorf("Remote \"%s\" uses socks as a listening stub; socks can only be the target of a remote, e.g. \"socks\" or \"1080:socks\"", chd.String())
+	}
+	if chd.Skeleton.Type != ChannelEndpointProtocolSocks {
+		return nil
+	}
+	if chd.Reverse && !reverseSocks {
+		return fmt.Errorf("Remote \"%s\" is a reverse socks remote, but the client does not run a SOCKS5 server; use a forward remote, e.g. \"socks\"", chd.String())
+	}
+	if !chd.Reverse && !forwardSocks {
+		return fmt.Errorf("Remote \"%s\" requires SOCKS5, which is not enabled on the server (--socks5)", chd.String())
+	}
+	return nil
+}
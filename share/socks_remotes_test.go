@@ -0,0 +1,38 @@
+package chshare
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheckSocksRemote(t *testing.T) {
+	tests := []struct {
+		chd          string
+		forwardSocks bool
+		reverseSocks bool
+		errContains  string
+	}{
+		{chd: "socks", forwardSocks: true},
+		{chd: "3000:localhost:80"},
+		{chd: "R:5000:socks", reverseSocks: true},
+		{chd: "socks", reverseSocks: true, errContains: "not enabled on the server"},
+		{chd: "R:5000:socks", forwardSocks: true, errContains: "client does not run a SOCKS5 server"},
+		{chd: "socks://,tcp://localhost:80", forwardSocks: true, reverseSocks: true, errContains: "listening stub"},
+	}
+	for _, test := range tests {
+		chd, _, err := ParseChannelDescriptorPath(test.chd)
+		if err != nil {
+			t.Fatalf("%s: %s", test.chd, err)
+		}
+		err = checkSocksRemote(&chd, test.forwardSocks, test.reverseSocks)
+		if test.errContains == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %s", test.chd, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), test.errContains) {
+			t.Errorf("%s: expected an error containing %q, got %v", test.chd, test.errContains, err)
+		}
+	}
+}
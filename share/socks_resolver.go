@@ -0,0 +1,83 @@
+package chshare
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+
+	socks5 "github.com/armon/go-socks5"
+)
+
+// Special values for ProxyServerConfig.SocksResolver
+const (
+	// SocksResolverSystem resolves SOCKS destination hostnames with the server's system resolver (the default)
+	SocksResolverSystem = "system"
+
+	// SocksResolverNone refuses SOCKS requests that name a destination by hostname; only literal IP
+	// addresses are allowed
+	SocksResolverNone = "none"
+)
+
+// socksResolver implements socks5.NameResolver using a specific DNS server, or refusing
+// to resolve names at all. SOCKS requests with a literal IP destination never reach the
+// resolver.
+type socksResolver struct {
+	dnsServer string
+	resolver  *net.Resolver
+}
+
+// NewSocksResolver creates a socks5.NameResolver from a resolver spec, which is one of:
+//
+//	"" or "system"     Use the server's system resolver. Returns nil, which selects the socks5 default.
+//	"none"             Fail closed; destinations given by hostname are rejected.
+//	<host>[:<port>]    Send all DNS queries to the given DNS server (port defaults to 53).
+func NewSocksResolver(spec string) (socks5.NameResolver, error) {
+	switch strings.ToLower(spec) {
+	case "", SocksResolverSystem:
+		return nil, nil
+	case SocksResolverNone:
+		return &socksResolver{}, nil
+	}
+
+	dnsServer := spec
+	if _, _, err := net.SplitHostPort(spec); err != nil {
+		dnsServer = net.JoinHostPort(strings.Trim(spec, "[]"), "53")
+	}
+	if _, _, err := net.SplitHostPort(dnsServer); err != nil {
+		return nil, fmt.Errorf("Invalid SOCKS resolver DNS server address \"%s\": %s", spec, err)
+	}
+
+	r := &socksResolver{
+		dnsServer: dnsServer,
+		resolver: &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+				d := net.Dialer{}
+				return d.DialContext(ctx, network, dnsServer)
+			},
+		},
+	}
+	return r, nil
+}
+
+// Resolve implements socks5.NameResolver
+func (r *socksResolver) Resolve(ctx context.Context, name string) (context.Context, net.IP, error) {
+	if r.resolver == nil {
+		return ctx, nil, fmt.Errorf("SOCKS hostname resolution is disabled; refusing to resolve \"%s\"", name)
+	}
+	addrs, err := r.resolver.LookupIPAddr(ctx, name)
+	if err != nil {
+		return ctx, nil, fmt.Errorf("Unable to resolve \"%s\" via DNS server %s: %s", name, r.dnsServer, err)
+	}
+	if len(addrs) == 0 {
+		return ctx, nil, fmt.Errorf("No addresses for \"%s\" from DNS server %s", name, r.dnsServer)
+	}
+	// prefer IPv4, consistent with the tcp4-only listeners elsewhere
+	for _, a := range addrs {
+		if a.IP.To4() != nil {
+			return ctx, a.IP, nil
+		}
+	}
+	return ctx, addrs[0].IP, nil
+}
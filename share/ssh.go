@@ -9,7 +9,9 @@ import (
 	"encoding/pem"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net"
+	"os"
 	"strings"
 
 	"github.com/jpillora/sizestr"
@@ -37,6 +39,42 @@ func GenerateKey(seed string) ([]byte, error) {
 	return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: b}), nil
 }
 
+// LoadOrGenerateKeyFile loads a PEM-encoded server private key from path. If the file
+// does not exist, a new random key is generated and written to path with 0600 permissions.
+// The file is created with O_EXCL, so an existing key file is never overwritten, even if
+// another process creates it concurrently. generated is true if a new key was written.
+func LoadOrGenerateKeyFile(path string) (key []byte, generated bool, err error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		if !os.IsExist(err) {
+			return nil, false, fmt.Errorf("Unable to create key file \"%s\": %s", path, err)
+		}
+		key, err = ioutil.ReadFile(path)
+		if err != nil {
+			return nil, false, fmt.Errorf("Unable to read key file \"%s\": %s", path, err)
+		}
+		if len(key) == 0 {
+			return nil, false, fmt.Errorf("Key file \"%s\" is empty", path)
+		}
+		return key, false, nil
+	}
+
+	key, err = GenerateKey("")
+	if err == nil {
+		_, err = f.Write(key)
+	}
+	closeErr := f.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		// Don't leave a truncated key behind; it would be loaded on the next run
+		os.Remove(path)
+		return nil, false, fmt.Errorf("Unable to write key file \"%s\": %s", path, err)
+	}
+	return key, true, nil
+}
+
 // FingerprintKey returns a standard fingerprint hash string for an SSH
 // public key, which clients can use to authenticate the SSH server.
 func FingerprintKey(k ssh.PublicKey) string {
@@ -48,6 +86,28 @@ func FingerprintKey(k ssh.PublicKey) string {
 	return strings.Join(strbytes, ":")
 }
 
+// FingerprintLength is the length of a complete fingerprint returned by FingerprintKey
+const FingerprintLength = len("00:11:22:33:44:55:66:77:88:99:aa:bb:cc:dd:ee:ff")
+
+// ValidateFingerprintLength ensures that an expected fingerprint (which may be a prefix of the
+// complete fingerprint) is at least minLength characters long, so that a short prefix cannot match
+// many keys. A minLength of 0 requires the complete fingerprint; a negative minLength allows any
+// prefix. An empty fingerprint (no host key validation) is always allowed.
+func ValidateFingerprintLength(fingerprint string, minLength int) error {
+	if fingerprint == "" || minLength < 0 {
+		return nil
+	}
+	if minLength == 0 || minLength > FingerprintLength {
+		minLength = FingerprintLength
+	}
+	if len(fingerprint) < minLength {
+		return fmt.Errorf("Fingerprint \"%s\" is too short (%d characters; at least %d required). "+
+			"Use the complete fingerprint that the server logs at startup (\"Fingerprint xx:xx:...\")",
+			fingerprint, len(fingerprint), minLength)
+	}
+	return nil
+}
+
 // HandleTCPStream handles a new ssh.Conn from a remote Stub that needs to Dial
 // to a local network resource and pipe between them. Returns when the connection
 // is complete. src will be closed before returning.
@@ -5,7 +5,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"golang.org/x/crypto/ssh"
+	"sync"
 	"sync/atomic"
+	"time"
 )
 
 // SSHSession wraps a primary SSH connection to the remote proxy
@@ -28,6 +30,41 @@ type SSHSession struct {
 
 	// sshRequests is the chan on which ssh requests are received (including initial config request)
 	sshRequests <-chan *ssh.Request
+
+	// events, if not nil, receives channel lifecycle events for this session
+	events *eventDispatcher
+
+	// sessionInfo describes this session in channel lifecycle events
+	sessionInfo *SessionInfo
+
+	// lastChannelID is the last allocated ID for channels in this session
+	lastChannelID int64
+
+	// channels holds the channels currently being bridged, by ID
+	channelsLock sync.Mutex
+	channels     map[int64]*activeChannel
+
+	// metrics, if not nil, receives channel counters for this session
+	metrics *Metrics
+
+	// channelType holds the agreed SSH channel type, if an alternate to ChannelTypeWstunnel was negotiated
+	channelType atomic.Value
+
+	// disconnectReason, if not nil, is called at shutdown with the completion error to determine the
+	// DisconnectReason to send to the remote proxy before closing the connection. Set on the server only.
+	disconnectReason func(completionErr error) *DisconnectReason
+
+	// phases, if not nil, shuts down listeners and then active channels, in that order, before the
+	// SSH connection is closed. If nil, everything is shut down concurrently.
+	phases *PhasedShutdown
+
+	// proxies holds the session's running stub-side proxies, which are shut down after the SSH
+	// connection is closed. A proxy stopped on its own is dropped from it.
+	proxies proxySet
+
+	// handleRequest, if not nil, is offered each incoming SSH request of a type not handled here,
+	// and returns false if it does not recognize the type either. Set on the server only.
+	handleRequest func(ctx context.Context, req *ssh.Request) bool
 }
 
 // LastSSHSessionID is the last allocated ID for SSH sessions, for logging purposes
@@ -52,6 +89,14 @@ func (s *SSHSession) String() string {
 	return s.strname
 }
 
+// GetChannelType returns the SSH channel type used to open tunnelled channels in this session
+func (s *SSHSession) GetChannelType() string {
+	if t, ok := s.channelType.Load().(string); ok {
+		return t
+	}
+	return ChannelTypeWstunnel
+}
+
 // receiveSSHRequest receives a single SSH request from the ssh.Conn. Can be
 // canceled with the context
 func (s *SSHSession) receiveSSHRequest(ctx context.Context) (*ssh.Request, error) {
@@ -99,7 +144,8 @@ func (s *SSHSession) sendSSHErrorReply(ctx context.Context, r *ssh.Request, err
 	return s.sendSSHReply(ctx, r, false, []byte(err.Error()))
 }
 
-// handleSSHRequests handles incoming requests for the SSH session. Currently only ping is supported.
+// handleSSHRequests handles incoming requests for the SSH session: ping, channel type negotiation,
+// and any types recognized by handleRequest.
 func (s *SSHSession) handleSSHRequests(ctx context.Context, sshRequests <-chan *ssh.Request) {
 	for {
 		select {
@@ -114,7 +160,26 @@ func (s *SSHSession) handleSSHRequests(ctx context.Context, sshRequests <-chan *
 				if err != nil {
 					s.DLogf("SSH ping reply send failed, ignoring: %s", err)
 				}
+			case ChannelTypeRequest:
+				channelType := string(req.Payload)
+				if !IsSupportedChannelType(channelType) {
+					err := s.DLogErrorf("Unsupported channel type \"%s\"", channelType)
+					err = s.sendSSHErrorReply(ctx, req, err)
+					if err != nil {
+						s.DLogf("SSH channel type reply send failed, ignoring: %s", err)
+					}
+					break
+				}
+				s.DLogf("Using channel type \"%s\"", channelType)
+				s.channelType.Store(channelType)
+				err := s.sendSSHReply(ctx, req, true, nil)
+				if err != nil {
+					s.DLogf("SSH channel type reply send failed, ignoring: %s", err)
+				}
 			default:
+				if s.handleRequest != nil && s.handleRequest(ctx, req) {
+					break
+				}
 				err := s.DLogErrorf("Unknown SSH request type: %s", req.Type)
 				err = s.sendSSHErrorReply(ctx, req, err)
 				if err != nil {
@@ -141,6 +206,9 @@ func (s *SSHSession) handleSSHNewChannel(ctx context.Context, ch ssh.NewChannel)
 		}
 		return err
 	}
+	if !isAcceptableChannelType(ch.ChannelType(), s.GetChannelType()) {
+		return reject(ssh.UnknownChannelType, s.Errorf("Unknown channel type \"%s\"; expected \"%s\"", ch.ChannelType(), s.GetChannelType()))
+	}
 	epdJSON := ch.ExtraData()
 	epd := &ChannelEndpointDescriptor{}
 	err := json.Unmarshal(epdJSON, epd)
@@ -154,6 +222,9 @@ func (s *SSHSession) handleSSHNewChannel(ctx context.Context, ch ssh.NewChannel)
 	ep, err := NewLocalSkeletonChannelEndpoint(s.Logger, s.localChannelEnv, epd)
 	if err != nil {
 		s.DLogf("Failed to create skeleton endpoint for SSH NewChannel: %s", err)
+		if s.metrics != nil {
+			s.metrics.DialError()
+		}
 		return reject(ssh.Prohibited, err)
 	}
 
@@ -165,6 +236,9 @@ func (s *SSHSession) handleSSHNewChannel(ctx context.Context, ch ssh.NewChannel)
 	sshChannel, sshRequests, err := ch.Accept()
 	if err != nil {
 		s.DLogf("Failed to accept SSH NewChannel: %s", err)
+		if s.metrics != nil {
+			s.metrics.AcceptError()
+		}
 		ep.Close()
 		return err
 	}
@@ -183,11 +257,52 @@ func (s *SSHSession) handleSSHNewChannel(ctx context.Context, ch ssh.NewChannel)
 
 	// sshChannel is now wrapped by sshConn, and will be closed when sshConn is closed
 
-	var extraData []byte
-	numSent, numReceived, err := ep.DialAndServe(ctx, sshConn, extraData)
+	channelID := atomic.AddInt64(&s.lastChannelID, 1)
+	openTime := time.Now()
+	s.addActiveChannel(ChannelStatus{
+		SessionID:  s.id,
+		ChannelID:  channelID,
+		Descriptor: epd.String(),
+		OpenTime:   openTime,
+	}, sshConn, ep)
+	defer s.removeActiveChannel(channelID)
+
+	var chInfo *ChannelInfo
+	if s.events != nil {
+		chInfo = &ChannelInfo{
+			Session:    s.sessionInfo,
+			ChannelID:  channelID,
+			Descriptor: epd.String(),
+			OpenTime:   openTime,
+		}
+		s.events.channelOpen(chInfo)
+	}
+
+	if s.metrics != nil {
+		s.metrics.Channels.New()
+		s.metrics.Channels.Open()
+	}
+
+	extraData := ExtractChannelMetadata(epdJSON)
+	bridgeCtx := WithHalfCloseLinger(ctx, GetEnvHalfCloseLinger(s.localChannelEnv))
+	bridgeCtx = WithChannelBufferSize(bridgeCtx, GetEnvChannelBufferSize(s.localChannelEnv))
+	bridgeCtx = withEnvChannelTrace(bridgeCtx, s.localChannelEnv, epd.String())
+	numSent, numReceived, err := ep.DialAndServe(bridgeCtx, sshConn, extraData)
 
 	// sshConn and sshChannel have now been closed
 
+	if s.metrics != nil {
+		s.metrics.Channels.Close()
+		s.metrics.recordChannelEnd(numSent, numReceived, err)
+	}
+
+	if chInfo != nil {
+		closeInfo := *chInfo
+		closeInfo.BytesSent = numSent
+		closeInfo.BytesReceived = numReceived
+		s.events.channelClose(&closeInfo, err)
+	}
+
 	if err != nil {
 		s.DLogf("NewChannel session ended with error after %d bytes (caller->called), %d bytes (called->caller): %s", numSent, numReceived, err)
 	} else {
@@ -213,13 +328,63 @@ func (s *SSHSession) handleSSHChannels(ctx context.Context, newChannels <-chan s
 	}
 }
 
+// Disconnect ends the session, telling the remote proxy why. It does not wait for shutdown to complete.
+func (s *SSHSession) Disconnect(code DisconnectCode, format string, args ...interface{}) {
+	s.StartShutdown(NewDisconnectReason(code, format, args...))
+}
+
+// sendDisconnectReason makes a best effort to tell the remote proxy why the session is ending.
+// The connection is about to be closed, so it does not wait long.
+func (s *SSHSession) sendDisconnectReason(reason *DisconnectReason) {
+	payload, err := reason.Marshal()
+	if err != nil {
+		s.DLogf("Unable to encode disconnect reason, ignoring: %s", err)
+		return
+	}
+	done := make(chan struct{})
+	go func() {
+		_, _, err := s.sshConn.SendRequest(DisconnectRequest, false, payload)
+		if err != nil {
+			s.DLogf("Unable to send disconnect reason, ignoring: %s", err)
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		s.DLogf("Timed out sending disconnect reason, ignoring")
+	}
+}
+
+// ForceClose closes the SSH connection immediately, which unblocks any channels still bridging traffic,
+// and force closes the session's proxies and their listeners. Implements ForceCloser for ShutdownWithTimeout.
+func (s *SSHSession) ForceClose() error {
+	var err error
+	if s.sshConn != nil {
+		err = s.sshConn.Close()
+	}
+	s.proxies.forceCloseAll()
+	return err
+}
+
 // HandleOnceShutdown will be called exactly once, in its own goroutine. It should take completionError
 // as an advisory completion value, actually shut down, then return the real completion value.
 func (s *SSHSession) HandleOnceShutdown(completionErr error) error {
 	var err error
+	if s.sshConn != nil && s.disconnectReason != nil {
+		// sent before draining, so a client can reconnect elsewhere while its channels finish
+		if reason := s.disconnectReason(completionErr); reason != nil {
+			s.DLogf("Disconnecting remote proxy: %s", reason)
+			s.sendDisconnectReason(reason)
+		}
+	}
+	if s.phases != nil {
+		s.phases.Shutdown(completionErr)
+	}
 	if s.sshConn != nil {
 		s.sshConn.Close()
 	}
+	s.proxies.shutdownAll(completionErr)
 	if completionErr == nil {
 		completionErr = err
 	}
@@ -0,0 +1,35 @@
+package chshare
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateFingerprintLength(t *testing.T) {
+	full := "a1:b2:c3:d4:e5:f6:07:18:29:3a:4b:5c:6d:7e:8f:90"
+	if len(full) != FingerprintLength {
+		t.Fatalf("FingerprintLength = %d, want %d", FingerprintLength, len(full))
+	}
+	tests := []struct {
+		fingerprint string
+		minLength   int
+		ok          bool
+	}{
+		{full, 0, true},
+		{"", 0, true},
+		{"a1:b2", 0, false},
+		{"a1:b2", -1, true},
+		{full[:23], 23, true},
+		{full[:22], 23, false},
+		{full[:40], 100, false},
+	}
+	for _, tt := range tests {
+		err := ValidateFingerprintLength(tt.fingerprint, tt.minLength)
+		if (err == nil) != tt.ok {
+			t.Errorf("ValidateFingerprintLength(%q, %d) = %v, want ok=%v", tt.fingerprint, tt.minLength, err, tt.ok)
+		}
+		if err != nil && !strings.Contains(err.Error(), "complete fingerprint") {
+			t.Errorf("ValidateFingerprintLength() error %q does not mention the complete fingerprint", err)
+		}
+	}
+}
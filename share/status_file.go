@@ -0,0 +1,131 @@
+package chshare
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultStatusFileInterval is how often a status file is rewritten, unless another interval is
+// given to StartStatusFile
+const DefaultStatusFileInterval = 10 * time.Second
+
+// Status is a snapshot of the state of a client or server, as written to a status file for
+// external monitoring without a metrics port (see StartStatusFile)
+type Status struct {
+	// Time is when the snapshot was taken
+	Time time.Time `json:"time"`
+
+	// Pid is the process id
+	Pid int `json:"pid"`
+
+	// Role is "client" or "server"
+	Role string `json:"role"`
+
+	// State is the connection state of a client (see ClientConnState), or "running" or "stopping"
+	// for a server
+	State string `json:"state"`
+
+	// SessionsActive is the number of SSH sessions currently open
+	SessionsActive int32 `json:"sessions_active"`
+
+	// ChannelsActive is the number of tunnelled channels currently open
+	ChannelsActive int32 `json:"channels_active"`
+
+	// BytesSent and BytesReceived are the bytes forwarded so far by completed channels, from callers
+	// to services and back (see Metrics)
+	BytesSent     int64 `json:"bytes_sent"`
+	BytesReceived int64 `json:"bytes_received"`
+
+	// LastError is the most recent connection error of a client, or "" if there has been none
+	LastError string `json:"last_error,omitempty"`
+}
+
+// StatusSource is implemented by Client and Server
+type StatusSource interface {
+	// Status returns a snapshot of the current state
+	Status() *Status
+}
+
+// newStatus returns a Status with the fields common to clients and servers filled in from m
+func newStatus(role string, state string, m *Metrics) *Status {
+	return &Status{
+		Time:           time.Now(),
+		Pid:            os.Getpid(),
+		Role:           role,
+		State:          state,
+		SessionsActive: m.Sessions.Active(),
+		ChannelsActive: m.Channels.Active(),
+		BytesSent:      atomic.LoadInt64(&m.bytesSent),
+		BytesReceived:  atomic.LoadInt64(&m.bytesReceived),
+	}
+}
+
+// writeStatusFile atomically replaces the file at path with st, encoded as JSON, by writing a
+// temporary file in the same directory and renaming it, so that a reader never sees a partial file
+func writeStatusFile(path string, st *Status) error {
+	b, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := fmt.Sprintf("%s.%d.tmp", path, os.Getpid())
+	if err := ioutil.WriteFile(tmp, append(b, '\n'), 0644); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// StartStatusFile writes the status of src to the file at path right away, and then again every
+// interval on clock (DefaultStatusFileInterval if interval <= 0), until the returned function is
+// called, which stops the updates and removes the file. A failed write is logged, and tried again
+// at the next interval; it does not stop the updates.
+func StartStatusFile(logger Logger, clock Clock, path string, interval time.Duration, src StatusSource) func() {
+	if interval <= 0 {
+		interval = DefaultStatusFileInterval
+	}
+	failing := false
+	update := func() {
+		err := writeStatusFile(path, src.Status())
+		if err != nil && !failing {
+			// logged once until a write succeeds again, not once per interval
+			logger.ILogf("Unable to write status file %s: %s", path, err)
+		} else if err == nil && failing {
+			logger.ILogf("Writing status file %s again", path)
+		}
+		failing = err != nil
+	}
+	update()
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		timer := clock.NewTimer(interval)
+		defer timer.Stop()
+		for {
+			select {
+			case <-timer.C():
+				update()
+				timer.Reset(interval)
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		wg.Wait()
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			logger.ILogf("Unable to remove status file %s: %s", path, err)
+		}
+	}
+}
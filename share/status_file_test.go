@@ -0,0 +1,101 @@
+package chshare
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingStatusSource returns a Status whose BytesSent counts the calls to Status
+type countingStatusSource struct {
+	calls int64
+}
+
+func (s *countingStatusSource) Status() *Status {
+	return &Status{Role: "test", State: "running", BytesSent: atomic.AddInt64(&s.calls, 1)}
+}
+
+func readStatusFile(t *testing.T, path string) *Status {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Unable to read status file: %s", err)
+	}
+	var st Status
+	if err := json.Unmarshal(b, &st); err != nil {
+		t.Fatalf("Status file is not valid JSON: %s", err)
+	}
+	return &st
+}
+
+func TestStatusFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "status-file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "status.json")
+
+	src := &countingStatusSource{}
+	clock := NewMockClock(time.Now())
+	stop := StartStatusFile(NewLogger("test", LogLevelInfo), clock, path, 10*time.Second, src)
+	if st := readStatusFile(t, path); st.Role != "test" || st.State != "running" || st.BytesSent != 1 {
+		t.Errorf("Initial status = %+v, want the source's status", st)
+	}
+	for want := int64(2); want <= 3; want++ {
+		waitForTimers(t, clock, 1)
+		clock.Advance(9 * time.Second)
+		time.Sleep(10 * time.Millisecond)
+		if n := atomic.LoadInt64(&src.calls); n != want-1 {
+			t.Fatalf("Status file was written %d times before the interval elapsed, want %d", n, want-1)
+		}
+		clock.Advance(time.Second)
+		deadline := time.Now().Add(5 * time.Second)
+		for atomic.LoadInt64(&src.calls) < want {
+			if time.Now().After(deadline) {
+				t.Fatalf("Status file was not updated after the interval elapsed")
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	waitForTimers(t, clock, 1)
+	if st := readStatusFile(t, path); st.BytesSent != 3 {
+		t.Errorf("Latest status has BytesSent %d, want 3", st.BytesSent)
+	}
+	stop()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Status file still exists after stop: %v", err)
+	}
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 0 {
+		t.Errorf("Temporary files left behind: %d", len(files))
+	}
+}
+
+func TestStatusFileWriteFailure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "status-file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "missing", "status.json")
+
+	src := &countingStatusSource{}
+	clock := NewMockClock(time.Now())
+	stop := StartStatusFile(NewLogger("test", LogLevelInfo), clock, path, 10*time.Second, src)
+	for want := int64(2); want <= 3; want++ {
+		waitForTimers(t, clock, 1)
+		clock.Advance(10 * time.Second)
+		deadline := time.Now().Add(5 * time.Second)
+		for atomic.LoadInt64(&src.calls) < want {
+			if time.Now().After(deadline) {
+				t.Fatalf("Status updates stopped after a failed write")
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	stop()
+}
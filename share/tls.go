@@ -0,0 +1,111 @@
+package chshare
+
+import (
+	"crypto/tls"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// certReloader serves a TLS certificate loaded from a cert/key file pair, and can reload it
+// (e.g., on SIGHUP, after the files have been rotated) without restarting the listener.
+// Connections already established keep the certificate they were handshaked with.
+type certReloader struct {
+	certFile string
+	keyFile  string
+	lock     sync.RWMutex
+	cert     *tls.Certificate
+}
+
+func newCertReloader(certFile string, keyFile string) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile}
+	err := r.Reload()
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload loads the certificate and key files again. On failure, the previously loaded
+// certificate remains in use.
+func (r *certReloader) Reload() error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("Unable to load TLS certificate '%s' and key '%s': %s", r.certFile, r.keyFile, err)
+	}
+	r.lock.Lock()
+	r.cert = &cert
+	r.lock.Unlock()
+	return nil
+}
+
+// GetCertificate implements tls.Config.GetCertificate
+func (r *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return r.cert, nil
+}
+
+// ParseTLSDomains splits a comma-separated list of domain names
+func ParseTLSDomains(s string) []string {
+	var domains []string
+	for _, d := range strings.Split(s, ",") {
+		d = strings.TrimSpace(d)
+		if d != "" {
+			domains = append(domains, d)
+		}
+	}
+	return domains
+}
+
+// DefaultAutocertCacheDir returns the default directory in which certificates obtained with
+// --tls-domain are cached across restarts
+func DefaultAutocertCacheDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "wstunnel", "autocert")
+}
+
+// NewServerTLSConfig creates the TLS configuration for a server that terminates TLS itself.
+// Either certFile and keyFile name a PEM certificate and key, or domains lists the hostnames for
+// which certificates are obtained automatically from Let's Encrypt (using the TLS-ALPN-01
+// challenge on the server's own listener, so the server must be reachable on port 443), and
+// cached in cacheDir. The returned reload function reloads certFile and keyFile; it is nil for
+// automatic certificates, which renew themselves.
+//
+// TLS only protects the transport; the SSH session inside it is still authenticated by the
+// server's key fingerprint, independent of the TLS certificate.
+func NewServerTLSConfig(certFile string, keyFile string, domains []string, cacheDir string) (*tls.Config, func() error, error) {
+	if len(domains) > 0 {
+		if certFile != "" || keyFile != "" {
+			return nil, nil, fmt.Errorf("A TLS certificate and key cannot be combined with automatic TLS domains")
+		}
+		if cacheDir == "" {
+			cacheDir = DefaultAutocertCacheDir()
+		}
+		m := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(domains...),
+			Cache:      autocert.DirCache(cacheDir),
+		}
+		return m.TLSConfig(), nil, nil
+	}
+	if certFile == "" || keyFile == "" {
+		return nil, nil, fmt.Errorf("Both a TLS certificate and key are required")
+	}
+	r, err := newCertReloader(certFile, keyFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	cfg := &tls.Config{
+		GetCertificate: r.GetCertificate,
+		MinVersion:     tls.VersionTLS12,
+	}
+	return cfg, r.Reload, nil
+}
@@ -0,0 +1,106 @@
+package chshare
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// TLS client certificate authentication lets a client that presents a certificate signed by the
+// server's client CA (see ProxyServerConfig.TLSClientCA) log in as the user named by the
+// certificate, without a password. It is only active when the server terminates TLS itself and
+// has a client CA configured. Clients without a certificate, or whose certificate names no known
+// user, authenticate with a password as usual. The SSH session is still established with the
+// server's host key, so clients' fingerprint checks apply unchanged.
+
+// setTLSClientCA configures cfg to verify client certificates, if presented, against the PEM CA
+// certificates in caFile. A client that presents a certificate that does not verify is refused
+// during the TLS handshake.
+func setTLSClientCA(cfg *tls.Config, caFile string) error {
+	b, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return fmt.Errorf("Unable to read TLS client CA file: %s", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(b) {
+		return fmt.Errorf("No PEM certificates found in TLS client CA file '%s'", caFile)
+	}
+	cfg.ClientCAs = pool
+	cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	return nil
+}
+
+// tlsClientCertIdentities returns the identities of a verified client certificate, in the order in
+// which they are matched against user names: the subject's common name, then the DNS names, then
+// the email addresses of its subject alternative names
+func tlsClientCertIdentities(cert *x509.Certificate) []string {
+	var ids []string
+	seen := make(map[string]bool)
+	add := func(id string) {
+		if id != "" && !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	add(cert.Subject.CommonName)
+	for _, name := range cert.DNSNames {
+		add(name)
+	}
+	for _, email := range cert.EmailAddresses {
+		add(email)
+	}
+	return ids
+}
+
+type tlsClientCertKey struct{}
+
+// withTLSClientCert returns a context carrying the identities of r's verified TLS client
+// certificate, or ctx unchanged if client certificate authentication is not enabled or r has no
+// verified certificate
+func (s *Server) withTLSClientCert(ctx context.Context, r *http.Request) context.Context {
+	if !s.certAuth || r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, tlsClientCertKey{}, tlsClientCertIdentities(r.TLS.VerifiedChains[0][0]))
+}
+
+// certUser returns the first user named by one of ids, or nil if there is none
+func (s *Server) certUser(ids []string) *User {
+	for _, id := range ids {
+		if user, found := s.users.Get(id); found {
+			return user
+		}
+	}
+	return nil
+}
+
+// sshConfigFor returns the SSH server config for a client connection. If the connection carries
+// a verified TLS client certificate (see withTLSClientCert) that names a user, password
+// authentication as that user (or with an empty user name) succeeds without checking the password.
+// Otherwise, the server's shared config is returned.
+func (s *Server) sshConfigFor(ctx context.Context) *ssh.ServerConfig {
+	ids, _ := ctx.Value(tlsClientCertKey{}).([]string)
+	if len(ids) == 0 || s.users.Len() == 0 {
+		return s.sshConfig
+	}
+	user := s.certUser(ids)
+	if user == nil {
+		s.DLogf("TLS client certificate %q names no user; requiring a password", ids)
+		return s.sshConfig
+	}
+	config := *s.sshConfig
+	config.PasswordCallback = func(c ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+		if c.User() != "" && c.User() != user.Name {
+			return s.authUser(c, password)
+		}
+		s.DLogf("Authenticated user %s by TLS client certificate", user.Name)
+		s.sessions.Set(string(c.SessionID()), user)
+		return nil, nil
+	}
+	return &config
+}
@@ -0,0 +1,154 @@
+package chshare
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestTLSClientCertIdentities(t *testing.T) {
+	cert := &x509.Certificate{
+		Subject:        pkix.Name{CommonName: "alice"},
+		DNSNames:       []string{"alice.example.com", "alice"},
+		EmailAddresses: []string{"alice@example.com"},
+	}
+	expected := []string{"alice", "alice.example.com", "alice@example.com"}
+	if ids := tlsClientCertIdentities(cert); !reflect.DeepEqual(ids, expected) {
+		t.Errorf("Expected %q, got %q", expected, ids)
+	}
+
+	cert = &x509.Certificate{DNSNames: []string{"bob.example.com"}}
+	expected = []string{"bob.example.com"}
+	if ids := tlsClientCertIdentities(cert); !reflect.DeepEqual(ids, expected) {
+		t.Errorf("Expected %q, got %q", expected, ids)
+	}
+}
+
+func TestSetTLSClientCA(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls-client-ca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	caFile := filepath.Join(dir, "ca.pem")
+	writeTestCert(t, caFile, filepath.Join(dir, "ca.key"), "test-ca")
+
+	cfg := &tls.Config{}
+	if err := setTLSClientCA(cfg, caFile); err != nil {
+		t.Fatalf("setTLSClientCA() returned error: %s", err)
+	}
+	if cfg.ClientCAs == nil || cfg.ClientAuth != tls.VerifyClientCertIfGiven {
+		t.Errorf("setTLSClientCA() did not enable client certificate verification")
+	}
+
+	badFile := filepath.Join(dir, "bad.pem")
+	if err := ioutil.WriteFile(badFile, []byte("not a certificate"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := setTLSClientCA(&tls.Config{}, badFile); err == nil {
+		t.Errorf("setTLSClientCA() accepted a file with no certificates")
+	}
+	if err := setTLSClientCA(&tls.Config{}, filepath.Join(dir, "missing.pem")); err == nil {
+		t.Errorf("setTLSClientCA() accepted a missing file")
+	}
+}
+
+// certTestConnMetadata is the ssh.ConnMetadata of a client logging in as user
+type certTestConnMetadata struct {
+	ssh.ConnMetadata
+	user string
+}
+
+func (c certTestConnMetadata) User() string {
+	return c.user
+}
+
+func (c certTestConnMetadata) SessionID() []byte {
+	return []byte("session-" + c.user)
+}
+
+func TestSSHConfigForTLSClientCert(t *testing.T) {
+	s, err := NewServer(&ProxyServerConfig{KeySeed: "cert-test", NoLoop: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	s.AddUser("alice", "alice-pw")
+	s.AddUser("bob", "bob-pw")
+	ctx := context.WithValue(context.Background(), tlsClientCertKey{}, []string{"alice"})
+
+	tests := []struct {
+		user, pass string
+		ok         bool
+	}{
+		// a verified certificate naming a user logs in as that user without a password
+		{"alice", "", true},
+		{"", "", true},
+		// a different SSH user still needs a password
+		{"bob", "", false},
+		{"bob", "bob-pw", true},
+	}
+	config := s.sshConfigFor(ctx)
+	for _, tt := range tests {
+		_, err := config.PasswordCallback(certTestConnMetadata{user: tt.user}, []byte(tt.pass))
+		if (err == nil) != tt.ok {
+			t.Errorf("Login as %q with password %q: error = %v; expected success=%v", tt.user, tt.pass, err, tt.ok)
+		}
+	}
+
+	// a certificate that names no user changes nothing
+	if s.sshConfigFor(context.WithValue(context.Background(), tlsClientCertKey{}, []string{"mallory"})) != s.sshConfig {
+		t.Errorf("sshConfigFor() with a certificate naming no user did not return the shared config")
+	}
+}
+
+func TestTLSClientCertWithoutClientCA(t *testing.T) {
+	s, err := NewServer(&ProxyServerConfig{KeySeed: "cert-test", Auth: "alice:alice-pw", NoLoop: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	r := httptest.NewRequest("GET", "/", nil)
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: "alice"}}
+	r.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+	ctx := s.withTLSClientCert(context.Background(), r)
+	config := s.sshConfigFor(ctx)
+	if config != s.sshConfig {
+		t.Fatalf("sshConfigFor() without a client CA did not return the shared config")
+	}
+	if _, err := config.PasswordCallback(certTestConnMetadata{user: "alice"}, nil); err == nil {
+		t.Errorf("Login as alice without a password succeeded without a client CA")
+	}
+}
+
+func TestClientTLSCert(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls-client-cert")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	certFile := filepath.Join(dir, "client.pem")
+	keyFile := filepath.Join(dir, "client.key")
+	writeTestCert(t, certFile, keyFile, "alice")
+
+	c, err := NewClient(&Config{Server: "https://localhost:8443", TLSCert: certFile, TLSKey: keyFile})
+	if err != nil {
+		t.Fatalf("NewClient() with a TLS client certificate returned error: %s", err)
+	}
+	if c.tlsConfig == nil || len(c.tlsConfig.Certificates) != 1 {
+		t.Errorf("NewClient() did not load the TLS client certificate")
+	}
+	if _, err := NewClient(&Config{Server: "https://localhost:8443", TLSCert: certFile}); err == nil {
+		t.Errorf("NewClient() accepted a TLS client certificate without a key")
+	}
+}
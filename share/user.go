@@ -38,3 +38,15 @@ func (u *User) HasAccess(addr string) bool {
 	}
 	return m
 }
+
+// HasChannelAccess returns true if the user may use a channel descriptor. A descriptor with
+// several fallback targets is allowed only if each of its targets is (see
+// ChannelDescriptor.AccessStrings).
+func (u *User) HasChannelAccess(chd *ChannelDescriptor) bool {
+	for _, s := range chd.AccessStrings() {
+		if !u.HasAccess(s) {
+			return false
+		}
+	}
+	return true
+}
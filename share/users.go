@@ -1,10 +1,13 @@
 package chshare
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"os"
 	"path/filepath"
 	"regexp"
 	"sync"
@@ -54,16 +57,32 @@ func (u *Users) Del(key string) {
 	u.Unlock()
 }
 
+// Replace atomically replaces all users with the given set, keyed by name
+func (u *Users) Replace(users map[string]*User) {
+	u.Lock()
+	u.inner = users
+	u.Unlock()
+}
+
 // AddUser adds a users to the list
 func (u *Users) AddUser(user *User) {
 	u.Set(user.Name, user)
 }
 
-// UserIndex is a reloadable user source
+// UserIndex is a reloadable user source. Users added with AddUser (e.g., with --auth, or through
+// the API) rather than loaded from the auth file are kept across reloads of the file, and take
+// precedence over a file user with the same name.
 type UserIndex struct {
 	Logger
 	*Users
 	configFile string
+
+	// OnReload, if not nil, is called after the auth file has been reloaded successfully
+	OnReload func()
+
+	// addedLock protects added, the users that did not come from the auth file
+	addedLock sync.Mutex
+	added     map[string]*User
 }
 
 // NewUserIndex creates a source for users
@@ -71,9 +90,27 @@ func NewUserIndex(logger Logger) *UserIndex {
 	return &UserIndex{
 		Logger: logger.Fork("users"),
 		Users:  NewUsers(),
+		added:  map[string]*User{},
 	}
 }
 
+// AddUser adds a user that is kept across reloads of the auth file
+func (u *UserIndex) AddUser(user *User) {
+	u.addedLock.Lock()
+	defer u.addedLock.Unlock()
+	u.added[user.Name] = user
+	u.Users.AddUser(user)
+}
+
+// Del deletes a user. A user loaded from the auth file returns if the file is reloaded and still
+// lists it.
+func (u *UserIndex) Del(key string) {
+	u.addedLock.Lock()
+	defer u.addedLock.Unlock()
+	delete(u.added, key)
+	u.Users.Del(key)
+}
+
 // LoadUsers is responsible for loading users from a file
 func (u *UserIndex) LoadUsers(configFile string) error {
 	u.configFile = configFile
@@ -109,30 +146,76 @@ func (u *UserIndex) addWatchEvents() error {
 				u.ILogf("Failed to reload the users configuration: %s", err)
 			} else {
 				u.DLogf("Users configuration successfully reloaded from: %s", u.configFile)
+				if u.OnReload != nil {
+					u.OnReload()
+				}
 			}
 		}
 	}()
 	return nil
 }
 
-// loadUserIndex is responsible for loading the users configuration
+// MaxAuthFileSize is the largest auth file that will be loaded, to guard against
+// loading an enormous file by mistake
+var MaxAuthFileSize int64 = 1 << 20
+
+// loadUserIndex is responsible for loading the users configuration. The file is validated
+// completely before any users are replaced, so on failure (e.g., during a reload) the
+// existing users remain in effect.
 func (u *UserIndex) loadUserIndex() error {
 	if u.configFile == "" {
 		return errors.New("configuration file not set")
 	}
-	b, err := ioutil.ReadFile(u.configFile)
+	f, err := os.Open(u.configFile)
+	if err != nil {
+		return fmt.Errorf("Failed to read auth file: %s, error: %s", u.configFile, err)
+	}
+	defer f.Close()
+	b, err := ioutil.ReadAll(io.LimitReader(f, MaxAuthFileSize+1))
 	if err != nil {
 		return fmt.Errorf("Failed to read auth file: %s, error: %s", u.configFile, err)
 	}
-	var raw map[string][]string
-	if err := json.Unmarshal(b, &raw); err != nil {
-		return errors.New("Invalid JSON: " + err.Error())
+	if int64(len(b)) > MaxAuthFileSize {
+		return fmt.Errorf("Auth file %s is larger than the %d byte limit", u.configFile, MaxAuthFileSize)
 	}
-	for auth, remotes := range raw {
+	users, err := parseUserIndex(b)
+	if err != nil {
+		return fmt.Errorf("Invalid auth file %s: %s", u.configFile, err)
+	}
+	u.addedLock.Lock()
+	defer u.addedLock.Unlock()
+	for name, user := range u.added {
+		users[name] = user
+	}
+	u.Users.Replace(users)
+	return nil
+}
+
+// parseUserIndex parses and validates the contents of an auth file: a JSON object whose keys
+// are "user:pass" strings and whose values are arrays of address regular expressions.
+func parseUserIndex(b []byte) (map[string]*User, error) {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return nil, errors.New("Invalid JSON: expected an object of \"user:pass\" keys")
+	}
+	users := map[string]*User{}
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, errors.New("Invalid JSON: " + err.Error())
+		}
+		auth := tok.(string)
 		user := &User{}
 		user.Name, user.Pass = ParseAuth(auth)
 		if user.Name == "" {
-			return errors.New("Invalid user:pass string")
+			return nil, fmt.Errorf("Invalid key \"%s\": must be a user:pass string", auth)
+		}
+		if _, dup := users[user.Name]; dup {
+			return nil, fmt.Errorf("Duplicate user \"%s\"", user.Name)
+		}
+		var remotes []string
+		if err := dec.Decode(&remotes); err != nil {
+			return nil, fmt.Errorf("Invalid entry for user \"%s\": must be an array of regular expression strings: %s", user.Name, err)
 		}
 		for _, r := range remotes {
 			if r == "" || r == "*" {
@@ -140,13 +223,18 @@ func (u *UserIndex) loadUserIndex() error {
 			} else {
 				re, err := regexp.Compile(r)
 				if err != nil {
-					return errors.New("Invalid address regex")
+					return nil, fmt.Errorf("Invalid address regex \"%s\" for user \"%s\": %s", r, user.Name, err)
 				}
 				user.Addrs = append(user.Addrs, re)
 			}
-
 		}
-		u.Users.AddUser(user)
+		users[user.Name] = user
 	}
-	return nil
+	if _, err := dec.Token(); err != nil {
+		return nil, errors.New("Invalid JSON: " + err.Error())
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, errors.New("Invalid JSON: unexpected data after the top-level object")
+	}
+	return users, nil
 }
@@ -0,0 +1,178 @@
+package chshare
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestParseUserIndex(t *testing.T) {
+	users, err := parseUserIndex([]byte(`{"alice:pw": ["^R:", "*"], "bob:pw": []}`))
+	if err != nil {
+		t.Fatalf("parseUserIndex() of a valid file failed: %s", err)
+	}
+	if len(users) != 2 || users["alice"] == nil || len(users["alice"].Addrs) != 2 {
+		t.Errorf("parseUserIndex() = %v, want alice with 2 addrs and bob", users)
+	}
+
+	tests := []struct {
+		content string
+		want    string
+	}{
+		{`["alice:pw"]`, "expected an object"},
+		{`{"alice": []}`, `Invalid key "alice"`},
+		{`{"alice:pw": "*"}`, `Invalid entry for user "alice"`},
+		{`{"alice:pw": [1]}`, `Invalid entry for user "alice"`},
+		{`{"alice:pw": ["a(b"]}`, `Invalid address regex "a(b" for user "alice"`},
+		{`{"alice:pw": [], "alice:other": []}`, `Duplicate user "alice"`},
+		{`{"alice:pw": []} {}`, "unexpected data"},
+	}
+	for _, tt := range tests {
+		_, err := parseUserIndex([]byte(tt.content))
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("parseUserIndex(%s) error = %v, want it to contain %q", tt.content, err, tt.want)
+		}
+	}
+}
+
+func TestUserIndexReloadKeepsAddedUsers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "users-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	authFile := filepath.Join(dir, "users.json")
+	if err := ioutil.WriteFile(authFile, []byte(`{"alice:pw": ["*"]}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	u := NewUserIndex(NewLogger("test", LogLevelInfo))
+	u.configFile = authFile
+	if err := u.loadUserIndex(); err != nil {
+		t.Fatal(err)
+	}
+	// as for --auth
+	u.AddUser(&User{Name: "admin", Pass: "secret", Addrs: []*regexp.Regexp{UserAllowAll}})
+
+	if err := ioutil.WriteFile(authFile, []byte(`{"bob:pw": ["*"]}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := u.loadUserIndex(); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]bool{"alice": false, "bob": true, "admin": true} {
+		if _, found := u.Get(name); found != want {
+			t.Errorf("After reload, user %s found=%v; expected %v", name, found, want)
+		}
+	}
+
+	u.Del("admin")
+	if err := u.loadUserIndex(); err != nil {
+		t.Fatal(err)
+	}
+	if _, found := u.Get("admin"); found {
+		t.Errorf("Deleted user admin returned after reload")
+	}
+}
+
+func TestHasChannelAccessFallbackTargets(t *testing.T) {
+	u := &User{Addrs: []*regexp.Regexp{regexp.MustCompile("localhost:80")}}
+	tests := []struct {
+		remote string
+		want   bool
+	}{
+		{"3000:localhost:80", true},
+		{"3000:internal-db:5432", false},
+		{"tcp://0.0.0.0:3000,tcp://internal-db:5432|localhost:80", false},
+		{"tcp://0.0.0.0:3000,tcp://localhost:80|localhost:80", true},
+		{`tcp://0.0.0.0:3000,tcp://{"targets":["internal-db:5432","localhost:80"]}`, false},
+		{`tcp://0.0.0.0:3000,tcp://{"targets":["localhost:80"]}`, true},
+	}
+	for _, tt := range tests {
+		chd, _, err := ParseChannelDescriptorPath(tt.remote)
+		if err != nil {
+			t.Fatalf("ParseChannelDescriptorPath(%q): %s", tt.remote, err)
+		}
+		if got := u.HasChannelAccess(&chd); got != tt.want {
+			t.Errorf("HasChannelAccess(%q) = %v; expected %v", tt.remote, got, tt.want)
+		}
+	}
+}
+
+func TestExampleUsersFile(t *testing.T) {
+	content, err := ioutil.ReadFile(filepath.Join("..", "example", "users.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	users, err := parseUserIndex(content)
+	if err != nil {
+		t.Fatalf("parseUserIndex() of the example users.json failed: %s", err)
+	}
+	tests := []struct {
+		user   string
+		remote string
+		want   bool
+	}{
+		{"root", "R:2222:localhost:22", true},
+		{"foo", "3000:0.0.0.0:3000", true},
+		{"foo", "3000:example.com:80", false},
+		{"ping", "8080:example.com:80", true},
+		{"ping", "5000:0.0.0.0:5000", true},
+		{"ping", "R:0.0.0.0:7000:localhost:22", true},
+		{"ping", "R:0.0.0.0:7001:localhost:22", false},
+		{"ping", "7000:localhost:22", false},
+	}
+	for _, tt := range tests {
+		chd, _, err := ParseChannelDescriptorPath(tt.remote)
+		if err != nil {
+			t.Fatalf("ParseChannelDescriptorPath(%q): %s", tt.remote, err)
+		}
+		if got := users[tt.user].HasChannelAccess(&chd); got != tt.want {
+			t.Errorf("%s HasChannelAccess(%q) = %v; expected %v", tt.user, chd.String(), got, tt.want)
+		}
+	}
+}
+
+func TestLegacyAuthfileAccess(t *testing.T) {
+	// an authfile written for the legacy address form, before remotes had a canonical full form
+	users, err := parseUserIndex([]byte(`{
+		"fwd:pw": ["^example\\.com:80$", "^localhost:(22|80)$", "^socks$", "^unix:/run/app\\.sock$"],
+		"rev:pw": ["^R:0.0.0.0:7000$", "^R:127\\.0\\.0\\.1:2222$"]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		user   string
+		remote string
+		want   bool
+	}{
+		{"fwd", "3000:example.com:80", true},
+		{"fwd", "tcp://0.0.0.0:3000,tcp://example.com:80", true},
+		{"fwd", `tcp://{"bind":"0.0.0.0:3000"},tcp://example.com:80`, true},
+		{"fwd", "3000:example.com:8080", false},
+		{"fwd", "tcp://0.0.0.0:3000,tcp://localhost:22|localhost:80", true},
+		{"fwd", "tcp://0.0.0.0:3000,tcp://localhost:22|example.org:80", false},
+		{"fwd", "socks", true},
+		{"fwd", "tcp://0.0.0.0:3000,unix:///run/app.sock", true},
+		{"fwd", "R:0.0.0.0:7000:example.com:80", false},
+		{"rev", "R:0.0.0.0:7000:localhost:22", true},
+		{"rev", "R:tcp://0.0.0.0:7000,tcp://localhost:22", true},
+		{"rev", `R:tcp://{"bind":"0.0.0.0:7000"},tcp://localhost:22`, true},
+		{"rev", "R:127.0.0.1:2222:localhost:22", true},
+		{"rev", "R:0.0.0.0:7001:localhost:22", false},
+		{"rev", "7000:localhost:22", false},
+	}
+	for _, tt := range tests {
+		chd, _, err := ParseChannelDescriptorPath(tt.remote)
+		if err != nil {
+			t.Fatalf("ParseChannelDescriptorPath(%q): %s", tt.remote, err)
+		}
+		if got := users[tt.user].HasChannelAccess(&chd); got != tt.want {
+			t.Errorf("%s HasChannelAccess(%q) = %v with access strings %q; expected %v", tt.user, tt.remote, got, chd.AccessStrings(), tt.want)
+		}
+	}
+}